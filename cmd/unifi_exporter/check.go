@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// errCheckFailed is returned by runCheck when any step of the check fails;
+// the failures themselves are written to its output.
+var errCheckFailed = errors.New("one or more checks failed")
+
+// runCheck tests the configuration of each UniFi Controller in config without
+// starting the HTTP server: it authenticates, lists sites, and retrieves the
+// devices and clients of each selected site, writing the outcome and duration
+// of each step to w.
+func runCheck(ctx context.Context, w io.Writer, config *Config) error {
+	sections, err := controllerSections(config)
+	if err != nil {
+		return fmt.Errorf("invalid controllers configuration: %v", err)
+	}
+	if len(sections) == 0 {
+		return errors.New("no UniFi Controllers are configured; probe modules cannot be checked without a target")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	var failed bool
+	for _, section := range sections {
+		if !checkController(ctx, tw, section, config.Collectors) {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errCheckFailed
+	}
+
+	return nil
+}
+
+// checkController runs each check against the UniFi Controller configured by
+// section, reporting whether all of them succeeded.
+func checkController(ctx context.Context, w io.Writer, section map[string]string, collectors map[string]string) bool {
+	name := section["name"]
+	if name == "" {
+		name = "unifi"
+	}
+	fmt.Fprintf(w, "controller %q (%s):\n", name, section["address"])
+
+	ok := true
+	step := func(desc string, fn func() (string, error)) bool {
+		start := time.Now()
+		detail, err := fn()
+		took := time.Since(start).Round(time.Millisecond)
+
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "  FAIL\t%s\t%v\t%v%s\n", desc, took, err, checkHint(err))
+			return false
+		}
+
+		fmt.Fprintf(w, "  ok\t%s\t%v\t%s\n", desc, took, detail)
+		return true
+	}
+
+	var cfg *controllerConfig
+	if !step("configuration", func() (string, error) {
+		var err error
+		cfg, err = parseControllerConfig(section, collectors)
+		return "", err
+	}) {
+		return false
+	}
+
+	var c api.Controller
+	if !step("login", func() (string, error) {
+		var err error
+		c, err = newClient(cfg.client)(ctx)
+		return "as " + cfg.client.username, err
+	}) {
+		return false
+	}
+	if client, isClient := c.(*api.Client); isClient {
+		defer func() {
+			_ = client.Logout(ctx)
+		}()
+	}
+
+	var sites []*api.Site
+	if !step("list sites", func() (string, error) {
+		all, err := c.Sites(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		sites, err = selectSites(cfg.site, cfg.shard, all)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%d site(s), exporting: %s", len(all), sitesString(sites)), nil
+	}) {
+		return false
+	}
+
+	for _, s := range sites {
+		s := s
+		step(fmt.Sprintf("site %q devices", s.Description), func() (string, error) {
+			devices, err := c.Devices(ctx, s.Name)
+			return fmt.Sprintf("%d device(s)", len(devices)), err
+		})
+		step(fmt.Sprintf("site %q clients", s.Description), func() (string, error) {
+			stations, err := c.Stations(ctx, s.Name)
+			return fmt.Sprintf("%d client(s)", len(stations)), err
+		})
+	}
+
+	return ok
+}
+
+// checkHints are advice for resolving common errors found by runCheck.
+var checkHints = []struct {
+	err  error
+	hint string
+}{
+	{err: api.ErrAuthFailed, hint: "check the username, password, and any two-factor authentication settings"},
+	{err: api.ErrTokenExpired, hint: "use totpsecret rather than a one-time totpcode to log in again automatically"},
+	{err: api.ErrForbidden, hint: "the user needs at least read-only access to this site"},
+	{err: api.ErrSiteNotFound, hint: "the site does not exist, or the user cannot see it"},
+}
+
+// checkHint returns advice for resolving err, if it is a common error.  Errors
+// are matched by message, because newClient wraps them.
+func checkHint(err error) string {
+	for _, h := range checkHints {
+		if strings.Contains(err.Error(), h.err.Error()) {
+			return " (" + h.hint + ")"
+		}
+	}
+
+	return ""
+}
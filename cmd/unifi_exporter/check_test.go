@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+)
+
+func Test_runCheck(t *testing.T) {
+	var tests = []struct {
+		desc     string
+		password string
+		matches  []*regexp.Regexp
+		ok       bool
+	}{
+		{
+			desc:     "OK",
+			password: apitest.Password,
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`ok +login +[0-9.]+m?s +as ` + apitest.Username),
+				regexp.MustCompile(`ok +list sites +[0-9.]+m?s +1 site\(s\), exporting: Default`),
+				regexp.MustCompile(`ok +site "Default" devices +[0-9.]+m?s +1 device\(s\)`),
+				regexp.MustCompile(`ok +site "Default" clients +[0-9.]+m?s +2 client\(s\)`),
+			},
+			ok: true,
+		},
+		{
+			desc:     "wrong password",
+			password: "wrong",
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`FAIL +login .*authentication with UniFi Controller failed \(check the username`),
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		dir, err := ioutil.TempDir("", "unifi-exporter")
+		if err != nil {
+			t.Fatalf("failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		unifi := apitest.NewServer()
+		defer unifi.Close()
+		unifi.SetCredentials(apitest.Username, tt.password)
+
+		config, err := loadConfig(testConfigFile(t, dir, unifi.URL, ""))
+		if err != nil {
+			t.Fatalf("failed to load configuration: %v", err)
+		}
+
+		var buf bytes.Buffer
+		err = runCheck(context.Background(), &buf, config)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected success:\n- want: %v\n-  got: %v (%v)\n%s", want, got, err, buf.String())
+		}
+
+		for j, m := range tt.matches {
+			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
+
+			if !m.Match(buf.Bytes()) {
+				t.Fatalf("\toutput failed to match regex:\n%s", buf.String())
+			}
+		}
+	}
+}
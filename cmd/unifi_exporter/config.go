@@ -0,0 +1,1221 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the structure of the exporter's YAML configuration file.
+type Config struct {
+	Listen map[string]string `yaml:"listen"`
+	Unifi  map[string]string `yaml:"unifi"`
+
+	// Controllers configures multiple UniFi Controllers, each identified by
+	// its name key.  Keys in the unifi section apply to every controller
+	// which does not set them itself.
+	Controllers []map[string]string `yaml:"controllers"`
+
+	// Modules configure credentials and other settings for controllers
+	// scraped via the /probe endpoint, keyed by module name.  Keys in the
+	// unifi section apply to every module which does not set them itself.
+	Modules map[string]map[string]string `yaml:"modules"`
+
+	// Collectors enables or disables collectors by name, for every
+	// controller.
+	Collectors map[string]string `yaml:"collectors"`
+
+	// Filter selects the metrics and labels which are exported.
+	Filter map[string]string `yaml:"filter"`
+
+	// Labels are constant labels added to every exported metric.
+	Labels map[string]string `yaml:"labels"`
+
+	// OTLP configures pushing metrics to an OpenTelemetry collector, in
+	// addition to serving them for Prometheus.
+	OTLP map[string]string `yaml:"otlp"`
+
+	// Sites override the configuration of selected sites of each
+	// controller, such as which collectors run for them.
+	Sites []map[string]string `yaml:"sites"`
+
+	// Textfile configures writing metrics to a file for the textfile
+	// collector of node_exporter, in addition to serving them for
+	// Prometheus.
+	Textfile map[string]string `yaml:"textfile"`
+
+	// Notify configures webhooks which are notified of selected events
+	// streamed from each controller.
+	Notify map[string]string `yaml:"notify"`
+
+	// EventLog configures writing the events streamed from each controller
+	// as JSON lines, such as for a log aggregator.
+	EventLog map[string]string `yaml:"eventlog"`
+}
+
+// loadConfig reads the configuration file at path, and applies any
+// command-line flags which override its keys.
+func loadConfig(path string) (*Config, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %v", path, err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(source, &config); err != nil {
+		return nil, fmt.Errorf("failed to read YAML from config file %q: %v", path, err)
+	}
+
+	config.Listen = applyFlagOverrides(config.Listen, map[string]*string{"address": listenAddress})
+	config.Listen, err = readSecretFiles(config.Listen, listenSecretKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen configuration in config file %q: %v", path, err)
+	}
+
+	config.Unifi = applyFlagOverrides(config.Unifi, unifiFlagOverrides())
+	config.Collectors = applyFlagOverrides(config.Collectors, collectorFlagOverrides())
+	return &config, nil
+}
+
+// Keys of configuration file sections which hold secrets.  Each may instead be
+// read from a file named by the same key with a "file" suffix, such as
+// passwordfile, which takes precedence.
+var (
+	listenSecretKeys = []string{"bearertoken", "reloadtoken"}
+	unifiSecretKeys  = []string{"username", "password", "totpsecret", "totpcode", "privacykey", "accesstoken", "headers"}
+)
+
+// readSecretFiles returns a copy of section in which each of keys is set to
+// the contents of the file named by its file key, if one is set.  A trailing
+// newline is removed from each file, so that secrets may be written with echo
+// or mounted from Docker and Kubernetes secrets.
+func readSecretFiles(section map[string]string, keys []string) (map[string]string, error) {
+	out := make(map[string]string, len(section))
+	for k, v := range section {
+		out[k] = v
+	}
+
+	for _, key := range keys {
+		file := out[key+"file"]
+		if file == "" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s file: %v", key, err)
+		}
+
+		out[key] = strings.TrimRight(string(b), "\r\n")
+	}
+
+	return out, nil
+}
+
+// unifiEnvOverrides returns the environment variables which override keys in
+// the unifi section of the configuration file, and of each controller.  Each
+// secret, such as the password, is set by a variable such as UNIFI_PASSWORD,
+// or read from the file named by a variable such as UNIFI_PASSWORD_FILE.
+func unifiEnvOverrides() map[string]*string {
+	vars := map[string]string{
+		"username":    "UNIFI_USERNAME",
+		"password":    "UNIFI_PASSWORD",
+		"totpsecret":  "UNIFI_TOTP_SECRET",
+		"totpcode":    "UNIFI_TOTP_CODE",
+		"privacykey":  "UNIFI_PRIVACY_KEY",
+		"accesstoken": "UNIFI_ACCESS_TOKEN",
+		"headers":     "UNIFI_HEADERS",
+	}
+
+	overrides := make(map[string]*string, 2*len(vars))
+	for key, name := range vars {
+		if v, ok := os.LookupEnv(name); ok {
+			overrides[key] = &v
+		}
+		if v, ok := os.LookupEnv(name + "_FILE"); ok {
+			overrides[key+"file"] = &v
+		}
+	}
+
+	return overrides
+}
+
+// collectorFlagOverrides returns the collector flags which were set on the
+// command line, which override keys in the collectors section of the
+// configuration file.
+func collectorFlagOverrides() map[string]*string {
+	overrides := make(map[string]*string)
+	flag.Visit(func(f *flag.Flag) {
+		name := strings.TrimPrefix(f.Name, "collector.")
+		if _, ok := collectorFlags[name]; !ok || name == f.Name {
+			return
+		}
+
+		v := f.Value.String()
+		overrides[name] = &v
+	})
+
+	return overrides
+}
+
+// unifiFlagOverrides returns the command-line flags and environment variables
+// which override keys in the unifi section of the configuration file, and of
+// each controller.  Flags take precedence over environment variables.
+func unifiFlagOverrides() map[string]*string {
+	overrides := unifiEnvOverrides()
+	for key, f := range unifiFlags() {
+		if f != nil && *f != "" {
+			overrides[key] = f
+		}
+	}
+
+	return overrides
+}
+
+// unifiFlags returns the command-line flags which override keys in the unifi
+// section of the configuration file, and of each controller.  Secrets
+// other than the username can only be read from files, so that they need not
+// appear on the command line.
+func unifiFlags() map[string]*string {
+	return map[string]*string{
+		"username":              unifiUsername,
+		"breakercooldown":       unifiBreakerCooldown,
+		"breakerfailures":       unifiBreakerFailures,
+		"passwordfile":          unifiPasswordFile,
+		"totpsecretfile":        unifiTOTPSecretFile,
+		"privacykeyfile":        unifiPrivacyKeyFile,
+		"timeout":               unifiTimeout,
+		"dialtimeout":           unifiDialTimeout,
+		"tlshandshaketimeout":   unifiTLSHandshakeTimeout,
+		"cafile":                unifiCAFile,
+		"certfile":              unifiCertFile,
+		"keyfile":               unifiKeyFile,
+		"tlsfingerprint":        unifiTLSFingerprint,
+		"proxy":                 unifiProxy,
+		"maxconcurrentrequests": unifiMaxRequests,
+		"pollinterval":          unifiPollInterval,
+		"recorddir":             unifiRecordDir,
+		"resolveinterval":       unifiResolveInterval,
+		"replaydir":             unifiReplayDir,
+		"servestale":            unifiServeStale,
+		"shard":                 unifiShard,
+		"site":                  unifiSite,
+		"siterefreshinterval":   unifiSiteRefresh,
+		"skipdisconnected":      unifiSkipDisconnected,
+		"stationnamelabels":     unifiStationNameLabels,
+	}
+}
+
+// defaultBreakerCooldown is how long requests to a UniFi Controller are
+// suspended once its circuit breaker opens, unless breakercooldown is set.
+const defaultBreakerCooldown = time.Minute
+
+// controllerSections returns a configuration section for each UniFi Controller
+// in config.  Without a controllers list, the unifi section alone configures a
+// single controller, unless it has no address and only probe modules are
+// configured.
+func controllerSections(config *Config) ([]map[string]string, error) {
+	if len(config.Controllers) == 0 {
+		if config.Unifi["address"] == "" && len(config.Modules) > 0 {
+			return nil, nil
+		}
+
+		return []map[string]string{config.Unifi}, nil
+	}
+
+	names := make(map[string]bool, len(config.Controllers))
+	sections := make([]map[string]string, 0, len(config.Controllers))
+	for i, c := range config.Controllers {
+		name := c["name"]
+		if name == "" {
+			return nil, fmt.Errorf("controller %d must specify a name", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("controller name %q is used more than once", name)
+		}
+		names[name] = true
+
+		sections = append(sections, mergeSection(config.Unifi, c))
+	}
+
+	return sections, nil
+}
+
+// probeSection returns the configuration section for probing the UniFi
+// Controller at target using the named module.
+func probeSection(config *Config, module string, target string) (map[string]string, error) {
+	m, ok := config.Modules[module]
+	if !ok {
+		return nil, fmt.Errorf("unknown module %q", module)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target %q: %v", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("target %q must be an http or https URL, such as https://unifi:8443", target)
+	}
+
+	section := mergeSection(config.Unifi, m)
+	section["address"] = target
+	return section, nil
+}
+
+// mergeSection returns a copy of the defaults section with the keys of
+// section applied on top, and then any overriding command-line flags, which
+// take precedence over both.
+func mergeSection(defaults map[string]string, section map[string]string) map[string]string {
+	out := make(map[string]string, len(defaults)+len(section))
+	for k, v := range defaults {
+		out[k] = v
+	}
+	for k, v := range section {
+		out[k] = v
+	}
+
+	return applyFlagOverrides(out, unifiFlagOverrides())
+}
+
+// applyFlagOverrides sets keys within a configuration file section to the
+// values of their corresponding command-line flags, for flags which were set
+// to a non-empty value.
+//
+// A secret set by an override replaces any file for it in the section, and
+// vice versa, so that an override always takes precedence.
+func applyFlagOverrides(section map[string]string, overrides map[string]*string) map[string]string {
+	if section == nil {
+		section = make(map[string]string)
+	}
+
+	for key, value := range overrides {
+		if value == nil || *value == "" {
+			continue
+		}
+
+		section[key] = *value
+	}
+
+	for _, key := range unifiSecretKeys {
+		file := key + "file"
+		switch {
+		case overrides[key] != nil && *overrides[key] != "":
+			if overrides[file] == nil || *overrides[file] == "" {
+				delete(section, file)
+			}
+		case overrides[file] != nil && *overrides[file] != "":
+			delete(section, key)
+		}
+	}
+
+	return section
+}
+
+// controllerConfig contains the parameters used to export metrics from a
+// single UniFi Controller.
+type controllerConfig struct {
+	// name, if set, is the value of the controller label on every metric
+	// exported for this controller.
+	name string
+
+	client  clientConfig
+	site    string
+	shard   siteShard
+	options []exporter.Option
+
+	// pollInterval, if set, is the interval at which metrics are collected
+	// in the background, rather than when the exporter is scraped.
+	pollInterval time.Duration
+}
+
+// parseControllerConfig parses and validates the configuration section of a
+// single UniFi Controller, loading any certificates it refers to.  collectors
+// enables or disables collectors by name.
+func parseControllerConfig(section map[string]string, collectors map[string]string) (*controllerConfig, error) {
+	section, err := readSecretFiles(section, unifiSecretKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	insecure := false
+	if ins, ok := section["insecure"]; ok {
+		insecure, err = strconv.ParseBool(ins)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bool %s: %v", ins, err)
+		}
+	}
+
+	timeout := 5 * time.Second
+	if to, ok := section["timeout"]; ok {
+		timeout, err = time.ParseDuration(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", to, err)
+		}
+	}
+
+	// Zero values select the defaults of the api package
+	var dialTimeout, tlsHandshakeTimeout time.Duration
+	if to, ok := section["dialtimeout"]; ok {
+		dialTimeout, err = time.ParseDuration(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", to, err)
+		}
+	}
+	if to, ok := section["tlshandshaketimeout"]; ok {
+		tlsHandshakeTimeout, err = time.ParseDuration(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", to, err)
+		}
+	}
+
+	var resolveInterval time.Duration
+	if ri, ok := section["resolveinterval"]; ok && ri != "" {
+		resolveInterval, err = time.ParseDuration(ri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", ri, err)
+		}
+	}
+
+	var pollInterval time.Duration
+	if pi, ok := section["pollinterval"]; ok && pi != "" {
+		pollInterval, err = time.ParseDuration(pi)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", pi, err)
+		}
+		if pollInterval < 0 {
+			return nil, fmt.Errorf("poll interval %q must not be negative", pi)
+		}
+	}
+
+	maxRequests := api.DefaultMaxConcurrentRequests
+	if m, ok := section["maxconcurrentrequests"]; ok {
+		maxRequests, err = strconv.Atoi(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse integer %q: %v", m, err)
+		}
+	}
+
+	var breakerFailures int
+	if bf := section["breakerfailures"]; bf != "" {
+		breakerFailures, err = strconv.Atoi(bf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse integer %q: %v", bf, err)
+		}
+		if breakerFailures < 0 {
+			return nil, fmt.Errorf("circuit breaker failures %q must not be negative", bf)
+		}
+	}
+
+	breakerCooldown := defaultBreakerCooldown
+	if bc := section["breakercooldown"]; bc != "" {
+		breakerCooldown, err = time.ParseDuration(bc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", bc, err)
+		}
+		if breakerCooldown <= 0 {
+			return nil, fmt.Errorf("circuit breaker cooldown %q must be positive", bc)
+		}
+	}
+
+	// Each collector fetches as many sites at once as the client allows
+	// requests in flight
+	options := []exporter.Option{exporter.SiteConcurrency(maxRequests)}
+	dpiLimit := exporter.DefaultDPILimit
+	if l, ok := section["dpilimit"]; ok {
+		dpiLimit, err = strconv.Atoi(l)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse integer %q: %v", l, err)
+		}
+	}
+
+	shard, err := parseSiteShard(section["shard"])
+	if err != nil {
+		return nil, err
+	}
+
+	if sr := section["siterefreshinterval"]; sr != "" {
+		siteRefresh, err := time.ParseDuration(sr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", sr, err)
+		}
+		if siteRefresh < 0 {
+			return nil, fmt.Errorf("site refresh interval %q must not be negative", sr)
+		}
+
+		site := section["site"]
+		options = append(options, exporter.RefreshSites(siteRefresh, func(sites []*api.Site) ([]*api.Site, error) {
+			return selectSites(site, shard, sites)
+		}))
+	}
+
+	if ss := section["servestale"]; ss != "" {
+		maxAge, err := time.ParseDuration(ss)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", ss, err)
+		}
+		if maxAge < 0 {
+			return nil, fmt.Errorf("stale metrics maximum age %q must not be negative", ss)
+		}
+
+		options = append(options, exporter.ServeStale(maxAge))
+	}
+
+	if p := section["privacy"]; p != "" {
+		options = append(options, exporter.StationPrivacy(p, []byte(section["privacykey"])))
+	}
+	if sn := section["stationnamelabels"]; sn != "" {
+		nameLabels, err := strconv.ParseBool(sn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bool %s: %v", sn, err)
+		}
+
+		if nameLabels {
+			options = append(options, exporter.StationNameLabels())
+		}
+	}
+	if l := section["sitelabel"]; l != "" {
+		options = append(options, exporter.SiteLabel(l))
+	}
+	if si, ok := section["siteinfo"]; ok && si != "" {
+		siteInfo, err := strconv.ParseBool(si)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bool %s: %v", si, err)
+		}
+
+		if siteInfo {
+			options = append(options, exporter.EnableSiteInfo())
+		}
+	}
+
+	if sd := section["skipdisconnected"]; sd != "" {
+		skip, err := strconv.ParseBool(sd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bool %s: %v", sd, err)
+		}
+
+		if skip {
+			options = append(options, exporter.SkipDisconnectedDevices())
+		}
+	}
+
+	// The dpi key predates the collectors section, which takes precedence
+	if d, ok := section["dpi"]; ok {
+		dpi, err := strconv.ParseBool(d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bool %s: %v", d, err)
+		}
+
+		if dpi {
+			options = append(options, exporter.EnableDPI(dpiLimit))
+		}
+	}
+
+	// The access collector is enabled by setting accessaddress, unless the
+	// collectors section disables it
+	access := section["accessaddress"] != ""
+	for name, c := range collectors {
+		enabled, err := strconv.ParseBool(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bool %s for collector %q: %v", c, name, err)
+		}
+
+		switch {
+		case name == exporter.CollectorAccess:
+			if enabled && section["accessaddress"] == "" {
+				return nil, errors.New("accessaddress of UniFi Access API must be specified to enable the access collector")
+			}
+			access = enabled
+		case name == exporter.CollectorDPI && enabled:
+			options = append(options, exporter.EnableDPI(dpiLimit))
+		case enabled:
+			options = append(options, exporter.EnableCollector(name))
+		default:
+			options = append(options, exporter.DisableCollector(name))
+		}
+	}
+	if !shard.first() {
+		access = false
+		for _, name := range unshardedCollectors {
+			options = append(options, exporter.DisableCollector(name))
+		}
+	}
+
+	if section["address"] == "" {
+		return nil, errors.New("address of UniFi Controller API must be specified")
+	}
+	recordDir, replayDir := section["recorddir"], section["replaydir"]
+	if recordDir != "" && replayDir != "" {
+		return nil, errors.New("only one of recorddir or replaydir may be specified")
+	}
+	// Recorded responses do not depend on the credentials, so none are
+	// needed to replay them
+	if section["username"] == "" && replayDir == "" {
+		return nil, errors.New("username to authenticate to UniFi Controller API must be specified")
+	}
+	if section["password"] == "" && replayDir == "" {
+		return nil, errors.New("password to authenticate to UniFi Controller API must be specified")
+	}
+	if section["totpsecret"] != "" && section["totpcode"] != "" {
+		return nil, errors.New("only one of totpsecret or totpcode may be specified")
+	}
+
+	var rootCAs *x509.CertPool
+	if caFile := section["cafile"]; caFile != "" {
+		rootCAs, err = loadCAFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var certs []tls.Certificate
+	certFile, keyFile := section["certfile"], section["keyfile"]
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both certfile and keyfile must be specified to use a client certificate")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %q: %v", certFile, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	var fingerprint []byte
+	if fp := section["tlsfingerprint"]; fp != "" {
+		fingerprint, err = api.ParseFingerprint(fp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var proxy *url.URL
+	if p := section["proxy"]; p != "" {
+		proxy, err = url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL %q: %v", p, err)
+		}
+		if proxy.Scheme == "" || proxy.Host == "" {
+			return nil, fmt.Errorf("proxy URL %q must include a scheme and host, such as http://proxy:3128", p)
+		}
+	}
+
+	headers, err := parseHeaders(section["headers"])
+	if err != nil {
+		return nil, err
+	}
+
+	httpCfg := api.HTTPClientConfig{
+		Timeout:             timeout,
+		DialTimeout:         dialTimeout,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		RootCAs:             rootCAs,
+		Certificates:        certs,
+		InsecureSkipVerify:  insecure,
+		Fingerprint:         fingerprint,
+		Proxy:               proxy,
+		ResolveInterval:     resolveInterval,
+	}
+
+	// UniFi Access usually runs on the same console as the UniFi Controller,
+	// so is reached with the same HTTP settings, but is not sent the
+	// controller's headers, and its responses are not recorded or replayed
+	if access {
+		if section["accesstoken"] == "" {
+			return nil, errors.New("accesstoken to authenticate to UniFi Access API must be specified")
+		}
+
+		ac, err := api.NewAccessClient(section["accessaddress"], section["accesstoken"], api.NewHTTPClient(httpCfg))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse accessaddress %q: %v", section["accessaddress"], err)
+		}
+		ac.UserAgent = userAgent
+
+		options = append(options, exporter.EnableAccess(ac))
+	}
+
+	httpCfg.Headers = headers
+	httpCfg.RecordDir, httpCfg.ReplayDir = recordDir, replayDir
+
+	// A proxy may be selected by the environment rather than the
+	// configuration, which is easily overlooked, so the proxy is logged
+	if p := controllerProxy(section["address"], proxy); p != nil && replayDir == "" {
+		log.Printf("[INFO] connecting to UniFi controller %q through proxy %q", section["address"], redactURL(p))
+	}
+
+	return &controllerConfig{
+		client: clientConfig{
+			addr:       section["address"],
+			username:   section["username"],
+			password:   section["password"],
+			totpSecret: section["totpsecret"],
+			totpCode:   newOneTimeCode(section["totpcode"]),
+
+			maxRequests: maxRequests,
+			controller:  section["name"],
+
+			breakerFailures: breakerFailures,
+			breakerCooldown: breakerCooldown,
+
+			http: httpCfg,
+		},
+		name:         section["name"],
+		site:         section["site"],
+		shard:        shard,
+		options:      options,
+		pollInterval: pollInterval,
+	}, nil
+}
+
+// controllerProxy returns the proxy through which the UniFi Controller at addr
+// is reached: proxy if it is set, or else the proxy selected by the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables, if any.
+func controllerProxy(addr string, proxy *url.URL) *url.URL {
+	if proxy != nil {
+		return proxy
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil
+	}
+
+	p, err := http.ProxyFromEnvironment(&http.Request{URL: u})
+	if err != nil {
+		return nil
+	}
+
+	return p
+}
+
+// redactURL returns u as a string, with any password replaced, so that it
+// may be logged.
+func redactURL(u *url.URL) string {
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+
+	r := *u
+	r.User = url.UserPassword(u.User.Username(), "xxxxx")
+
+	return r.String()
+}
+
+// listenTLSConfig returns the TLS configuration for the exporter's HTTP
+// listener from the listen section of the configuration file, or nil if the
+// listener should serve plaintext HTTP.
+func listenTLSConfig(section map[string]string) (*tls.Config, error) {
+	certFile, keyFile := section["tlscertfile"], section["tlskeyfile"]
+	clientCAFile := section["tlsclientcafile"]
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both tlscertfile and tlskeyfile must be specified to serve HTTPS")
+	}
+	if certFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("tlsclientcafile requires tlscertfile and tlskeyfile")
+		}
+
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate %q: %v", certFile, err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// Only clients presenting a certificate signed by one of these
+	// authorities may connect
+	if clientCAFile != "" {
+		pool, err := loadCAFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// metricsAuth contains the credentials which grant access to the metrics and
+// probe endpoints.  If neither is set, access is unrestricted.
+type metricsAuth struct {
+	// bearerToken, if set, is accepted in an Authorization header.
+	bearerToken string
+
+	// users maps basic authentication usernames to bcrypt password hashes.
+	users map[string][]byte
+}
+
+// parseMetricsAuth parses the bearertoken and basicauthusers keys of the
+// listen section of the configuration file.  basicauthusers is a
+// comma-separated list of username:bcrypt-hash pairs.
+func parseMetricsAuth(section map[string]string) (*metricsAuth, error) {
+	auth := &metricsAuth{
+		bearerToken: section["bearertoken"],
+		users:       make(map[string][]byte),
+	}
+
+	for _, pair := range strings.Split(section["basicauthusers"], ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		ss := strings.SplitN(pair, ":", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			return nil, fmt.Errorf("basicauthusers entry %q must be of the form username:bcrypt-hash", pair)
+		}
+		if _, err := bcrypt.Cost([]byte(ss[1])); err != nil {
+			return nil, fmt.Errorf("basicauthusers entry for %q is not a bcrypt hash: %v", ss[0], err)
+		}
+
+		auth.users[ss[0]] = []byte(ss[1])
+	}
+
+	return auth, nil
+}
+
+// metricFilter selects the metrics and labels which are exported.
+type metricFilter struct {
+	// allow and deny, if set, match the names of metrics which are
+	// exported and dropped, respectively.
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+
+	// dropLabels are the names of labels removed from every metric.
+	dropLabels map[string]bool
+}
+
+// parseMetricFilter parses the filter section of the configuration file.
+// allow and deny are regular expressions which must match an entire metric
+// name, and droplabels is a comma-separated list of label names.  A nil
+// metricFilter is returned if the section filters nothing.
+func parseMetricFilter(section map[string]string) (*metricFilter, error) {
+	f := &metricFilter{
+		dropLabels: make(map[string]bool),
+	}
+
+	var err error
+	if a := section["allow"]; a != "" {
+		f.allow, err = regexp.Compile("^(?:" + a + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allow regular expression %q: %v", a, err)
+		}
+	}
+	if d := section["deny"]; d != "" {
+		f.deny, err = regexp.Compile("^(?:" + d + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse deny regular expression %q: %v", d, err)
+		}
+	}
+
+	for _, l := range strings.Split(section["droplabels"], ",") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		f.dropLabels[l] = true
+	}
+
+	if f.allow == nil && f.deny == nil && len(f.dropLabels) == 0 {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// labelNameRE matches valid Prometheus label names.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseConstLabels validates the labels section of the configuration file.
+func parseConstLabels(labels map[string]string) (map[string]string, error) {
+	for name := range labels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+	}
+
+	return labels, nil
+}
+
+// otlpConfig configures an otlpPusher.
+type otlpConfig struct {
+	endpoint string
+	interval time.Duration
+	timeout  time.Duration
+	headers  map[string]string
+}
+
+// parseOTLPConfig parses the otlp section of the configuration file.
+// endpoint is the http or https URL of an OTLP/gRPC endpoint, without a path,
+// whose scheme selects plaintext or TLS.  headers is a comma-separated list of
+// name=value pairs sent as gRPC metadata with each export.  A nil otlpConfig
+// is returned if no endpoint is set.
+func parseOTLPConfig(section map[string]string) (*otlpConfig, error) {
+	if section["endpoint"] == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(section["endpoint"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint %q: %v", section["endpoint"], err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("endpoint %q must be an http or https URL", section["endpoint"])
+	}
+	if u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("endpoint %q must be an OTLP/gRPC address, such as http://otel-collector:4317, without a path", section["endpoint"])
+	}
+
+	cfg := &otlpConfig{
+		endpoint: u.String(),
+		interval: 60 * time.Second,
+		timeout:  10 * time.Second,
+	}
+
+	if i := section["interval"]; i != "" {
+		cfg.interval, err = time.ParseDuration(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", i, err)
+		}
+		if cfg.interval <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", i)
+		}
+	}
+	if to := section["timeout"]; to != "" {
+		cfg.timeout, err = time.ParseDuration(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", to, err)
+		}
+	}
+
+	cfg.headers, err = parseHeaders(section["headers"])
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// A siteConfig is an entry of the sites list of the configuration file, which
+// overrides the configuration of the sites it selects.
+type siteConfig struct {
+	// controller, if set, is the name of the only controller whose sites
+	// are selected.
+	controller string
+	selector   siteSelector
+	config     exporter.SiteConfig
+}
+
+// siteConfigCollectors are the collectors which can be selected for a site.
+var siteConfigCollectors = []string{
+	exporter.CollectorDevices,
+	exporter.CollectorClients,
+	exporter.CollectorDPI,
+}
+
+// parseSiteConfigs parses the sites list of the configuration file.  Each
+// entry selects sites with site, a single selector as accepted by pickSites,
+// and sets collectors, a comma-separated list of the devices, clients and dpi
+// collectors which run for them; labels, a comma-separated list of name=value
+// pairs added to their metrics; and pollinterval, the minimum interval at
+// which their data is retrieved.
+func parseSiteConfigs(entries []map[string]string) ([]siteConfig, error) {
+	scs := make([]siteConfig, 0, len(entries))
+	for i, entry := range entries {
+		if entry["site"] == "" {
+			return nil, fmt.Errorf("site %d must select a site", i)
+		}
+
+		sel, err := parseSiteSelector(entry["site"])
+		if err != nil {
+			return nil, err
+		}
+
+		sc := siteConfig{
+			controller: entry["controller"],
+			selector:   sel,
+		}
+
+		if cs, ok := entry["collectors"]; ok {
+			sc.config.Collectors = make(map[string]bool, len(siteConfigCollectors))
+			for _, name := range siteConfigCollectors {
+				sc.config.Collectors[name] = false
+			}
+
+			for _, name := range strings.Split(cs, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					continue
+				}
+				if _, ok := sc.config.Collectors[name]; !ok {
+					return nil, fmt.Errorf("collector %q of site %q must be one of %s",
+						name, entry["site"], strings.Join(siteConfigCollectors, ", "))
+				}
+
+				sc.config.Collectors[name] = true
+			}
+		}
+
+		sc.config.Labels, err = parseHeaders(entry["labels"])
+		if err != nil {
+			return nil, err
+		}
+		for name := range sc.config.Labels {
+			if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") || name == "site" || name == "controller" {
+				return nil, fmt.Errorf("invalid label name %q for site %q", name, entry["site"])
+			}
+		}
+
+		if pi := entry["pollinterval"]; pi != "" {
+			sc.config.Interval, err = time.ParseDuration(pi)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse duration %q: %v", pi, err)
+			}
+			if sc.config.Interval < 0 {
+				return nil, fmt.Errorf("poll interval %q must not be negative", pi)
+			}
+		}
+
+		scs = append(scs, sc)
+	}
+
+	return scs, nil
+}
+
+// siteConfigFunc returns an exporter.SiteConfigFunc which applies the first of
+// scs to select each site of the named controller, or nil if none of scs can.
+func siteConfigFunc(scs []siteConfig, controller string) exporter.SiteConfigFunc {
+	var own []siteConfig
+	for _, sc := range scs {
+		if sc.controller == "" || sc.controller == controller {
+			own = append(own, sc)
+		}
+	}
+	if len(own) == 0 {
+		return nil
+	}
+
+	return func(s *api.Site) *exporter.SiteConfig {
+		for _, sc := range own {
+			if sc.selector.matches(s) {
+				config := sc.config
+				return &config
+			}
+		}
+
+		return nil
+	}
+}
+
+// textfileConfig configures a textfileWriter.
+type textfileConfig struct {
+	path     string
+	interval time.Duration
+}
+
+// parseTextfileConfig parses the textfile section of the configuration file.
+// A nil textfileConfig is returned if no path is set.
+func parseTextfileConfig(section map[string]string) (*textfileConfig, error) {
+	if section["path"] == "" {
+		return nil, nil
+	}
+	if !strings.HasSuffix(section["path"], ".prom") {
+		return nil, fmt.Errorf("path %q must end in .prom to be read by node_exporter", section["path"])
+	}
+
+	cfg := &textfileConfig{
+		path:     section["path"],
+		interval: 60 * time.Second,
+	}
+
+	if i := section["interval"]; i != "" {
+		var err error
+		cfg.interval, err = time.ParseDuration(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", i, err)
+		}
+		if cfg.interval <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", i)
+		}
+	}
+
+	return cfg, nil
+}
+
+// parseHeaders parses headers, a comma-separated list of name=value pairs.
+func parseHeaders(headers string) (map[string]string, error) {
+	h := make(map[string]string)
+	for _, pair := range strings.Split(headers, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		ss := strings.SplitN(pair, "=", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			return nil, fmt.Errorf("headers entry %q must be of the form name=value", pair)
+		}
+
+		h[strings.TrimSpace(ss[0])] = strings.TrimSpace(ss[1])
+	}
+
+	return h, nil
+}
+
+// Formats of the notifications sent by a notifier.
+const (
+	notifyFormatJSON         = "json"
+	notifyFormatAlertmanager = "alertmanager"
+)
+
+// notifyConfig configures a notifier.
+type notifyConfig struct {
+	urls    []string
+	events  map[string]bool
+	format  string
+	timeout time.Duration
+	headers map[string]string
+}
+
+// parseNotifyConfig parses the notify section of the configuration file.
+// urls is a comma-separated list of webhook URLs, and events a comma-separated
+// list of the keys of the events which are notified, such as
+// EVT_AP_Lost_Contact.  A nil notifyConfig is returned if no URLs are set.
+func parseNotifyConfig(section map[string]string) (*notifyConfig, error) {
+	if section["urls"] == "" {
+		return nil, nil
+	}
+
+	cfg := &notifyConfig{
+		events:  make(map[string]bool),
+		format:  notifyFormatJSON,
+		timeout: 10 * time.Second,
+	}
+
+	for _, raw := range strings.Split(section["urls"], ",") {
+		raw = strings.TrimSpace(raw)
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL %q: %v", raw, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("URL %q must be an http or https URL", raw)
+		}
+
+		cfg.urls = append(cfg.urls, u.String())
+	}
+
+	for _, key := range strings.Split(section["events"], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			cfg.events[key] = true
+		}
+	}
+	if len(cfg.events) == 0 {
+		return nil, errors.New("events must list the keys of the events to notify")
+	}
+
+	switch f := section["format"]; f {
+	case "", notifyFormatJSON:
+	case notifyFormatAlertmanager:
+		cfg.format = f
+	default:
+		return nil, fmt.Errorf("unknown format %q; formats are %s and %s", f, notifyFormatJSON, notifyFormatAlertmanager)
+	}
+
+	var err error
+	if to := section["timeout"]; to != "" {
+		cfg.timeout, err = time.ParseDuration(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration %q: %v", to, err)
+		}
+	}
+
+	cfg.headers, err = parseHeaders(section["headers"])
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// eventLogConfig configures an eventLogger.
+type eventLogConfig struct {
+	// output is "stdout", the path of a file, or, if network is set, the
+	// address of a syslog server.
+	output  string
+	network string
+
+	// events are the keys of the events which are logged; if empty, every
+	// event is logged.
+	events map[string]bool
+}
+
+// parseEventLogConfig parses the eventlog section of the configuration file.
+// output is stdout, the path of a file to append to, or syslog://host:port
+// or syslog+tcp://host:port for a syslog server reached over UDP or TCP.
+// events is an optional comma-separated list of the keys of the events which
+// are logged.  A nil eventLogConfig is returned if no output is set.
+func parseEventLogConfig(section map[string]string) (*eventLogConfig, error) {
+	output := section["output"]
+	if output == "" {
+		return nil, nil
+	}
+
+	cfg := &eventLogConfig{
+		output: output,
+		events: make(map[string]bool),
+	}
+
+	for prefix, network := range map[string]string{
+		"syslog://":     "udp",
+		"syslog+tcp://": "tcp",
+	} {
+		if !strings.HasPrefix(output, prefix) {
+			continue
+		}
+
+		addr := strings.TrimPrefix(output, prefix)
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "514")
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil || strings.HasPrefix(addr, ":") {
+			return nil, fmt.Errorf("invalid syslog server address %q", output)
+		}
+
+		cfg.output, cfg.network = addr, network
+	}
+
+	for _, key := range strings.Split(section["events"], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			cfg.events[key] = true
+		}
+	}
+
+	return cfg, nil
+}
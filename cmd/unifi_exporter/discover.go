@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// runDiscover finds the UniFi devices on the local network with the Ubiquiti
+// discovery protocol, and writes them to w, consoles first, so that the
+// address of a UniFi OS console can be found without knowing it beforehand.
+func runDiscover(w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *discoverTimeout)
+	defer cancel()
+
+	devices, err := api.Discover(ctx, *discoverAddress)
+	if err != nil {
+		return fmt.Errorf("failed to discover UniFi devices: %v", err)
+	}
+	if len(devices) == 0 {
+		return errors.New("no UniFi devices replied; discovery only reaches devices on the same network segment as the exporter")
+	}
+
+	writeDiscovered(w, devices)
+	return nil
+}
+
+// writeDiscovered writes a table of devices to w, with the address to set as
+// unifi.address for each console.
+func writeDiscovered(w io.Writer, devices []*api.DiscoveredDevice) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "ADDRESS\tMAC\tHOSTNAME\tMODEL\tFIRMWARE")
+	for _, console := range []bool{true, false} {
+		for _, d := range devices {
+			if d.Console() != console {
+				continue
+			}
+
+			// Devices managed by a controller cannot be scraped
+			addr := "-"
+			if console && d.IP != nil {
+				addr = "https://" + d.IP.String()
+			}
+
+			model := d.Model
+			if model == "" {
+				model = d.Platform
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", addr, d.MAC, d.Hostname, model, d.Firmware)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+func Test_writeDiscovered(t *testing.T) {
+	var buf bytes.Buffer
+	writeDiscovered(&buf, []*api.DiscoveredDevice{
+		{
+			MAC:      net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x02},
+			IP:       net.IPv4(192, 168, 1, 20),
+			Hostname: "office-ap",
+			Platform: "U7PG2",
+			Firmware: "6.5.28",
+		},
+		{
+			MAC:      net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01},
+			IP:       net.IPv4(192, 168, 1, 1),
+			Hostname: "udm",
+			Platform: "UDMPRO",
+			Model:    "UniFi Dream Machine Pro",
+			Firmware: "3.2.9",
+		},
+	})
+
+	// The console is listed first, with the address to scrape it at
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if want, got := 3, len(lines); want != got {
+		t.Fatalf("unexpected number of lines:\n- want: %v\n-  got: %v\n%s", want, got, buf.String())
+	}
+
+	for i, want := range [][]string{
+		{"ADDRESS", "MAC", "HOSTNAME", "MODEL", "FIRMWARE"},
+		{"https://192.168.1.1", "f0:9f:c2:00:00:01", "udm", "UniFi", "Dream", "Machine", "Pro", "3.2.9"},
+		{"-", "f0:9f:c2:00:00:02", "office-ap", "U7PG2", "6.5.28"},
+	} {
+		if got := strings.Fields(lines[i]); strings.Join(want, " ") != strings.Join(got, " ") {
+			t.Fatalf("unexpected line %d:\n- want: %v\n-  got: %v", i, want, got)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A metricDoc documents a single metric exported by unifi_exporter.
+type metricDoc struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+// descRE parses the output of (*prometheus.Desc).String, which is the only
+// way to retrieve the name, help and labels of a Desc.
+var descRE = regexp.MustCompile(`^Desc\{fqName: ("(?:[^"\\]|\\.)*"), help: ("(?:[^"\\]|\\.)*"), constLabels: \{.*\}, variableLabels: \[(.*)\]\}$`)
+
+// exportedMetrics returns documentation for every metric exported by
+// unifi_exporter, sorted by name.  The metrics of the UniFi collectors are
+// described with every collector enabled, so that the list is complete no
+// matter which collectors are configured.
+func exportedMetrics() ([]metricDoc, error) {
+	e, err := exporter.NewFromController(nil, nil,
+		exporter.EnableDPI(0),
+		exporter.EnableSiteInfo(),
+		exporter.EnableCollector(exporter.CollectorEvents),
+		exporter.EnableCollector(exporter.CollectorProtect),
+		exporter.EnableCollector(exporter.CollectorAccess),
+		exporter.EnableCollector(exporter.CollectorKnownClients),
+		exporter.EnableCollector(exporter.CollectorBackups),
+		exporter.ServeStale(time.Minute),
+		exporter.Logger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		e.Describe(ch)
+		prometheus.NewGaugeFunc(snapshotAgeOpts, nil).Describe(ch)
+		clientRequestDuration.Describe(ch)
+		clientRequests.Describe(ch)
+		close(ch)
+	}()
+
+	docs := make(map[string]metricDoc)
+	for d := range ch {
+		m := descRE.FindStringSubmatch(d.String())
+		if m == nil {
+			return nil, fmt.Errorf("failed to parse descriptor: %s", d)
+		}
+
+		name, err := strconv.Unquote(m[1])
+		if err != nil {
+			return nil, err
+		}
+		help, err := strconv.Unquote(m[2])
+		if err != nil {
+			return nil, err
+		}
+
+		docs[name] = metricDoc{
+			Name:   name,
+			Help:   help,
+			Labels: strings.Fields(m[3]),
+		}
+	}
+
+	// Metrics about the exporter itself are registered with the default
+	// registry, alongside the Go runtime and process metrics.  Those with
+	// labels are only gathered once they have a value, so are described above
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), "unifi_") {
+			continue
+		}
+
+		var labels []string
+		if len(mf.Metric) > 0 {
+			for _, lp := range mf.Metric[0].Label {
+				labels = append(labels, lp.GetName())
+			}
+		}
+
+		docs[mf.GetName()] = metricDoc{
+			Name:   mf.GetName(),
+			Help:   mf.GetHelp(),
+			Labels: labels,
+		}
+	}
+
+	out := make([]metricDoc, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+// runDocs writes a markdown reference of every metric exported by
+// unifi_exporter to w.
+func runDocs(w io.Writer) error {
+	docs, err := exportedMetrics()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, docsHeader)
+	for _, d := range docs {
+		labels := make([]string, 0, len(d.Labels))
+		for _, l := range d.Labels {
+			labels = append(labels, "`"+l+"`")
+		}
+
+		fmt.Fprintf(w, "| `%s` | %s | %s |\n",
+			d.Name,
+			strings.Join(labels, ", "),
+			strings.Replace(d.Help, "|", `\|`, -1),
+		)
+	}
+
+	return nil
+}
+
+// docsHeader introduces the metrics reference written by runDocs.
+const docsHeader = `# unifi_exporter metrics
+
+This reference is generated by ` + "`unifi_exporter docs`" + `.  When a controller
+has a ` + "`name`" + `, every UniFi metric also carries a ` + "`controller`" + ` label, and labels
+from the ` + "`labels`" + ` section of the config file are added to every metric.
+
+| Metric | Labels | Help |
+| ------ | ------ | ---- |
+`
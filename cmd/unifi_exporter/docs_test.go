@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func Test_runDocs(t *testing.T) {
+	var buf bytes.Buffer
+	if err := runDocs(&buf); err != nil {
+		t.Fatalf("failed to write metrics reference: %v", err)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^\\| `unifi_up` \\|  \\| Whether the last scrape"),
+		regexp.MustCompile("(?m)^\\| `unifi_devices_uptime_seconds_total` \\| `site`, `id`, `mac`, `name` \\| Device uptime"),
+		regexp.MustCompile("(?m)^\\| `unifi_stations_dpi_received_bytes_total` \\| "),
+		regexp.MustCompile("(?m)^\\| `unifi_exporter_build_info` \\| `goversion`, `revision`, `version` \\| "),
+		regexp.MustCompile("(?m)^\\| `unifi_snapshot_age_seconds` \\| "),
+	}
+
+	for i, m := range matches {
+		t.Logf("[%02d] match: %s", i, m.String())
+
+		if !m.Match(buf.Bytes()) {
+			t.Fatalf("\toutput failed to match regex:\n%s", buf.String())
+		}
+	}
+
+	if regexp.MustCompile("`go_|`process_").Match(buf.Bytes()) {
+		t.Fatalf("output contains Go runtime or process metrics:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// dumpEndpoints are the site-specific UniFi Controller endpoints whose
+// responses are written by runDump, by kind of data.  Clients are paged, and
+// retrieved a page at a time, as the clients collector does, so that the
+// controller does not truncate the response.
+var dumpEndpoints = []struct {
+	kind     string
+	endpoint string
+	paged    bool
+}{
+	{kind: "devices", endpoint: "/api/s/%s/stat/device"},
+	{kind: "clients", endpoint: "/api/s/%s/stat/sta", paged: true},
+}
+
+// A dumpResponse is a single raw response from a UniFi Controller.
+type dumpResponse struct {
+	Controller string          `json:"controller"`
+	Site       string          `json:"site"`
+	Kind       string          `json:"kind"`
+	Endpoint   string          `json:"endpoint"`
+	Error      string          `json:"error,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+}
+
+// runDump writes the raw JSON responses of the UniFi Controller for the
+// devices and clients of each selected site of each controller in config to w,
+// so that they can be attached to bug reports.  Requests which fail, and
+// controllers which cannot be reached, are recorded with their error, rather
+// than ending the dump.
+func runDump(ctx context.Context, w io.Writer, config *Config) error {
+	sections, err := controllerSections(config)
+	if err != nil {
+		return fmt.Errorf("invalid controllers configuration: %v", err)
+	}
+
+	responses := make([]dumpResponse, 0)
+	for _, section := range sections {
+		rs, err := dumpController(ctx, section, config.Collectors)
+		if err != nil {
+			rs = append(rs, dumpResponse{
+				Controller: section["name"],
+				Error:      err.Error(),
+			})
+		}
+
+		responses = append(responses, rs...)
+	}
+
+	b, err := json.MarshalIndent(responses, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// dumpController retrieves the raw responses for each selected site of the
+// UniFi Controller configured by section.
+func dumpController(ctx context.Context, section map[string]string, collectors map[string]string) ([]dumpResponse, error) {
+	cfg, err := parseControllerConfig(section, collectors)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newClient(cfg.client)(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := c.(*api.Client)
+	defer func() {
+		_ = client.Logout(ctx)
+	}()
+
+	all, err := client.Sites(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve list of sites: %v", err)
+	}
+
+	sites, err := selectSites(cfg.site, cfg.shard, all)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []dumpResponse
+	for _, s := range sites {
+		for _, e := range dumpEndpoints {
+			r := dumpResponse{
+				Controller: cfg.name,
+				Site:       s.Name,
+				Kind:       e.kind,
+				Endpoint:   fmt.Sprintf(e.endpoint, s.Name),
+			}
+
+			r.Response, err = dumpEndpoint(ctx, client, s.Name, r.Endpoint, e.paged)
+			if err != nil {
+				r.Error = err.Error()
+			}
+
+			responses = append(responses, r)
+		}
+	}
+
+	return responses, nil
+}
+
+// dumpEndpoint retrieves the raw response of endpoint for a site.  The pages
+// of clients are combined into a single response of the same form.
+func dumpEndpoint(ctx context.Context, client *api.Client, site string, endpoint string, paged bool) (json.RawMessage, error) {
+	if !paged {
+		return client.Raw(ctx, endpoint)
+	}
+
+	raws, err := client.RawClients(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Data []json.RawMessage `json:"data"`
+	}{Data: raws})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+)
+
+func Test_runDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	config, err := loadConfig(testConfigFile(t, dir, unifi.URL, ""))
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := runDump(context.Background(), &buf, config); err != nil {
+		t.Fatalf("failed to dump responses: %v", err)
+	}
+
+	var responses []struct {
+		Site     string `json:"site"`
+		Kind     string `json:"kind"`
+		Endpoint string `json:"endpoint"`
+		Error    string `json:"error"`
+		Response struct {
+			Data []json.RawMessage `json:"data"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &responses); err != nil {
+		t.Fatalf("failed to decode dump: %v\n%s", err, buf.String())
+	}
+
+	if want, got := 2, len(responses); want != got {
+		t.Fatalf("unexpected number of responses:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	for i, want := range []struct {
+		endpoint string
+		objects  int
+	}{
+		{endpoint: "/api/s/" + apitest.DefaultSite + "/stat/device", objects: 1},
+		{endpoint: "/api/s/" + apitest.DefaultSite + "/stat/sta", objects: 2},
+	} {
+		r := responses[i]
+		if r.Error != "" {
+			t.Fatalf("unexpected error for %q: %v", r.Endpoint, r.Error)
+		}
+		if want, got := want.endpoint, r.Endpoint; want != got {
+			t.Fatalf("unexpected endpoint:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := want.objects, len(r.Response.Data); want != got {
+			t.Fatalf("unexpected number of objects for %q:\n- want: %v\n-  got: %v", r.Endpoint, want, got)
+		}
+	}
+}
+
+func Test_runDumpUnreachableController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	foo, bar := apitest.NewServer(), apitest.NewServer()
+	defer foo.Close()
+	defer bar.Close()
+
+	bar.SetCredentials(apitest.Username, "other")
+
+	config := fmt.Sprintf(`
+unifi:
+  username: %s
+  password: %s
+controllers:
+  - name: bar
+    address: %s
+  - name: foo
+    address: %s
+`, apitest.Username, apitest.Password, bar.URL, foo.URL)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := runDump(context.Background(), &buf, c); err != nil {
+		t.Fatalf("failed to dump responses: %v", err)
+	}
+
+	var responses []struct {
+		Controller string `json:"controller"`
+		Endpoint   string `json:"endpoint"`
+		Error      string `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &responses); err != nil {
+		t.Fatalf("failed to decode dump: %v\n%s", err, buf.String())
+	}
+
+	if want, got := 3, len(responses); want != got {
+		t.Fatalf("unexpected number of responses:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if r := responses[0]; r.Controller != "bar" || r.Error == "" {
+		t.Fatalf("expected an error for controller bar, but got: %+v", r)
+	}
+	for _, r := range responses[1:] {
+		if r.Controller != "foo" || r.Error != "" {
+			t.Fatalf("expected a response from controller foo, but got: %+v", r)
+		}
+	}
+}
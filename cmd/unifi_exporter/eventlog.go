@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	eventLogFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "unifi_exporter",
+		Name:      "eventlog_write_failures_total",
+		Help:      "Number of events which could not be written to the event log.",
+	})
+
+	eventLogDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "unifi_exporter",
+		Name:      "eventlog_dropped_total",
+		Help:      "Number of events which were not written to the event log because too many were waiting to be written.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(eventLogFailures, eventLogDropped)
+}
+
+// eventLogQueueSize is the number of events which may wait to be written
+// before further events are dropped.
+const eventLogQueueSize = 100
+
+// An eventLogger writes each selected event streamed from a UniFi Controller
+// as a line of JSON, so that events can be collected by a log aggregator
+// alongside the exporter's metrics.
+type eventLogger struct {
+	events map[string]bool
+	w      io.WriteCloser
+	queue  chan notification
+
+	// done is closed once every queued event has been written, after
+	// the eventLogger is closed.
+	done chan struct{}
+
+	// mu guards closed, so that no event is queued after queue is closed.
+	mu     sync.Mutex
+	closed bool
+}
+
+// newEventLogger creates an eventLogger which writes to the output set by
+// cfg, opening its file or dialing its syslog server as needed.
+func newEventLogger(cfg eventLogConfig) (*eventLogger, error) {
+	var w io.WriteCloser
+	switch {
+	case cfg.network != "":
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "-"
+		}
+
+		w = &syslogWriter{
+			network:  cfg.network,
+			addr:     cfg.output,
+			hostname: hostname,
+		}
+	case cfg.output == "stdout":
+		w = nopCloser{os.Stdout}
+	default:
+		f, err := os.OpenFile(cfg.output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event log: %v", err)
+		}
+
+		w = f
+	}
+
+	return startEventLogger(w, cfg.events), nil
+}
+
+// startEventLogger creates an eventLogger which writes the selected events to
+// w, and begins writing queued events until it is closed.
+func startEventLogger(w io.WriteCloser, events map[string]bool) *eventLogger {
+	l := &eventLogger{
+		events: events,
+		w:      w,
+		queue:  make(chan notification, eventLogQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(l.done)
+
+		for nt := range l.queue {
+			if err := l.write(nt); err != nil {
+				eventLogFailures.Inc()
+				log.Printf("[ERROR] failed to write event %s to event log: %v", nt.event.Key, err)
+			}
+		}
+	}()
+
+	return l
+}
+
+// close writes any events which are still queued, and closes the event log's
+// file or syslog connection.  Events passed to its handlers afterwards are
+// dropped.
+func (l *eventLogger) close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+	return l.w.Close()
+}
+
+// handler returns an exporter.EventFunc which queues each selected event from
+// the named controller to be written to the event log.  Events are streamed
+// as they occur, so, as with notifications, they are dropped rather than
+// delaying the stream when a slow file or syslog server lets the queue fill.
+func (l *eventLogger) handler(controller string) exporter.EventFunc {
+	return func(site *api.Site, ev *api.Event) {
+		if len(l.events) > 0 && !l.events[ev.Key] {
+			return
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.closed {
+			return
+		}
+
+		select {
+		case l.queue <- notification{controller: controller, site: site, event: ev}:
+		default:
+			eventLogDropped.Inc()
+			log.Printf("[WARN] dropped event %s in site %q from event log: too many events are waiting to be written", ev.Key, site.Name)
+		}
+	}
+}
+
+// write writes the eventRecord of nt as a line of JSON.
+func (l *eventLogger) write(nt notification) error {
+	b, err := json.Marshal(newEventRecord(nt))
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	_, err = l.w.Write(b)
+	return err
+}
+
+// A nopCloser is an io.WriteCloser which does not close its io.Writer, such
+// as for os.Stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer.
+func (nopCloser) Close() error { return nil }
+
+// syslogPriority is the priority of syslog messages written by a
+// syslogWriter: the local0 facility, at informational severity.
+const syslogPriority = 16*8 + 6
+
+// A syslogWriter writes each line written to it as an RFC 5424 syslog message.
+// The log/syslog package is not available on every platform, and cannot send
+// RFC 5424 messages, so messages are formatted here.  The syslog server is
+// dialed again for the next message after a write fails.
+type syslogWriter struct {
+	network  string
+	addr     string
+	hostname string
+
+	conn net.Conn
+}
+
+// Write implements io.Writer.
+func (w *syslogWriter) Write(b []byte) (int, error) {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.addr, 10*time.Second)
+		if err != nil {
+			return 0, err
+		}
+
+		w.conn = conn
+	}
+
+	// Messages are framed by newlines, which UDP syslog servers ignore
+	msg := fmt.Sprintf("<%d>1 %s %s unifi_exporter - - - %s\n",
+		syslogPriority, time.Now().UTC().Format(time.RFC3339Nano), w.hostname, bytes.TrimSpace(b))
+	if _, err := io.WriteString(w.conn, msg); err != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Close implements io.Closer.
+func (w *syslogWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
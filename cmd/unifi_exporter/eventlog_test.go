@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_eventLoggerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi_exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	l, err := newEventLogger(eventLogConfig{
+		output: path,
+		events: map[string]bool{"EVT_AP_Lost_Contact": true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create event logger: %v", err)
+	}
+
+	fn := l.handler("foo")
+	site := &api.Site{Name: "default", Description: "Default"}
+	fn(site, &api.Event{Key: "EVT_WU_Roam"})
+	fn(site, &api.Event{
+		Key:  "EVT_AP_Lost_Contact",
+		Time: time.Unix(1500000000, 0).UTC(),
+		Raw:  json.RawMessage(`{"key":"EVT_AP_Lost_Contact"}`),
+	})
+
+	if err := l.close(); err != nil {
+		t.Fatalf("failed to close event logger: %v", err)
+	}
+
+	// Events after close are dropped
+	fn(site, &api.Event{Key: "EVT_AP_Lost_Contact"})
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read event log: %v", err)
+	}
+
+	want := `{"controller":"foo","site":"default","site_description":"Default","key":"EVT_AP_Lost_Contact","subsystem":"","message":"","time":"2017-07-14T02:40:00Z","event":{"key":"EVT_AP_Lost_Contact"}}` + "\n"
+	if got := string(b); want != got {
+		t.Fatalf("unexpected event log:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_eventLoggerSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	l, err := newEventLogger(eventLogConfig{
+		output:  conn.LocalAddr().String(),
+		network: "udp",
+	})
+	if err != nil {
+		t.Fatalf("failed to create event logger: %v", err)
+	}
+	defer l.close()
+
+	l.handler("")(&api.Site{Name: "default"}, &api.Event{Key: "EVT_WU_Roam"})
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("failed to read syslog message: %v", err)
+	}
+
+	re := regexp.MustCompile(`^<134>1 \S+ \S+ unifi_exporter - - - {"site":"default",.*"key":"EVT_WU_Roam",.*}\n$`)
+	if !re.Match(b[:n]) {
+		t.Fatalf("unexpected syslog message: %q", b[:n])
+	}
+}
+
+func Test_eventLoggerQueueFull(t *testing.T) {
+	w := &blockingWriter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	l := startEventLogger(w, nil)
+
+	var m dto.Metric
+	if err := eventLogDropped.Write(&m); err != nil {
+		t.Fatalf("failed to read dropped events: %v", err)
+	}
+	before := m.GetCounter().GetValue()
+
+	fn := l.handler("foo")
+	site := &api.Site{Name: "default"}
+
+	// The first event blocks the writer, and the rest fill its queue
+	fn(site, &api.Event{Key: "EVT_WU_Roam"})
+	select {
+	case <-w.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not written")
+	}
+
+	const extra = 10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < eventLogQueueSize+extra; i++ {
+			fn(site, &api.Event{Key: "EVT_WU_Roam"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event handler blocked on a slow event log")
+	}
+
+	if err := eventLogDropped.Write(&m); err != nil {
+		t.Fatalf("failed to read dropped events: %v", err)
+	}
+	if want, got := float64(extra), m.GetCounter().GetValue()-before; want != got {
+		t.Fatalf("unexpected number of dropped events:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	close(w.release)
+	if err := l.close(); err != nil {
+		t.Fatalf("failed to close event logger: %v", err)
+	}
+
+	if want, got := eventLogQueueSize+1, w.lines(); want != got {
+		t.Fatalf("unexpected number of written events:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// A blockingWriter is an io.WriteCloser which signals started on its first
+// write, and blocks every write until release is closed.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu sync.Mutex
+	n  int
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.n++
+	if w.n == 1 {
+		close(w.started)
+	}
+	w.mu.Unlock()
+
+	<-w.release
+	return len(b), nil
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func (w *blockingWriter) lines() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.n
+}
+
+func Test_parseEventLogConfig(t *testing.T) {
+	tests := []struct {
+		desc    string
+		section map[string]string
+		want    *eventLogConfig
+	}{
+		{
+			desc: "no output",
+		},
+		{
+			desc:    "stdout",
+			section: map[string]string{"output": "stdout"},
+			want: &eventLogConfig{
+				output: "stdout",
+				events: map[string]bool{},
+			},
+		},
+		{
+			desc: "file with events",
+			section: map[string]string{
+				"output": "/var/log/events.log",
+				"events": "EVT_AP_Lost_Contact, EVT_WU_Roam",
+			},
+			want: &eventLogConfig{
+				output: "/var/log/events.log",
+				events: map[string]bool{
+					"EVT_AP_Lost_Contact": true,
+					"EVT_WU_Roam":         true,
+				},
+			},
+		},
+		{
+			desc:    "syslog default port",
+			section: map[string]string{"output": "syslog://loghost"},
+			want: &eventLogConfig{
+				output:  "loghost:514",
+				network: "udp",
+				events:  map[string]bool{},
+			},
+		},
+		{
+			desc:    "syslog over TCP",
+			section: map[string]string{"output": "syslog+tcp://loghost:6514"},
+			want: &eventLogConfig{
+				output:  "loghost:6514",
+				network: "tcp",
+				events:  map[string]bool{},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		cfg, err := parseEventLogConfig(tt.section)
+		if err != nil {
+			t.Fatalf("failed to parse eventlog section: %v", err)
+		}
+
+		if want, got := tt.want, cfg; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected eventlog configuration:\n- want: %+v\n-  got: %+v", want, got)
+		}
+	}
+
+	if _, err := parseEventLogConfig(map[string]string{"output": "syslog://"}); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
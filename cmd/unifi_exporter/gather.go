@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A labelGatherer is a prometheus.Gatherer which adds a constant label to
+// every metric gathered by another prometheus.Gatherer.  Metrics which
+// already have a label of the same name keep their own value.
+type labelGatherer struct {
+	g     prometheus.Gatherer
+	name  string
+	value string
+}
+
+// Gather implements prometheus.Gatherer.
+func (lg *labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := lg.g.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if hasLabel(m, lg.name) {
+				continue
+			}
+
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String(lg.name),
+				Value: proto.String(lg.value),
+			})
+		}
+	}
+
+	return mfs, err
+}
+
+// hasLabel reports whether m has a label with the specified name.
+func hasLabel(m *dto.Metric, name string) bool {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// constLabelGatherer returns a prometheus.Gatherer which adds each of labels
+// to every metric gathered by g.
+func constLabelGatherer(g prometheus.Gatherer, labels map[string]string) prometheus.Gatherer {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		g = &labelGatherer{
+			g:     g,
+			name:  name,
+			value: labels[name],
+		}
+	}
+
+	return g
+}
+
+// concurrentGatherer returns a prometheus.Gatherer which invokes each of gs
+// concurrently, and merges their results as prometheus.Gatherers does.
+func concurrentGatherer(gs []prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		type result struct {
+			mfs []*dto.MetricFamily
+			err error
+		}
+
+		results := make([]result, len(gs))
+
+		var wg sync.WaitGroup
+		wg.Add(len(gs))
+		for i, g := range gs {
+			go func(i int, g prometheus.Gatherer) {
+				defer wg.Done()
+
+				mfs, err := g.Gather()
+				results[i] = result{mfs: mfs, err: err}
+			}(i, g)
+		}
+		wg.Wait()
+
+		merged := make(prometheus.Gatherers, 0, len(results))
+		for _, r := range results {
+			r := r
+			merged = append(merged, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return r.mfs, r.err
+			}))
+		}
+
+		return merged.Gather()
+	})
+}
+
+// gatherer returns a prometheus.Gatherer which applies f to the metrics
+// gathered by g.  If f is nil, g is returned.
+func (f *metricFilter) gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if f == nil {
+		return g
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		out := make([]*dto.MetricFamily, 0, len(mfs))
+		for _, mf := range mfs {
+			name := mf.GetName()
+			if f.allow != nil && !f.allow.MatchString(name) {
+				continue
+			}
+			if f.deny != nil && f.deny.MatchString(name) {
+				continue
+			}
+
+			if len(f.dropLabels) > 0 {
+				mf.Metric = f.dropMetricLabels(mf.Metric)
+			}
+
+			out = append(out, mf)
+		}
+
+		return out, err
+	})
+}
+
+// dropMetricLabels removes the labels in f.dropLabels from each of ms.
+// Metrics which are no longer distinguishable from an earlier metric once
+// their labels are removed are dropped, because a metric family must not
+// contain duplicate series.
+func (f *metricFilter) dropMetricLabels(ms []*dto.Metric) []*dto.Metric {
+	seen := make(map[string]bool, len(ms))
+	out := ms[:0]
+	for _, m := range ms {
+		labels := m.Label[:0]
+		for _, l := range m.Label {
+			if !f.dropLabels[l.GetName()] {
+				labels = append(labels, l)
+			}
+		}
+		m.Label = labels
+
+		sig := labelSignature(labels)
+		if seen[sig] {
+			continue
+		}
+		seen[sig] = true
+
+		out = append(out, m)
+	}
+
+	return out
+}
+
+// labelSignature returns a string which uniquely identifies a set of labels.
+func labelSignature(labels []*dto.LabelPair) string {
+	ss := make([]string, 0, len(labels))
+	for _, l := range labels {
+		ss = append(ss, l.GetName()+"\xff"+l.GetValue())
+	}
+	sort.Strings(ss)
+
+	return strings.Join(ss, "\xfe")
+}
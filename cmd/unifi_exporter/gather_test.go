@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_metricFilter(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		section map[string]string
+		want    []string
+	}{
+		{
+			desc: "no filter",
+			want: []string{
+				"unifi_devices{site=Default}",
+				"unifi_stations{id=1,site=Default}",
+				"unifi_stations{id=2,site=Default}",
+			},
+		},
+		{
+			desc:    "allow",
+			section: map[string]string{"allow": "unifi_dev.*"},
+			want: []string{
+				"unifi_devices{site=Default}",
+			},
+		},
+		{
+			desc:    "allow matches entire name",
+			section: map[string]string{"allow": "unifi_dev"},
+		},
+		{
+			desc:    "deny",
+			section: map[string]string{"deny": "unifi_devices|foo"},
+			want: []string{
+				"unifi_stations{id=1,site=Default}",
+				"unifi_stations{id=2,site=Default}",
+			},
+		},
+		{
+			desc:    "drop labels",
+			section: map[string]string{"droplabels": "id, foo"},
+			want: []string{
+				"unifi_devices{site=Default}",
+				"unifi_stations{site=Default}",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		f, err := parseMetricFilter(tt.section)
+		if err != nil {
+			t.Fatalf("failed to parse filter: %v", err)
+		}
+
+		reg := prometheus.NewRegistry()
+		devices := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_devices", Help: "Devices."}, []string{"site"})
+		stations := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_stations", Help: "Stations."}, []string{"site", "id"})
+		reg.MustRegister(devices, stations)
+
+		devices.WithLabelValues("Default").Set(1)
+		stations.WithLabelValues("Default", "1").Set(1)
+		stations.WithLabelValues("Default", "2").Set(1)
+
+		mfs, err := f.gatherer(reg).Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+
+		var got []string
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				var labels []string
+				for _, l := range m.Label {
+					labels = append(labels, l.GetName()+"="+l.GetValue())
+				}
+				sort.Strings(labels)
+
+				got = append(got, mf.GetName()+"{"+strings.Join(labels, ",")+"}")
+			}
+		}
+
+		if want := tt.want; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected metrics:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func Test_parseMetricFilterInvalid(t *testing.T) {
+	for i, section := range []map[string]string{
+		{"allow": "("},
+		{"deny": "[a-"},
+	} {
+		t.Logf("[%02d] section: %v", i, section)
+
+		if _, err := parseMetricFilter(section); err == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+	}
+}
+
+func Test_constLabelGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	devices := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_devices", Help: "Devices."}, []string{"site"})
+	reg.MustRegister(devices)
+	devices.WithLabelValues("Default").Set(1)
+
+	// Labels which a metric already has are left as they are
+	g := constLabelGatherer(reg, map[string]string{
+		"environment": "prod",
+		"region":      "us-east",
+		"site":        "foo",
+	})
+
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	var got []string
+	for _, l := range mfs[0].Metric[0].Label {
+		got = append(got, l.GetName()+"="+l.GetValue())
+	}
+	sort.Strings(got)
+
+	want := []string{"environment=prod", "region=us-east", "site=Default"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected labels:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_parseConstLabelsInvalid(t *testing.T) {
+	for i, name := range []string{"", "1st", "foo-bar", "__name__"} {
+		t.Logf("[%02d] label name: %q", i, name)
+
+		if _, err := parseConstLabels(map[string]string{name: "value"}); err == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixScheme prefixes listen addresses which are the paths of Unix domain
+// sockets, such as unix:///run/unifi_exporter.sock.
+const unixScheme = "unix://"
+
+// listen returns a listener for addr, a TCP address such as :9130, or the path
+// of a Unix domain socket prefixed with unix://.  A socket left behind by an
+// exporter which did not shut down cleanly is removed first; the socket is
+// removed again when the listener is closed.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixScheme) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, unixScheme)
+	if path == "" {
+		return nil, fmt.Errorf("listen address %q must include the path of a socket", addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+// removeStaleSocket removes the Unix domain socket at path if nothing accepts
+// connections on it.  Files which are not sockets are left in place, so that
+// a mistyped path cannot delete them.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listen socket %q already exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("listen socket %q is already in use", path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_listenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "unifi_exporter.sock")
+
+	// Leave a stale socket behind, as an exporter which was killed would
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+
+	l, err = listen(unixScheme + path)
+	if err != nil {
+		t.Fatalf("failed to listen on stale socket: %v", err)
+	}
+
+	if _, err := listen(unixScheme + path); err == nil {
+		t.Fatal("expected an error listening on a socket in use, but none occurred")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to socket: %v", err)
+	}
+	_ = conn.Close()
+
+	// Closing the listener removes its socket
+	_ = l.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket was not removed: %v", err)
+	}
+
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := listen(unixScheme + file); err == nil {
+		t.Fatal("expected an error listening on a regular file, but none occurred")
+	}
+	if _, err := os.Lstat(file); err != nil {
+		t.Fatalf("regular file was removed: %v", err)
+	}
+}
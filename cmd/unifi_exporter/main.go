@@ -3,77 +3,146 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
 	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
 	"github.com/prometheus/client_golang/prometheus"
-	"gopkg.in/yaml.v2"
 )
 
-type Config struct {
-	Listen map[string]string `yaml:"listen"`
-	Unifi  map[string]string `yaml:"unifi"`
-}
-
 const (
 	// userAgent is ther user agent reported to the UniFi Controller API.
 	userAgent = "github.com/bah2830/unifi_exporter"
+
+	// shutdownTimeout bounds the time spent waiting for requests to complete
+	// and logging out of each UniFi Controller on shutdown.
+	shutdownTimeout = 10 * time.Second
 )
 
-func main() {
-	var configFile = flag.String("config.file", "", "Relative path to config file yaml")
-	flag.Parse()
+var (
+	configFile    = flag.String("config.file", "", "Relative path to config file yaml")
+	listenAddress = flag.String("listen.address", "", "Address on which to serve metrics, such as :9130, or unix:///run/unifi_exporter.sock for a Unix domain socket (overrides listen.address in config file)")
+	showVersion   = flag.Bool("version", false, "Print version information and exit")
 
-	var config Config
-	source, err := ioutil.ReadFile(*configFile)
-	if err != nil {
-		log.Fatalf("failed to read config file %q: %v", *configFile, err)
+	// Flags of the discover command.
+	discoverAddress = flag.String("discover.address", api.DiscoveryAddr, "Address to which the discover command sends its probe, such as the broadcast address of one network of a host attached to several")
+	discoverTimeout = flag.Duration("discover.timeout", 3*time.Second, "Time for which the discover command waits for UniFi devices to reply")
+
+	// Flags which override their equivalent keys in the unifi section of
+	// the config file.
+	unifiShard               = flag.String("unifi.shard", "", "Part of the selected sites exported by this exporter, of the form index/count such as 2/4, to split a UniFi Controller among several exporters (overrides unifi.shard in config file)")
+	unifiSite                = flag.String("unifi.site", "", "Comma-separated names, descriptions, or /regexps/ of the sites to export, each prefixed with ! to exclude instead (overrides unifi.site in config file)")
+	unifiSiteRefresh         = flag.String("unifi.site-refresh-interval", "", "Interval at which the list of sites is retrieved again from the UniFi Controller, so that new sites are exported without a restart (overrides unifi.siterefreshinterval in config file)")
+	unifiSkipDisconnected    = flag.String("unifi.skip-disconnected", "", "If true, devices which are disconnected or have missed heartbeats are only exported by unifi_devices_state (overrides unifi.skipdisconnected in config file)")
+	unifiStationNameLabels   = flag.String("unifi.station-name-labels", "", "If true, client metrics carry the client's hostname and its alias in the controller as separate hostname and name labels (overrides unifi.stationnamelabels in config file)")
+	unifiUsername            = flag.String("unifi.username", "", "Username used to authenticate to the UniFi Controller (overrides unifi.username in config file)")
+	unifiPasswordFile        = flag.String("unifi.password-file", "", "File containing the password used to authenticate to the UniFi Controller (overrides unifi.passwordfile in config file)")
+	unifiTOTPSecretFile      = flag.String("unifi.totp-secret-file", "", "File containing the TOTP secret used for two-factor authentication (overrides unifi.totpsecretfile in config file)")
+	unifiPrivacyKeyFile      = flag.String("unifi.privacy-key-file", "", "File containing the HMAC key used by privacy mode hash (overrides unifi.privacykeyfile in config file)")
+	unifiRecordDir           = flag.String("unifi.record-dir", "", "Directory to which all UniFi Controller responses are recorded (overrides unifi.recorddir in config file)")
+	unifiServeStale          = flag.String("unifi.serve-stale", "", "Maximum age of the last good metrics served for a collector while the UniFi Controller cannot be scraped (overrides unifi.servestale in config file)")
+	unifiResolveInterval     = flag.String("unifi.resolve-interval", "", "Interval at which idle connections to the UniFi Controller are closed, so that its hostname is resolved again (overrides unifi.resolveinterval in config file)")
+	unifiReplayDir           = flag.String("unifi.replay-dir", "", "Directory of recorded responses to serve instead of contacting the UniFi Controller (overrides unifi.replaydir in config file)")
+	unifiTimeout             = flag.String("unifi.timeout", "", "Overall timeout for each request to the UniFi Controller (overrides unifi.timeout in config file)")
+	unifiDialTimeout         = flag.String("unifi.dial-timeout", "", "Timeout for connecting to the UniFi Controller (overrides unifi.dialtimeout in config file)")
+	unifiBreakerFailures     = flag.String("unifi.breaker-failures", "", "Number of consecutive failed requests after which requests to the UniFi Controller are suspended for -unifi.breaker-cooldown; 0 disables the circuit breaker (overrides unifi.breakerfailures in config file)")
+	unifiBreakerCooldown     = flag.String("unifi.breaker-cooldown", "", "Time for which requests to the UniFi Controller are suspended once the circuit breaker opens (overrides unifi.breakercooldown in config file)")
+	unifiCAFile              = flag.String("unifi.ca-file", "", "Path to a PEM file of certificate authorities used to verify the UniFi Controller's certificate (overrides unifi.cafile in config file)")
+	unifiCertFile            = flag.String("unifi.cert-file", "", "Path to a PEM client certificate presented to the UniFi Controller (overrides unifi.certfile in config file)")
+	unifiKeyFile             = flag.String("unifi.key-file", "", "Path to the PEM private key for -unifi.cert-file (overrides unifi.keyfile in config file)")
+	unifiMaxRequests         = flag.String("unifi.max-concurrent-requests", "", "Maximum number of requests in flight to the UniFi Controller at once (overrides unifi.maxconcurrentrequests in config file)")
+	unifiPollInterval        = flag.String("unifi.poll-interval", "", "Interval at which metrics are collected in the background and cached, instead of on each scrape (overrides unifi.pollinterval in config file)")
+	unifiProxy               = flag.String("unifi.proxy", "", "URL of an HTTP(S) proxy used to reach the UniFi Controller, instead of HTTPS_PROXY (overrides unifi.proxy in config file)")
+	unifiTLSFingerprint      = flag.String("unifi.tls-fingerprint", "", "SHA-256 fingerprint of the UniFi Controller's certificate; only a matching certificate is accepted (overrides unifi.tlsfingerprint in config file)")
+	unifiTLSHandshakeTimeout = flag.String("unifi.tls-handshake-timeout", "", "Timeout for the TLS handshake with the UniFi Controller (overrides unifi.tlshandshaketimeout in config file)")
+
+	// collectorFlags enable or disable each collector, overriding the
+	// collectors section of the config file when set.
+	collectorFlags = map[string]*bool{
+		exporter.CollectorDevices: flag.Bool("collector.devices", true, "Enable the devices collector (overrides collectors.devices in config file)"),
+		exporter.CollectorClients: flag.Bool("collector.clients", true, "Enable the clients collector (overrides collectors.clients in config file)"),
+		exporter.CollectorDPI:     flag.Bool("collector.dpi", false, "Enable the DPI collector (overrides collectors.dpi in config file)"),
+		exporter.CollectorEvents:  flag.Bool("collector.events", false, "Enable the events collector, which counts events streamed from the UniFi Controller (overrides collectors.events in config file)"),
+		exporter.CollectorProtect: flag.Bool("collector.protect", false, "Enable the UniFi Protect collector, for cameras and NVRs on UniFi OS consoles (overrides collectors.protect in config file)"),
+		exporter.CollectorAccess:  flag.Bool("collector.access", false, "Enable the UniFi Access collector, which also requires unifi.accessaddress and unifi.accesstoken in config file (overrides collectors.access in config file)"),
+
+		exporter.CollectorKnownClients: flag.Bool("collector.knownclients", false, "Enable the known clients collector, which counts every client the UniFi Controller remembers, connected or not (overrides collectors.knownclients in config file)"),
+		exporter.CollectorBackups:      flag.Bool("collector.backups", false, "Enable the backups collector, which exports the age and size of the UniFi Controller's latest automatic backup (overrides collectors.backups in config file)"),
 	}
-	err = yaml.Unmarshal(source, &config)
-	if err != nil {
-		log.Fatalf("failed to read YAML from config file %q: %v", *configFile, err)
+)
+
+func main() {
+	// Commands, such as check, may precede or follow the flags
+	args := os.Args[1:]
+	var command string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	_ = flag.CommandLine.Parse(args)
+	if command == "" && flag.NArg() > 0 {
+		command = flag.Arg(0)
 	}
 
-	listenAddr := config.Listen["address"]
-	metricsPath := config.Listen["metricspath"]
-	unifiAddr := config.Unifi["address"]
-	username := config.Unifi["username"]
-	password := config.Unifi["password"]
-	site := config.Unifi["site"]
-
-	insecure := false
-	if ins, ok := config.Unifi["insecure"]; ok {
-		insecure, err = strconv.ParseBool(ins)
-		if err != nil {
-			log.Fatalf("failed to parse bool %s: %v", ins, err)
-		}
+	if *showVersion {
+		fmt.Println(versionString())
+		return
 	}
 
-	timeout := 5 * time.Second
-	if to, ok := config.Unifi["timeout"]; ok {
-		timeout, err = time.ParseDuration(to)
-		if err != nil {
-			log.Fatalf("failed to parse duration %q: %v", to, err)
+	// Commands which only describe the exporter need no configuration file
+	static := map[string]func(io.Writer) error{
+		"discover": runDiscover,
+		"docs":     runDocs,
+		"rules":    runRules,
+	}
+	if run, ok := static[command]; ok {
+		if err := run(os.Stdout); err != nil {
+			log.Fatal(err)
 		}
+
+		return
 	}
 
-	if unifiAddr == "" {
-		log.Fatal("address of UniFi Controller API must be specified within config file: ", *configFile)
+	commands := map[string]func(context.Context, io.Writer, *Config) error{
+		"check": runCheck,
+		"dump":  runDump,
 	}
-	if username == "" {
-		log.Fatal("username to authenticate to UniFi Controller API must be specified within config file: ", *configFile)
+	run, ok := commands[command]
+	if command != "" && !ok {
+		log.Fatalf("unknown command %q; commands are check, discover, docs, dump, and rules", command)
 	}
-	if password == "" {
-		log.Fatal("password to authenticate to UniFi Controller API must be specified within config file: ", *configFile)
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if run != nil {
+		if err := run(context.Background(), os.Stdout, config); err != nil {
+			log.Fatal(err)
+		}
+
+		return
 	}
+
+	listenAddr := config.Listen["address"]
+	metricsPath := config.Listen["metricspath"]
 	if listenAddr == "" {
 		// Set default port to 9130 if left blank in config.yml
 		listenAddr = ":9130"
@@ -81,67 +150,276 @@ func main() {
 	if metricsPath == "" {
 		metricsPath = "/metrics"
 	}
-
-	clientFn := newClient(
-		unifiAddr,
-		username,
-		password,
-		insecure,
-		timeout,
-	)
-	c, err := clientFn()
-	if err != nil {
-		log.Fatalf("failed to create client: %v", err)
+	if listenAddr == "none" && config.Textfile["path"] == "" && config.OTLP["endpoint"] == "" {
+		log.Fatalf("invalid listen configuration in config file %q: address none requires textfile.path or otlp.endpoint, or metrics are never exported", *configFile)
 	}
 
-	sites, err := c.Sites()
+	tlsConfig, err := listenTLSConfig(config.Listen)
 	if err != nil {
-		log.Fatalf("failed to retrieve list of sites: %v", err)
+		log.Fatalf("invalid listen configuration in config file %q: %v", *configFile, err)
 	}
 
-	useSites, err := pickSites(site, sites)
-	if err != nil {
-		log.Fatalf("failed to select a site: %v", err)
+	s := newServer(*configFile)
+	if err := s.apply(config); err != nil {
+		log.Fatal(err)
 	}
 
-	e, err := exporter.New(useSites, clientFn)
-	if err != nil {
-		log.Fatalf("failed to create exporter: %v", err)
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go s.reloadOnSignal(sighup)
+
+	http.Handle(metricsPath, s.authenticate(s.metricsHandler()))
+	http.Handle("/-/reload", s.reloadHandler())
+	http.Handle("/probe", s.authenticate(s.probeHandler()))
+	http.Handle("/sd", s.authenticate(s.sdHandler()))
+	http.Handle("/-/healthy", s.healthyHandler())
+	http.Handle("/-/ready", s.readyHandler())
+	http.Handle("/", s.indexHandler(metricsPath))
+
+	srv := &http.Server{
+		Addr:      listenAddr,
+		TLSConfig: tlsConfig,
 	}
 
-	prometheus.MustRegister(e)
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
 
-	http.Handle(metricsPath, prometheus.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, metricsPath, http.StatusMovedPermanently)
-	})
+	shutdownDone := make(chan struct{})
+	go func() {
+		sig := <-terminate
+		log.Printf("[INFO] received %s, shutting down", sig)
 
-	log.Printf("Starting UniFi exporter on %q for site(s): %s", listenAddr, sitesString(useSites))
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
 
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
+		s.shutdown(ctx, srv)
+		close(shutdownDone)
+	}()
+
+	var l net.Listener
+	if listenAddr != "none" {
+		l, err = listen(listenAddr)
+		if err != nil {
+			log.Fatalf("cannot start UniFi exporter: %s", err)
+		}
+	}
+
+	switch {
+	case l == nil:
+		// Metrics are only pushed or written to a file, such as on hosts
+		// which may not open another port
+		log.Printf("Starting UniFi exporter %s without an HTTP listener for %s", version, s.describe())
+		err = http.ErrServerClosed
+	case tlsConfig != nil:
+		log.Printf("Starting UniFi exporter %s with TLS on %q for %s", version, listenAddr, s.describe())
+		err = srv.ServeTLS(l, "", "")
+	default:
+		log.Printf("Starting UniFi exporter %s on %q for %s", version, listenAddr, s.describe())
+		err = srv.Serve(l)
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("cannot start UniFi exporter: %s", err)
 	}
+
+	<-shutdownDone
+	log.Println("[INFO] UniFi exporter shut down")
 }
 
-// pickSites attempts to find a site with a description matching the value
-// specified in choose.  If choose is empty, all sites are returned.
+// pickSites returns the sites selected by choose, a comma-separated list of
+// selectors.  Each selector matches sites whose name or description is equal
+// to it, or, if it is of the form /regexp/, whose name or description matches
+// the entire regular expression.  A selector prefixed with ! excludes the
+// sites it matches instead.
+//
+// If choose is empty or only excludes sites, all remaining sites are
+// returned.  Sites are returned in their original order.
 func pickSites(choose string, sites []*api.Site) ([]*api.Site, error) {
-	if choose == "" {
-		return sites, nil
+	var include, exclude []siteSelector
+	for _, sel := range strings.Split(choose, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+
+		negate := strings.HasPrefix(sel, "!")
+		ss, err := parseSiteSelector(strings.TrimPrefix(sel, "!"))
+		if err != nil {
+			return nil, err
+		}
+
+		if negate {
+			exclude = append(exclude, ss)
+		} else {
+			include = append(include, ss)
+		}
 	}
 
-	var pick *api.Site
+	// Exact selectors are expected to name a site, so one which matches
+	// nothing is most likely a typo
+	for _, ss := range include {
+		if ss.re != nil {
+			continue
+		}
+
+		found := false
+		for _, s := range sites {
+			if ss.matches(s) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("site with name or description %q was not found in UniFi Controller", ss.value)
+		}
+	}
+
+	var pick []*api.Site
 	for _, s := range sites {
-		if s.Description == choose {
-			pick = s
-			break
+		if len(include) > 0 && !matchSite(include, s) {
+			continue
 		}
+		if matchSite(exclude, s) {
+			continue
+		}
+
+		pick = append(pick, s)
 	}
-	if pick == nil {
-		return nil, fmt.Errorf("site with description %q was not found in UniFi Controller", choose)
+
+	if len(pick) == 0 {
+		return nil, fmt.Errorf("no sites in UniFi Controller match %q", choose)
+	}
+
+	return pick, nil
+}
+
+// A siteSelector matches sites by name or description.
+type siteSelector struct {
+	value string
+	re    *regexp.Regexp
+}
+
+// parseSiteSelector parses a single selector for pickSites.
+func parseSiteSelector(sel string) (siteSelector, error) {
+	if len(sel) < 2 || !strings.HasPrefix(sel, "/") || !strings.HasSuffix(sel, "/") {
+		return siteSelector{value: sel}, nil
+	}
+
+	expr := sel[1 : len(sel)-1]
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return siteSelector{}, fmt.Errorf("failed to parse site regular expression %q: %v", expr, err)
+	}
+
+	return siteSelector{value: sel, re: re}, nil
+}
+
+// matches reports whether the selector matches site s.
+func (ss siteSelector) matches(s *api.Site) bool {
+	if ss.re != nil {
+		return ss.re.MatchString(s.Name) || ss.re.MatchString(s.Description)
 	}
 
-	return []*api.Site{pick}, nil
+	return ss.value == s.Name || ss.value == s.Description
+}
+
+// matchSite reports whether any of sels matches site s.
+func matchSite(sels []siteSelector, s *api.Site) bool {
+	for _, ss := range sels {
+		if ss.matches(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectSites returns the sites selected by choose, as with pickSites, which
+// belong to shard.  A shard may have no sites, such as when there are fewer
+// sites than shards.
+func selectSites(choose string, shard siteShard, sites []*api.Site) ([]*api.Site, error) {
+	pick, err := pickSites(choose, sites)
+	if err != nil {
+		return nil, err
+	}
+	if shard.count == 0 {
+		return pick, nil
+	}
+
+	inShard := make([]*api.Site, 0, len(pick)/shard.count+1)
+	for _, s := range pick {
+		if shard.contains(s) {
+			inShard = append(inShard, s)
+		}
+	}
+
+	return inShard, nil
+}
+
+// A siteShard is the part of a UniFi Controller's sites exported by the index
+// of count exporters, numbered from 1, so that a controller with many sites
+// can be split among several exporters.  The zero value contains every site.
+type siteShard struct {
+	index, count int
+}
+
+// parseSiteShard parses a shard of the form index/count, such as 2/4.
+func parseSiteShard(s string) (siteShard, error) {
+	if s == "" {
+		return siteShard{}, nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return siteShard{}, fmt.Errorf("shard %q must be of the form index/count, such as 2/4", s)
+	}
+
+	index, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return siteShard{}, fmt.Errorf("failed to parse integer %q: %v", parts[0], err)
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return siteShard{}, fmt.Errorf("failed to parse integer %q: %v", parts[1], err)
+	}
+	if count < 1 || index < 1 || index > count {
+		return siteShard{}, fmt.Errorf("shard %q must have an index from 1 to its count", s)
+	}
+
+	return siteShard{index: index, count: count}, nil
+}
+
+// contains reports whether site s belongs to the shard.  Sites are assigned
+// by a hash of their name, so that each site belongs to exactly one shard of
+// the same count, and adding or removing a site moves no others.
+func (sh siteShard) contains(s *api.Site) bool {
+	if sh.count == 0 {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s.Name))
+	return int(h.Sum32()%uint32(sh.count)) == sh.index-1
+}
+
+// first reports whether the shard is the first of its count, or contains every
+// site, and so runs the unshardedCollectors.
+func (sh siteShard) first() bool {
+	return sh.index <= 1
+}
+
+// unshardedCollectors are the collectors whose metrics are not split by site,
+// which only the first shard runs, so that they are not exported once by each
+// replica.
+var unshardedCollectors = []string{
+	exporter.CollectorProtect,
+	exporter.CollectorAccess,
+	exporter.CollectorBackups,
+	exporter.CollectorEvents,
+}
+
+// String returns the shard in the form index/count.
+func (sh siteShard) String() string {
+	return fmt.Sprintf("%d/%d", sh.index, sh.count)
 }
 
 // sitesString returns a comma-separated string of site descriptions, meant
@@ -155,24 +433,159 @@ func sitesString(sites []*api.Site) string {
 	return strings.Join(ds, ", ")
 }
 
+// clientConfig contains the parameters used to create and authenticate a UniFi
+// Controller API client.
+type clientConfig struct {
+	addr     string
+	username string
+	password string
+
+	// At most one of totpSecret or totpCode may be set, for accounts which
+	// require two-factor authentication.
+	totpSecret string
+	totpCode   *oneTimeCode
+
+	// maxRequests limits the number of requests in flight at once.
+	maxRequests int
+
+	// controller is the value of the controller label on the metrics of
+	// the client's requests.
+	controller string
+
+	// If breakerFailures is set, requests are suspended for breakerCooldown
+	// after that many consecutive failures.
+	breakerFailures int
+	breakerCooldown time.Duration
+
+	// http configures the HTTP client used to reach the UniFi Controller.
+	http api.HTTPClientConfig
+}
+
+// A oneTimeCode is a two-factor authentication token, which is submitted to
+// the UniFi Controller at most once.  It is shared by every copy of the
+// clientConfig which holds it, so that a client created again, such as to
+// retry an unreachable controller, does not replay it.
+type oneTimeCode struct {
+	mu   sync.Mutex
+	code string
+	used bool
+}
+
+// newOneTimeCode returns a oneTimeCode for code, or nil if code is empty.
+func newOneTimeCode(code string) *oneTimeCode {
+	if code == "" {
+		return nil
+	}
+
+	return &oneTimeCode{code: code}
+}
+
+// take returns the code, or false if it has already been taken.
+func (c *oneTimeCode) take() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.used {
+		return "", false
+	}
+
+	c.used = true
+	return c.code, true
+}
+
+// Metrics about the requests made to each UniFi Controller, which can explain
+// a slow or failing scrape.
+var (
+	clientRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "unifi",
+			Subsystem: "client",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests to the UniFi Controller, by endpoint.",
+		},
+		[]string{"controller", "endpoint"},
+	)
+
+	clientRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "unifi",
+			Subsystem: "client",
+			Name:      "requests_total",
+			Help:      "Number of requests to the UniFi Controller, by endpoint and HTTP status code, which is 0 if no response was received.",
+		},
+		[]string{"controller", "endpoint", "code"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(clientRequestDuration)
+	prometheus.MustRegister(clientRequests)
+}
+
+// observeRequest records a request made to the UniFi Controller named
+// controller.
+func observeRequest(controller string) api.RequestObserver {
+	return func(endpoint string, code int, d time.Duration) {
+		clientRequestDuration.WithLabelValues(controller, endpoint).Observe(d.Seconds())
+		clientRequests.WithLabelValues(controller, endpoint, strconv.Itoa(code)).Inc()
+	}
+}
+
 // newClient returns a unifiexporter.ClientFunc using the input parameters.
-func newClient(addr, username, password string, insecure bool, timeout time.Duration) exporter.ClientFunc {
-	return func() (*api.Client, error) {
-		httpClient := &http.Client{Timeout: timeout}
-		if insecure {
-			httpClient = api.InsecureHTTPClient(timeout)
-		}
+func newClient(cfg clientConfig) exporter.ClientFunc {
+	// Every client shares the breaker, so that it stays open while the
+	// Exporter authenticates again with a new client
+	var breaker *api.CircuitBreaker
+	if cfg.breakerFailures > 0 {
+		breaker = api.NewCircuitBreaker(cfg.breakerFailures, cfg.breakerCooldown)
+	}
 
-		c, err := api.NewClient(addr, httpClient)
+	return func(ctx context.Context) (api.Controller, error) {
+		c, err := api.NewClient(cfg.addr, api.NewHTTPClient(cfg.http))
 		if err != nil {
 			return nil, fmt.Errorf("cannot create UniFi Controller client: %v", err)
 		}
 		c.UserAgent = userAgent
+		c.SetMaxConcurrentRequests(cfg.maxRequests)
+		c.SetRequestObserver(observeRequest(cfg.controller))
+		if breaker != nil {
+			c.SetCircuitBreaker(breaker)
+		}
 
-		if err := c.Login(username, password); err != nil {
+		// A TOTP secret generates a fresh token each time the client must
+		// authenticate, while a one-time code can only be used once, and
+		// is never submitted again
+		switch {
+		case cfg.totpSecret != "":
+			err = c.LoginWithTOTPSecret(ctx, cfg.username, cfg.password, cfg.totpSecret)
+		case cfg.totpCode != nil:
+			code, ok := cfg.totpCode.take()
+			if !ok {
+				return nil, fmt.Errorf("failed to authenticate to UniFi Controller: %v", api.ErrTokenExpired)
+			}
+			err = c.LoginWithToken(ctx, cfg.username, cfg.password, code)
+		default:
+			err = c.Login(ctx, cfg.username, cfg.password)
+		}
+		if err != nil {
 			return nil, fmt.Errorf("failed to authenticate to UniFi Controller: %v", err)
 		}
 
 		return c, nil
 	}
 }
+
+// loadCAFile loads a pool of certificate authorities from a PEM file.
+func loadCAFile(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file %q: %v", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in CA file %q", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var notifyFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "unifi_exporter",
+	Name:      "notify_failures_total",
+	Help:      "Number of event notifications which could not be delivered to a webhook.",
+})
+
+func init() {
+	prometheus.MustRegister(notifyFailures)
+}
+
+// notifyQueueSize is the number of notifications which may wait to be sent
+// before further notifications are dropped.
+const notifyQueueSize = 100
+
+// A notifier POSTs a notification to each of its webhook URLs when a selected
+// event is streamed from a UniFi Controller.
+type notifier struct {
+	cfg    notifyConfig
+	client *http.Client
+	queue  chan notification
+
+	// ctx is cancelled when the notifier is stopped, which also cancels any
+	// notification in progress.
+	ctx    context.Context
+	cancel func()
+}
+
+// A notification is an event to be sent by a notifier.
+type notification struct {
+	controller string
+	site       *api.Site
+	event      *api.Event
+}
+
+// newNotifier creates a notifier.  start must be called to begin sending
+// notifications.
+func newNotifier(cfg notifyConfig) *notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.timeout},
+		queue:  make(chan notification, notifyQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start begins sending queued notifications until the notifier is stopped.
+func (n *notifier) start() {
+	go func() {
+		for {
+			select {
+			case nt := <-n.queue:
+				n.send(n.ctx, nt)
+			case <-n.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops sending notifications, cancelling any in progress.
+func (n *notifier) stop() {
+	n.cancel()
+}
+
+// handler returns an exporter.EventFunc which queues a notification of each
+// selected event from the named controller.  Events are streamed as they
+// occur, so notifications are dropped rather than delaying the stream when
+// the queue is full.
+func (n *notifier) handler(controller string) exporter.EventFunc {
+	return func(site *api.Site, ev *api.Event) {
+		if !n.cfg.events[ev.Key] || n.ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case n.queue <- notification{controller: controller, site: site, event: ev}:
+		default:
+			notifyFailures.Inc()
+			log.Printf("[WARN] dropped notification of event %s in site %q: too many notifications are waiting to be sent", ev.Key, site.Name)
+		}
+	}
+}
+
+// send sends nt to every webhook URL, logging and counting any failures.
+func (n *notifier) send(ctx context.Context, nt notification) {
+	var (
+		body []byte
+		err  error
+	)
+	switch n.cfg.format {
+	case notifyFormatAlertmanager:
+		body, err = json.Marshal(alertmanagerAlerts(nt))
+	default:
+		body, err = json.Marshal(newEventRecord(nt))
+	}
+	if err != nil {
+		notifyFailures.Inc()
+		log.Printf("[ERROR] failed to encode notification of event %s: %v", nt.event.Key, err)
+		return
+	}
+
+	for _, u := range n.cfg.urls {
+		if err := n.post(ctx, u, body); err != nil && ctx.Err() == nil {
+			notifyFailures.Inc()
+			log.Printf("[ERROR] failed to notify webhook %q of event %s: %v", u, nt.event.Key, err)
+		}
+	}
+}
+
+// post POSTs body to the webhook at u.
+func (n *notifier) post(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range n.cfg.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected HTTP status %q: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
+
+// An eventRecord is the JSON representation of an event sent to webhooks and
+// written to the event log.
+type eventRecord struct {
+	Controller      string          `json:"controller,omitempty"`
+	Site            string          `json:"site"`
+	SiteDescription string          `json:"site_description"`
+	Key             string          `json:"key"`
+	Subsystem       string          `json:"subsystem"`
+	Message         string          `json:"message"`
+	Time            time.Time       `json:"time"`
+	Event           json.RawMessage `json:"event"`
+}
+
+// newEventRecord returns the eventRecord of nt.  The event is included as the
+// UniFi Controller reported it, for fields which are not otherwise included.
+func newEventRecord(nt notification) eventRecord {
+	return eventRecord{
+		Controller:      nt.controller,
+		Site:            nt.site.Name,
+		SiteDescription: nt.site.Description,
+		Key:             nt.event.Key,
+		Subsystem:       nt.event.Subsystem,
+		Message:         nt.event.Message,
+		Time:            nt.event.Time,
+		Event:           nt.event.Raw,
+	}
+}
+
+// An alertmanagerAlert is an alert in the format accepted by the
+// Alertmanager's /api/v2/alerts endpoint.
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+}
+
+// alertmanagerAlerts returns nt as an alert for the Alertmanager.  Events have
+// no end, so the Alertmanager resolves the alert after its resolve_timeout.
+// The alert is labeled with the devices and station involved, so that events
+// of the same kind for different devices are separate alerts.
+func alertmanagerAlerts(nt notification) []alertmanagerAlert {
+	labels := map[string]string{
+		"alertname": "UniFiEvent",
+		"key":       nt.event.Key,
+		"site":      nt.site.Name,
+	}
+	for name, value := range map[string]string{
+		"controller":  nt.controller,
+		"ap_mac":      nt.event.APMAC,
+		"switch_mac":  nt.event.SwitchMAC,
+		"gateway_mac": nt.event.GatewayMAC,
+		"station_mac": nt.event.StationMAC,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+
+	summary := nt.event.Message
+	if summary == "" {
+		summary = nt.event.Key
+	}
+
+	return []alertmanagerAlert{{
+		Labels:      labels,
+		Annotations: map[string]string{"summary": summary},
+		StartsAt:    nt.event.Time,
+	}}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+func Test_notifierSend(t *testing.T) {
+	var (
+		body   []byte
+		header http.Header
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	nt := notification{
+		controller: "foo",
+		site:       &api.Site{Name: "default", Description: "Default"},
+		event: &api.Event{
+			Key:     "EVT_AP_Lost_Contact",
+			Message: "AP[f0:9f:c2:00:00:01] was disconnected",
+			Time:    time.Unix(1500000000, 0).UTC(),
+			APMAC:   "f0:9f:c2:00:00:01",
+			Raw:     json.RawMessage(`{"key":"EVT_AP_Lost_Contact"}`),
+		},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: notifyFormatJSON,
+			want:   `{"controller":"foo","site":"default","site_description":"Default","key":"EVT_AP_Lost_Contact","subsystem":"","message":"AP[f0:9f:c2:00:00:01] was disconnected","time":"2017-07-14T02:40:00Z","event":{"key":"EVT_AP_Lost_Contact"}}`,
+		},
+		{
+			format: notifyFormatAlertmanager,
+			want:   `[{"labels":{"alertname":"UniFiEvent","ap_mac":"f0:9f:c2:00:00:01","controller":"foo","key":"EVT_AP_Lost_Contact","site":"default"},"annotations":{"summary":"AP[f0:9f:c2:00:00:01] was disconnected"},"startsAt":"2017-07-14T02:40:00Z"}]`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.format)
+
+		n := newNotifier(notifyConfig{
+			urls:    []string{srv.URL},
+			format:  tt.format,
+			timeout: time.Minute,
+			headers: map[string]string{"Authorization": "Bearer foo"},
+		})
+		n.send(context.Background(), nt)
+		n.stop()
+
+		if want, got := tt.want, string(body); want != got {
+			t.Fatalf("unexpected notification:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "Bearer foo", header.Get("Authorization"); want != got {
+			t.Fatalf("unexpected Authorization header:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func Test_notifierHandler(t *testing.T) {
+	n := newNotifier(notifyConfig{
+		events: map[string]bool{"EVT_AP_Lost_Contact": true},
+	})
+	defer n.stop()
+
+	fn := n.handler("foo")
+	site := &api.Site{Name: "default"}
+	fn(site, &api.Event{Key: "EVT_WU_Roam"})
+	fn(site, &api.Event{Key: "EVT_AP_Lost_Contact"})
+
+	if want, got := 1, len(n.queue); want != got {
+		t.Fatalf("unexpected number of queued notifications:\n- want: %v\n-  got: %v", want, got)
+	}
+	nt := <-n.queue
+	if want, got := "foo", nt.controller; want != got {
+		t.Fatalf("unexpected controller:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := "EVT_AP_Lost_Contact", nt.event.Key; want != got {
+		t.Fatalf("unexpected event key:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_parseNotifyConfig(t *testing.T) {
+	cfg, err := parseNotifyConfig(map[string]string{
+		"urls":    "http://alertmanager:9093/api/v2/alerts, https://hooks.example.com/unifi",
+		"events":  "EVT_AP_Lost_Contact, EVT_GW_WANTransition",
+		"format":  "alertmanager",
+		"headers": "Authorization=Bearer foo",
+	})
+	if err != nil {
+		t.Fatalf("failed to parse notify section: %v", err)
+	}
+
+	want := &notifyConfig{
+		urls: []string{
+			"http://alertmanager:9093/api/v2/alerts",
+			"https://hooks.example.com/unifi",
+		},
+		events: map[string]bool{
+			"EVT_AP_Lost_Contact":  true,
+			"EVT_GW_WANTransition": true,
+		},
+		format:  notifyFormatAlertmanager,
+		timeout: 10 * time.Second,
+		headers: map[string]string{"Authorization": "Bearer foo"},
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("unexpected notify configuration:\n- want: %+v\n-  got: %+v", want, cfg)
+	}
+
+	if cfg, err := parseNotifyConfig(nil); err != nil || cfg != nil {
+		t.Fatalf("expected no notify configuration, but got: %+v, %v", cfg, err)
+	}
+
+	for i, section := range []map[string]string{
+		{"urls": "hooks.example.com", "events": "EVT_AP_Lost_Contact"},
+		{"urls": "http://hooks.example.com"},
+		{"urls": "http://hooks.example.com", "events": "EVT_AP_Lost_Contact", "format": "slack"},
+		{"urls": "http://hooks.example.com", "events": "EVT_AP_Lost_Contact", "timeout": "foo"},
+	} {
+		t.Logf("[%02d] section: %v", i, section)
+
+		if _, err := parseNotifyConfig(section); err == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+var otlpExportFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "unifi_exporter",
+	Name:      "otlp_export_failures_total",
+	Help:      "Number of failed exports of metrics to the OTLP endpoint.",
+})
+
+func init() {
+	prometheus.MustRegister(otlpExportFailures)
+}
+
+// otlpStartTime is the start time of every cumulative series exported over
+// OTLP.  When a controller began counting is unknown, so, like the
+// OpenTelemetry collector's Prometheus receiver, the exporter uses the time at
+// which it began observing the series, which must not change across reloads.
+var otlpStartTime = time.Now()
+
+// otlpDefaultPort is the port on which the OpenTelemetry collector accepts
+// OTLP/gRPC.
+const otlpDefaultPort = "4317"
+
+// An otlpPusher periodically exports metrics to an OpenTelemetry collector,
+// using the OTLP/gRPC protocol.
+type otlpPusher struct {
+	cfg    otlpConfig
+	conn   *grpc.ClientConn
+	client collectorpb.MetricsServiceClient
+
+	// gather returns the metrics to export, bounded by ctx.
+	gather func(ctx context.Context) (prometheus.Gatherer, error)
+
+	// ctx is cancelled when the pusher is stopped, which also cancels any
+	// export in progress.
+	ctx    context.Context
+	cancel func()
+}
+
+// newOTLPPusher creates an otlpPusher which exports the metrics returned by
+// gather.  start must be called to begin exporting, and stop to release its
+// connection.
+func newOTLPPusher(cfg otlpConfig, gather func(ctx context.Context) (prometheus.Gatherer, error)) (*otlpPusher, error) {
+	u, err := url.Parse(cfg.endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	target := u.Host
+	if u.Port() == "" {
+		target = net.JoinHostPort(u.Hostname(), otlpDefaultPort)
+	}
+
+	creds := insecure.NewCredentials()
+	if u.Scheme == "https" {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUserAgent(userAgent),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &otlpPusher{
+		cfg:    cfg,
+		conn:   conn,
+		client: collectorpb.NewMetricsServiceClient(conn),
+		gather: gather,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// start begins exporting metrics every interval until the pusher is stopped.
+func (p *otlpPusher) start() {
+	go func() {
+		t := time.NewTicker(p.cfg.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if err := p.push(p.ctx); err != nil && p.ctx.Err() == nil {
+					otlpExportFailures.Inc()
+					log.Printf("[ERROR] failed to export metrics to OTLP endpoint %q: %v", p.cfg.endpoint, err)
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops exporting, cancelling any export in progress, and closes the
+// connection to the endpoint.
+func (p *otlpPusher) stop() {
+	p.cancel()
+	_ = p.conn.Close()
+}
+
+// push gathers metrics once and exports them.  If only some metrics could be
+// gathered, those which were are still exported.
+func (p *otlpPusher) push(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.interval)
+	defer cancel()
+
+	g, err := p.gather(ctx)
+	if err != nil {
+		return err
+	}
+
+	mfs, err := g.Gather()
+	if err != nil {
+		log.Printf("[WARN] exporting incomplete metrics to OTLP endpoint %q: %v", p.cfg.endpoint, err)
+	}
+
+	ctx, cancel = context.WithTimeout(ctx, p.cfg.timeout)
+	defer cancel()
+
+	md := metadata.MD{}
+	for k, v := range p.cfg.headers {
+		md.Set(k, v)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	res, err := p.client.Export(ctx, otlpRequest(mfs, otlpStartTime, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	if ps := res.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+		return fmt.Errorf("%d data points were rejected: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+	}
+
+	return nil
+}
+
+// otlpRequest converts Prometheus metric families gathered at now into an
+// OTLP export request.  Counters become monotonic cumulative sums, gauges and
+// untyped metrics become gauges, and summaries and histograms keep their
+// types.  Cumulative series began at start.
+func otlpRequest(mfs []*dto.MetricFamily, start, now time.Time) *collectorpb.ExportMetricsServiceRequest {
+	metrics := make([]*metricspb.Metric, 0, len(mfs))
+	for _, mf := range mfs {
+		m := &metricspb.Metric{
+			Name:        mf.GetName(),
+			Description: mf.GetHelp(),
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, pm := range mf.Metric {
+				dp := otlpNumberPoint(pm, now, pm.GetCounter().GetValue())
+				dp.StartTimeUnixNano = uint64(start.UnixNano())
+				sum.DataPoints = append(sum.DataPoints, dp)
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE:
+			gauge := &metricspb.Gauge{}
+			for _, pm := range mf.Metric {
+				gauge.DataPoints = append(gauge.DataPoints, otlpNumberPoint(pm, now, pm.GetGauge().GetValue()))
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, pm := range mf.Metric {
+				gauge.DataPoints = append(gauge.DataPoints, otlpNumberPoint(pm, now, pm.GetUntyped().GetValue()))
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, pm := range mf.Metric {
+				summary.DataPoints = append(summary.DataPoints, otlpSummaryPoint(pm, start, now))
+			}
+			m.Data = &metricspb.Metric_Summary{Summary: summary}
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, pm := range mf.Metric {
+				histogram.DataPoints = append(histogram.DataPoints, otlpHistogramPoint(pm, start, now))
+			}
+			m.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		default:
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{
+					otlpString("service.name", "unifi_exporter"),
+					otlpString("service.version", version),
+				},
+			},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope: &commonpb.InstrumentationScope{
+					Name:    userAgent,
+					Version: version,
+				},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// otlpString returns an OTLP attribute with a string value.
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key: key,
+		Value: &commonpb.AnyValue{
+			Value: &commonpb.AnyValue_StringValue{StringValue: value},
+		},
+	}
+}
+
+// otlpAttributes converts the labels of a metric into OTLP attributes.
+func otlpAttributes(pm *dto.Metric) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(pm.Label))
+	for _, l := range pm.Label {
+		attrs = append(attrs, otlpString(l.GetName(), l.GetValue()))
+	}
+
+	return attrs
+}
+
+// otlpTime returns the time of a metric, which is now unless the metric
+// carries its own timestamp.
+func otlpTime(pm *dto.Metric, now time.Time) uint64 {
+	if pm.TimestampMs != nil {
+		return uint64(pm.GetTimestampMs()) * uint64(time.Millisecond)
+	}
+
+	return uint64(now.UnixNano())
+}
+
+// otlpNumberPoint converts the value v of a Prometheus counter, gauge or
+// untyped metric into an OTLP number data point.  The caller sets the start
+// time of a counter's point.
+func otlpNumberPoint(pm *dto.Metric, now time.Time, v float64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:   otlpAttributes(pm),
+		TimeUnixNano: otlpTime(pm, now),
+		Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: v},
+	}
+}
+
+// otlpSummaryPoint converts a Prometheus summary into an OTLP summary, whose
+// count and sum are cumulative since start.
+func otlpSummaryPoint(pm *dto.Metric, start, now time.Time) *metricspb.SummaryDataPoint {
+	s := pm.GetSummary()
+	p := &metricspb.SummaryDataPoint{
+		Attributes:        otlpAttributes(pm),
+		StartTimeUnixNano: uint64(start.UnixNano()),
+		TimeUnixNano:      otlpTime(pm, now),
+		Count:             s.GetSampleCount(),
+		Sum:               s.GetSampleSum(),
+	}
+	for _, q := range s.Quantile {
+		p.QuantileValues = append(p.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+			Quantile: q.GetQuantile(),
+			Value:    q.GetValue(),
+		})
+	}
+
+	return p
+}
+
+// otlpHistogramPoint converts a Prometheus histogram, whose buckets are
+// cumulative, into an OTLP histogram, whose buckets are not and which has one
+// more bucket than it has bounds.
+func otlpHistogramPoint(pm *dto.Metric, start, now time.Time) *metricspb.HistogramDataPoint {
+	h := pm.GetHistogram()
+	sum := h.GetSampleSum()
+	p := &metricspb.HistogramDataPoint{
+		Attributes:        otlpAttributes(pm),
+		StartTimeUnixNano: uint64(start.UnixNano()),
+		TimeUnixNano:      otlpTime(pm, now),
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+	}
+
+	var prev uint64
+	for _, b := range h.Bucket {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+
+		p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	p.BucketCounts = append(p.BucketCounts, h.GetSampleCount()-prev)
+
+	return p
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// A testMetricsService is an OTLP/gRPC metrics endpoint which records the
+// last export request and its metadata, and replies with res or err.
+type testMetricsService struct {
+	collectorpb.UnimplementedMetricsServiceServer
+
+	req *collectorpb.ExportMetricsServiceRequest
+	md  metadata.MD
+
+	res *collectorpb.ExportMetricsServiceResponse
+	err error
+}
+
+func (s *testMetricsService) Export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	s.req = req
+	s.md, _ = metadata.FromIncomingContext(ctx)
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.res != nil {
+		return s.res, nil
+	}
+
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+// testOTLPServer serves svc over OTLP/gRPC, and returns its endpoint and a
+// function which stops it.
+func testOTLPServer(t *testing.T, svc *testMetricsService) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(srv, svc)
+	go func() { _ = srv.Serve(l) }()
+
+	return "http://" + l.Addr().String(), srv.Stop
+}
+
+func Test_otlpRequest(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	devices := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_devices", Help: "Devices."}, []string{"site"})
+	bytes := prometheus.NewCounter(prometheus.CounterOpts{Name: "unifi_bytes_total", Help: "Bytes."})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "unifi_latency_seconds", Help: "Latency.", Buckets: []float64{1, 2}})
+	reg.MustRegister(devices, bytes, latency)
+
+	devices.WithLabelValues("Default").Set(42)
+	bytes.Add(10)
+	latency.Observe(0.5)
+	latency.Observe(1.5)
+	latency.Observe(5)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	var (
+		start = time.Unix(100, 0)
+		now   = time.Unix(200, 0)
+		sum   = 7.0
+	)
+	req := otlpRequest(mfs, start, now)
+
+	got := make(map[string]*metricspb.Metric)
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		got[m.Name] = m
+	}
+
+	want := map[string]*metricspb.Metric{
+		"unifi_bytes_total": {
+			Name:        "unifi_bytes_total",
+			Description: "Bytes.",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+				DataPoints: []*metricspb.NumberDataPoint{{
+					StartTimeUnixNano: uint64(start.UnixNano()),
+					TimeUnixNano:      uint64(now.UnixNano()),
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: 10},
+				}},
+			}},
+		},
+		"unifi_devices": {
+			Name:        "unifi_devices",
+			Description: "Devices.",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{
+					Attributes:   otlpAttributes(mfs[1].Metric[0]),
+					TimeUnixNano: uint64(now.UnixNano()),
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 42},
+				}},
+			}},
+		},
+		"unifi_latency_seconds": {
+			Name:        "unifi_latency_seconds",
+			Description: "Latency.",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					StartTimeUnixNano: uint64(start.UnixNano()),
+					TimeUnixNano:      uint64(now.UnixNano()),
+					Count:             3,
+					Sum:               &sum,
+					BucketCounts:      []uint64{1, 1, 1},
+					ExplicitBounds:    []float64{1, 2},
+				}},
+			}},
+		},
+	}
+	if want, got := len(want), len(got); want != got {
+		t.Fatalf("unexpected number of metrics:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	for name, w := range want {
+		if g := got[name]; !proto.Equal(w, g) {
+			t.Fatalf("unexpected metric %q:\n- want: %v\n-  got: %v", name, w, g)
+		}
+	}
+}
+
+func Test_otlpPusherPush(t *testing.T) {
+	svc := &testMetricsService{}
+	endpoint, stop := testOTLPServer(t, svc)
+	defer stop()
+
+	reg := prometheus.NewRegistry()
+	devices := prometheus.NewGauge(prometheus.GaugeOpts{Name: "unifi_devices", Help: "Devices."})
+	reg.MustRegister(devices)
+	devices.Set(math.NaN())
+
+	p, err := newOTLPPusher(otlpConfig{
+		endpoint: endpoint,
+		interval: time.Minute,
+		timeout:  time.Minute,
+		headers:  map[string]string{"Authorization": "Bearer foo"},
+	}, func(_ context.Context) (prometheus.Gatherer, error) {
+		return reg, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+	defer p.stop()
+
+	if err := p.push(context.Background()); err != nil {
+		t.Fatalf("failed to push metrics: %v", err)
+	}
+
+	if want, got := []string{"Bearer foo"}, svc.md.Get("authorization"); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected authorization metadata:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	metrics := svc.req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+	if want, got := 1, len(metrics); want != got {
+		t.Fatalf("unexpected number of metrics:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	v := metrics[0].GetGauge().GetDataPoints()[0].GetAsDouble()
+	if !math.IsNaN(v) {
+		t.Fatalf("unexpected value for unifi_devices:\n- want: %v\n-  got: %v", math.NaN(), v)
+	}
+}
+
+func Test_otlpPusherPushError(t *testing.T) {
+	tests := []struct {
+		desc string
+		svc  *testMetricsService
+	}{
+		{
+			desc: "export refused",
+			svc:  &testMetricsService{err: status.Error(codes.InvalidArgument, "no thanks")},
+		},
+		{
+			desc: "data points rejected",
+			svc: &testMetricsService{res: &collectorpb.ExportMetricsServiceResponse{
+				PartialSuccess: &collectorpb.ExportMetricsPartialSuccess{
+					RejectedDataPoints: 1,
+					ErrorMessage:       "no thanks",
+				},
+			}},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		endpoint, stop := testOTLPServer(t, tt.svc)
+
+		p, err := newOTLPPusher(otlpConfig{
+			endpoint: endpoint,
+			interval: time.Minute,
+			timeout:  time.Minute,
+		}, func(_ context.Context) (prometheus.Gatherer, error) {
+			return prometheus.NewRegistry(), nil
+		})
+		if err != nil {
+			t.Fatalf("failed to create pusher: %v", err)
+		}
+
+		err = p.push(context.Background())
+		p.stop()
+		stop()
+
+		if err == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+	}
+}
+
+func Test_parseOTLPConfig(t *testing.T) {
+	cfg, err := parseOTLPConfig(map[string]string{
+		"endpoint": "http://otel-collector:4317",
+		"interval": "30s",
+		"headers":  "Authorization=Bearer foo, X-Scope=bar",
+	})
+	if err != nil {
+		t.Fatalf("failed to parse otlp section: %v", err)
+	}
+
+	want := &otlpConfig{
+		endpoint: "http://otel-collector:4317",
+		interval: 30 * time.Second,
+		timeout:  10 * time.Second,
+		headers: map[string]string{
+			"Authorization": "Bearer foo",
+			"X-Scope":       "bar",
+		},
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("unexpected otlp configuration:\n- want: %+v\n-  got: %+v", want, cfg)
+	}
+
+	if cfg, err := parseOTLPConfig(nil); err != nil || cfg != nil {
+		t.Fatalf("expected no otlp configuration, but got: %+v, %v", cfg, err)
+	}
+
+	for i, section := range []map[string]string{
+		{"endpoint": "otel-collector:4317"},
+		{"endpoint": "http://otel-collector:4318/v1/metrics"},
+		{"endpoint": "http://otel-collector:4317", "interval": "0s"},
+		{"endpoint": "http://otel-collector:4317", "timeout": "foo"},
+		{"endpoint": "http://otel-collector:4317", "headers": "foo"},
+	} {
+		t.Logf("[%02d] section: %v", i, section)
+
+		if _, err := parseOTLPConfig(section); err == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A poller collects metrics from an Exporter on a fixed interval, and serves
+// the most recent snapshot to every scrape, so that the load on the UniFi
+// Controller does not depend on how often, or by how many Prometheus servers,
+// the exporter is scraped.
+type poller struct {
+	e        *exporter.Exporter
+	interval time.Duration
+
+	// age reports the staleness of the snapshot alongside its metrics.
+	age prometheus.Gatherer
+
+	mu   sync.RWMutex
+	mfs  []*dto.MetricFamily
+	err  error
+	last time.Time
+
+	// ctx is cancelled when the poller is stopped, which also cancels any
+	// poll in progress.
+	ctx    context.Context
+	cancel func()
+}
+
+// snapshotAgeOpts describes the metric reporting the age of a poller's
+// snapshot.
+var snapshotAgeOpts = prometheus.GaugeOpts{
+	Namespace: "unifi",
+	Name:      "snapshot_age_seconds",
+	Help:      "Number of seconds since the metrics served were collected from the UniFi Controller.",
+}
+
+// Verify that the poller implements the prometheus.Gatherer interface.
+var _ prometheus.Gatherer = &poller{}
+
+// startPoller collects metrics from e once, and then starts polling it every
+// interval until the poller is stopped.
+func startPoller(e *exporter.Exporter, interval time.Duration) *poller {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &poller{
+		e:        e,
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	age := func() float64 {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return time.Since(p.last).Seconds()
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGaugeFunc(snapshotAgeOpts, age))
+	p.age = reg
+
+	p.poll()
+	go p.run()
+
+	return p
+}
+
+// run polls the Exporter every interval until the poller is stopped.
+func (p *poller) run() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			p.poll()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// poll collects a new snapshot of metrics from the Exporter.  A poll which
+// takes longer than the interval is cancelled, so that polls never overlap.
+func (p *poller) poll() {
+	ctx, cancel := context.WithTimeout(p.ctx, p.interval)
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(p.e.WithContext(ctx)); err != nil {
+		log.Printf("[ERROR] failed to poll UniFi controller: %v", err)
+		return
+	}
+
+	mfs, err := reg.Gather()
+
+	// A poll cancelled by stop is incomplete, so keep the previous snapshot
+	if p.ctx.Err() != nil {
+		return
+	}
+
+	// Every scrape until the next poll serves the same samples, so they
+	// carry the time at which they were collected rather than that of the
+	// scrape
+	last := time.Now()
+	setTimestamps(mfs, last)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.mfs, p.err, p.last = mfs, err, last
+}
+
+// setTimestamps sets the timestamp of every metric in mfs to t.
+func setTimestamps(mfs []*dto.MetricFamily, t time.Time) {
+	ms := proto.Int64(t.UnixNano() / int64(time.Millisecond))
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.TimestampMs = ms
+		}
+	}
+}
+
+// clearTimestamps removes the timestamp of every metric in mfs.
+func clearTimestamps(mfs []*dto.MetricFamily) {
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.TimestampMs = nil
+		}
+	}
+}
+
+// stop stops polling, cancelling any poll in progress.  The last snapshot
+// remains available to Gather.
+func (p *poller) stop() {
+	p.cancel()
+}
+
+// Gather implements prometheus.Gatherer, returning a copy of the last
+// snapshot which the caller is free to modify.  The metrics reporting the age
+// of the snapshot are current, so carry no timestamp.
+func (p *poller) Gather() ([]*dto.MetricFamily, error) {
+	p.mu.RLock()
+	mfs := make([]*dto.MetricFamily, 0, len(p.mfs))
+	for _, mf := range p.mfs {
+		mfs = append(mfs, proto.Clone(mf).(*dto.MetricFamily))
+	}
+	err := p.err
+	p.mu.RUnlock()
+
+	return prometheus.Gatherers{
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return mfs, err
+		}),
+		p.age,
+	}.Gather()
+}
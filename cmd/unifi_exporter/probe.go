@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// defaultModule is the module used by the /probe endpoint when none is
+	// specified.
+	defaultModule = "default"
+)
+
+// probeHandler returns a http.Handler which serves metrics from the UniFi
+// Controller named by the target query parameter, using the settings of the
+// module named by the module query parameter, in the manner of the
+// snmp_exporter.
+func (s *server) probeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		module := r.URL.Query().Get("module")
+		if module == "" {
+			module = defaultModule
+		}
+
+		s.mu.RLock()
+		config, filter, labels := s.config, s.filter, s.labels
+		s.mu.RUnlock()
+
+		section, err := probeSection(config, module, target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := s.collectContext(r)
+		defer cancel()
+
+		c, cached, err := s.probeController(ctx, config, module, section)
+		if err != nil {
+			log.Printf("[ERROR] failed to probe %q with module %q: %v", target, module, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !cached {
+			defer closeControllers([]*controller{c})
+		}
+
+		g, err := c.gatherer(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		promhttp.HandlerFor(filter.gatherer(constLabelGatherer(g, labels)), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// Bounds on the controllers cached for the /probe endpoint, each of which
+// holds a session with its UniFi Controller.  A controller which has not been
+// probed for probeIdleTimeout is closed, as is the least recently probed one
+// once maxProbes are cached, so that requests for arbitrary targets cannot
+// accumulate sessions without limit.
+const (
+	maxProbes        = 64
+	probeIdleTimeout = 15 * time.Minute
+)
+
+// A probe is a controller cached for the /probe endpoint.
+type probe struct {
+	c    *controller
+	used time.Time
+}
+
+// probeController returns the controller for a module and target, creating
+// and authenticating it if it has not been probed recently since the
+// configuration was last loaded.  If the controller is not cached, because
+// the configuration was reloaded meanwhile, the caller must close it once the
+// probe is served.
+func (s *server) probeController(ctx context.Context, config *Config, module string, section map[string]string) (*controller, bool, error) {
+	key := module + "\x00" + section["address"]
+
+	s.probeMu.Lock()
+	p, ok := s.probes[key]
+	if ok {
+		p.used = time.Now()
+	}
+	s.probeMu.Unlock()
+	if ok {
+		return p.c, true, nil
+	}
+
+	cfg, err := parseControllerConfig(section, config.Collectors)
+	if err != nil {
+		return nil, false, err
+	}
+
+	e, sites, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c := &controller{
+		name:  section["address"],
+		e:     e,
+		sites: sites,
+	}
+
+	// A reload during creation replaces the cache, and the controller must
+	// not be stored in the new one with outdated settings
+	s.mu.RLock()
+	current := s.config == config
+	s.mu.RUnlock()
+
+	if !current {
+		return c, false, nil
+	}
+
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+
+	// Another request may have created the same controller meanwhile
+	if p, ok := s.probes[key]; ok {
+		closeControllers([]*controller{c})
+		p.used = time.Now()
+		return p.c, true, nil
+	}
+
+	closeControllers(s.evictProbes(time.Now()))
+	s.probes[key] = &probe{c: c, used: time.Now()}
+
+	return c, true, nil
+}
+
+// evictProbes removes the probed controllers which have been idle for
+// probeIdleTimeout, and then the least recently probed ones until there is
+// room for another, and returns them to be closed.  s.probeMu must be held.
+func (s *server) evictProbes(now time.Time) []*controller {
+	var evicted []*controller
+	for k, p := range s.probes {
+		if now.Sub(p.used) >= probeIdleTimeout {
+			evicted = append(evicted, p.c)
+			delete(s.probes, k)
+		}
+	}
+
+	for len(s.probes) >= maxProbes {
+		var oldest string
+		for k, p := range s.probes {
+			if oldest == "" || p.used.Before(s.probes[oldest].used) {
+				oldest = k
+			}
+		}
+
+		evicted = append(evicted, s.probes[oldest].c)
+		delete(s.probes, oldest)
+	}
+
+	return evicted
+}
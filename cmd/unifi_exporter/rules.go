@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A ruleFile is a Prometheus rule file.
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+// A ruleGroup is a named group of rules within a ruleFile.
+type ruleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []alertRule `yaml:"rules"`
+}
+
+// An alertRule is a Prometheus alerting rule.
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// alertRules are a starter set of alerts for the metrics exported by
+// unifi_exporter.  Thresholds are deliberately conservative, and are meant
+// to be tuned for each deployment.
+var alertRules = ruleFile{
+	Groups: []ruleGroup{
+		{
+			Name: "unifi_exporter",
+			Rules: []alertRule{
+				{
+					Alert:  "UniFiControllerUnreachable",
+					Expr:   "unifi_up == 0",
+					For:    "5m",
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"summary":     "UniFi Controller cannot be scraped",
+						"description": "unifi_exporter at {{ $labels.instance }} has failed to collect metrics from its UniFi Controller for 5 minutes.",
+					},
+				},
+				{
+					Alert:  "UniFiCollectorFailing",
+					Expr:   "increase(unifi_collector_scrape_errors_total[15m]) > 3",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi collector {{ $labels.collector }} is failing",
+						"description": "The {{ $labels.collector }} collector of unifi_exporter at {{ $labels.instance }} failed {{ $value }} times in the last 15 minutes.",
+					},
+				},
+				{
+					Alert:  "UniFiCollectorNotSucceeding",
+					Expr:   "time() - unifi_collector_last_success_timestamp_seconds > 3600",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi collector {{ $labels.collector }} has not succeeded for an hour",
+						"description": "The {{ $labels.collector }} collector of unifi_exporter at {{ $labels.instance }} last succeeded {{ $value | humanizeDuration }} ago.",
+					},
+				},
+				{
+					Alert:  "UniFiSiteScrapeFailing",
+					Expr:   "unifi_site_scrape_error == 1",
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi site {{ $labels.site }} cannot be scraped",
+						"description": "The {{ $labels.collector }} collector of unifi_exporter at {{ $labels.instance }} has failed to retrieve site {{ $labels.site }} for 15 minutes, while other sites succeed.",
+					},
+				},
+				{
+					Alert:  "UniFiExporterConfigReloadFailed",
+					Expr:   "unifi_exporter_config_last_reload_successful == 0",
+					For:    "10m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "unifi_exporter configuration reload failed",
+						"description": "unifi_exporter at {{ $labels.instance }} failed to reload its configuration, and is still using the previous one.",
+					},
+				},
+				{
+					Alert:  "UniFiExporterCacheStale",
+					Expr:   "unifi_snapshot_age_seconds > 600",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "unifi_exporter is serving stale metrics",
+						"description": "unifi_exporter at {{ $labels.instance }} last polled its UniFi Controller {{ $value | humanizeDuration }} ago.",
+					},
+				},
+			},
+		},
+		{
+			Name: "unifi_devices",
+			Rules: []alertRule{
+				{
+					Alert:  "UniFiDeviceOffline",
+					Expr:   "unifi_devices_last_seen_seconds > 300",
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"summary":     "UniFi device {{ $labels.name }} is offline",
+						"description": "UniFi device {{ $labels.name }} ({{ $labels.mac }}) in site {{ $labels.site }} was last seen by the controller {{ $value | humanizeDuration }} ago.",
+					},
+				},
+				{
+					Alert:  "UniFiDeviceRestarted",
+					Expr:   "unifi_devices_uptime_seconds < 600",
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"summary":     "UniFi device {{ $labels.name }} restarted",
+						"description": "UniFi device {{ $labels.name }} ({{ $labels.mac }}) in site {{ $labels.site }} has been up for only {{ $value | humanizeDuration }}.",
+					},
+				},
+				{
+					Alert:  "UniFiDevicesUnadopted",
+					Expr:   "unifi_devices_unadopted > 0",
+					For:    "1h",
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"summary":     "UniFi devices are waiting for adoption",
+						"description": "Site {{ $labels.site }} has {{ $value }} device(s) which have not been adopted for an hour.",
+					},
+				},
+				{
+					Alert:  "UniFiDevicePendingAdoption",
+					Expr:   "unifi_devices_pending_adoption_info == 1",
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"summary":     "UniFi device {{ $labels.mac }} is waiting for adoption",
+						"description": "A {{ $labels.model }} ({{ $labels.mac }}) at {{ $labels.ip }} in site {{ $labels.site }} has contacted the controller and is waiting to be adopted.",
+					},
+				},
+				{
+					Alert:  "UniFiDeviceStuckProvisioning",
+					Expr:   "unifi_devices_state{state=\"provisioning\"} == 1",
+					For:    "30m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi device {{ $labels.name }} is stuck provisioning",
+						"description": "UniFi device {{ $labels.name }} ({{ $labels.mac }}) in site {{ $labels.site }} has been provisioning for 30 minutes; forcing a provision or restarting the device usually clears it.",
+					},
+				},
+				{
+					Alert:  "UniFiDeviceDroppingPackets",
+					Expr:   "rate(unifi_devices_transmitted_packets_dropped_total[10m]) / rate(unifi_devices_transmitted_packets_total[10m]) > 0.05",
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi device {{ $labels.name }} is dropping packets",
+						"description": "UniFi device {{ $labels.name }} ({{ $labels.mac }}) in site {{ $labels.site }} is dropping {{ $value | humanizePercentage }} of transmitted packets.",
+					},
+				},
+				{
+					Alert:  "UniFiPortHalfDuplex",
+					Expr:   "unifi_devices_port_full_duplex == 0",
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi port {{ $labels.interface }} of {{ $labels.name }} is half duplex",
+						"description": "Port {{ $labels.port }} ({{ $labels.interface }}) of UniFi device {{ $labels.name }} in site {{ $labels.site }} negotiated half duplex, which usually means a bad cable or a duplex mismatch.",
+					},
+				},
+				{
+					Alert:  "UniFiLAGDegraded",
+					Expr:   "unifi_devices_lag_members_down > 0 and unifi_devices_lag_up == 1",
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi link aggregation group {{ $labels.lag }} of {{ $labels.name }} is degraded",
+						"description": "{{ $value }} member port(s) of the link aggregation group led by port {{ $labels.lag }} of UniFi device {{ $labels.name }} in site {{ $labels.site }} are down, leaving the link with less bandwidth and no redundancy.",
+					},
+				},
+				{
+					Alert:  "UniFiPortBroadcastStorm",
+					Expr:   "rate(unifi_devices_port_received_broadcast_packets_total[5m]) > 1000",
+					For:    "5m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi port {{ $labels.interface }} of {{ $labels.name }} is receiving a broadcast storm",
+						"description": "Port {{ $labels.port }} ({{ $labels.interface }}) of UniFi device {{ $labels.name }} in site {{ $labels.site }} is receiving {{ $value | humanize }} broadcast packets per second, which usually means a switching loop.",
+					},
+				},
+				{
+					Alert:  "UniFiRadarDetectionsRecurring",
+					Expr:   "increase(unifi_events_radar_detections_total[1d]) > 3",
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"summary":     "UniFi access point {{ $labels.ap_mac }} keeps detecting radar on channel {{ $labels.channel }}",
+						"description": "UniFi access point {{ $labels.ap_mac }} in site {{ $labels.site }} detected radar on channel {{ $labels.channel }} {{ $value }} times in the last day, disconnecting its 5 GHz clients each time; consider a channel outside the DFS range.",
+					},
+				},
+				{
+					Alert:  "UniFiStationsFailingToConnect",
+					Expr:   "sum by (site, ap_mac, ssid) (increase(unifi_events_station_failures_total[15m])) > 10",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "Stations are failing to connect to {{ $labels.ssid }} on UniFi access point {{ $labels.ap_mac }}",
+						"description": "Stations failed to connect to SSID {{ $labels.ssid }} on UniFi access point {{ $labels.ap_mac }} in site {{ $labels.site }} {{ $value }} times in the last 15 minutes.",
+					},
+				},
+				{
+					Alert:  "UniFiAdminLoginFailures",
+					Expr:   "increase(unifi_events_admin_logins_total{result=\"failure\"}[15m]) > 5",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "Repeated failed logins to the UniFi Controller in site {{ $labels.site }}",
+						"description": "{{ $value }} logins to the UniFi Controller in site {{ $labels.site }} failed in the last 15 minutes.",
+					},
+				},
+				{
+					Alert:  "UniFiControllerBackupStale",
+					Expr:   "time() - unifi_backup_last_timestamp_seconds > 2 * 86400 or unifi_backup_count == 0",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi Controller has not made an automatic backup for two days",
+						"description": "The UniFi Controller scraped by {{ $labels.instance }} has no automatic backup from the last two days, or none at all.",
+					},
+				},
+				{
+					Alert:  "UniFiWANUnavailable",
+					Expr:   "unifi_devices_wan_availability_ratio < 0.99",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "UniFi gateway {{ $labels.name }} WAN {{ $labels.wan }} is unreliable",
+						"description": "WAN {{ $labels.wan }} of UniFi gateway {{ $labels.name }} in site {{ $labels.site }} was available for only {{ $value | humanizePercentage }} of the controller's monitoring period.",
+					},
+				},
+			},
+		},
+	},
+}
+
+// runRules writes alertRules to w as a Prometheus rule file.
+func runRules(w io.Writer) error {
+	b, err := yaml.Marshal(alertRules)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s%s", rulesHeader, b)
+	return err
+}
+
+// rulesHeader introduces the rule file written by runRules.
+const rulesHeader = `# Prometheus alerting rules for unifi_exporter, generated by
+# "unifi_exporter rules".  Thresholds are starting points; tune them, and the
+# severity labels, for your own network and Alertmanager routing.
+#
+# The exporter does not yet export DHCP or PoE metrics, so there are no rules
+# for exhausted DHCP pools or PoE budgets.
+`
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// metricRE matches the names of metrics exported by unifi_exporter.
+var metricRE = regexp.MustCompile(`\bunifi_[a-z_]+`)
+
+func Test_runRules(t *testing.T) {
+	var buf bytes.Buffer
+	if err := runRules(&buf); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+
+	var rules ruleFile
+	if err := yaml.Unmarshal(buf.Bytes(), &rules); err != nil {
+		t.Fatalf("failed to parse rules: %v\n%s", err, buf.String())
+	}
+
+	// Every metric referenced by the rules must be exported by unifi_exporter,
+	// so that the rules are not silently broken by renaming a metric.
+	docs, err := exportedMetrics()
+	if err != nil {
+		t.Fatalf("failed to list exported metrics: %v", err)
+	}
+
+	known := map[string]bool{}
+	for _, d := range docs {
+		known[d.Name] = true
+	}
+
+	for _, g := range rules.Groups {
+		if len(g.Rules) == 0 {
+			t.Fatalf("rule group %q has no rules", g.Name)
+		}
+
+		for _, r := range g.Rules {
+			if r.Alert == "" || r.Annotations["summary"] == "" {
+				t.Fatalf("rule in group %q is missing an alert name or summary: %+v", g.Name, r)
+			}
+
+			for _, name := range metricRE.FindAllString(r.Expr, -1) {
+				if !known[name] {
+					t.Fatalf("rule %q references unknown metric %q", r.Alert, name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// sdLabelPrefix prefixes the labels of discovered targets, which Prometheus
+// drops after relabeling unless they are copied to other labels.
+const sdLabelPrefix = "__meta_unifi_"
+
+// An sdTargetGroup is a group of targets in the format of Prometheus HTTP
+// service discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler returns a http.Handler which serves the devices of every
+// configured UniFi Controller for Prometheus HTTP service discovery, so that
+// other scrapers, such as the snmp_exporter or blackbox_exporter, can target
+// them.  Each device with an IP address is a target, labeled with its site,
+// name, MAC address and model.
+func (s *server) sdHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := s.collectContext(r)
+		defer cancel()
+
+		// Prometheus keeps the previous targets when a refresh fails, so
+		// fail only when no devices could be retrieved at all
+		groups := []sdTargetGroup{}
+		var failed []string
+		for _, c := range s.currentControllers() {
+			e, _ := c.current()
+			if e == nil {
+				failed = append(failed, c.name)
+				continue
+			}
+
+			sds, err := e.Devices(ctx)
+			if err != nil {
+				log.Printf("[WARN] failed to retrieve devices of controller %q for service discovery: %v", c.name, err)
+				failed = append(failed, c.name)
+			}
+
+			for _, sd := range sds {
+				groups = append(groups, sdTargetGroups(c.name, sd.Site, sd.Devices)...)
+			}
+		}
+		if len(failed) > 0 && len(groups) == 0 {
+			http.Error(w, "failed to retrieve devices from UniFi controller(s)", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(groups); err != nil {
+			log.Printf("[ERROR] failed to write service discovery targets: %v", err)
+		}
+	})
+}
+
+// sdTargetGroups returns a target group for each of the devices of site s
+// with an IP address.  controller, if set, is the name of
+// the UniFi Controller which manages them.
+func sdTargetGroups(controller string, s *api.Site, devices []*api.Device) []sdTargetGroup {
+	groups := make([]sdTargetGroup, 0, len(devices))
+	for _, d := range devices {
+		if d.IP == nil {
+			continue
+		}
+
+		labels := map[string]string{
+			sdLabelPrefix + "site_name":        s.Name,
+			sdLabelPrefix + "site_description": s.Description,
+			sdLabelPrefix + "device_id":        d.ID,
+			sdLabelPrefix + "device_name":      d.Name,
+			sdLabelPrefix + "device_mac":       d.MAC.String(),
+			sdLabelPrefix + "device_model":     d.Model,
+			sdLabelPrefix + "device_type":      d.Type,
+			sdLabelPrefix + "device_version":   d.Version,
+		}
+		if controller != "" {
+			labels[sdLabelPrefix+"controller"] = controller
+		}
+
+		groups = append(groups, sdTargetGroup{
+			Targets: []string{d.IP.String()},
+			Labels:  labels,
+		})
+	}
+
+	return groups
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+)
+
+func Test_serverSD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	s := newServer(testConfigFile(t, dir, unifi.URL, "Default"))
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.sdHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sd", nil))
+
+	if want, got := http.StatusOK, w.Code; want != got {
+		t.Fatalf("unexpected HTTP status code:\n- want: %v\n-  got: %v (%s)",
+			want, got, w.Body.String())
+	}
+
+	var groups []sdTargetGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+		t.Fatalf("failed to decode targets: %v", err)
+	}
+
+	want := []sdTargetGroup{{
+		Targets: []string{"192.168.1.2"},
+		Labels: map[string]string{
+			"__meta_unifi_site_name":        "default",
+			"__meta_unifi_site_description": "Default",
+			"__meta_unifi_device_id":        "device1",
+			"__meta_unifi_device_name":      "Office AP",
+			"__meta_unifi_device_mac":       "de:ad:be:ef:00:01",
+			"__meta_unifi_device_model":     "U7PG2",
+			"__meta_unifi_device_type":      "uap",
+			"__meta_unifi_device_version":   "",
+		},
+	}}
+	if got := groups; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected targets:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_sdTargetGroups(t *testing.T) {
+	s := &api.Site{Name: "default", Description: "Default"}
+	devices := []*api.Device{
+		{Name: "pending"},
+		{
+			Name:  "gateway",
+			IP:    net.IPv4(192, 168, 1, 1),
+			MAC:   net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01},
+			Model: "UDMPRO",
+		},
+	}
+
+	// Devices without an address cannot be targeted
+	groups := sdTargetGroups("hq", s, devices)
+	if want, got := 1, len(groups); want != got {
+		t.Fatalf("unexpected number of target groups:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	g := groups[0]
+	if want, got := []string{"192.168.1.1"}, g.Targets; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected targets:\n- want: %v\n-  got: %v", want, got)
+	}
+	for name, want := range map[string]string{
+		"__meta_unifi_controller":   "hq",
+		"__meta_unifi_device_mac":   "de:ad:be:ef:00:01",
+		"__meta_unifi_device_model": "UDMPRO",
+	} {
+		if got := g.Labels[name]; want != got {
+			t.Fatalf("unexpected label %s:\n- want: %v\n-  got: %v", name, want, got)
+		}
+	}
+}
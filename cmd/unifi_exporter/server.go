@@ -0,0 +1,909 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/bah2830/unifi_exporter/pkg/unifi/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	reloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "unifi_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+
+	reloadSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "unifi_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reloadSuccess)
+	prometheus.MustRegister(reloadSuccessTime)
+}
+
+// A server serves metrics from Exporters built from the configuration file,
+// which may be reloaded at runtime without interrupting the HTTP listener.
+type server struct {
+	configFile string
+
+	// reloadMu serializes reloads, which may take some time because they
+	// authenticate against the UniFi Controller.
+	reloadMu sync.Mutex
+
+	// mu protects the fields below, which are replaced on each reload.
+	mu          sync.RWMutex
+	config      *Config
+	controllers []*controller
+	reloadToken string
+	auth        *metricsAuth
+	filter      *metricFilter
+	labels      map[string]string
+
+	// otlp, if set, exports metrics to an OpenTelemetry collector.
+	otlp *otlpPusher
+
+	// textfile, if set, writes metrics to a file for node_exporter.
+	textfile *textfileWriter
+
+	// notifier, if set, notifies webhooks of events from each controller,
+	// and eventLog, if set, writes them as JSON lines.
+	notifier *notifier
+	eventLog *eventLogger
+
+	// probeMu protects probes, which caches the controllers created for
+	// each module and target requested via the /probe endpoint.
+	probeMu sync.Mutex
+	probes  map[string]*probe
+
+	// ctx is cancelled when the server shuts down, which also cancels any
+	// collections in progress.
+	ctx    context.Context
+	cancel func()
+}
+
+// A controller is the Exporter for a single UniFi Controller.
+type controller struct {
+	name string
+
+	// cfg, if set, is used to create the Exporter on a later scrape, when
+	// the UniFi Controller could not be reached as the configuration was
+	// loaded.
+	cfg *controllerConfig
+
+	// mu protects the fields below, which are only set once the Exporter
+	// has been created.
+	mu     sync.Mutex
+	e      *exporter.Exporter
+	sites  []*api.Site
+	closed bool
+
+	// poller, if set, serves cached metrics instead of scraping e on
+	// each request.
+	poller *poller
+}
+
+// current returns the Exporter of c and the sites it exports, or a nil
+// Exporter if it has not yet been created.
+func (c *controller) current() (*exporter.Exporter, []*api.Site) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.e, c.sites
+}
+
+// connect creates the Exporter of c, if the UniFi Controller could not be
+// reached when the configuration was loaded, and starts polling it if
+// configured to.
+func (c *controller) connect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.e != nil {
+		return nil
+	}
+	if c.closed {
+		return errors.New("controller configuration was replaced")
+	}
+
+	e, sites, err := newExporter(ctx, c.cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] connected to UniFi controller %q for site(s): %s", c.name, sitesString(sites))
+
+	c.e, c.sites = e, sites
+	if c.cfg.pollInterval > 0 {
+		c.poller = startPoller(e, c.cfg.pollInterval)
+	}
+
+	return nil
+}
+
+// gatherer returns a prometheus.Gatherer for the metrics of c, creating its
+// Exporter first if necessary.
+func (c *controller) gatherer(ctx context.Context) (prometheus.Gatherer, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	e, p := c.e, c.poller
+	c.mu.Unlock()
+
+	if p != nil {
+		return p, nil
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(e.WithContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// close stops polling c and logs out of its UniFi Controller.  An Exporter
+// which has not been created by then never will be.
+func (c *controller) close(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	e, p := c.e, c.poller
+	c.mu.Unlock()
+
+	if p != nil {
+		p.stop()
+	}
+	if e == nil {
+		return nil
+	}
+
+	return e.Close(ctx)
+}
+
+// downGatherer reports a UniFi Controller which cannot be reached, and so has
+// no Exporter to report it.  Its metadata matches the up metric of an
+// Exporter, so that both may be served together.
+var downGatherer = func() prometheus.Gatherer {
+	up := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "unifi",
+		Name:      "up",
+		Help:      "Whether the last scrape of the UniFi Controller was successful",
+	})
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(up)
+
+	return reg
+}()
+
+// newServer creates a server for the configuration file at configFile.
+// apply or reload must be called before the server is used.
+func newServer(configFile string) *server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &server{
+		configFile: configFile,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// reload reads the configuration file again and applies it.  If any part of
+// the new configuration is invalid, the previous configuration remains in
+// effect.
+func (s *server) reload() error {
+	config, err := loadConfig(s.configFile)
+	if err == nil {
+		err = s.apply(config)
+	}
+
+	if err != nil {
+		reloadSuccess.Set(0)
+		return err
+	}
+
+	return nil
+}
+
+// apply builds new Exporters for each UniFi Controller in config, and replaces
+// the current Exporters only once all of the new ones are ready.
+//
+// The listen address and metrics path cannot be changed without restarting
+// the exporter, so only the remainder of the listen section is applied.
+func (s *server) apply(config *Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	auth, err := parseMetricsAuth(config.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen configuration in config file %q: %v", s.configFile, err)
+	}
+
+	filter, err := parseMetricFilter(config.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter configuration in config file %q: %v", s.configFile, err)
+	}
+
+	labels, err := parseConstLabels(config.Labels)
+	if err != nil {
+		return fmt.Errorf("invalid labels configuration in config file %q: %v", s.configFile, err)
+	}
+
+	otlp, err := parseOTLPConfig(config.OTLP)
+	if err != nil {
+		return fmt.Errorf("invalid otlp configuration in config file %q: %v", s.configFile, err)
+	}
+
+	textfile, err := parseTextfileConfig(config.Textfile)
+	if err != nil {
+		return fmt.Errorf("invalid textfile configuration in config file %q: %v", s.configFile, err)
+	}
+
+	notify, err := parseNotifyConfig(config.Notify)
+	if err != nil {
+		return fmt.Errorf("invalid notify configuration in config file %q: %v", s.configFile, err)
+	}
+
+	eventLog, err := parseEventLogConfig(config.EventLog)
+	if err != nil {
+		return fmt.Errorf("invalid eventlog configuration in config file %q: %v", s.configFile, err)
+	}
+
+	sections, err := controllerSections(config)
+	if err != nil {
+		return fmt.Errorf("invalid controllers configuration in config file %q: %v", s.configFile, err)
+	}
+
+	siteConfigs, err := parseSiteConfigs(config.Sites)
+	if err != nil {
+		return fmt.Errorf("invalid sites configuration in config file %q: %v", s.configFile, err)
+	}
+
+	// The OTLP pusher is only started once the configuration is applied
+	var op *otlpPusher
+	if otlp != nil {
+		// As with several controllers, metrics which can be gathered are
+		// exported even if others cannot
+		op, err = newOTLPPusher(*otlp, func(ctx context.Context) (prometheus.Gatherer, error) {
+			g, _, err := s.gatherer(ctx)
+			return g, err
+		})
+		if err != nil {
+			return fmt.Errorf("invalid otlp configuration in config file %q: %v", s.configFile, err)
+		}
+	}
+
+	// Events may arrive as soon as each Exporter is created, so the
+	// notifier and event log are ready first, and are stopped if any
+	// Exporter cannot be created
+	var (
+		n  *notifier
+		el *eventLogger
+	)
+	if eventLog != nil {
+		el, err = newEventLogger(*eventLog)
+		if err != nil {
+			if op != nil {
+				op.stop()
+			}
+			return fmt.Errorf("invalid eventlog configuration in config file %q: %v", s.configFile, err)
+		}
+	}
+	if notify != nil {
+		n = newNotifier(*notify)
+		n.start()
+	}
+	fail := func(err error) error {
+		if op != nil {
+			op.stop()
+		}
+		if n != nil {
+			n.stop()
+		}
+		if el != nil {
+			_ = el.close()
+		}
+
+		return err
+	}
+
+	controllers := make([]*controller, 0, len(sections))
+	for _, section := range sections {
+		cfg, err := parseControllerConfig(section, config.Collectors)
+		if err != nil {
+			closeControllers(controllers)
+			return fail(fmt.Errorf("invalid configuration for controller %q in config file %q: %v",
+				section["name"], s.configFile, err))
+		}
+		if fn := siteConfigFunc(siteConfigs, cfg.name); fn != nil {
+			cfg.options = append(cfg.options, exporter.SiteConfigs(fn))
+		}
+		// OnEvent enables the events collector, which, like the other
+		// unshardedCollectors, runs only in the first shard
+		if n != nil && cfg.shard.first() {
+			cfg.options = append(cfg.options, exporter.OnEvent(n.handler(cfg.name)))
+		}
+		if el != nil && cfg.shard.first() {
+			cfg.options = append(cfg.options, exporter.OnEvent(el.handler(cfg.name)))
+		}
+
+		c := &controller{
+			name: cfg.name,
+			cfg:  cfg,
+		}
+
+		e, sites, err := newExporter(context.Background(), cfg)
+		switch {
+		case err != nil && len(sections) == 1:
+			closeControllers(controllers)
+			return fail(fmt.Errorf("controller %q: %v", cfg.name, err))
+		case err != nil:
+			// One unreachable controller must not stop the others from
+			// being exported, so it reports itself down, and is tried
+			// again on each scrape
+			log.Printf("[WARN] failed to connect to UniFi controller %q, will retry on each scrape: %v", cfg.name, err)
+		default:
+			c.e, c.sites = e, sites
+		}
+
+		controllers = append(controllers, c)
+	}
+
+	// Polling only begins once every controller is ready, so that nothing
+	// need be stopped if the configuration is invalid
+	for _, c := range controllers {
+		if c.e != nil && c.cfg.pollInterval > 0 {
+			c.poller = startPoller(c.e, c.cfg.pollInterval)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	closeControllers(s.controllers)
+
+	s.config = config
+	s.controllers = controllers
+	s.reloadToken = config.Listen["reloadtoken"]
+	s.auth = auth
+	s.filter = filter
+	s.labels = labels
+
+	if s.notifier != nil {
+		s.notifier.stop()
+	}
+	s.notifier = n
+	if s.eventLog != nil {
+		_ = s.eventLog.close()
+	}
+	s.eventLog = el
+
+	if s.otlp != nil {
+		s.otlp.stop()
+		s.otlp = nil
+	}
+	if op != nil {
+		s.otlp = op
+		s.otlp.start()
+	}
+
+	if s.textfile != nil {
+		s.textfile.stop()
+		s.textfile = nil
+	}
+	if textfile != nil {
+		s.textfile = newTextfileWriter(*textfile, func(ctx context.Context) (prometheus.Gatherer, error) {
+			g, _, err := s.gatherer(ctx)
+			return g, err
+		})
+		s.textfile.start()
+	}
+
+	// Probed controllers are created again on demand, using the new modules
+	s.probeMu.Lock()
+	probes := make([]*controller, 0, len(s.probes))
+	for _, p := range s.probes {
+		probes = append(probes, p.c)
+	}
+	s.probes = make(map[string]*probe)
+	s.probeMu.Unlock()
+	closeControllers(probes)
+
+	reloadSuccess.Set(1)
+	reloadSuccessTime.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// closeControllers stops polling and closes the Exporters of controllers which
+// are no longer used, without waiting for any scrape in progress to complete.
+// Closing an Exporter also stops the streams of its events collector, which
+// would otherwise run forever.
+func closeControllers(cs []*controller) {
+	for _, c := range cs {
+		go func(c *controller) {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+
+			if err := c.close(ctx); err != nil {
+				log.Printf("[WARN] failed to log out of UniFi controller %q: %v", c.name, err)
+			}
+		}(c)
+	}
+}
+
+// shutdown cancels any collections in progress, shuts down srv, and then stops
+// polling and logs out of every UniFi Controller, so that no sessions are left
+// behind.  ctx bounds the time spent waiting for requests to complete and for
+// each controller to log out.
+func (s *server) shutdown(ctx context.Context, srv *http.Server) {
+	s.cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] failed to shut down HTTP server: %v", err)
+	}
+
+	s.mu.Lock()
+	if s.otlp != nil {
+		s.otlp.stop()
+	}
+	if s.textfile != nil {
+		s.textfile.stop()
+	}
+	if s.notifier != nil {
+		s.notifier.stop()
+	}
+	if s.eventLog != nil {
+		_ = s.eventLog.close()
+	}
+	s.mu.Unlock()
+
+	cs := append([]*controller(nil), s.currentControllers()...)
+	s.probeMu.Lock()
+	for _, p := range s.probes {
+		cs = append(cs, p.c)
+	}
+	s.probeMu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+	for _, c := range cs {
+		go func(c *controller) {
+			defer wg.Done()
+
+			if err := c.close(ctx); err != nil {
+				log.Printf("[ERROR] failed to log out of UniFi controller %q: %v", c.name, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// collectContext returns a context for collecting metrics on behalf of r,
+// which is also cancelled when the server shuts down.  If r carries the scrape
+// timeout of a Prometheus server, collection is cancelled shortly before the
+// timeout, so that Prometheus receives whatever metrics were collected in time
+// rather than marking the target down.
+func (s *server) collectContext(r *http.Request) (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout, ok := scrapeTimeout(r.Header); ok {
+		ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout of a Prometheus
+// server, leaving time to encode and send the collected metrics.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// scrapeTimeout returns the time available for collecting metrics, if h carries
+// the X-Prometheus-Scrape-Timeout-Seconds header.  Timeouts too short to
+// subtract scrapeTimeoutOffset from are used as they are.
+func scrapeTimeout(h http.Header) (time.Duration, bool) {
+	v := h.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return 0, false
+	}
+
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(secs) || secs <= 0 || secs > math.MaxInt64/float64(time.Second) {
+		return 0, false
+	}
+
+	timeout := time.Duration(secs * float64(time.Second))
+	if timeout > 2*scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+
+	return timeout, true
+}
+
+// currentControllers returns the controllers for the current configuration.
+func (s *server) currentControllers() []*controller {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.controllers
+}
+
+// describe returns a summary of the sites exported for each controller, meant
+// for displaying to users.
+func (s *server) describe() string {
+	cs := s.currentControllers()
+	if len(cs) == 0 {
+		return "probes only"
+	}
+	if len(cs) == 1 && cs[0].name == "" {
+		_, sites := cs[0].current()
+		return "site(s): " + sitesString(sites)
+	}
+
+	ds := make([]string, 0, len(cs))
+	for _, c := range cs {
+		e, sites := c.current()
+		if e == nil {
+			ds = append(ds, fmt.Sprintf("%s (unreachable)", c.name))
+			continue
+		}
+
+		ds = append(ds, fmt.Sprintf("%s (%s)", c.name, sitesString(sites)))
+	}
+
+	return "controller(s): " + strings.Join(ds, ", ")
+}
+
+// reloadOnSignal reloads the configuration each time a signal is received
+// on sigC.
+func (s *server) reloadOnSignal(sigC <-chan os.Signal) {
+	for range sigC {
+		log.Println("[INFO] received SIGHUP, reloading configuration")
+		s.logReload(s.reload())
+	}
+}
+
+// logReload reports the outcome of a reload.
+func (s *server) logReload(err error) {
+	if err != nil {
+		log.Printf("[ERROR] failed to reload configuration, keeping previous configuration: %v", err)
+		return
+	}
+
+	log.Printf("[INFO] reloaded configuration for %s", s.describe())
+}
+
+// metricsHandler returns a http.Handler which serves the metrics returned by
+// gatherer.  Collection is cancelled if the scrape request is abandoned, or
+// the server shuts down.
+func (s *server) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := s.collectContext(r)
+		defer cancel()
+
+		g, opts, err := s.gatherer(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		promhttp.HandlerFor(g, opts).ServeHTTP(w, r)
+	})
+}
+
+// gatherer returns a prometheus.Gatherer for metrics from the current
+// Exporters alongside those of the default Prometheus registry, with the
+// options used to serve them.  Named controllers are scraped concurrently,
+// and their metrics carry a controller label.  Controllers with a poll
+// interval serve their cached metrics instead.  The labels and filter
+// sections of the configuration file apply to every metric.
+func (s *server) gatherer(ctx context.Context) (prometheus.Gatherer, promhttp.HandlerOpts, error) {
+	cs := s.currentControllers()
+
+	// With several controllers, one which is unreachable should not
+	// prevent the others from being scraped
+	var opts promhttp.HandlerOpts
+	if len(cs) > 1 {
+		opts.ErrorHandling = promhttp.ContinueOnError
+	}
+
+	gs := make([]prometheus.Gatherer, 0, len(cs))
+	for _, c := range cs {
+		g, err := c.gatherer(ctx)
+		if err != nil {
+			if len(cs) == 1 {
+				return nil, opts, err
+			}
+
+			log.Printf("[WARN] UniFi controller %q is unreachable: %v", c.name, err)
+			g = downGatherer
+		}
+
+		if c.name != "" {
+			g = &labelGatherer{
+				g:     g,
+				name:  "controller",
+				value: c.name,
+			}
+		}
+
+		gs = append(gs, g)
+	}
+
+	s.mu.RLock()
+	filter, labels := s.filter, s.labels
+	s.mu.RUnlock()
+
+	g := prometheus.Gatherers{prometheus.DefaultGatherer, concurrentGatherer(gs)}
+	return filter.gatherer(constLabelGatherer(g, labels)), opts, nil
+}
+
+// reloadHandler returns a http.Handler which reloads the configuration upon
+// a POST request bearing the token set by reloadtoken in the listen section
+// of the configuration file.  Without a token, the handler is disabled.
+func (s *server) reloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "reload requires a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.mu.RLock()
+		token := s.reloadToken
+		s.mu.RUnlock()
+
+		if token == "" {
+			http.Error(w, "reload endpoint is disabled; set reloadtoken in the listen section of the config file", http.StatusForbidden)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="unifi_exporter"`)
+			http.Error(w, "invalid reload token", http.StatusUnauthorized)
+			return
+		}
+
+		err := s.reload()
+		s.logReload(err)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload configuration: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// indexTemplate renders the landing page served at the root of the exporter.
+var indexTemplate = template.Must(template.New("index").Parse(`<html>
+<head><title>UniFi Exporter</title></head>
+<body>
+<h1>UniFi Exporter</h1>
+<p>Exporting metrics for {{.Description}}.</p>
+<ul>
+<li><a href="{{.MetricsPath}}">Metrics</a></li>
+<li><a href="/sd">Service discovery</a></li>
+<li><a href="/-/healthy">Health</a></li>
+<li><a href="/-/ready">Readiness</a></li>
+</ul>
+</body>
+</html>
+`))
+
+// indexHandler returns a http.Handler which serves a landing page linking to
+// the metrics at metricsPath.  Any path other than the root is not found.
+func (s *server) indexHandler(metricsPath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := indexTemplate.Execute(w, struct {
+			Description string
+			MetricsPath string
+		}{
+			Description: s.describe(),
+			MetricsPath: metricsPath,
+		})
+		if err != nil {
+			log.Printf("[ERROR] failed to render landing page: %v", err)
+		}
+	})
+}
+
+// healthyHandler returns a http.Handler which reports that the exporter is
+// running, for use as a liveness probe.
+func (s *server) healthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("UniFi exporter is healthy.\n"))
+	})
+}
+
+// readyHandler returns a http.Handler which reports whether every configured
+// UniFi Controller has an established session, for use as a readiness probe.
+func (s *server) readyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var notReady []string
+		for _, c := range s.currentControllers() {
+			if e, _ := c.current(); e == nil || !e.Authenticated() {
+				name := c.name
+				if name == "" {
+					name = "unifi"
+				}
+
+				notReady = append(notReady, name)
+			}
+		}
+
+		if len(notReady) > 0 {
+			http.Error(w, fmt.Sprintf("not authenticated to UniFi controller(s): %s",
+				strings.Join(notReady, ", ")), http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = w.Write([]byte("UniFi exporter is ready.\n"))
+	})
+}
+
+// authenticate returns a http.Handler which serves requests with h only if
+// they carry the bearer token or the basic authentication credentials of a
+// user configured in the listen section of the configuration file.
+func (s *server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		auth := s.auth
+		s.mu.RUnlock()
+
+		if auth.allowed(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if len(auth.users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="unifi_exporter"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="unifi_exporter"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// allowed reports whether r may access the metrics and probe endpoints.
+func (a *metricsAuth) allowed(r *http.Request) bool {
+	if a == nil || (a.bearerToken == "" && len(a.users) == 0) {
+		return true
+	}
+
+	if a.bearerToken != "" {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(a.bearerToken)) == 1 {
+			return true
+		}
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	hash, ok := a.users[username]
+	if !ok {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// newExporter authenticates against a UniFi Controller and creates an
+// Exporter for the sites selected by cfg.
+func newExporter(ctx context.Context, cfg *controllerConfig) (*exporter.Exporter, []*api.Site, error) {
+	clientFn := newClient(cfg.client)
+	c, err := clientFn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	e, sites, err := newExporterFromClient(ctx, cfg, c, clientFn)
+	if err != nil {
+		// The session is not used by any Exporter, so it must not linger
+		if client, ok := c.(*api.Client); ok {
+			if err := client.Logout(ctx); err != nil {
+				log.Printf("[WARN] failed to log out of UniFi controller: %v", err)
+			}
+		}
+
+		return nil, nil, err
+	}
+
+	return e, sites, nil
+}
+
+// newExporterFromClient creates an Exporter for the sites selected by cfg,
+// which starts with c, already authenticated, rather than logging in again,
+// which would submit a one-time code twice.  clientFn authenticates again
+// if the session of c can no longer be renewed.
+func newExporterFromClient(ctx context.Context, cfg *controllerConfig, c api.Controller, clientFn exporter.ClientFunc) (*exporter.Exporter, []*api.Site, error) {
+	sites, err := c.Sites(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve list of sites: %v", err)
+	}
+
+	useSites, err := selectSites(cfg.site, cfg.shard, sites)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select a site: %v", err)
+	}
+
+	// The first of several shards runs the unshardedCollectors, which
+	// stream events from, and list backups through, every selected site,
+	// not only its own
+	options := cfg.options
+	if cfg.shard.count > 1 && cfg.shard.first() {
+		site := cfg.site
+		selectAll := func(sites []*api.Site) ([]*api.Site, error) {
+			return selectSites(site, siteShard{}, sites)
+		}
+
+		allSites, err := selectAll(sites)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to select a site: %v", err)
+		}
+
+		options = append(options[:len(options):len(options)], exporter.AllSites(allSites, selectAll))
+	}
+
+	// The Exporter never calls its ClientFunc concurrently, so first needs
+	// no lock of its own
+	first := c
+	fn := func(ctx context.Context) (api.Controller, error) {
+		if c := first; c != nil {
+			first = nil
+			return c, nil
+		}
+
+		return clientFn(ctx)
+	}
+
+	e, err := exporter.New(useSites, fn, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create exporter: %v", err)
+	}
+
+	return e, useSites, nil
+}
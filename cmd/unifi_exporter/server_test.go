@@ -0,0 +1,757 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testConfigFile writes a configuration file for the fake UniFi Controller at
+// addr, selecting site, and returns its path.
+func testConfigFile(t *testing.T, dir string, addr string, site string) string {
+	config := fmt.Sprintf(`
+listen:
+  reloadtoken: secret
+unifi:
+  address: %s
+  username: %s
+  password: %s
+  site: %s
+`, addr, apitest.Username, apitest.Password, site)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	return path
+}
+
+func Test_serverReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	s := newServer(testConfigFile(t, dir, unifi.URL, "Default"))
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	cs := s.currentControllers()
+
+	// An invalid configuration must leave the previous one in effect
+	_ = testConfigFile(t, dir, unifi.URL, "Missing")
+	if err := s.reload(); err == nil {
+		t.Fatal("expected an error reloading configuration with a missing site")
+	}
+	if s.currentControllers()[0] != cs[0] {
+		t.Fatal("exporter was replaced by an invalid configuration")
+	}
+
+	unifi.SetSites(apitest.DefaultSiteJSON, `{"_id":"site2","desc":"Missing","name":"missing"}`)
+
+	var tests = []struct {
+		desc   string
+		method string
+		auth   string
+		code   int
+	}{
+		{
+			desc:   "GET request",
+			method: http.MethodGet,
+			auth:   "Bearer secret",
+			code:   http.StatusMethodNotAllowed,
+		},
+		{
+			desc:   "no token",
+			method: http.MethodPost,
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "wrong token",
+			method: http.MethodPost,
+			auth:   "Bearer wrong",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "OK",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			code:   http.StatusNoContent,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		r := httptest.NewRequest(tt.method, "/-/reload", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+
+		w := httptest.NewRecorder()
+		s.reloadHandler().ServeHTTP(w, r)
+
+		if want, got := tt.code, w.Code; want != got {
+			t.Fatalf("unexpected HTTP status code:\n- want: %v\n-  got: %v (%s)",
+				want, got, w.Body.String())
+		}
+	}
+
+	if want, got := "site(s): Missing", s.describe(); want != got {
+		t.Fatalf("unexpected sites after reload:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_serverMultipleControllers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	foo, bar := apitest.NewServer(), apitest.NewServer()
+	defer foo.Close()
+	defer bar.Close()
+
+	bar.SetCredentials("bar", "barpass")
+
+	// Keys in the unifi section apply to every controller which does not
+	// set them itself
+	config := fmt.Sprintf(`
+unifi:
+  username: %s
+  password: %s
+controllers:
+  - name: foo
+    address: %s
+  - name: bar
+    address: %s
+    username: bar
+    password: barpass
+`, apitest.Username, apitest.Password, foo.URL, bar.URL)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	s := newServer(path)
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	if want, got := "controller(s): foo (Default), bar (Default)", s.describe(); want != got {
+		t.Fatalf("unexpected description:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	w := httptest.NewRecorder()
+	s.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := w.Body.String()
+	for _, name := range []string{"foo", "bar"} {
+		want := fmt.Sprintf(`unifi_devices{controller=%q,site="Default"} 1`, name)
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func Test_serverShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	// The only site belongs to one of the shards, so the other has none
+	config := fmt.Sprintf(`
+collectors:
+  backups: true
+unifi:
+  address: %s
+  username: %s
+  password: %s
+controllers:
+  - name: foo
+    shard: 1/2
+  - name: bar
+    shard: 2/2
+`, unifi.URL, apitest.Username, apitest.Password)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	s := newServer(path)
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	devices := regexp.MustCompile(`(?m)^unifi_devices{controller="(foo|bar)",site="Default"} 1$`)
+	if want, got := 1, len(devices.FindAllString(body, -1)); want != got {
+		t.Fatalf("unexpected number of shards exporting the site:\n- want: %v\n-  got: %v\n%s", want, got, body)
+	}
+
+	// Only the first shard runs the collectors which are not split by site
+	if want := `unifi_collector_scrape_errors_total{collector="backups",controller="foo"}`; !strings.Contains(body, want) {
+		t.Fatalf("metrics do not contain %q:\n%s", want, body)
+	}
+	if nomatch := `unifi_collector_scrape_errors_total{collector="backups",controller="bar"}`; strings.Contains(body, nomatch) {
+		t.Fatalf("metrics unexpectedly contain %q:\n%s", nomatch, body)
+	}
+}
+
+func Test_serverUnreachableController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	foo, bar := apitest.NewServer(), apitest.NewServer()
+	defer foo.Close()
+	defer bar.Close()
+
+	// bar rejects the configured credentials until they are changed
+	bar.SetCredentials(apitest.Username, "other")
+
+	config := fmt.Sprintf(`
+unifi:
+  username: %s
+  password: %s
+controllers:
+  - name: foo
+    address: %s
+  - name: bar
+    address: %s
+`, apitest.Username, apitest.Password, foo.URL, bar.URL)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	s := newServer(path)
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	defer closeControllers(s.currentControllers())
+
+	if want, got := "controller(s): foo (Default), bar (unreachable)", s.describe(); want != got {
+		t.Fatalf("unexpected description:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		s.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+
+	body := scrape()
+	for _, want := range []string{
+		`unifi_devices{controller="foo",site="Default"} 1`,
+		`unifi_up{controller="foo"} 1`,
+		`unifi_up{controller="bar"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+
+	// Once reachable, the controller is exported without a reload
+	bar.SetCredentials(apitest.Username, apitest.Password)
+
+	body = scrape()
+	for _, want := range []string{
+		`unifi_devices{controller="bar",site="Default"} 1`,
+		`unifi_up{controller="bar"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+	if want, got := "controller(s): foo (Default), bar (Default)", s.describe(); want != got {
+		t.Fatalf("unexpected description:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_serverProbe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	config := fmt.Sprintf(`
+modules:
+  default:
+    username: %s
+    password: %s
+`, apitest.Username, apitest.Password)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	s := newServer(path)
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	var tests = []struct {
+		desc  string
+		query string
+		code  int
+	}{
+		{
+			desc: "no target",
+			code: http.StatusBadRequest,
+		},
+		{
+			desc:  "unknown module",
+			query: "?module=foo&target=" + unifi.URL,
+			code:  http.StatusBadRequest,
+		},
+		{
+			desc:  "invalid target",
+			query: "?target=unifi:8443",
+			code:  http.StatusBadRequest,
+		},
+		{
+			desc:  "OK",
+			query: "?target=" + unifi.URL,
+			code:  http.StatusOK,
+		},
+		{
+			desc:  "OK, cached",
+			query: "?module=default&target=" + unifi.URL,
+			code:  http.StatusOK,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		w := httptest.NewRecorder()
+		s.probeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe"+tt.query, nil))
+
+		if want, got := tt.code, w.Code; want != got {
+			t.Fatalf("unexpected HTTP status code:\n- want: %v\n-  got: %v (%s)",
+				want, got, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		if want := `unifi_devices{site="Default"} 1`; !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, w.Body.String())
+		}
+	}
+
+	// Creating the controller logs in once, both to list sites and for the
+	// exporter itself, and the second probe reuses it
+	if want, got := 1, unifi.Requests("/api/login"); want != got {
+		t.Fatalf("unexpected number of logins:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// A reload logs out of the probed controller
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to reload configuration: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for unifi.Requests("/api/logout") < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("probed controller was not logged out on reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_serverEvictProbes(t *testing.T) {
+	now := time.Now()
+
+	s := newServer("")
+	s.probes = map[string]*probe{
+		"idle": {c: &controller{name: "idle"}, used: now.Add(-probeIdleTimeout)},
+	}
+	for i := 0; i < maxProbes; i++ {
+		name := fmt.Sprintf("probe%d", i)
+		s.probes[name] = &probe{
+			c:    &controller{name: name},
+			used: now.Add(-time.Duration(maxProbes-i) * time.Second),
+		}
+	}
+
+	var evicted []string
+	for _, c := range s.evictProbes(now) {
+		evicted = append(evicted, c.name)
+	}
+	sort.Strings(evicted)
+
+	// The idle controller is evicted regardless of the limit, and the least
+	// recently used one to make room for another
+	if want, got := "idle, probe0", strings.Join(evicted, ", "); want != got {
+		t.Fatalf("unexpected evicted controllers:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := maxProbes-1, len(s.probes); want != got {
+		t.Fatalf("unexpected number of cached controllers:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_serverAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	auth, err := parseMetricsAuth(map[string]string{
+		"bearertoken":    "secret",
+		"basicauthusers": "alice:" + string(hash),
+	})
+	if err != nil {
+		t.Fatalf("failed to parse auth configuration: %v", err)
+	}
+
+	var tests = []struct {
+		desc  string
+		auth  *metricsAuth
+		setup func(r *http.Request)
+		code  int
+	}{
+		{
+			desc:  "no auth configured",
+			auth:  &metricsAuth{},
+			setup: func(_ *http.Request) {},
+			code:  http.StatusOK,
+		},
+		{
+			desc:  "no credentials",
+			auth:  auth,
+			setup: func(_ *http.Request) {},
+			code:  http.StatusUnauthorized,
+		},
+		{
+			desc:  "bearer token",
+			auth:  auth,
+			setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+			code:  http.StatusOK,
+		},
+		{
+			desc:  "wrong bearer token",
+			auth:  auth,
+			setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			code:  http.StatusUnauthorized,
+		},
+		{
+			desc:  "basic auth",
+			auth:  auth,
+			setup: func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") },
+			code:  http.StatusOK,
+		},
+		{
+			desc:  "wrong password",
+			auth:  auth,
+			setup: func(r *http.Request) { r.SetBasicAuth("alice", "wrong") },
+			code:  http.StatusUnauthorized,
+		},
+		{
+			desc:  "unknown user",
+			auth:  auth,
+			setup: func(r *http.Request) { r.SetBasicAuth("bob", "hunter2") },
+			code:  http.StatusUnauthorized,
+		},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		s := &server{auth: tt.auth}
+
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		tt.setup(r)
+
+		w := httptest.NewRecorder()
+		s.authenticate(ok).ServeHTTP(w, r)
+
+		if want, got := tt.code, w.Code; want != got {
+			t.Fatalf("unexpected HTTP status code:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func Test_parseMetricsAuthInvalid(t *testing.T) {
+	for i, users := range []string{"alice", ":hash", "alice:not-a-hash"} {
+		t.Logf("[%02d] basicauthusers: %q", i, users)
+
+		if _, err := parseMetricsAuth(map[string]string{"basicauthusers": users}); err == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+	}
+}
+
+func Test_serverPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	config := fmt.Sprintf(`
+controllers:
+  - name: foo
+    address: %s
+    username: %s
+    password: %s
+    pollinterval: 1h
+`, unifi.URL, apitest.Username, apitest.Password)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	s := newServer(path)
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	defer s.currentControllers()[0].poller.stop()
+
+	// Every scrape is served from the snapshot collected when the
+	// configuration was loaded
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		s.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		// Cached metrics carry the time of the poll, but their age is
+		// current
+		body := w.Body.String()
+		for _, m := range []*regexp.Regexp{
+			regexp.MustCompile(`unifi_devices{controller="foo",site="Default"} 1 \d+\n`),
+			regexp.MustCompile(`unifi_snapshot_age_seconds{controller="foo"} \S+\n`),
+		} {
+			if !m.MatchString(body) {
+				t.Fatalf("[%02d] metrics do not match %q:\n%s", i, m, body)
+			}
+		}
+	}
+
+	if want, got := 1, unifi.Requests("/api/s/default/stat/device"); want != got {
+		t.Fatalf("unexpected number of device requests:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_serverHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	s := newServer(testConfigFile(t, dir, unifi.URL, "Default"))
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	var tests = []struct {
+		desc    string
+		h       http.Handler
+		path    string
+		code    int
+		contain string
+	}{
+		{
+			desc:    "index",
+			h:       s.indexHandler("/metrics"),
+			path:    "/",
+			code:    http.StatusOK,
+			contain: `<a href="/metrics">`,
+		},
+		{
+			desc: "index, unknown path",
+			h:    s.indexHandler("/metrics"),
+			path: "/foo",
+			code: http.StatusNotFound,
+		},
+		{
+			desc: "healthy",
+			h:    s.healthyHandler(),
+			path: "/-/healthy",
+			code: http.StatusOK,
+		},
+		{
+			desc: "ready",
+			h:    s.readyHandler(),
+			path: "/-/ready",
+			code: http.StatusOK,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		w := httptest.NewRecorder()
+		tt.h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if want, got := tt.code, w.Code; want != got {
+			t.Fatalf("unexpected HTTP status code:\n- want: %v\n-  got: %v (%s)",
+				want, got, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), tt.contain) {
+			t.Fatalf("response does not contain %q:\n%s", tt.contain, w.Body.String())
+		}
+	}
+
+	// Once the exporter's credentials are revoked, a scrape fails to
+	// authenticate again and the exporter is no longer ready
+	unifi.SetCredentials("admin", "changed")
+
+	w := httptest.NewRecorder()
+	s.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	w = httptest.NewRecorder()
+	s.readyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+
+	if want, got := http.StatusServiceUnavailable, w.Code; want != got {
+		t.Fatalf("unexpected HTTP status code after revoking credentials:\n- want: %v\n-  got: %v (%s)",
+			want, got, w.Body.String())
+	}
+}
+
+func Test_serverShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unifi := apitest.NewServer()
+	defer unifi.Close()
+
+	s := newServer(testConfigFile(t, dir, unifi.URL, "Default"))
+	if err := s.reload(); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	// The session used to list sites is kept by the exporter
+	if want, got := 0, unifi.Requests("/api/logout"); want != got {
+		t.Fatalf("unexpected number of logouts before shutdown:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	s.shutdown(context.Background(), &http.Server{})
+
+	if want, got := 1, unifi.Requests("/api/logout"); want != got {
+		t.Fatalf("unexpected number of logouts after shutdown:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	w := httptest.NewRecorder()
+	s.readyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+
+	if want, got := http.StatusServiceUnavailable, w.Code; want != got {
+		t.Fatalf("unexpected HTTP status code after shutdown:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_scrapeTimeout(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		header  string
+		timeout time.Duration
+		ok      bool
+	}{
+		{
+			desc: "no header",
+		},
+		{
+			desc:   "invalid",
+			header: "foo",
+		},
+		{
+			desc:   "negative",
+			header: "-1",
+		},
+		{
+			desc:   "NaN",
+			header: "NaN",
+		},
+		{
+			desc:   "too large",
+			header: "1e20",
+		},
+		{
+			desc:    "offset",
+			header:  "10",
+			timeout: 9500 * time.Millisecond,
+			ok:      true,
+		},
+		{
+			desc:    "fractional",
+			header:  "2.5",
+			timeout: 2 * time.Second,
+			ok:      true,
+		},
+		{
+			desc:    "too short for offset",
+			header:  "0.5",
+			timeout: 500 * time.Millisecond,
+			ok:      true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h := make(http.Header)
+		if tt.header != "" {
+			h.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+		}
+
+		timeout, ok := scrapeTimeout(h)
+		if want, got := tt.ok, ok; want != got {
+			t.Fatalf("unexpected ok:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.timeout, timeout; want != got {
+			t.Fatalf("unexpected timeout:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var textfileWriteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "unifi_exporter",
+	Name:      "textfile_write_failures_total",
+	Help:      "Number of failed writes of metrics to the textfile.",
+})
+
+func init() {
+	prometheus.MustRegister(textfileWriteFailures)
+}
+
+// textfileOmitPrefixes are the prefixes of metrics which are not written to
+// the textfile: those of the Go runtime and the process, which node_exporter
+// exports for itself, and would reject as duplicates.
+var textfileOmitPrefixes = []string{"go_", "process_"}
+
+// A textfileWriter periodically writes metrics to a file in the text
+// exposition format, for the textfile collector of node_exporter.  The file is
+// replaced atomically, so that node_exporter never reads a partial file.
+type textfileWriter struct {
+	cfg textfileConfig
+
+	// gather returns the metrics to write, bounded by ctx.
+	gather func(ctx context.Context) (prometheus.Gatherer, error)
+
+	// ctx is cancelled when the writer is stopped, which also cancels any
+	// collection in progress.
+	ctx    context.Context
+	cancel func()
+}
+
+// newTextfileWriter creates a textfileWriter which writes the metrics returned
+// by gather.  start must be called to begin writing.
+func newTextfileWriter(cfg textfileConfig, gather func(ctx context.Context) (prometheus.Gatherer, error)) *textfileWriter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &textfileWriter{
+		cfg:    cfg,
+		gather: gather,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start writes metrics immediately, and then every interval until the writer
+// is stopped.
+func (w *textfileWriter) start() {
+	go func() {
+		t := time.NewTicker(w.cfg.interval)
+		defer t.Stop()
+
+		for {
+			if err := w.write(w.ctx); err != nil && w.ctx.Err() == nil {
+				textfileWriteFailures.Inc()
+				log.Printf("[ERROR] failed to write metrics to textfile %q: %v", w.cfg.path, err)
+			}
+
+			select {
+			case <-t.C:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops writing, cancelling any collection in progress.  The last file
+// written is left in place.
+func (w *textfileWriter) stop() {
+	w.cancel()
+}
+
+// write gathers metrics once and replaces the file with them.  If only some
+// metrics could be gathered, those which were are still written.
+func (w *textfileWriter) write(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.interval)
+	defer cancel()
+
+	g, err := w.gather(ctx)
+	if err != nil {
+		return err
+	}
+
+	mfs, err := g.Gather()
+	if err != nil {
+		log.Printf("[WARN] writing incomplete metrics to textfile %q: %v", w.cfg.path, err)
+	}
+
+	// node_exporter only reads files ending in .prom, so the temporary file
+	// is ignored until it is renamed
+	f, err := ioutil.TempFile(filepath.Dir(w.cfg.path), "."+filepath.Base(w.cfg.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// The textfile collector rejects files with timestamps, which cached
+	// metrics carry
+	clearTimestamps(mfs)
+
+	bw := bufio.NewWriter(f)
+	for _, mf := range mfs {
+		if omitFromTextfile(mf.GetName()) {
+			continue
+		}
+
+		if _, err := expfmt.MetricFamilyToText(bw, mf); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// Temporary files are only readable by their owner
+	if err := f.Chmod(0644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), w.cfg.path)
+}
+
+// omitFromTextfile reports whether the metric named name is omitted from the
+// textfile.
+func omitFromTextfile(name string) bool {
+	for _, p := range textfileOmitPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_textfileWriterWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-exporter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	reg := prometheus.NewRegistry()
+	devices := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_devices", Help: "Devices."}, []string{"site"})
+	goroutines := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines", Help: "Goroutines."})
+	reg.MustRegister(devices, goroutines)
+
+	devices.WithLabelValues("Default").Set(3)
+	goroutines.Set(10)
+
+	path := filepath.Join(dir, "unifi.prom")
+	w := newTextfileWriter(textfileConfig{
+		path:     path,
+		interval: time.Minute,
+	}, func(_ context.Context) (prometheus.Gatherer, error) {
+		// Metrics cached by a poller carry timestamps, which must not
+		// be written
+		return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			mfs, err := reg.Gather()
+			setTimestamps(mfs, time.Now())
+			return mfs, err
+		}), nil
+	})
+	defer w.stop()
+
+	for i := 0; i < 2; i++ {
+		if err := w.write(context.Background()); err != nil {
+			t.Fatalf("failed to write metrics: %v", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read textfile: %v", err)
+	}
+
+	if want, got := "unifi_devices{site=\"Default\"} 3\n", string(b); !strings.Contains(got, want) {
+		t.Fatalf("textfile is missing metric:\n- want: %v\n-  got: %v", want, got)
+	}
+	if strings.Contains(string(b), "go_goroutines") {
+		t.Fatalf("textfile unexpectedly contains Go runtime metrics:\n%s", b)
+	}
+
+	// Only the textfile itself remains, without temporary files
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if want, got := 1, len(files); want != got {
+		t.Fatalf("unexpected number of files:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := os.FileMode(0644), files[0].Mode().Perm(); want != got {
+		t.Fatalf("unexpected textfile mode:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_parseTextfileConfig(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		section map[string]string
+		cfg     *textfileConfig
+		ok      bool
+	}{
+		{
+			desc: "disabled",
+			ok:   true,
+		},
+		{
+			desc:    "defaults",
+			section: map[string]string{"path": "/var/lib/node_exporter/unifi.prom"},
+			cfg:     &textfileConfig{path: "/var/lib/node_exporter/unifi.prom", interval: time.Minute},
+			ok:      true,
+		},
+		{
+			desc:    "interval",
+			section: map[string]string{"path": "unifi.prom", "interval": "15s"},
+			cfg:     &textfileConfig{path: "unifi.prom", interval: 15 * time.Second},
+			ok:      true,
+		},
+		{
+			desc:    "not a .prom file",
+			section: map[string]string{"path": "unifi.txt"},
+		},
+		{
+			desc:    "bad interval",
+			section: map[string]string{"path": "unifi.prom", "interval": "0s"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		cfg, err := parseTextfileConfig(tt.section)
+		if err != nil && tt.ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err == nil && !tt.ok {
+			t.Fatal("expected an error, but none occurred")
+		}
+
+		if want, got := tt.cfg, cfg; (want == nil) != (got == nil) || (want != nil && *want != *got) {
+			t.Fatalf("unexpected config:\n- want: %+v\n-  got: %+v", want, got)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build information, set at build time using:
+//
+//	go build -ldflags "-X main.version=... -X main.revision=... -X main.buildDate=..."
+//
+// The Makefile sets each of these from git.
+var (
+	version   = "dev"
+	revision  = "unknown"
+	buildDate = "unknown"
+)
+
+// buildInfo reports the build of the running exporter, so that the builds in
+// use across a fleet of exporters can be queried from Prometheus.
+var buildInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "unifi_exporter",
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by the version, revision, and Go version from which unifi_exporter was built.",
+	},
+	[]string{"version", "revision", "goversion"},
+)
+
+func init() {
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+	prometheus.MustRegister(buildInfo)
+}
+
+// versionString returns a human-readable description of the build, as printed
+// by the -version flag.
+func versionString() string {
+	return fmt.Sprintf("unifi_exporter, version %s (revision: %s, build date: %s, go version: %s)",
+		version, revision, buildDate, runtime.Version())
+}
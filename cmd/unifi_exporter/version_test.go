@@ -0,0 +1,37 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_buildInfo(t *testing.T) {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, mf := range mfs {
+		if mf.GetName() != "unifi_exporter_build_info" {
+			continue
+		}
+
+		for _, l := range mf.Metric[0].Label {
+			got[l.GetName()] = l.GetValue()
+		}
+	}
+
+	want := map[string]string{
+		"version":   version,
+		"revision":  revision,
+		"goversion": runtime.Version(),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("unexpected label %q:\n- want: %v\n-  got: %v", k, v, got[k])
+		}
+	}
+}
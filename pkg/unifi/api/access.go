@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// accessSuccess is the code of a successful response from the UniFi Access
+// API.
+const accessSuccess = "SUCCESS"
+
+// An AccessClient is a client for the API of UniFi Access, which manages door
+// controllers.  Unlike the UniFi Network API, the UniFi Access API is served
+// on its own port, usually 12445, and authenticates each request with an API
+// token created in UniFi Access, rather than with a session.
+type AccessClient struct {
+	UserAgent string
+
+	apiURL *url.URL
+	token  string
+	client *http.Client
+}
+
+// NewAccessClient creates a new AccessClient for the UniFi Access API at addr,
+// such as https://unifi:12445, which authenticates with token.  If no HTTP
+// client is specified, a default one will be used.
+func NewAccessClient(addr string, token string, client *http.Client) (*AccessClient, error) {
+	u, err := url.Parse(strings.TrimRight(addr, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		client = NewHTTPClient(HTTPClientConfig{})
+	}
+
+	return &AccessClient{
+		UserAgent: userAgent,
+
+		apiURL: u,
+		token:  token,
+		client: client,
+	}, nil
+}
+
+// Doors returns the doors managed by UniFi Access.
+func (c *AccessClient) Doors(ctx context.Context) ([]*Door, error) {
+	var doors []*Door
+	if err := c.do(ctx, http.MethodGet, "/api/v1/developer/doors", nil, &doors); err != nil {
+		return nil, err
+	}
+
+	return doors, nil
+}
+
+// accessLogPageSize is the number of log entries requested from UniFi Access
+// at once.
+const accessLogPageSize = 100
+
+// DoorOpenings returns the attempts to open a door which UniFi Access logged
+// between since and until, inclusive, whether or not access was granted.  The
+// log is retrieved a page at a time until every entry has been returned.
+func (c *AccessClient) DoorOpenings(ctx context.Context, since time.Time, until time.Time) ([]*DoorOpening, error) {
+	var openings []*DoorOpening
+	for page := 1; ; page++ {
+		var v struct {
+			Hits []*DoorOpening `json:"hits"`
+		}
+
+		err := c.do(
+			ctx,
+			http.MethodPost,
+			fmt.Sprintf("/api/v1/developer/system/logs?page_num=%d&page_size=%d", page, accessLogPageSize),
+			&accessLogRequest{
+				Topic: "door_openings",
+				Since: since.Unix(),
+				Until: until.Unix(),
+			},
+			&v,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		openings = append(openings, v.Hits...)
+		if len(v.Hits) < accessLogPageSize {
+			return openings, nil
+		}
+	}
+}
+
+type accessLogRequest struct {
+	Topic string `json:"topic"`
+	Since int64  `json:"since"`
+	Until int64  `json:"until"`
+}
+
+// do performs a request to endpoint with an optional JSON body, and
+// unmarshals the data of the response onto v.
+func (c *AccessClient) do(ctx context.Context, method string, endpoint string, body interface{}, v interface{}) error {
+	u, err := joinURL(c.apiURL, endpoint)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, u.String(), buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.Header.Set("Content-Type", jsonMediaType)
+	}
+	req.Header.Set("Accept", jsonMediaType)
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrAuthFailed
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	if c := res.StatusCode; c < 200 || c > 299 {
+		return fmt.Errorf("unexpected HTTP status code from UniFi Access: %d", c)
+	}
+
+	var ar struct {
+		Code string          `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ar); err != nil {
+		return err
+	}
+
+	// UniFi Access reports some failures, such as an invalid token, with a
+	// successful HTTP status
+	if ar.Code != accessSuccess {
+		if ar.Code == "CODE_UNAUTHORIZED" {
+			return ErrAuthFailed
+		}
+
+		return fmt.Errorf("UniFi Access error %s: %s", ar.Code, ar.Msg)
+	}
+
+	return json.Unmarshal(ar.Data, v)
+}
+
+// A Door is a door managed by UniFi Access.
+type Door struct {
+	ID       string
+	Name     string
+	FullName string
+
+	// Open reports whether the door position sensor reports the door as
+	// open, and Locked whether its lock relay is locked.
+	Open   bool
+	Locked bool
+
+	// Bound reports whether the door is bound to a hub, without which its
+	// state is not reported.
+	Bound bool
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a Door.
+func (d *Door) UnmarshalJSON(b []byte) error {
+	var door door
+	if err := json.Unmarshal(b, &door); err != nil {
+		return err
+	}
+
+	*d = Door{
+		ID:       door.ID,
+		Name:     door.Name,
+		FullName: door.FullName,
+		Open:     door.DoorPositionStatus == "open",
+		Locked:   door.DoorLockRelayStatus == "lock",
+		Bound:    door.IsBindHub,
+	}
+
+	return nil
+}
+
+// A door is the raw structure of a Door returned from the UniFi Access API.
+type door struct {
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	FullName            string `json:"full_name"`
+	DoorPositionStatus  string `json:"door_position_status"`
+	DoorLockRelayStatus string `json:"door_lock_relay_status"`
+	IsBindHub           bool   `json:"is_bind_hub"`
+}
+
+// Results of a DoorOpening.
+const (
+	DoorOpeningGranted = "ACCESS"
+	DoorOpeningDenied  = "BLOCKED"
+)
+
+// A DoorOpening is an attempt to open a door, logged by UniFi Access.
+type DoorOpening struct {
+	// DoorID is the ID of the door, if the attempt was at a door.
+	DoorID string
+
+	// Result is DoorOpeningGranted or DoorOpeningDenied.
+	Result string
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a DoorOpening.
+func (o *DoorOpening) UnmarshalJSON(b []byte) error {
+	var do doorOpening
+	if err := json.Unmarshal(b, &do); err != nil {
+		return err
+	}
+
+	*o = DoorOpening{
+		Result: do.Source.Event.Result,
+	}
+	for _, t := range do.Source.Target {
+		if t.Type == "door" {
+			o.DoorID = t.ID
+			break
+		}
+	}
+
+	return nil
+}
+
+// A doorOpening is the raw structure of a DoorOpening returned from the UniFi
+// Access API.
+type doorOpening struct {
+	Source struct {
+		Event struct {
+			Result string `json:"result"`
+		} `json:"event"`
+		Target []struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		} `json:"target"`
+	} `json:"_source"`
+}
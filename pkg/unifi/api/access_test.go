@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessClientDoors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/v1/developer/doors", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "Bearer foo", r.Header.Get("Authorization"); want != got {
+			t.Fatalf("unexpected Authorization header:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonMediaType)
+		_, _ = w.Write([]byte(`{"code":"SUCCESS","msg":"success","data":[{"id":"door1","name":"Front Door","full_name":"HQ - 1F - Front Door","door_position_status":"open","door_lock_relay_status":"unlock","is_bind_hub":true},{"id":"door2","name":"Back Door","door_position_status":"","door_lock_relay_status":"lock","is_bind_hub":false}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewAccessClient(srv.URL, "foo", nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	doors, err := c.Doors(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve doors: %v", err)
+	}
+
+	want := []*Door{
+		{
+			ID:       "door1",
+			Name:     "Front Door",
+			FullName: "HQ - 1F - Front Door",
+			Open:     true,
+			Bound:    true,
+		},
+		{
+			ID:     "door2",
+			Name:   "Back Door",
+			Locked: true,
+		},
+	}
+	if got := doors; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected doors:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
+
+func TestAccessClientDoorOpenings(t *testing.T) {
+	since, until := time.Unix(1500000000, 0), time.Unix(1500003600, 0)
+
+	// A full page is followed by a request for the next page
+	hit := `{"_source":{"event":{"result":"ACCESS"},"target":[{"type":"UAH","id":"hub1"},{"type":"door","id":"door1"}]}}`
+	pages := map[string]string{
+		"1": strings.TrimSuffix(strings.Repeat(hit+",", accessLogPageSize), ","),
+		"2": `{"_source":{"event":{"result":"BLOCKED"},"target":[{"type":"door","id":"door2"}]}}`,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/v1/developer/system/logs", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var req accessLogRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		want := accessLogRequest{Topic: "door_openings", Since: 1500000000, Until: 1500003600}
+		if got := req; want != got {
+			t.Fatalf("unexpected request:\n- want: %+v\n-  got: %+v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonMediaType)
+		_, _ = fmt.Fprintf(w, `{"code":"SUCCESS","msg":"success","data":{"hits":[%s]}}`, pages[r.URL.Query().Get("page_num")])
+	}))
+	defer srv.Close()
+
+	c, err := NewAccessClient(srv.URL, "foo", nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	openings, err := c.DoorOpenings(context.Background(), since, until)
+	if err != nil {
+		t.Fatalf("failed to retrieve door openings: %v", err)
+	}
+
+	if want, got := accessLogPageSize+1, len(openings); want != got {
+		t.Fatalf("unexpected number of door openings:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := (&DoorOpening{DoorID: "door1", Result: DoorOpeningGranted}), openings[0]; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected door opening:\n- want: %+v\n-  got: %+v", want, got)
+	}
+	if want, got := (&DoorOpening{DoorID: "door2", Result: DoorOpeningDenied}), openings[accessLogPageSize]; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected door opening:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
+
+func TestAccessClientUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonMediaType)
+		_, _ = w.Write([]byte(`{"code":"CODE_UNAUTHORIZED","msg":"unauthorized","data":null}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewAccessClient(srv.URL, "foo", nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if _, err := c.Doors(context.Background()); err != ErrAuthFailed {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", ErrAuthFailed, err)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -8,12 +9,13 @@ import (
 )
 
 // Alarms returns all of the Alarms for a specified site name.
-func (c *Client) Alarms(siteName string) ([]*Alarm, error) {
+func (c *Client) Alarms(ctx context.Context, siteName string) ([]*Alarm, error) {
 	var v struct {
 		Alarms []*Alarm `json:"data"`
 	}
 
 	req, err := c.newRequest(
+		ctx,
 		"GET",
 		fmt.Sprintf("/api/s/%s/list/alarm", siteName),
 		nil,
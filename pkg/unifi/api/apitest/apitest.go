@@ -0,0 +1,466 @@
+// Package apitest provides a fake UniFi Controller for use in tests.
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const (
+	// Username and Password are the credentials accepted by a Server by
+	// default.
+	Username = "admin"
+	Password = "password"
+
+	// DefaultSite is the name of the site served by a Server by default.
+	DefaultSite = "default"
+
+	// sessionCookie is the name of the cookie which identifies an
+	// authenticated session.
+	sessionCookie = "unifises"
+
+	// unifiOSNetworkPrefix is the path prefix below which a UniFi OS
+	// console serves the UniFi Network API.
+	unifiOSNetworkPrefix = "/proxy/network"
+
+	// Headers used by UniFi OS consoles to issue and verify CSRF tokens.
+	csrfTokenHeader        = "X-CSRF-Token"
+	updatedCSRFTokenHeader = "X-Updated-CSRF-Token"
+
+	jsonContentType = "application/json;charset=UTF-8"
+)
+
+// Canned JSON objects served by a Server by default.
+const (
+	DefaultSiteJSON = `{"_id":"site1","desc":"Default","name":"default","num_ap":1,"num_sta":2,"role":"admin"}`
+
+	DefaultDeviceJSON = `{
+	"_id": "device1",
+	"adopted": true,
+	"inform_ip": "192.168.1.1",
+	"inform_url": "http://192.168.1.1:8080/inform",
+	"ip": "192.168.1.2",
+	"name": "Office AP",
+	"model": "U7PG2",
+	"type": "uap",
+	"ethernet_table": [{"mac": "de:ad:be:ef:00:01", "name": "eth0"}],
+	"radio_table": [{"name": "wifi0", "radio": "ng"}, {"name": "wifi1", "radio": "na"}],
+	"radio_table_stats": [
+		{"name": "wifi0", "radio": "ng", "num_sta": 1, "user-num_sta": 1, "guest-num_sta": 0},
+		{"name": "wifi1", "radio": "na", "num_sta": 1, "user-num_sta": 0, "guest-num_sta": 1}
+	],
+	"stat": {"ap": {"bytes": 300, "rx_bytes": 200, "tx_bytes": 100, "rx_packets": 20, "tx_packets": 10}},
+	"uptime": 3600,
+	"last_seen": 1500000000
+}`
+
+	DefaultWirelessClientJSON = `{"_id":"client1","ap_mac":"de:ad:be:ef:00:01","channel":36,"hostname":"laptop","ip":"192.168.1.10","mac":"de:ad:be:ef:10:01","rssi":40,"rx_bytes":1000,"tx_bytes":500,"uptime":600}`
+
+	DefaultWiredClientJSON = `{"_id":"client2","is_wired":true,"hostname":"desktop","ip":"192.168.1.11","mac":"de:ad:be:ef:10:02","rx_bytes":2000,"tx_bytes":1500,"uptime":1200}`
+)
+
+// A Server is a fake UniFi Controller, which serves canned JSON for login,
+// sites, devices and clients (stations).
+//
+// A Server behaves like a classic UniFi Controller: requests other than
+// login are rejected until a client has authenticated, and requests for
+// unknown sites are rejected as the real controller would reject them.
+// SetUniFiOS makes a Server behave like a UniFi OS console instead.
+type Server struct {
+	// URL is the base URL of the Server, for use with api.NewClient.
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	username string
+	password string
+	sites    []json.RawMessage
+	devices  map[string][]json.RawMessage
+	clients  map[string][]json.RawMessage
+	requests map[string]int
+
+	unifiOS   bool
+	csrfToken string
+	csrfCount int
+
+	// blocked, if not nil, holds requests other than login until it is
+	// closed or the client gives up.
+	blocked chan struct{}
+}
+
+// NewServer starts a Server which accepts Username and Password, and serves
+// the default JSON objects for DefaultSite.  The caller must call Close when
+// the Server is no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		username: Username,
+		password: Password,
+		devices:  make(map[string][]json.RawMessage),
+		clients:  make(map[string][]json.RawMessage),
+		requests: make(map[string]int),
+	}
+
+	s.SetSites(DefaultSiteJSON)
+	s.SetDevices(DefaultSite, DefaultDeviceJSON)
+	s.SetClients(DefaultSite, DefaultWirelessClientJSON, DefaultWiredClientJSON)
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+
+	return s
+}
+
+// Close shuts down the Server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetCredentials sets the username and password accepted by the Server.
+func (s *Server) SetCredentials(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.username, s.password = username, password
+}
+
+// SetSites replaces the sites served by the Server with the specified JSON
+// objects.  Sites without devices or clients serve an empty list of each.
+func (s *Server) SetSites(sites ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites = rawObjects(sites)
+}
+
+// SetDevices replaces the devices served by the Server for the specified site
+// name with the specified JSON objects.
+func (s *Server) SetDevices(site string, devices ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices[site] = rawObjects(devices)
+}
+
+// SetClients replaces the clients (stations) served by the Server for the
+// specified site name with the specified JSON objects.
+func (s *Server) SetClients(site string, clients ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[site] = rawObjects(clients)
+}
+
+// SetUniFiOS sets whether the Server behaves like a UniFi OS console.  A UniFi
+// OS console serves its web interface at its root, authenticates using
+// /api/auth/login, serves the UniFi Network API below /proxy/network, and
+// rejects requests which do not carry the CSRF token issued by its most
+// recent response.
+func (s *Server) SetUniFiOS(unifiOS bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unifiOS = unifiOS
+}
+
+// CSRFToken returns the CSRF token most recently issued by the Server, if it
+// behaves like a UniFi OS console.
+func (s *Server) CSRFToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.csrfToken
+}
+
+// Block holds every subsequent request other than login, without a response,
+// until the returned function is called or the client abandons the request,
+// such as to simulate a hung UniFi Controller.
+func (s *Server) Block() (unblock func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocked := make(chan struct{})
+	s.blocked = blocked
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			close(blocked)
+			if s.blocked == blocked {
+				s.blocked = nil
+			}
+		})
+	}
+}
+
+// Requests returns the number of requests the Server has received for the
+// specified path.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// rawObjects converts JSON objects to json.RawMessages, panicking if any
+// are not valid JSON, because canned data is a programming error in a test.
+func rawObjects(objs []string) []json.RawMessage {
+	raw := make([]json.RawMessage, 0, len(objs))
+	for _, o := range objs {
+		if !json.Valid([]byte(o)) {
+			panic(fmt.Sprintf("apitest: invalid JSON object: %s", o))
+		}
+
+		raw = append(raw, json.RawMessage(o))
+	}
+
+	return raw
+}
+
+// serveHTTP routes requests to the fake UniFi Controller API.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	blocked := s.blocked
+	s.mu.Unlock()
+
+	if blocked != nil && !strings.HasSuffix(r.URL.Path, "/login") {
+		select {
+		case <-blocked:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unifiOS {
+		s.serveUniFiOS(w, r)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/":
+		// Classic controllers redirect to their web interface, which
+		// distinguishes them from UniFi OS consoles
+		http.Redirect(w, r, "/manage", http.StatusFound)
+		return
+	case r.URL.Path == "/api/login":
+		s.login(w, r)
+		return
+	}
+
+	if c, err := r.Cookie(sessionCookie); err != nil || c.Value != s.session() {
+		writeError(w, http.StatusUnauthorized, "api.err.LoginRequired")
+		return
+	}
+
+	if r.URL.Path == "/api/logout" {
+		s.logout(w)
+		return
+	}
+
+	s.serveNetwork(w, r, r.URL.Path)
+}
+
+// serveUniFiOS routes requests to the fake UniFi OS console.
+func (s *Server) serveUniFiOS(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		// UniFi OS consoles serve their web interface directly
+		w.WriteHeader(http.StatusOK)
+		return
+	case "/api/auth/login":
+		s.login(w, r)
+		return
+	}
+
+	if c, err := r.Cookie(sessionCookie); err != nil || c.Value != s.session() {
+		writeError(w, http.StatusUnauthorized, "api.err.LoginRequired")
+		return
+	}
+
+	// Every request after login must carry the current CSRF token, which is
+	// rotated by each response
+	if r.Header.Get(csrfTokenHeader) != s.csrfToken {
+		writeError(w, http.StatusForbidden, "api.err.InvalidCSRFToken")
+		return
+	}
+	w.Header().Set(updatedCSRFTokenHeader, s.rotateCSRFToken())
+
+	if r.URL.Path == "/api/auth/logout" {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "api.err.Invalid")
+			return
+		}
+
+		s.logout(w)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, unifiOSNetworkPrefix+"/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.serveNetwork(w, r, strings.TrimPrefix(r.URL.Path, unifiOSNetworkPrefix))
+}
+
+// serveNetwork serves the UniFi Network API endpoint at path, once a client
+// has authenticated.
+func (s *Server) serveNetwork(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "/api/self/sites" {
+		writeData(w, s.sites)
+		return
+	}
+
+	// Site-specific endpoints are of the form /api/s/{site}/stat/{kind} or
+	// /api/s/{site}/rest/{kind}
+	parts := strings.Split(strings.TrimPrefix(path, "/api/s/"), "/")
+	if !strings.HasPrefix(path, "/api/s/") || len(parts) != 3 || (parts[1] != "stat" && parts[1] != "rest") {
+		http.NotFound(w, r)
+		return
+	}
+
+	site := parts[0]
+	if !s.hasSite(site) {
+		writeError(w, http.StatusBadRequest, "api.err.NoSiteContext")
+		return
+	}
+
+	switch parts[1] + "/" + parts[2] {
+	case "stat/device":
+		writeData(w, s.devices[site])
+	case "stat/sta":
+		s.stations(w, r, s.clients[site])
+	case "rest/networkconf", "rest/portconf", "stat/health":
+		// No networks or port profiles are configured, and no health is
+		// reported
+		writeData(w, []json.RawMessage{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// login authenticates a client and issues a session cookie.
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "api.err.Invalid")
+		return
+	}
+
+	if req.Username != s.username || req.Password != s.password {
+		writeError(w, http.StatusBadRequest, "api.err.Invalid")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:  sessionCookie,
+		Value: s.session(),
+		Path:  "/",
+	})
+	if s.unifiOS {
+		w.Header().Set(csrfTokenHeader, s.rotateCSRFToken())
+	}
+	writeData(w, nil)
+}
+
+// logout expires a client's session.
+func (s *Server) logout(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessionCookie,
+		Path:   "/",
+		MaxAge: -1,
+	})
+	writeData(w, nil)
+}
+
+// rotateCSRFToken issues a new CSRF token, which replaces any earlier one.
+func (s *Server) rotateCSRFToken() string {
+	s.csrfCount++
+	s.csrfToken = fmt.Sprintf("csrf%d", s.csrfCount)
+
+	return s.csrfToken
+}
+
+// session returns the session cookie value for the current credentials, so
+// that changing the credentials expires any existing sessions.
+func (s *Server) session() string {
+	return fmt.Sprintf("%x", s.username+":"+s.password)
+}
+
+// hasSite reports whether the Server serves the specified site name.
+func (s *Server) hasSite(name string) bool {
+	for _, raw := range s.sites {
+		var site struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &site); err == nil && site.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stations serves clients, honoring the paging parameters of a POST request.
+func (s *Server) stations(w http.ResponseWriter, r *http.Request, clients []json.RawMessage) {
+	var req struct {
+		Start *int `json:"_start"`
+		Limit *int `json:"_limit"`
+	}
+	if r.Method == http.MethodPost {
+		_ = json.NewDecoder(r.Body).Decode(&req)
+	}
+
+	if req.Start != nil {
+		start := *req.Start
+		if start > len(clients) {
+			start = len(clients)
+		}
+		clients = clients[start:]
+	}
+	if req.Limit != nil && *req.Limit < len(clients) {
+		clients = clients[:*req.Limit]
+	}
+
+	writeData(w, clients)
+}
+
+// writeData writes a successful response containing data.
+func writeData(w http.ResponseWriter, data []json.RawMessage) {
+	if data == nil {
+		data = []json.RawMessage{}
+	}
+
+	write(w, http.StatusOK, map[string]interface{}{
+		"meta": map[string]string{"rc": "ok"},
+		"data": data,
+	})
+}
+
+// writeError writes an unsuccessful response with the specified message.
+func writeError(w http.ResponseWriter, code int, msg string) {
+	write(w, code, map[string]interface{}{
+		"meta": map[string]string{"rc": "error", "msg": msg},
+		"data": []json.RawMessage{},
+	})
+}
+
+func write(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,130 @@
+package apitest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+)
+
+func TestServer(t *testing.T) {
+	s := apitest.NewServer()
+	defer s.Close()
+
+	c, err := api.NewClient(s.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := c.Sites(ctx); err != api.ErrAuthFailed {
+		t.Fatalf("expected unauthenticated request to fail with ErrAuthFailed, but got: %v", err)
+	}
+	if err := c.Login(ctx, apitest.Username, "wrong"); err != api.ErrAuthFailed {
+		t.Fatalf("expected login with bad credentials to fail with ErrAuthFailed, but got: %v", err)
+	}
+	if err := c.Login(ctx, apitest.Username, apitest.Password); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	sites, err := c.Sites(ctx)
+	if err != nil {
+		t.Fatalf("failed to retrieve sites: %v", err)
+	}
+	if want, got := apitest.DefaultSite, sites[0].Name; want != got {
+		t.Fatalf("unexpected site name:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	devices, err := c.Devices(ctx, apitest.DefaultSite)
+	if err != nil {
+		t.Fatalf("failed to retrieve devices: %v", err)
+	}
+	if want, got := "Office AP", devices[0].Name; want != got {
+		t.Fatalf("unexpected device name:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	clients, err := c.Clients(ctx, apitest.DefaultSite)
+	if err != nil {
+		t.Fatalf("failed to retrieve clients: %v", err)
+	}
+	if want, got := 2, len(clients); want != got {
+		t.Fatalf("unexpected number of clients:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if _, err := c.Devices(ctx, "nope"); err != api.ErrSiteNotFound {
+		t.Fatalf("expected request for unknown site to fail with ErrSiteNotFound, but got: %v", err)
+	}
+
+	// Changing the credentials expires the session, which the client must
+	// recover from by logging in again
+	s.SetCredentials(apitest.Username, "changed")
+	if _, err := c.Sites(ctx); err != api.ErrAuthFailed {
+		t.Fatalf("expected request with expired session to fail with ErrAuthFailed, but got: %v", err)
+	}
+	if want, got := 3, s.Requests("/api/login"); want != got {
+		t.Fatalf("unexpected number of login requests:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServerUniFiOS(t *testing.T) {
+	s := apitest.NewServer()
+	defer s.Close()
+	s.SetUniFiOS(true)
+
+	c, err := api.NewClient(s.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := c.Login(ctx, apitest.Username, apitest.Password); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if !c.IsUniFiOS() {
+		t.Fatal("expected client to detect a UniFi OS console")
+	}
+	if want, got := 1, s.Requests("/api/auth/login"); want != got {
+		t.Fatalf("unexpected number of UniFi OS login requests:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	issued := s.CSRFToken()
+	if issued == "" {
+		t.Fatal("expected a CSRF token to be issued on login")
+	}
+
+	// Each response rotates the CSRF token, so the second request only
+	// succeeds if the client sends the token updated by the first
+	if _, err := c.Sites(ctx); err != nil {
+		t.Fatalf("failed to retrieve sites: %v", err)
+	}
+	devices, err := c.Devices(ctx, apitest.DefaultSite)
+	if err != nil {
+		t.Fatalf("failed to retrieve devices: %v", err)
+	}
+	if want, got := "Office AP", devices[0].Name; want != got {
+		t.Fatalf("unexpected device name:\n- want: %v\n-  got: %v", want, got)
+	}
+	if s.CSRFToken() == issued {
+		t.Fatal("expected the CSRF token to be rotated")
+	}
+
+	for _, path := range []string{
+		"/proxy/network/api/self/sites",
+		"/proxy/network/api/s/default/stat/device",
+	} {
+		if want, got := 1, s.Requests(path); want != got {
+			t.Fatalf("unexpected number of requests for %q:\n- want: %v\n-  got: %v", path, want, got)
+		}
+	}
+	if want, got := 0, s.Requests("/api/self/sites"); want != got {
+		t.Fatalf("unexpected number of unprefixed requests:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatalf("failed to log out: %v", err)
+	}
+	if _, err := c.Sites(ctx); err != api.ErrAuthFailed {
+		t.Fatalf("expected request after logout to fail with ErrAuthFailed, but got: %v", err)
+	}
+}
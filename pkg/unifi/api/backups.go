@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Backups returns the automatic backups which the UniFi Controller has kept,
+// in the order it returns them.  Backups are of the whole UniFi Controller,
+// but are only listed through a command of a site, so any site name to which
+// the user has access may be specified.
+func (c *Client) Backups(ctx context.Context, siteName string) ([]*Backup, error) {
+	var v struct {
+		Backups []*Backup `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"POST",
+		fmt.Sprintf("/api/s/%s/cmd/backup", siteName),
+		&backupRequest{Command: "list-backups"},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.Backups, err
+}
+
+type backupRequest struct {
+	Command string `json:"cmd"`
+}
+
+// A Backup is an automatic backup of the UniFi Controller.
+type Backup struct {
+	Filename string
+	Size     float64 // Bytes
+	Time     time.Time
+	Version  string // Version of the UniFi Controller which made the backup
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a Backup.
+func (b *Backup) UnmarshalJSON(data []byte) error {
+	var bk backup
+	if err := json.Unmarshal(data, &bk); err != nil {
+		return err
+	}
+
+	*b = Backup{
+		Filename: bk.Filename,
+		Size:     bk.Size,
+		Time:     time.Unix(0, bk.Time*int64(time.Millisecond)),
+		Version:  bk.Version,
+	}
+
+	return nil
+}
+
+// A backup is the raw structure of a Backup returned from the UniFi
+// Controller API.
+type backup struct {
+	Filename string  `json:"filename"`
+	Size     float64 `json:"size"`
+	Time     int64   `json:"time"`
+	Version  string  `json:"version"`
+}
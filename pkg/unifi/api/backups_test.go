@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientBackups(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/s/branch/cmd/backup", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var req backupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if want, got := "list-backups", req.Command; want != got {
+			t.Fatalf("unexpected command:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{"data": [
+	{
+		"filename": "autobackup_7.4.162_20230801_0000_1690848000000.unf",
+		"size": 1048576,
+		"time": 1690848000000,
+		"version": "7.4.162"
+	}
+]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	backups, err := c.Backups(context.Background(), "branch")
+	if err != nil {
+		t.Fatalf("failed to retrieve backups: %v", err)
+	}
+
+	want := []*Backup{{
+		Filename: "autobackup_7.4.162_20230801_0000_1690848000000.unf",
+		Size:     1048576,
+		Time:     time.Unix(1690848000, 0),
+		Version:  "7.4.162",
+	}}
+	if got := backups; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected backups:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
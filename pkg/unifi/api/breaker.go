@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, without contacting the UniFi Controller, while
+// a CircuitBreaker is open.
+var ErrCircuitOpen = errors.New("UniFi Controller failed repeatedly, so requests are suspended until the cooldown expires")
+
+// A CircuitBreaker stops Clients from making requests to a UniFi Controller
+// which has failed repeatedly, so that an overloaded controller is given time
+// to recover, rather than being sent more requests on every scrape.
+//
+// After a number of consecutive failed requests, the breaker opens, and every
+// request fails immediately with ErrCircuitOpen until the cooldown expires.
+// The breaker is then half-open: a single request is sent, and the others
+// still fail immediately until its outcome is known.  If it fails, the
+// breaker opens again, and if it succeeds, the breaker closes.  A request
+// fails if no response is received, or if the UniFi Controller responds with
+// a server error or rate limits the request; a request cancelled by its
+// caller does not count, and a cancelled half-open request lets the next one
+// through in its place.
+//
+// A CircuitBreaker may be shared by several Clients, such as those created to
+// authenticate again with the same UniFi Controller.
+type CircuitBreaker struct {
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	consecutive int
+	// openUntil is the end of the cooldown, and is zero while the breaker
+	// is closed.
+	openUntil time.Time
+	// probing is set while the single half-open request is in flight.
+	probing bool
+}
+
+// NewCircuitBreaker creates a CircuitBreaker which opens for cooldown after
+// failures consecutive failed requests.
+func NewCircuitBreaker(failures int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failures: failures,
+		cooldown: cooldown,
+		now:      time.Now,
+	}
+}
+
+// allow returns ErrCircuitOpen if requests are suspended.  Otherwise, it
+// reports whether the request is the single request sent while the breaker is
+// half-open, which must be passed to record with the request's outcome.
+func (b *CircuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openUntil.IsZero():
+		return false, nil
+	case b.now().Before(b.openUntil), b.probing:
+		return false, ErrCircuitOpen
+	}
+
+	b.probing = true
+	return true, nil
+}
+
+// record records the outcome of a request, closing the breaker if it
+// succeeded, and opening it if it is the half-open request or the last of too
+// many consecutive failures.
+func (b *CircuitBreaker) record(probe bool, outcome requestOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	switch outcome {
+	case requestCancelled:
+		return
+	case requestSucceeded:
+		b.consecutive = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.consecutive++
+	if probe || b.consecutive >= b.failures {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// A requestOutcome is the outcome of a request, as counted by a
+// CircuitBreaker.
+type requestOutcome int
+
+const (
+	requestSucceeded requestOutcome = iota
+	requestFailed
+	requestCancelled
+)
+
+// breakerOutcome returns the outcome of a request with the specified
+// response and error.
+func breakerOutcome(req *http.Request, res *http.Response, err error) requestOutcome {
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		return requestCancelled
+	case err != nil:
+		return requestFailed
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+		return requestFailed
+	}
+
+	return requestSucceeded
+}
+
+// SetCircuitBreaker sets the CircuitBreaker which suspends the Client's
+// requests while the UniFi Controller is failing.  By default, a Client has
+// no CircuitBreaker.
+//
+// SetCircuitBreaker must be called before the Client is used.
+func (c *Client) SetCircuitBreaker(b *CircuitBreaker) {
+	c.breaker = b
+}
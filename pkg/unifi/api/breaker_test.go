@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var requests int
+	healthy := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1500000000, 0)
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time {
+		return now
+	}
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	c.SetCircuitBreaker(b)
+
+	sites := func() error {
+		_, err := c.Sites(context.Background())
+		return err
+	}
+
+	// The breaker opens after two failures, so the third request is never
+	// sent to the controller
+	for i := 0; i < 2; i++ {
+		if err := sites(); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("unexpected error for request %d: %v", i, err)
+		}
+	}
+	if want, got := ErrCircuitOpen, sites(); want != got {
+		t.Fatalf("unexpected error while open:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 2, requests; want != got {
+		t.Fatalf("unexpected number of requests:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// After the cooldown, a single failure opens the breaker again
+	now = now.Add(time.Minute)
+	if err := sites(); err == nil || err == ErrCircuitOpen {
+		t.Fatalf("unexpected error after cooldown: %v", err)
+	}
+	if want, got := ErrCircuitOpen, sites(); want != got {
+		t.Fatalf("unexpected error after failure following cooldown:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Once the controller recovers, a success closes the breaker
+	now = now.Add(time.Minute)
+	healthy = true
+	for i := 0; i < 3; i++ {
+		if err := sites(); err != nil {
+			t.Fatalf("unexpected error after recovery: %v", err)
+		}
+	}
+	if want, got := 6, requests; want != got {
+		t.Fatalf("unexpected number of requests:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := NewCircuitBreaker(1, time.Minute)
+	b.now = func() time.Time {
+		return now
+	}
+
+	b.record(false, requestFailed)
+	if _, err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("unexpected error while open:\n- want: %v\n-  got: %v", ErrCircuitOpen, err)
+	}
+
+	// After the cooldown, only one request is let through until its outcome
+	// is known
+	now = now.Add(time.Minute)
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("expected the half-open request, but got: %v, %v", probe, err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := b.allow(); err != ErrCircuitOpen {
+			t.Fatalf("unexpected error for concurrent request %d:\n- want: %v\n-  got: %v", i, ErrCircuitOpen, err)
+		}
+	}
+
+	// A cancelled half-open request lets the next one through instead
+	b.record(probe, requestCancelled)
+	probe, err = b.allow()
+	if err != nil || !probe {
+		t.Fatalf("expected another half-open request, but got: %v, %v", probe, err)
+	}
+
+	// A failed half-open request opens the breaker again
+	b.record(probe, requestFailed)
+	if _, err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("unexpected error after failed half-open request:\n- want: %v\n-  got: %v", ErrCircuitOpen, err)
+	}
+
+	// A successful half-open request closes the breaker
+	now = now.Add(time.Minute)
+	probe, _ = b.allow()
+	b.record(probe, requestSucceeded)
+	for i := 0; i < 3; i++ {
+		if probe, err := b.allow(); err != nil || probe {
+			t.Fatalf("expected a closed breaker, but got: %v, %v", probe, err)
+		}
+	}
+}
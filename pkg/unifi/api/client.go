@@ -2,13 +2,23 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,10 +26,23 @@ const (
 	// Predefined content types for HTTP requests.
 	formEncodedContentType = "application/x-www-form-urlencoded"
 	jsonContentType        = "application/json;charset=UTF-8"
+	jsonMediaType          = "application/json"
+
+	// Headers used by UniFi OS consoles to issue and verify CSRF tokens.
+	csrfTokenHeader        = "X-CSRF-Token"
+	updatedCSRFTokenHeader = "X-Updated-CSRF-Token"
+
+	// unifiOSNetworkPrefix is the path prefix below which UniFi OS consoles
+	// serve the UniFi Network API.
+	unifiOSNetworkPrefix = "/proxy/network"
 
 	// userAgent is the default user agent this package will report to the UniFi
 	// Controller v4 API.
 	userAgent = "github.com/mdlayher/unifi"
+
+	// DefaultMaxConcurrentRequests is the default number of requests a
+	// Client will have in flight to the UniFi Controller at once.
+	DefaultMaxConcurrentRequests = 4
 )
 
 // InsecureHTTPClient creates a *http.Client which does not verify a UniFi
@@ -28,16 +51,242 @@ const (
 // Please think carefully before using this client: it should only be used
 // with self-hosted, internal UniFi Controllers.
 func InsecureHTTPClient(timeout time.Duration) *http.Client {
+	return NewHTTPClient(HTTPClientConfig{
+		Timeout:            timeout,
+		InsecureSkipVerify: true,
+	})
+}
+
+// HTTPClientConfig configures a *http.Client created by NewHTTPClient.  Zero
+// values for timeouts select a reasonable default.
+type HTTPClientConfig struct {
+	// Timeout bounds the total time taken by a single request, including
+	// reading the response body.
+	Timeout time.Duration
+
+	// DialTimeout bounds the time taken to establish a TCP connection.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout bounds the time taken to complete a TLS handshake.
+	TLSHandshakeTimeout time.Duration
+
+	// RootCAs, if not nil, is the set of certificate authorities used to
+	// verify the UniFi Controller's certificate, instead of the system's
+	// certificate pool.
+	RootCAs *x509.CertPool
+
+	// Certificates are presented to the UniFi Controller, or a reverse proxy
+	// in front of it, when it requests a client certificate.
+	Certificates []tls.Certificate
+
+	// InsecureSkipVerify disables verification of the UniFi Controller's
+	// certificate chain and hostname.  See InsecureHTTPClient.
+	InsecureSkipVerify bool
+
+	// Fingerprint, if set, is the SHA-256 fingerprint of the UniFi
+	// Controller's certificate.  The certificate is accepted only if it
+	// matches, regardless of its chain and hostname, which makes pinning a
+	// safer alternative to InsecureSkipVerify for self-signed certificates.
+	// See ParseFingerprint.
+	Fingerprint []byte
+
+	// Proxy, if not nil, is the URL of an HTTP or HTTPS proxy through which
+	// all connections to the UniFi Controller are made.  Otherwise, the
+	// proxy is selected by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	Proxy *url.URL
+
+	// ResolveInterval, if set, is the interval at which idle connections to
+	// the UniFi Controller are closed, so that its hostname is resolved
+	// again, and a change of its address, such as a failover or a dynamic
+	// DNS update, takes effect.  Idle connections are also closed whenever
+	// a request fails without a response, whether or not ResolveInterval is
+	// set.
+	ResolveInterval time.Duration
+
+	// Headers are added to every request to the UniFi Controller, such as
+	// those required by a reverse proxy in front of it.
+	Headers map[string]string
+
+	// RecordDir, if set, is a directory to which every response from the
+	// UniFi Controller is written, for later use with ReplayDir.
+	RecordDir string
+
+	// ReplayDir, if set, is a directory of responses previously written
+	// using RecordDir.  The recorded responses are served in place of a
+	// UniFi Controller, which is never contacted.
+	ReplayDir string
+}
+
+// ParseFingerprint parses a hex-encoded SHA-256 certificate fingerprint, with
+// or without colon separators, for use in HTTPClientConfig.
+func ParseFingerprint(s string) ([]byte, error) {
+	fp, err := hex.DecodeString(strings.Replace(s, ":", "", -1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate fingerprint %q: %v", s, err)
+	}
+	if len(fp) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate fingerprint %q: must be a %d byte SHA-256 hash", s, sha256.Size)
+	}
+
+	return fp, nil
+}
+
+// verifyFingerprint returns a function for tls.Config.VerifyPeerCertificate
+// which accepts only a leaf certificate with the specified SHA-256
+// fingerprint.
+func verifyFingerprint(fingerprint []byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented by UniFi Controller")
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		if !hmac.Equal(sum[:], fingerprint) {
+			return fmt.Errorf("UniFi Controller certificate fingerprint %x does not match pinned fingerprint %x",
+				sum[:], fingerprint)
+		}
+
+		return nil
+	}
+}
+
+// Default timeouts used by NewHTTPClient.
+const (
+	defaultTimeout             = 10 * time.Second
+	defaultDialTimeout         = 5 * time.Second
+	defaultTLSHandshakeTimeout = 5 * time.Second
+)
+
+// NewHTTPClient creates a *http.Client suitable for use with NewClient,
+// using the parameters in cfg.
+func NewHTTPClient(cfg HTTPClientConfig) *http.Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.TLSHandshakeTimeout == 0 {
+		cfg.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:            cfg.RootCAs,
+		Certificates:       cfg.Certificates,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	// With a pinned fingerprint, the usual chain and hostname verification
+	// is replaced by comparing the fingerprint alone
+	if len(cfg.Fingerprint) > 0 {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyFingerprint(cfg.Fingerprint)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != nil {
+		proxy = http.ProxyURL(cfg.Proxy)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		TLSClientConfig:     tlsConfig,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	var rt http.RoundTripper = transport
+	if cfg.ResolveInterval > 0 {
+		rt = &resolveTransport{interval: cfg.ResolveInterval, next: transport, closed: time.Now()}
+	}
+	if len(cfg.Headers) > 0 {
+		rt = &headerTransport{headers: cfg.Headers, next: rt}
+	}
+
+	switch {
+	case cfg.ReplayDir != "":
+		rt = &replayTransport{dir: cfg.ReplayDir}
+	case cfg.RecordDir != "":
+		rt = &recordTransport{dir: cfg.RecordDir, next: rt}
+	}
+
 	return &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
+		Timeout:   cfg.Timeout,
+		Transport: rt,
 	}
 }
 
+// A headerTransport is an http.RoundTripper which adds headers to each
+// request.
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is given
+	req = req.WithContext(req.Context())
+	req.Header = cloneHeader(req.Header)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// A resolveTransport is an http.RoundTripper which closes the idle connections
+// of its *http.Transport at an interval, so that new connections are made to
+// the current address of the host.
+type resolveTransport struct {
+	interval time.Duration
+	next     *http.Transport
+
+	mu     sync.Mutex
+	closed time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *resolveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	if time.Since(t.closed) >= t.interval {
+		t.next.CloseIdleConnections()
+		t.closed = time.Now()
+	}
+	t.mu.Unlock()
+
+	return t.next.RoundTrip(req)
+}
+
+// transportHeaders returns the headers added by the headerTransport within
+// rt, if any, for requests which are not made through rt, such as to open a
+// WebSocket.
+func transportHeaders(rt http.RoundTripper) map[string]string {
+	switch t := rt.(type) {
+	case *headerTransport:
+		return t.headers
+	case *recordTransport:
+		return transportHeaders(t.next)
+	default:
+		return nil
+	}
+}
+
+// cloneHeader returns a copy of h.
+func cloneHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		out[k] = append([]string(nil), v...)
+	}
+
+	return out
+}
+
 // A Client is a client for the Ubiquiti UniFi Controller v4 API.
 //
 // Client.Login must be called and return a nil error before any additional
@@ -47,8 +296,45 @@ type Client struct {
 
 	apiURL *url.URL
 	client *http.Client
+
+	// sem limits the number of requests in flight at once.
+	sem chan struct{}
+
+	// loginMu serializes re-authentication, so that concurrent requests
+	// which find their session expired log in only once.
+	loginMu sync.Mutex
+
+	// mu protects the fields below, which may be updated by any request.
+	mu        sync.Mutex
+	csrfToken string
+
+	// unifiOS reports whether the Client is connected to a UniFi OS console,
+	// such as a UDM Pro or Cloud Key Gen2, rather than a classic controller.
+	unifiOS bool
+
+	// session is incremented by every successful login, so that a request
+	// can tell whether the session it was sent with has since been replaced.
+	session uint64
+
+	// relogin re-authenticates using the credentials of the last successful
+	// login, if any.
+	relogin func(ctx context.Context) error
+
+	// observe, if set, is notified of the outcome of every request.
+	observe RequestObserver
+
+	// breaker, if set, suspends requests while the controller is failing.
+	breaker *CircuitBreaker
 }
 
+// A RequestObserver is notified after each request a Client makes to the UniFi
+// Controller, such as to record metrics.  endpoint is the path of the request
+// with the site name replaced by "{site}", such as "/api/s/{site}/stat/device",
+// so that it is the same for every site.  code is the HTTP status code of the
+// response, or 0 if no response was received, and d is the time taken to
+// receive and decode the response.
+type RequestObserver func(endpoint string, code int, d time.Duration)
+
 // NewClient creates a new Client, using the input API address and an optional
 // HTTP client.  If no HTTP client is specified, a default one will be used.
 //
@@ -65,9 +351,7 @@ func NewClient(addr string, client *http.Client) (*Client, error) {
 	}
 
 	if client == nil {
-		client = &http.Client{
-			Timeout: 10 * time.Second,
-		}
+		client = NewHTTPClient(HTTPClientConfig{})
 	}
 
 	if client.Jar == nil {
@@ -83,21 +367,152 @@ func NewClient(addr string, client *http.Client) (*Client, error) {
 
 		apiURL: u,
 		client: client,
+		sem:    make(chan struct{}, DefaultMaxConcurrentRequests),
 	}
 
 	return c, nil
 }
 
+// SetMaxConcurrentRequests sets the maximum number of requests the Client will
+// have in flight to the UniFi Controller at once.  Additional requests wait
+// until an earlier one completes.  If n is zero or less,
+// DefaultMaxConcurrentRequests is used.
+//
+// SetMaxConcurrentRequests must be called before the Client is used.
+func (c *Client) SetMaxConcurrentRequests(n int) {
+	if n <= 0 {
+		n = DefaultMaxConcurrentRequests
+	}
+
+	c.sem = make(chan struct{}, n)
+}
+
+// SetRequestObserver sets a function which is notified after each request
+// made to the UniFi Controller.
+//
+// SetRequestObserver must be called before the Client is used.
+func (c *Client) SetRequestObserver(fn RequestObserver) {
+	c.observe = fn
+}
+
 // Login authenticates against the UniFi Controller using the specified
 // username and password.  Login must be called and return a nil error before
 // any additional actions can be performed.
-func (c *Client) Login(username string, password string) error {
+//
+// Login automatically detects whether the Client is connected to a classic
+// UniFi Controller or a UniFi OS console, and uses the appropriate login
+// flow for each.
+func (c *Client) Login(ctx context.Context, username string, password string) error {
+	return c.LoginWithToken(ctx, username, password, "")
+}
+
+// LoginWithToken is like Login, but also supplies a two-factor
+// authentication token, for accounts which require one.  TOTPCode can be
+// used to generate a token from a TOTP secret.
+//
+// Because a token can only be used once, the Client cannot re-authenticate
+// by itself when a session established using LoginWithToken expires, and
+// requests fail with ErrTokenExpired instead.  Use LoginWithTOTPSecret to
+// re-authenticate transparently.
+func (c *Client) LoginWithToken(ctx context.Context, username string, password string, token string) error {
+	unifiOS, err := c.detectUniFiOS(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.unifiOS = unifiOS
+	c.mu.Unlock()
+
 	auth := &login{
 		Username: username,
 		Password: password,
 	}
 
-	req, err := c.newRequest(http.MethodPost, "/api/login", auth)
+	endpoint := "/api/login"
+	if unifiOS {
+		endpoint = "/api/auth/login"
+	}
+
+	// Classic controllers and UniFi OS consoles expect the two-factor token
+	// under different keys
+	if token != "" {
+		if unifiOS {
+			auth.Token = token
+		} else {
+			auth.UbicToken = token
+		}
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, endpoint, auth)
+	if err != nil {
+		return err
+	}
+
+	// UniFi OS consoles refuse login requests which are not explicitly
+	// marked as JSON
+	if unifiOS {
+		req.Header.Set("Content-Type", jsonContentType)
+	}
+
+	if _, err := c.do(req, nil); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.session++
+	c.mu.Unlock()
+
+	// A two-factor token is only valid once, so replaying it could never
+	// succeed and would only count as another failed login
+	if token != "" {
+		c.setRelogin(func(context.Context) error {
+			return ErrTokenExpired
+		})
+		return nil
+	}
+
+	c.setRelogin(func(ctx context.Context) error {
+		return c.LoginWithToken(ctx, username, password, "")
+	})
+	return nil
+}
+
+// LoginWithTOTPSecret is like LoginWithToken, but generates a two-factor
+// authentication token from a base32-encoded TOTP secret.  Unlike a single
+// token, the secret can also be used to transparently re-authenticate when
+// a session expires.
+func (c *Client) LoginWithTOTPSecret(ctx context.Context, username string, password string, secret string) error {
+	token, err := TOTPCode(secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := c.LoginWithToken(ctx, username, password, token); err != nil {
+		return err
+	}
+
+	c.setRelogin(func(ctx context.Context) error {
+		return c.LoginWithTOTPSecret(ctx, username, password, secret)
+	})
+	return nil
+}
+
+// Logout ends the Client's session with the UniFi Controller, so the session
+// does not linger until it expires.  The Client no longer re-authenticates
+// automatically after Logout, and Login must be called again before any
+// additional actions can be performed.
+func (c *Client) Logout(ctx context.Context) error {
+	c.setRelogin(nil)
+
+	// Classic controllers end a session on POST, while UniFi OS consoles
+	// expect DELETE
+	method, endpoint := http.MethodPost, "/api/logout"
+	if c.IsUniFiOS() {
+		method, endpoint = http.MethodDelete, "/api/auth/logout"
+	}
+
+	req, err := c.newRequest(ctx, method, endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -106,20 +521,104 @@ func (c *Client) Login(username string, password string) error {
 	return err
 }
 
+// setRelogin stores a function used to re-authenticate when a session
+// expires.
+func (c *Client) setRelogin(fn func(ctx context.Context) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.relogin = fn
+}
+
+// IsUniFiOS reports whether the Client is connected to a UniFi OS console,
+// rather than a classic UniFi Controller.  IsUniFiOS is only accurate after
+// Login has been called.
+func (c *Client) IsUniFiOS() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.unifiOS
+}
+
+// detectUniFiOS determines if the Client is connected to a UniFi OS console.
+// A classic UniFi Controller redirects requests for its root to the web
+// interface, while a UniFi OS console serves its web interface directly.
+func (c *Client) detectUniFiOS(ctx context.Context) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, c.apiURL.String()+"/", nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("User-Agent", c.UserAgent)
+
+	// Copy the HTTP client so redirects are not followed for this request only
+	client := *c.client
+	client.CheckRedirect = func(_ *http.Request, _ []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var probe bool
+	if c.breaker != nil {
+		probe, err = c.breaker.allow()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	res, err := client.Do(req)
+	if c.breaker != nil {
+		c.breaker.record(probe, breakerOutcome(req, res, err))
+	}
+	if err != nil {
+		return false, err
+	}
+	_ = res.Body.Close()
+
+	return res.StatusCode == http.StatusOK, nil
+}
+
 type login struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Token     string `json:"token,omitempty"`
+	UbicToken string `json:"ubic_2fa_token,omitempty"`
+}
+
+// endpointURL returns the full URL of an API endpoint.
+func (c *Client) endpointURL(endpoint string) (*url.URL, error) {
+	// UniFi OS consoles serve the UniFi Network API below a fixed prefix,
+	// but handle authentication themselves, and serve other applications,
+	// such as UniFi Protect, below prefixes of their own
+	if c.IsUniFiOS() && !strings.HasPrefix(endpoint, "/api/auth/") && !strings.HasPrefix(endpoint, "/proxy/") {
+		endpoint = unifiOSNetworkPrefix + endpoint
+	}
+
+	return joinURL(c.apiURL, endpoint)
 }
 
-// newRequest creates a new HTTP request, using the specified HTTP method and
-// API endpoint. Additionally, it accepts a struct which can be marshaled to
-// a JSON body.
-func (c *Client) newRequest(method string, endpoint string, body interface{}) (*http.Request, error) {
+// joinURL returns the URL of endpoint below base.  Unlike resolving endpoint
+// as a reference, the path of base is kept, so that an API may be served
+// below a path prefix, such as by a reverse proxy.
+func joinURL(base *url.URL, endpoint string) (*url.URL, error) {
 	rel, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u := c.apiURL.ResolveReference(rel)
+
+	u := base.ResolveReference(rel)
+	u.Path = base.Path + rel.Path
+	u.RawPath = ""
+
+	return u, nil
+}
+
+// newRequest creates a new HTTP request bound to ctx, using the specified HTTP
+// method and API endpoint. Additionally, it accepts a struct which can be
+// marshaled to a JSON body.
+func (c *Client) newRequest(ctx context.Context, method string, endpoint string, body interface{}) (*http.Request, error) {
+	u, err := c.endpointURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
 
 	hasBody := method == http.MethodPost && body != nil
 	var length int64
@@ -138,6 +637,7 @@ func (c *Client) newRequest(method string, endpoint string, body interface{}) (*
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 
 	// For POST requests, add proper headers
 	if hasBody {
@@ -148,18 +648,168 @@ func (c *Client) newRequest(method string, endpoint string, body interface{}) (*
 	req.Header.Add("Accept", jsonContentType)
 	req.Header.Add("User-Agent", c.UserAgent)
 
+	c.mu.Lock()
+	if c.csrfToken != "" {
+		req.Header.Set(csrfTokenHeader, c.csrfToken)
+	}
+	c.mu.Unlock()
+
 	return req, nil
 }
 
 // do performs an HTTP request using req and unmarshals the result onto v, if
 // v is not nil.
+//
+// If the request fails because the session has expired, do re-authenticates
+// using the credentials of the last successful login and retries the request
+// once.
 func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	res, err := c.client.Do(req)
+	c.mu.Lock()
+	session := c.session
+	c.mu.Unlock()
+
+	res, err := c.doOnce(req, v)
+	if res == nil || res.StatusCode != http.StatusUnauthorized || isLoginEndpoint(req.URL.Path) {
+		return res, err
+	}
+
+	ok, rerr := c.reauthenticate(req.Context(), session)
+	if !ok {
+		return res, err
+	}
+	if rerr != nil {
+		// Preserve typed errors so callers can tell rejected credentials
+		// apart from an unreachable controller
+		if rerr == ErrAuthFailed || rerr == ErrTokenExpired {
+			return res, rerr
+		}
+
+		return res, fmt.Errorf("failed to re-authenticate after session expired: %v", rerr)
+	}
+
+	retry, err := c.retryRequest(req)
+	if err != nil {
+		return res, err
+	}
+
+	return c.doOnce(retry, v)
+}
+
+// reauthenticate logs in again after a request sent with the specified
+// session was rejected, and reports whether the request may be retried.
+// Concurrent callers are serialized, and only the first logs in: the others
+// find the session already replaced, and retry using the new one.
+func (c *Client) reauthenticate(ctx context.Context, session uint64) (bool, error) {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	c.mu.Lock()
+	relogin, current := c.relogin, c.session
+	c.mu.Unlock()
+
+	if relogin == nil {
+		return false, nil
+	}
+	if current != session {
+		return true, nil
+	}
+
+	return true, relogin(ctx)
+}
+
+// isLoginEndpoint reports whether path is one of the login endpoints, which
+// must never trigger re-authentication themselves.
+func isLoginEndpoint(path string) bool {
+	return strings.HasSuffix(path, "/api/login") || strings.HasSuffix(path, "/api/auth/login")
+}
+
+// retryRequest prepares a copy of req to be sent again, with a fresh body and
+// the current CSRF token.
+func (c *Client) retryRequest(req *http.Request) (*http.Request, error) {
+	retry := req.WithContext(req.Context())
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	retry.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		retry.Header[k] = v
+	}
+
+	c.mu.Lock()
+	if c.csrfToken != "" {
+		retry.Header.Set(csrfTokenHeader, c.csrfToken)
+	}
+	c.mu.Unlock()
+
+	return retry, nil
+}
+
+// doOnce performs a single HTTP request using req and unmarshals the result
+// onto v, if v is not nil.
+func (c *Client) doOnce(req *http.Request, v interface{}) (res *http.Response, err error) {
+	var probe bool
+	if c.breaker != nil {
+		probe, err = c.breaker.allow()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Hold a slot until the response body has been consumed, so the limit
+	// applies to the whole exchange with the controller
+	select {
+	case c.sem <- struct{}{}:
+	case <-req.Context().Done():
+		if c.breaker != nil {
+			c.breaker.record(probe, requestCancelled)
+		}
+		return nil, req.Context().Err()
+	}
+	defer func() { <-c.sem }()
+
+	if c.observe != nil {
+		start := time.Now()
+		defer func() {
+			var code int
+			if res != nil {
+				code = res.StatusCode
+			}
+
+			c.observe(observedEndpoint(strings.TrimPrefix(req.URL.Path, c.apiURL.Path)), code, time.Since(start))
+		}()
+	}
+
+	res, err = c.client.Do(req)
+	if c.breaker != nil {
+		c.breaker.record(probe, breakerOutcome(req, res, err))
+	}
 	if err != nil {
+		// The UniFi Controller may have moved to another address, which is
+		// only resolved for new connections
+		if t, ok := baseTransport(c.client.Transport); ok {
+			t.CloseIdleConnections()
+		}
+
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	// UniFi OS consoles issue a CSRF token on login, and may rotate it on
+	// any subsequent response
+	c.mu.Lock()
+	if t := res.Header.Get(updatedCSRFTokenHeader); t != "" {
+		c.csrfToken = t
+	} else if t := res.Header.Get(csrfTokenHeader); t != "" {
+		c.csrfToken = t
+	}
+	c.mu.Unlock()
+
 	if err := checkResponse(res); err != nil {
 		return res, err
 	}
@@ -172,17 +822,58 @@ func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	return res, json.NewDecoder(res.Body).Decode(v)
 }
 
+// siteEndpointRE matches the site name in the path of a site's endpoints.
+var siteEndpointRE = regexp.MustCompile(`^/api/s/[^/]+/`)
+
+// observedEndpoint returns the endpoint reported to a RequestObserver for a
+// request to path, which is the same whether or not the Client is connected
+// to a UniFi OS console.
+func observedEndpoint(path string) string {
+	path = strings.TrimPrefix(path, unifiOSNetworkPrefix)
+
+	return siteEndpointRE.ReplaceAllString(path, "/api/s/{site}/")
+}
+
 // checkResponse checks for correct content type in a response and for non-200
-// HTTP status codes, and returns any errors encountered.
+// HTTP status codes, and returns any errors encountered.  Failures reported by
+// the UniFi Controller are returned as one of the error values defined by this
+// package where possible, such as ErrAuthFailed.
 func checkResponse(res *http.Response) error {
-	if cType := res.Header.Get("Content-Type"); cType != jsonContentType {
+	// UniFi OS consoles vary the case and spacing of the content type
+	// parameters, so only the media type itself is compared
+	cType := res.Header.Get("Content-Type")
+	mType, _, err := mime.ParseMediaType(cType)
+	isJSON := err == nil && mType == jsonMediaType
+
+	// Check for 200-range status code
+	if c := res.StatusCode; c < 200 || c > 299 {
+		// UniFi OS consoles may reject requests before they reach the UniFi
+		// Network application, without a JSON body, so the status code is
+		// checked first
+		return statusError(res, isJSON)
+	}
+
+	if !isJSON {
 		return fmt.Errorf("expected %q content type, but received %q", jsonContentType, cType)
 	}
 
-	// Check for 200-range status code
-	if c := res.StatusCode; 200 <= c && c <= 299 {
-		return nil
+	return nil
+}
+
+// Raw performs a GET request to endpoint, such as "/api/s/default/stat/device",
+// and returns the UniFi Controller's response as it was received.  Raw is
+// intended for debugging, such as capturing the responses of a new controller
+// version whose fields are not yet understood by this package.
+func (c *Client) Raw(ctx context.Context, endpoint string) (json.RawMessage, error) {
+	req, err := c.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var v json.RawMessage
+	if _, err := c.do(req, &v); err != nil {
+		return nil, err
 	}
 
-	return fmt.Errorf("unexpected HTTP status code: %d", res.StatusCode)
+	return v, nil
 }
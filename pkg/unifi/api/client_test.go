@@ -0,0 +1,895 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+)
+
+func TestClientReloginOnUnauthorized(t *testing.T) {
+	var (
+		mu               sync.Mutex
+		logins, requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", jsonContentType)
+
+		switch r.URL.Path {
+		case "/":
+			// Classic controllers redirect to their web interface
+			http.Redirect(w, r, "/manage", http.StatusFound)
+		case "/api/login":
+			logins++
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/self/sites":
+			requests++
+
+			// Expire the session established by the first login
+			if logins < 2 {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`))
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"data":[{"name":"default"}]}`))
+		default:
+			t.Errorf("unexpected request path: %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if err := c.Login(context.Background(), "user", "pass"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	sites, err := c.Sites(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve sites: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if want, got := 1, len(sites); want != got {
+		t.Fatalf("unexpected number of sites:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 2, logins; want != got {
+		t.Fatalf("unexpected number of logins:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 2, requests; want != got {
+		t.Fatalf("unexpected number of requests:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientReloginConcurrent(t *testing.T) {
+	const n = 4
+
+	var (
+		mu       sync.Mutex
+		logins   int
+		rejected int
+		expired  = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonContentType)
+
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/manage", http.StatusFound)
+		case "/api/login":
+			mu.Lock()
+			logins++
+			mu.Unlock()
+
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/self/sites":
+			mu.Lock()
+			first := logins < 2
+			if first {
+				rejected++
+				if rejected == n {
+					close(expired)
+				}
+			}
+			mu.Unlock()
+
+			// Reject every request sent with the first session only once
+			// all of them are in flight, so that they expire together
+			if first {
+				<-expired
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`))
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"data":[{"name":"default"}]}`))
+		default:
+			t.Errorf("unexpected request path: %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	c.SetMaxConcurrentRequests(n)
+
+	if err := c.Login(context.Background(), "user", "pass"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Sites(context.Background()); err != nil {
+				t.Errorf("failed to retrieve sites: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if want, got := 2, logins; want != got {
+		t.Fatalf("unexpected number of logins:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientReloginWithTokenRefused(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		logins int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", jsonContentType)
+
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/manage", http.StatusFound)
+		case "/api/login":
+			logins++
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/self/sites":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`))
+		default:
+			t.Errorf("unexpected request path: %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if err := c.LoginWithToken(context.Background(), "user", "pass", "123456"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	// The one-time token must not be replayed once the session expires
+	if _, err := c.Sites(context.Background()); err != ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired, but got: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if want, got := 1, logins; want != got {
+		t.Fatalf("unexpected number of logins:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientLogout(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		unifiOS bool
+		method  string
+		path    string
+	}{
+		{
+			desc:   "classic controller",
+			method: http.MethodPost,
+			path:   "/api/logout",
+		},
+		{
+			desc:    "UniFi OS console",
+			unifiOS: true,
+			method:  http.MethodDelete,
+			path:    "/api/auth/logout",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var (
+			mu              sync.Mutex
+			logins, logouts int
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			w.Header().Set("Content-Type", jsonContentType)
+
+			switch r.URL.Path {
+			case "/":
+				if !tt.unifiOS {
+					http.Redirect(w, r, "/manage", http.StatusFound)
+				}
+			case "/api/login", "/api/auth/login":
+				logins++
+				_, _ = w.Write([]byte(`{}`))
+			case tt.path:
+				if r.Method != tt.method {
+					t.Errorf("unexpected logout method:\n- want: %v\n-  got: %v", tt.method, r.Method)
+				}
+
+				logouts++
+				_, _ = w.Write([]byte(`{}`))
+			case "/api/self/sites", "/proxy/network/api/self/sites":
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`))
+			default:
+				t.Errorf("unexpected request path: %q", r.URL.Path)
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		c, err := NewClient(srv.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		if err := c.Login(context.Background(), "user", "pass"); err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+		if err := c.Logout(context.Background()); err != nil {
+			t.Fatalf("failed to log out: %v", err)
+		}
+
+		// A logged out client must not log in again by itself
+		if _, err := c.Sites(context.Background()); err == nil {
+			t.Fatal("expected an error retrieving sites after logout")
+		}
+		srv.Close()
+
+		mu.Lock()
+		gotLogins, gotLogouts := logins, logouts
+		mu.Unlock()
+
+		if want, got := 1, gotLogins; want != got {
+			t.Fatalf("unexpected number of logins:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := 1, gotLogouts; want != got {
+			t.Fatalf("unexpected number of logouts:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestClientRaw(t *testing.T) {
+	const body = `{"meta":{"rc":"ok"},"data":[{"_id":"abc","new_field":1}]}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/s/default/stat/device", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	raw, err := c.Raw(context.Background(), "/api/s/default/stat/device")
+	if err != nil {
+		t.Fatalf("failed to retrieve raw response: %v", err)
+	}
+
+	if want, got := body, string(raw); want != got {
+		t.Fatalf("unexpected raw response:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientRequestObserver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonContentType)
+
+		switch r.URL.Path {
+		case "/api/s/default/stat/device":
+			_, _ = w.Write([]byte(`{"data":[]}`))
+		case "/api/s/lab/stat/device":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.NoSiteContext"}}`))
+		default:
+			t.Fatalf("unexpected request path: %q", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var observed []string
+	c.SetRequestObserver(func(endpoint string, code int, d time.Duration) {
+		if d <= 0 {
+			t.Fatalf("unexpected request duration: %v", d)
+		}
+
+		observed = append(observed, fmt.Sprintf("%s %d", endpoint, code))
+	})
+
+	if _, err := c.Devices(context.Background(), "default"); err != nil {
+		t.Fatalf("failed to retrieve devices: %v", err)
+	}
+	if _, err := c.Devices(context.Background(), "lab"); err == nil {
+		t.Fatal("expected an error for a forbidden site, but none occurred")
+	}
+
+	want := "/api/s/{site}/stat/device 200, /api/s/{site}/stat/device 403"
+	if got := strings.Join(observed, ", "); want != got {
+		t.Fatalf("unexpected observed requests:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientMaxConcurrentRequests(t *testing.T) {
+	const max = 2
+
+	var (
+		mu             sync.Mutex
+		inFlight, peak int
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	c.SetMaxConcurrentRequests(max)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3*max; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Sites(context.Background()); err != nil {
+				t.Errorf("failed to retrieve sites: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if want, got := max, peak; want != got {
+		t.Fatalf("unexpected peak number of requests in flight:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestNewHTTPClientFingerprint(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+
+	var tests = []struct {
+		desc        string
+		fingerprint []byte
+		ok          bool
+	}{
+		{
+			desc:        "matching fingerprint",
+			fingerprint: sum[:],
+			ok:          true,
+		},
+		{
+			desc:        "mismatched fingerprint",
+			fingerprint: make([]byte, sha256.Size),
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		c := NewHTTPClient(HTTPClientConfig{Fingerprint: tt.fingerprint})
+
+		res, err := c.Get(srv.URL)
+		if err == nil {
+			_ = res.Body.Close()
+		}
+
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected request success:\n- want: %v\n-  got: %v (%v)",
+				want, got, err)
+		}
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	u, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("failed to parse proxy URL: %v", err)
+	}
+
+	c := NewHTTPClient(HTTPClientConfig{Proxy: u})
+
+	// The controller address need not resolve, because only the proxy is
+	// contacted directly
+	res, err := c.Get("http://unifi.example.com:8443/")
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	_ = res.Body.Close()
+
+	if want, got := "unifi.example.com:8443", host; want != got {
+		t.Fatalf("unexpected proxied host:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	var tests = []struct {
+		in string
+		ok bool
+	}{
+		{in: strings.Repeat("ab", 32), ok: true},
+		{in: strings.TrimSuffix(strings.Repeat("AB:", 32), ":"), ok: true},
+		{in: strings.Repeat("ab", 20)},
+		{in: "zz"},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] fingerprint: %q", i, tt.in)
+
+		_, err := ParseFingerprint(tt.in)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected parse success:\n- want: %v\n-  got: %v (%v)",
+				want, got, err)
+		}
+	}
+}
+
+func TestClientBasePathAndHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/unifi/api/s/default/stat/device", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "bar", r.Header.Get("X-Foo"); want != got {
+			t.Fatalf("unexpected X-Foo header:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL+"/unifi/", NewHTTPClient(HTTPClientConfig{
+		Headers: map[string]string{"X-Foo": "bar"},
+	}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var observed string
+	c.SetRequestObserver(func(endpoint string, _ int, _ time.Duration) {
+		observed = endpoint
+	})
+
+	if _, err := c.Devices(context.Background(), "default"); err != nil {
+		t.Fatalf("failed to retrieve devices: %v", err)
+	}
+
+	// The path prefix is not part of the observed endpoint
+	if want, got := "/api/s/{site}/stat/device", observed; want != got {
+		t.Fatalf("unexpected observed endpoint:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestNewHTTPClientResolveInterval(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			defer mu.Unlock()
+			conns++
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	var tests = []struct {
+		desc     string
+		interval time.Duration
+		want     int
+	}{
+		{
+			desc: "connections reused",
+			want: 1,
+		},
+		{
+			desc:     "connections closed at each interval",
+			interval: time.Nanosecond,
+			want:     3,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		mu.Lock()
+		conns = 0
+		mu.Unlock()
+
+		c := NewHTTPClient(HTTPClientConfig{ResolveInterval: tt.interval})
+		for j := 0; j < 3; j++ {
+			res, err := c.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("failed to perform request: %v", err)
+			}
+			_, _ = io.Copy(ioutil.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+
+		mu.Lock()
+		got := conns
+		mu.Unlock()
+
+		if want := tt.want; want != got {
+			t.Fatalf("unexpected number of connections:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestClientEndpointURL(t *testing.T) {
+	var tests = []struct {
+		desc     string
+		addr     string
+		unifiOS  bool
+		endpoint string
+		want     string
+	}{
+		{
+			desc:     "classic controller",
+			addr:     "https://unifi.example.com:8443",
+			endpoint: "/api/s/default/stat/device",
+			want:     "https://unifi.example.com:8443/api/s/default/stat/device",
+		},
+		{
+			desc:     "UniFi OS network endpoint",
+			addr:     "https://udm.example.com",
+			unifiOS:  true,
+			endpoint: "/api/s/default/stat/device",
+			want:     "https://udm.example.com/proxy/network/api/s/default/stat/device",
+		},
+		{
+			desc:     "UniFi OS authentication endpoint",
+			addr:     "https://udm.example.com",
+			unifiOS:  true,
+			endpoint: "/api/auth/login",
+			want:     "https://udm.example.com/api/auth/login",
+		},
+		{
+			desc:     "UniFi OS application endpoint",
+			addr:     "https://udm.example.com",
+			unifiOS:  true,
+			endpoint: "/proxy/protect/api/bootstrap",
+			want:     "https://udm.example.com/proxy/protect/api/bootstrap",
+		},
+		{
+			desc:     "UniFi OS below a path prefix",
+			addr:     "https://proxy.example.com/udm/",
+			unifiOS:  true,
+			endpoint: "/api/self/sites",
+			want:     "https://proxy.example.com/udm/proxy/network/api/self/sites",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		c, err := NewClient(tt.addr, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		c.unifiOS = tt.unifiOS
+
+		u, err := c.endpointURL(tt.endpoint)
+		if err != nil {
+			t.Fatalf("failed to create endpoint URL: %v", err)
+		}
+
+		if want, got := tt.want, u.String(); want != got {
+			t.Fatalf("unexpected endpoint URL:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestClientUniFiOSDetection(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		unifiOS bool
+	}{
+		{desc: "classic controller"},
+		{desc: "UniFi OS console", unifiOS: true},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", jsonContentType)
+
+			switch r.URL.Path {
+			case "/":
+				if !tt.unifiOS {
+					http.Redirect(w, r, "/manage", http.StatusFound)
+				}
+			case "/api/login", "/api/auth/login":
+				_, _ = w.Write([]byte(`{}`))
+			default:
+				t.Errorf("unexpected request path: %q", r.URL.Path)
+			}
+		}))
+
+		c, err := NewClient(srv.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		err = c.Login(context.Background(), "user", "pass")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+
+		if want, got := tt.unifiOS, c.IsUniFiOS(); want != got {
+			t.Fatalf("unexpected UniFi OS detection:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestClientContextCancel(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if err := c.Login(context.Background(), apitest.Username, apitest.Password); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	unblock := srv.Block()
+	defer unblock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errC := make(chan error, 1)
+	go func() {
+		_, err := c.Devices(ctx, apitest.DefaultSite)
+		errC <- err
+	}()
+
+	// Cancel only once the request has reached the hung controller
+	for srv.Requests("/api/s/default/stat/device") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-errC:
+		uerr, ok := err.(*url.Error)
+		if !ok || uerr.Err != context.Canceled {
+			t.Fatalf("expected request to fail with context.Canceled, but got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not aborted by cancelling its context")
+	}
+}
+
+func TestNewHTTPClientRootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	trusted := x509.NewCertPool()
+	trusted.AddCert(srv.Certificate())
+
+	var tests = []struct {
+		desc    string
+		rootCAs *x509.CertPool
+		ok      bool
+	}{
+		{
+			desc:    "certificate signed by trusted CA",
+			rootCAs: trusted,
+			ok:      true,
+		},
+		{
+			desc:    "certificate signed by unknown CA",
+			rootCAs: x509.NewCertPool(),
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		c := NewHTTPClient(HTTPClientConfig{RootCAs: tt.rootCAs})
+
+		res, err := c.Get(srv.URL)
+		if err == nil {
+			_ = res.Body.Close()
+		}
+
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected request success:\n- want: %v\n-  got: %v (%v)",
+				want, got, err)
+		}
+	}
+}
+
+func TestNewHTTPClientCertificates(t *testing.T) {
+	cert := testClientCertificate(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert.Leaf)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(srv.Certificate())
+
+	var tests = []struct {
+		desc  string
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{
+			desc:  "client certificate presented",
+			certs: []tls.Certificate{cert},
+			ok:    true,
+		},
+		{
+			desc: "no client certificate",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		c := NewHTTPClient(HTTPClientConfig{
+			RootCAs:      rootCAs,
+			Certificates: tt.certs,
+		})
+
+		res, err := c.Get(srv.URL)
+		if err == nil {
+			_ = res.Body.Close()
+		}
+
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected request success:\n- want: %v\n-  got: %v (%v)",
+				want, got, err)
+		}
+	}
+}
+
+// testClientCertificate generates a self-signed TLS client certificate.
+func testClientCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "unifi_exporter"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// A Controller retrieves information from a UniFi Controller.  Client is the
+// canonical implementation; Controller exists so that consumers of this
+// package, such as metrics collectors, can be tested with fakes or use
+// instrumented implementations.
+type Controller interface {
+	Sites(ctx context.Context) ([]*Site, error)
+	Devices(ctx context.Context, siteName string) ([]*Device, error)
+	Stations(ctx context.Context, siteName string) ([]*Station, error)
+	Clients(ctx context.Context, siteName string) ([]*Station, error)
+	Alarms(ctx context.Context, siteName string) ([]*Alarm, error)
+	StationDPI(ctx context.Context, siteName string) ([]*StationDPI, error)
+	SiteReport(ctx context.Context, siteName string, interval ReportInterval, start time.Time, end time.Time) ([]*SiteReport, error)
+}
+
+// Verify that the Client implements the Controller interface.
+var _ Controller = &Client{}
@@ -1,20 +1,24 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
 // Devices returns all of the Devices for a specified site name.
-func (c *Client) Devices(siteName string) ([]*Device, error) {
+func (c *Client) Devices(ctx context.Context, siteName string) ([]*Device, error) {
 	var v struct {
 		Devices []*Device `json:"data"`
 	}
 
 	req, err := c.newRequest(
+		ctx,
 		"GET",
 		fmt.Sprintf("/api/s/%s/stat/device", siteName),
 		nil,
@@ -33,19 +37,77 @@ type Device struct {
 	Adopted   bool
 	InformIP  net.IP
 	InformURL *url.URL
+	IP        net.IP
+	LastSeen  time.Time
+	MAC       net.HardwareAddr
 	Model     string
 	Name      string
 	NICs      []*NIC
+	Ports     []*Port
 	Radios    []*Radio
 	Serial    string
 	SiteID    string
+	State     DeviceState
 	Stats     *DeviceStats
+	Type      string
+	Uplink    *Uplink
 	Uptime    time.Duration
 	Version   string
+	WANs      []*WAN
+	WANUptime []*WANUptime
+
+	// Upgradable reports whether the controller has newer firmware for the
+	// device than its Version.
+	Upgradable bool
 
 	// TODO(mdlayher): add more fields from unexported device type
 }
 
+// A DeviceState is the state of a Device, as reported by the UniFi Controller.
+type DeviceState int
+
+// Known DeviceState values.
+const (
+	DeviceStateDisconnected     DeviceState = 0
+	DeviceStateConnected        DeviceState = 1
+	DeviceStatePending          DeviceState = 2
+	DeviceStateFirmwareMismatch DeviceState = 3
+	DeviceStateUpgrading        DeviceState = 4
+	DeviceStateProvisioning     DeviceState = 5
+	DeviceStateHeartbeatMissed  DeviceState = 6
+	DeviceStateAdopting         DeviceState = 7
+	DeviceStateDeleting         DeviceState = 8
+	DeviceStateInformError      DeviceState = 9
+	DeviceStateAdoptionFailed   DeviceState = 10
+	DeviceStateIsolated         DeviceState = 11
+)
+
+// deviceStates are the names of known DeviceState values.
+var deviceStates = map[DeviceState]string{
+	DeviceStateDisconnected:     "disconnected",
+	DeviceStateConnected:        "connected",
+	DeviceStatePending:          "pending",
+	DeviceStateFirmwareMismatch: "firmware_mismatch",
+	DeviceStateUpgrading:        "upgrading",
+	DeviceStateProvisioning:     "provisioning",
+	DeviceStateHeartbeatMissed:  "heartbeat_missed",
+	DeviceStateAdopting:         "adopting",
+	DeviceStateDeleting:         "deleting",
+	DeviceStateInformError:      "inform_error",
+	DeviceStateAdoptionFailed:   "adoption_failed",
+	DeviceStateIsolated:         "isolated",
+}
+
+// String returns the name of s, such as "connected", or "unknown_N" for a
+// state which is not known.
+func (s DeviceState) String() string {
+	if name, ok := deviceStates[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown_%d", int(s))
+}
+
 // A Radio is a wireless radio, attached to a Device.
 type Radio struct {
 	BuiltInAntenna     bool
@@ -70,6 +132,118 @@ type NIC struct {
 	Name string
 }
 
+// A Port is a wired port of a Device, such as a switch port or a gateway's
+// WAN port, with its own traffic statistics.
+type Port struct {
+	Index int
+	Name  string
+
+	// InterfaceName is the operating system's name for the port, such as
+	// eth0, which only some devices report.
+	InterfaceName string
+
+	// ProfileID and NativeNetworkID are the IDs of the port profile and of
+	// the network of untagged traffic configured for the port, if any.
+	// Network IDs are not VLAN IDs; each network has its own VLAN.
+	ProfileID       string
+	NativeNetworkID string
+
+	// PoEMode is the power over ethernet mode of the port, such as "auto"
+	// or "off", or empty if the port does not support PoE.
+	PoEMode string
+
+	// Speed is the negotiated link speed of the port in Mbps, and FullDuplex
+	// reports whether the link is full duplex.  Neither is meaningful while
+	// the port is down.
+	Speed      int
+	FullDuplex bool
+
+	// OpMode is the operating mode of the port, such as "switch", "mirror"
+	// or "aggregate".  AggregatedBy is the Index of the port which leads the
+	// link aggregation group the port is a member of, or zero if the port
+	// is not a member or leads the group itself.
+	OpMode       string
+	AggregatedBy int
+
+	// STPState is the spanning tree state of the port, such as "forwarding"
+	// or "blocking", or empty if the device does not report one.  Ports
+	// which loop protection has shut off are blocked.
+	STPState string
+
+	// ReceiveBroadcast and ReceiveMulticast are the number of broadcast and
+	// multicast packets received on the port, which storm control limits.
+	// Packets dropped by storm control are counted in the ReceiveDropped
+	// count of Stats, which the controller does not break down further.
+	ReceiveBroadcast float64
+	ReceiveMulticast float64
+
+	// MACTable holds the addresses the port has learned, which only some
+	// switches report.  It is nil if the port reports no table at all.
+	// Entries whose address cannot be parsed are skipped.
+	MACTable []*MACTableEntry
+
+	Up    bool
+	Stats *WiredStats
+}
+
+// A MACTableEntry is an address learned on a Port, and the VLAN it was
+// learned on, or zero if the switch does not report one.  Static entries were
+// configured, rather than learned from traffic.
+type MACTableEntry struct {
+	MAC    net.HardwareAddr
+	VLAN   int
+	Static bool
+}
+
+// An Uplink describes the upstream connection of a Device to another Device,
+// such as the switch port an access point is connected to.
+type Uplink struct {
+	MAC        net.HardwareAddr
+	RemotePort int
+	Type       string
+}
+
+// A WAN is a WAN interface of a gateway Device.
+type WAN struct {
+	// Name is the UniFi Controller's name for the WAN, such as "wan1" or
+	// "wan2", and InterfaceName is the operating system's name for its
+	// interface, such as eth0.
+	Name          string
+	InterfaceName string
+
+	// IP is the address assigned to the WAN, such as by the ISP's DHCP
+	// server, or nil if it has none.
+	IP net.IP
+	Up bool
+}
+
+// WANUptime is the UniFi Controller's monitoring of the availability of a
+// WAN of a gateway Device, as shown on its ISP health panel.
+type WANUptime struct {
+	// WAN is the name of the WAN, such as "wan1", matching WAN.Name.
+	WAN string
+
+	// Availability is the percentage of Period for which the WAN was
+	// available, and LatencyAverage is its average latency over Period.
+	Availability   float64
+	LatencyAverage time.Duration
+	Period         time.Duration
+
+	Monitors []*WANMonitor
+}
+
+// A WANMonitor is a target which the UniFi Controller probes over a WAN to
+// determine its availability and latency.
+type WANMonitor struct {
+	// Target is the monitored host, such as "ping.ui.com", and Type is the
+	// kind of probe, such as "icmp" or "dns".
+	Target string
+	Type   string
+
+	Availability   float64
+	LatencyAverage time.Duration
+}
+
 // DeviceStats contains device network activity statistics.
 type DeviceStats struct {
 	TotalBytes float64
@@ -82,17 +256,24 @@ type DeviceStats struct {
 // WirelessStats contains wireless device network activity statistics.
 type WirelessStats struct {
 	ReceiveBytes    float64
+	ReceiveDropped  float64
+	ReceiveErrors   float64
 	ReceivePackets  float64
 	TransmitBytes   float64
 	TransmitDropped float64
+	TransmitErrors  float64
 	TransmitPackets float64
 }
 
 // WiredStats contains wired device network activity statistics.
 type WiredStats struct {
 	ReceiveBytes    float64
+	ReceiveDropped  float64
+	ReceiveErrors   float64
 	ReceivePackets  float64
 	TransmitBytes   float64
+	TransmitDropped float64
+	TransmitErrors  float64
 	TransmitPackets float64
 }
 
@@ -116,6 +297,15 @@ func (d *Device) UnmarshalJSON(b []byte) error {
 		return fmt.Errorf("failed to parse inform IP: %v", dev.InformIP)
 	}
 
+	// The address of a device which has not yet been adopted may be unknown
+	var ip net.IP
+	if dev.IP != "" {
+		ip = net.ParseIP(dev.IP)
+		if ip == nil {
+			return fmt.Errorf("failed to parse IP: %v", dev.IP)
+		}
+	}
+
 	informURL, err := url.Parse(dev.InformURL)
 	if err != nil {
 		return err
@@ -134,6 +324,68 @@ func (d *Device) UnmarshalJSON(b []byte) error {
 		})
 	}
 
+	ports := make([]*Port, 0, len(dev.PortTable))
+	for _, pt := range dev.PortTable {
+		var macTable []*MACTableEntry
+		if pt.MacTable != nil {
+			macTable = make([]*MACTableEntry, 0, len(pt.MacTable))
+		}
+		for _, mt := range pt.MacTable {
+			// A table can hold hundreds of addresses, so one which
+			// cannot be parsed is skipped rather than failing the
+			// whole device
+			mac, err := net.ParseMAC(mt.MAC)
+			if err != nil {
+				continue
+			}
+
+			macTable = append(macTable, &MACTableEntry{
+				MAC:    mac,
+				VLAN:   mt.Vlan,
+				Static: mt.Static,
+			})
+		}
+
+		ports = append(ports, &Port{
+			Index:         pt.PortIdx,
+			Name:          pt.Name,
+			InterfaceName: pt.Ifname,
+			Up:            pt.Up,
+			Stats: &WiredStats{
+				ReceiveBytes:    pt.RxBytes,
+				ReceivePackets:  pt.RxPackets,
+				ReceiveDropped:  pt.RxDropped,
+				TransmitBytes:   pt.TxBytes,
+				TransmitPackets: pt.TxPackets,
+				TransmitDropped: pt.TxDropped,
+			},
+			ProfileID:        pt.PortconfID,
+			NativeNetworkID:  pt.NativeNetworkconfID,
+			PoEMode:          pt.PoeMode,
+			Speed:            pt.Speed,
+			FullDuplex:       pt.FullDuplex,
+			OpMode:           pt.OpMode,
+			AggregatedBy:     int(pt.AggregatedBy),
+			STPState:         pt.StpState,
+			ReceiveBroadcast: pt.RxBroadcast,
+			ReceiveMulticast: pt.RxMulticast,
+			MACTable:         macTable,
+		})
+	}
+
+	// Devices which are still being adopted may not report an ethernet
+	// table, so prefer the device's own MAC address to that of its first NIC
+	var mac net.HardwareAddr
+	switch {
+	case dev.MAC != "":
+		mac, err = net.ParseMAC(dev.MAC)
+		if err != nil {
+			return err
+		}
+	case len(nics) > 0:
+		mac = nics[0].MAC
+	}
+
 	radios := make([]*Radio, 0, len(dev.RadioTable))
 	for _, rt := range dev.RadioTable {
 		r := &Radio{
@@ -183,60 +435,170 @@ func (d *Device) UnmarshalJSON(b []byte) error {
 		totalBytes = dev.Stat.Ap.Bytes
 		allStats = &WirelessStats{
 			ReceiveBytes:    dev.Stat.Ap.RxBytes,
+			ReceiveDropped:  dev.Stat.Ap.RxDropped,
+			ReceiveErrors:   dev.Stat.Ap.RxErrors,
 			ReceivePackets:  dev.Stat.Ap.RxPackets,
 			TransmitBytes:   dev.Stat.Ap.TxBytes,
 			TransmitDropped: dev.Stat.Ap.TxDropped,
+			TransmitErrors:  dev.Stat.Ap.TxErrors,
 			TransmitPackets: dev.Stat.Ap.TxPackets,
 		}
 		userStats = &WirelessStats{
 			ReceiveBytes:    dev.Stat.Ap.UserRxBytes,
+			ReceiveDropped:  dev.Stat.Ap.UserRxDropped,
+			ReceiveErrors:   dev.Stat.Ap.UserRxErrors,
 			ReceivePackets:  dev.Stat.Ap.UserRxPackets,
 			TransmitBytes:   dev.Stat.Ap.UserTxBytes,
 			TransmitDropped: dev.Stat.Ap.UserTxDropped,
+			TransmitErrors:  dev.Stat.Ap.UserTxErrors,
 			TransmitPackets: dev.Stat.Ap.UserTxPackets,
 		}
 	case "ugw":
 		totalBytes = dev.Stat.Gw.Bytes
 		allStats = &WirelessStats{
 			ReceiveBytes:    dev.Stat.Gw.RxBytes,
+			ReceiveDropped:  dev.Stat.Gw.RxDropped,
+			ReceiveErrors:   dev.Stat.Gw.RxErrors,
 			ReceivePackets:  dev.Stat.Gw.RxPackets,
 			TransmitBytes:   dev.Stat.Gw.TxBytes,
 			TransmitDropped: dev.Stat.Gw.TxDropped,
+			TransmitErrors:  dev.Stat.Gw.TxErrors,
 			TransmitPackets: dev.Stat.Gw.TxPackets,
 		}
 		userStats = &WirelessStats{
 			ReceiveBytes:    dev.Stat.Gw.UserRxBytes,
+			ReceiveDropped:  dev.Stat.Gw.UserRxDropped,
+			ReceiveErrors:   dev.Stat.Gw.UserRxErrors,
 			ReceivePackets:  dev.Stat.Gw.UserRxPackets,
 			TransmitBytes:   dev.Stat.Gw.UserTxBytes,
 			TransmitDropped: dev.Stat.Gw.UserTxDropped,
+			TransmitErrors:  dev.Stat.Gw.UserTxErrors,
 			TransmitPackets: dev.Stat.Gw.UserTxPackets,
 		}
 	}
 
+	var uplink *Uplink
+	if dev.Uplink.UplinkMAC != "" {
+		mac, err := net.ParseMAC(dev.Uplink.UplinkMAC)
+		if err != nil {
+			return err
+		}
+
+		uplink = &Uplink{
+			MAC:        mac,
+			RemotePort: dev.Uplink.UplinkRemotePort,
+			Type:       dev.Uplink.Type,
+		}
+	}
+
+	var wans []*WAN
+	for _, w := range []struct {
+		name string
+		wan  *deviceWAN
+	}{
+		{name: "wan1", wan: dev.WAN1},
+		{name: "wan2", wan: dev.WAN2},
+	} {
+		if w.wan == nil {
+			continue
+		}
+
+		var ip net.IP
+		if w.wan.IP != "" {
+			ip = net.ParseIP(w.wan.IP)
+			if ip == nil {
+				return fmt.Errorf("failed to parse %s IP: %v", w.name, w.wan.IP)
+			}
+		}
+
+		wans = append(wans, &WAN{
+			Name:          w.name,
+			InterfaceName: w.wan.Ifname,
+			IP:            ip,
+			Up:            w.wan.Up,
+		})
+	}
+
+	// Uptime statistics are keyed "WAN", "WAN2" and so on, where WAN
+	// interfaces are named "wan1", "wan2"
+	uptimeKeys := make([]string, 0, len(dev.UptimeStats))
+	for k := range dev.UptimeStats {
+		uptimeKeys = append(uptimeKeys, k)
+	}
+	sort.Strings(uptimeKeys)
+
+	wanUptime := make([]*WANUptime, 0, len(uptimeKeys))
+	for _, k := range uptimeKeys {
+		us := dev.UptimeStats[k]
+
+		name := strings.ToLower(k)
+		if name == "wan" {
+			name = "wan1"
+		}
+
+		monitors := make([]*WANMonitor, 0, len(us.Monitors))
+		for _, m := range us.Monitors {
+			monitors = append(monitors, &WANMonitor{
+				Target:         m.Target,
+				Type:           m.Type,
+				Availability:   m.Availability,
+				LatencyAverage: time.Duration(m.LatencyAverage * float64(time.Millisecond)),
+			})
+		}
+
+		wanUptime = append(wanUptime, &WANUptime{
+			WAN:            name,
+			Availability:   us.Availability,
+			LatencyAverage: time.Duration(us.LatencyAverage * float64(time.Millisecond)),
+			Period:         time.Duration(us.TimePeriod) * time.Second,
+			Monitors:       monitors,
+		})
+	}
+
+	// A zero last_seen indicates the device has never checked in
+	var lastSeen time.Time
+	if dev.LastSeen != 0 {
+		lastSeen = time.Unix(int64(dev.LastSeen), 0)
+	}
+
 	*d = Device{
 		ID:        dev.ID,
 		Adopted:   dev.Adopted,
 		InformIP:  informIP,
 		InformURL: informURL,
+		IP:        ip,
+		LastSeen:  lastSeen,
+		MAC:       mac,
 		Model:     dev.Model,
 		Name:      dev.Name,
 		NICs:      nics,
+		Ports:     ports,
 		Radios:    radios,
 		Serial:    dev.Serial,
 		SiteID:    dev.SiteID,
+		State:     DeviceState(dev.State),
+		Type:      dev.Type,
+		Uplink:    uplink,
 		Uptime:    time.Duration(time.Duration(dev.Uptime) * time.Second),
 		Version:   dev.Version,
+		WANs:      wans,
+		WANUptime: wanUptime,
 		Stats: &DeviceStats{
 			TotalBytes: totalBytes,
 			All:        allStats,
 			User:       userStats,
 			Uplink: &WiredStats{
 				ReceiveBytes:    dev.Uplink.RxBytes,
+				ReceiveDropped:  dev.Uplink.RxDropped,
+				ReceiveErrors:   dev.Uplink.RxErrors,
 				ReceivePackets:  dev.Uplink.RxPackets,
 				TransmitBytes:   dev.Uplink.TxBytes,
+				TransmitDropped: dev.Uplink.TxDropped,
+				TransmitErrors:  dev.Uplink.TxErrors,
 				TransmitPackets: dev.Uplink.TxPackets,
 			},
 		},
+		Upgradable: dev.Upgradable,
 	}
 
 	return nil
@@ -260,6 +622,33 @@ type device struct {
 		Name    string `json:"name"`
 		NumPort int    `json:"num_port"`
 	} `json:"ethernet_table"`
+	PortTable []struct {
+		PortIdx             int     `json:"port_idx"`
+		Name                string  `json:"name"`
+		Ifname              string  `json:"ifname"`
+		Up                  bool    `json:"up"`
+		RxBytes             float64 `json:"rx_bytes"`
+		RxPackets           float64 `json:"rx_packets"`
+		TxBytes             float64 `json:"tx_bytes"`
+		TxPackets           float64 `json:"tx_packets"`
+		RxDropped           float64 `json:"rx_dropped"`
+		TxDropped           float64 `json:"tx_dropped"`
+		RxBroadcast         float64 `json:"rx_broadcast"`
+		RxMulticast         float64 `json:"rx_multicast"`
+		StpState            string  `json:"stp_state"`
+		PortconfID          string  `json:"portconf_id"`
+		NativeNetworkconfID string  `json:"native_networkconf_id"`
+		PoeMode             string  `json:"poe_mode"`
+		Speed               int     `json:"speed"`
+		FullDuplex          bool    `json:"full_duplex"`
+		OpMode              string  `json:"op_mode"`
+		AggregatedBy        portRef `json:"aggregated_by"`
+		MacTable            []struct {
+			MAC    string `json:"mac"`
+			Vlan   int    `json:"vlan"`
+			Static bool   `json:"static"`
+		} `json:"mac_table"`
+	} `json:"port_table"`
 	GuestNumSta   int         `json:"guest-num_sta"`
 	HasSpeaker    bool        `json:"has_speaker"`
 	InformIP      string      `json:"inform_ip"`
@@ -320,9 +709,12 @@ type device struct {
 			NgTxDropped      float64 `json:"ng-tx_dropped"`
 			NgTxPackets      float64 `json:"ng-tx_packets"`
 			RxBytes          float64 `json:"rx_bytes"`
+			RxDropped        float64 `json:"rx_dropped"`
+			RxErrors         float64 `json:"rx_errors"`
 			RxPackets        float64 `json:"rx_packets"`
 			TxBytes          float64 `json:"tx_bytes"`
 			TxDropped        float64 `json:"tx_dropped"`
+			TxErrors         float64 `json:"tx_errors"`
 			TxPackets        float64 `json:"tx_packets"`
 			UserNgRxBytes    float64 `json:"user-ng-rx_bytes"`
 			UserNgRxPackets  float64 `json:"user-ng-rx_packets"`
@@ -330,9 +722,12 @@ type device struct {
 			UserNgTxDropped  float64 `json:"user-ng-tx_dropped"`
 			UserNgTxPackets  float64 `json:"user-ng-tx_packets"`
 			UserRxBytes      float64 `json:"user-rx_bytes"`
+			UserRxDropped    float64 `json:"user-rx_dropped"`
+			UserRxErrors     float64 `json:"user-rx_errors"`
 			UserRxPackets    float64 `json:"user-rx_packets"`
 			UserTxBytes      float64 `json:"user-tx_bytes"`
 			UserTxDropped    float64 `json:"user-tx_dropped"`
+			UserTxErrors     float64 `json:"user-tx_errors"`
 			UserTxPackets    float64 `json:"user-tx_packets"`
 		}
 		Gw struct {
@@ -350,9 +745,12 @@ type device struct {
 			NgTxDropped      float64 `json:"ng-tx_dropped"`
 			NgTxPackets      float64 `json:"ng-tx_packets"`
 			RxBytes          float64 `json:"rx_bytes"`
+			RxDropped        float64 `json:"rx_dropped"`
+			RxErrors         float64 `json:"rx_errors"`
 			RxPackets        float64 `json:"rx_packets"`
 			TxBytes          float64 `json:"tx_bytes"`
 			TxDropped        float64 `json:"tx_dropped"`
+			TxErrors         float64 `json:"tx_errors"`
 			TxPackets        float64 `json:"tx_packets"`
 			UserNgRxBytes    float64 `json:"user-ng-rx_bytes"`
 			UserNgRxPackets  float64 `json:"user-ng-rx_packets"`
@@ -360,33 +758,91 @@ type device struct {
 			UserNgTxDropped  float64 `json:"user-ng-tx_dropped"`
 			UserNgTxPackets  float64 `json:"user-ng-tx_packets"`
 			UserRxBytes      float64 `json:"user-rx_bytes"`
+			UserRxDropped    float64 `json:"user-rx_dropped"`
+			UserRxErrors     float64 `json:"user-rx_errors"`
 			UserRxPackets    float64 `json:"user-rx_packets"`
 			UserTxBytes      float64 `json:"user-tx_bytes"`
 			UserTxDropped    float64 `json:"user-tx_dropped"`
+			UserTxErrors     float64 `json:"user-tx_errors"`
 			UserTxPackets    float64 `json:"user-tx_packets"`
 		}
 	} `json:"stat"`
 
 	Uplink struct {
 		RxBytes   float64 `json:"rx_bytes"`
+		RxDropped float64 `json:"rx_dropped"`
 		RxPackets float64 `json:"rx_packets"`
 		RxErrors  float64 `json:"rx_errors"`
 		TxBytes   float64 `json:"tx_bytes"`
+		TxDropped float64 `json:"tx_dropped"`
 		TxPackets float64 `json:"tx_packets"`
 		TxErrors  float64 `json:"tx_errors"`
 		Type      string  `json:"type"`
+
+		UplinkMAC        string `json:"uplink_mac"`
+		UplinkRemotePort int    `json:"uplink_remote_port"`
 	} `json:"uplink"`
 	State         int           `json:"state"`
 	TxBytes       float64       `json:"tx_bytes"`
 	Type          string        `json:"type"`
+	Upgradable    bool          `json:"upgradable"`
 	UplinkTable   []interface{} `json:"uplink_table"`
 	Uptime        int           `json:"uptime"`
+	UptimeStats   deviceUptime  `json:"uptime_stats"`
 	UserNumSta    int           `json:"user-num_sta"`
 	Version       string        `json:"version"`
 	VwireEnabled  bool          `json:"vwireEnabled"`
 	VwireTable    []interface{} `json:"vwire_table"`
+	WAN1          *deviceWAN    `json:"wan1"`
+	WAN2          *deviceWAN    `json:"wan2"`
 	WlangroupIDNg string        `json:"wlangroup_id_ng"`
 	XAuthkey      string        `json:"x_authkey"`
 	XFingerprint  string        `json:"x_fingerprint"`
 	XVwirekey     string        `json:"x_vwirekey"`
 }
+
+// A deviceWAN is the raw structure of a WAN within a device.
+type deviceWAN struct {
+	Ifname string `json:"ifname"`
+	IP     string `json:"ip"`
+	Up     bool   `json:"up"`
+}
+
+// A deviceUptime is the raw structure of the uptime statistics of each WAN
+// within a device.
+type deviceUptime map[string]deviceUptimeStats
+
+// deviceUptimeStats is the raw structure of the uptime statistics of a WAN
+// within a device.  Latencies are in milliseconds.
+type deviceUptimeStats struct {
+	Availability   float64 `json:"availability"`
+	LatencyAverage float64 `json:"latency_average"`
+	TimePeriod     int     `json:"time_period"`
+	Monitors       []struct {
+		Availability   float64 `json:"availability"`
+		LatencyAverage float64 `json:"latency_average"`
+		Target         string  `json:"target"`
+		Type           string  `json:"type"`
+	} `json:"monitors"`
+}
+
+// A portRef is the raw structure of a reference to another port, which the
+// UniFi Controller reports as false when there is none.
+type portRef int
+
+// UnmarshalJSON unmarshals a JSON port index or false into a portRef.
+func (r *portRef) UnmarshalJSON(b []byte) error {
+	var ok bool
+	if err := json.Unmarshal(b, &ok); err == nil {
+		*r = 0
+		return nil
+	}
+
+	var idx int
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return err
+	}
+
+	*r = portRef(idx)
+	return nil
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// DiscoveryAddr is the address to which Discover sends its probe by default:
+// the broadcast address of the local network, on the port of the Ubiquiti
+// discovery protocol.
+const DiscoveryAddr = "255.255.255.255:10001"
+
+// discoveryProbe is the request of version 1 of the Ubiquiti discovery
+// protocol, to which every UniFi device on the local network replies.
+var discoveryProbe = []byte{0x01, 0x00, 0x00, 0x00}
+
+// Types of the fields of a reply to discoveryProbe.
+const (
+	discoveryMAC      = 0x01
+	discoveryIPInfo   = 0x02
+	discoveryFirmware = 0x03
+	discoveryUptime   = 0x0a
+	discoveryHostname = 0x0b
+	discoveryPlatform = 0x0c
+	discoveryModel    = 0x14
+)
+
+// errDiscoveryReply is returned when a reply to discoveryProbe is malformed.
+var errDiscoveryReply = errors.New("malformed Ubiquiti discovery reply")
+
+// A DiscoveredDevice is a Ubiquiti device which replied to Discover.
+type DiscoveredDevice struct {
+	MAC      net.HardwareAddr
+	IP       net.IP
+	Hostname string
+	Platform string
+	Model    string
+	Firmware string
+	Uptime   time.Duration
+}
+
+// consolePlatforms are the prefixes of the platforms of UniFi OS consoles and
+// Cloud Keys, which run the UniFi Network application.
+var consolePlatforms = []string{"UCG", "UCK", "UDM", "UDR", "UDW", "UNVR", "UX"}
+
+// Console reports whether d is a UniFi OS console or Cloud Key, which runs a
+// UniFi Controller, rather than a device managed by one.
+func (d *DiscoveredDevice) Console() bool {
+	p := strings.ToUpper(d.Platform)
+	for _, cp := range consolePlatforms {
+		if strings.HasPrefix(p, cp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Discover finds Ubiquiti devices on the local network by sending a probe of
+// the Ubiquiti discovery protocol to addr, such as DiscoveryAddr, and
+// collecting replies until ctx is done.  Each device is returned once, in the
+// order in which it replied.  Software UniFi Controllers do not reply, only
+// UniFi OS consoles and the devices themselves.
+func Discover(ctx context.Context, addr string) ([]*DiscoveredDevice, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteTo(discoveryProbe, raddr); err != nil {
+		return nil, err
+	}
+
+	// Unblock ReadFrom once ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	var (
+		devices []*DiscoveredDevice
+		seen    = make(map[string]bool)
+		buf     = make([]byte, 1500)
+	)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return devices, nil
+			}
+
+			return nil, err
+		}
+
+		d, err := parseDiscoveryReply(buf[:n])
+		if err != nil {
+			// Ignore stray packets and devices which speak another
+			// version of the protocol
+			continue
+		}
+		if d.IP == nil {
+			if ua, ok := from.(*net.UDPAddr); ok {
+				d.IP = ua.IP
+			}
+		}
+
+		key := d.MAC.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		devices = append(devices, d)
+	}
+}
+
+// parseDiscoveryReply parses a reply to discoveryProbe: a header of the
+// protocol version, a command and the length of the payload, followed by a
+// payload of fields, each of a type, a length, and a value.
+func parseDiscoveryReply(b []byte) (*DiscoveredDevice, error) {
+	if len(b) < 4 || b[0] != 0x01 || b[1] != 0x00 {
+		return nil, errDiscoveryReply
+	}
+
+	payload := b[4:]
+	if int(binary.BigEndian.Uint16(b[2:4])) != len(payload) {
+		return nil, errDiscoveryReply
+	}
+
+	d := new(DiscoveredDevice)
+	for len(payload) > 0 {
+		if len(payload) < 3 {
+			return nil, errDiscoveryReply
+		}
+
+		typ, n := payload[0], int(binary.BigEndian.Uint16(payload[1:3]))
+		if len(payload) < 3+n {
+			return nil, errDiscoveryReply
+		}
+		v := payload[3 : 3+n]
+		payload = payload[3+n:]
+
+		switch typ {
+		case discoveryMAC:
+			if n == 6 {
+				d.MAC = net.HardwareAddr(append([]byte(nil), v...))
+			}
+		case discoveryIPInfo:
+			// A device with several interfaces reports each of their
+			// MAC and IPv4 addresses; the first is used
+			if n == 10 && d.IP == nil {
+				d.IP = net.IP(append([]byte(nil), v[6:]...))
+			}
+		case discoveryFirmware:
+			d.Firmware = string(v)
+		case discoveryUptime:
+			if n == 4 {
+				d.Uptime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+			}
+		case discoveryHostname:
+			d.Hostname = string(v)
+		case discoveryPlatform:
+			d.Platform = string(v)
+		case discoveryModel:
+			d.Model = string(v)
+		}
+	}
+
+	if d.MAC == nil {
+		return nil, errDiscoveryReply
+	}
+
+	return d, nil
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A discoveryField is a field of a reply to discoveryProbe.
+type discoveryField struct {
+	typ byte
+	v   []byte
+}
+
+// discoveryReply builds a reply to discoveryProbe from fields.
+func discoveryReply(fields []discoveryField) []byte {
+	var payload []byte
+	for _, f := range fields {
+		payload = append(payload, f.typ, byte(len(f.v)>>8), byte(len(f.v)))
+		payload = append(payload, f.v...)
+	}
+
+	b := []byte{0x01, 0x00, byte(len(payload) >> 8), byte(len(payload))}
+	return append(b, payload...)
+}
+
+func Test_parseDiscoveryReply(t *testing.T) {
+	uptime := make([]byte, 4)
+	binary.BigEndian.PutUint32(uptime, 3600)
+
+	var tests = []struct {
+		desc string
+		b    []byte
+		d    *DiscoveredDevice
+		ok   bool
+	}{
+		{
+			desc: "too short",
+			b:    []byte{0x01, 0x00},
+		},
+		{
+			desc: "another version of the protocol",
+			b:    []byte{0x02, 0x06, 0x00, 0x00},
+		},
+		{
+			desc: "payload shorter than its length",
+			b:    []byte{0x01, 0x00, 0x00, 0x09, discoveryMAC, 0x00, 0x06},
+		},
+		{
+			desc: "no MAC address",
+			b:    discoveryReply([]discoveryField{{discoveryHostname, []byte("udm")}}),
+		},
+		{
+			desc: "UniFi Dream Machine Pro",
+			b: discoveryReply([]discoveryField{
+				{discoveryMAC, []byte{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01}},
+				{discoveryIPInfo, []byte{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01, 192, 168, 1, 1}},
+				{discoveryIPInfo, []byte{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x02, 10, 0, 0, 1}},
+				{discoveryFirmware, []byte("UDMPRO.al324.v3.2.9")},
+				{discoveryUptime, uptime},
+				{discoveryHostname, []byte("udm")},
+				{discoveryPlatform, []byte("UDMPRO")},
+				{discoveryModel, []byte("UniFi Dream Machine Pro")},
+				{0x10, []byte{0xea, 0x15}},
+			}),
+			d: &DiscoveredDevice{
+				MAC:      net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01},
+				IP:       net.IP{192, 168, 1, 1},
+				Hostname: "udm",
+				Platform: "UDMPRO",
+				Model:    "UniFi Dream Machine Pro",
+				Firmware: "UDMPRO.al324.v3.2.9",
+				Uptime:   time.Hour,
+			},
+			ok: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		d, err := parseDiscoveryReply(tt.b)
+		if err != nil && tt.ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err == nil && !tt.ok {
+			t.Fatal("expected an error, but none occurred")
+		}
+
+		if want, got := tt.d, d; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected device:\n- want: %+v\n-  got: %+v", want, got)
+		}
+	}
+}
+
+func TestDiscoveredDeviceConsole(t *testing.T) {
+	var tests = []struct {
+		platform string
+		console  bool
+	}{
+		{platform: "UDMPRO", console: true},
+		{platform: "UCKG2", console: true},
+		{platform: "UDR", console: true},
+		{platform: "U7PG2"},
+		{platform: "US24P250"},
+		{platform: ""},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.platform)
+
+		d := &DiscoveredDevice{Platform: tt.platform}
+		if want, got := tt.console, d.Console(); want != got {
+			t.Fatalf("unexpected console:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	// Reply twice, as a device with several interfaces would, then send a
+	// stray packet.  The reply has no IP address, so the sender's is used.
+	go func() {
+		buf := make([]byte, 64)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil || !bytes.Equal(discoveryProbe, buf[:n]) {
+			return
+		}
+
+		reply := discoveryReply([]discoveryField{
+			{discoveryMAC, []byte{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01}},
+			{discoveryPlatform, []byte("UDR")},
+		})
+		for i := 0; i < 2; i++ {
+			_, _ = conn.WriteTo(reply, from)
+		}
+		_, _ = conn.WriteTo([]byte("garbage"), from)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	devices, err := Discover(ctx, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to discover devices: %v", err)
+	}
+
+	want := []*DiscoveredDevice{{
+		MAC:      net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01},
+		IP:       net.IPv4(127, 0, 0, 1).To4(),
+		Platform: "UDR",
+	}}
+	if got := devices; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected devices:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
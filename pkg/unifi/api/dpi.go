@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// StationDPI returns deep packet inspection (DPI) statistics for each Station
+// in a specified site name, broken down by application category.
+//
+// DPI must be enabled on the site's gateway for the UniFi Controller to
+// report any statistics.
+func (c *Client) StationDPI(ctx context.Context, siteName string) ([]*StationDPI, error) {
+	var v struct {
+		StationDPI []*StationDPI `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"POST",
+		fmt.Sprintf("/api/s/%s/stat/stadpi", siteName),
+		&dpiRequest{Type: "by_cat"},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.StationDPI, err
+}
+
+type dpiRequest struct {
+	Type string `json:"type"`
+}
+
+// A StationDPI contains DPI statistics for a single Station.
+type StationDPI struct {
+	MAC        net.HardwareAddr
+	Categories []*DPIStats
+}
+
+// DPIStats contains network activity statistics for a single DPI application
+// category.
+type DPIStats struct {
+	Category        string
+	ReceiveBytes    float64
+	ReceivePackets  float64
+	TransmitBytes   float64
+	TransmitPackets float64
+}
+
+// dpiCategories maps the numeric DPI category IDs reported by the UniFi
+// Controller to the names shown in its web interface.
+var dpiCategories = map[int]string{
+	0:   "Instant messaging",
+	1:   "P2P",
+	3:   "File Transfer",
+	4:   "Streaming Media",
+	5:   "Mail and Collaboration",
+	6:   "Voice over IP",
+	7:   "Database",
+	8:   "Games",
+	9:   "Network Management",
+	10:  "Remote Access Terminals",
+	11:  "Bypass Proxies and Tunnels",
+	12:  "Stock Market",
+	13:  "Web",
+	14:  "Security Update",
+	15:  "Web IM",
+	17:  "Business",
+	18:  "Network Protocols",
+	19:  "Network Protocols",
+	20:  "Network Protocols",
+	23:  "Private Protocol",
+	24:  "Social Network",
+	255: "Unknown",
+}
+
+// dpiCategory returns the human readable name of a DPI category, or its
+// numeric ID if the category is not known.
+func dpiCategory(id int) string {
+	if name, ok := dpiCategories[id]; ok {
+		return name
+	}
+
+	return strconv.Itoa(id)
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a StationDPI.
+func (s *StationDPI) UnmarshalJSON(b []byte) error {
+	var dpi stationDPI
+	if err := json.Unmarshal(b, &dpi); err != nil {
+		return err
+	}
+
+	mac, err := net.ParseMAC(dpi.MAC)
+	if err != nil {
+		return err
+	}
+
+	// Several category IDs share a name, so combine their statistics
+	// rather than reporting the same category more than once
+	byName := make(map[string]*DPIStats, len(dpi.ByCat))
+	cats := make([]*DPIStats, 0, len(dpi.ByCat))
+	for _, bc := range dpi.ByCat {
+		name := dpiCategory(bc.Cat)
+
+		st, ok := byName[name]
+		if !ok {
+			st = &DPIStats{Category: name}
+			byName[name] = st
+			cats = append(cats, st)
+		}
+
+		st.ReceiveBytes += bc.RxBytes
+		st.ReceivePackets += bc.RxPackets
+		st.TransmitBytes += bc.TxBytes
+		st.TransmitPackets += bc.TxPackets
+	}
+
+	*s = StationDPI{
+		MAC:        mac,
+		Categories: cats,
+	}
+
+	return nil
+}
+
+// A stationDPI is the raw structure of a StationDPI returned from the UniFi
+// Controller API.
+type stationDPI struct {
+	MAC   string `json:"mac"`
+	ByCat []struct {
+		Cat       int     `json:"cat"`
+		Apps      []int   `json:"apps"`
+		RxBytes   float64 `json:"rx_bytes"`
+		RxPackets float64 `json:"rx_packets"`
+		TxBytes   float64 `json:"tx_bytes"`
+		TxPackets float64 `json:"tx_packets"`
+	} `json:"by_cat"`
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrAuthFailed is returned when the UniFi Controller rejects the
+	// supplied credentials, or when a session has expired and could not be
+	// re-established.
+	ErrAuthFailed = errors.New("authentication with UniFi Controller failed")
+
+	// ErrTokenExpired is returned when a session established using a
+	// single two-factor authentication token has expired, and so cannot be
+	// re-established without a new token.
+	ErrTokenExpired = errors.New("UniFi Controller session expired and two-factor token cannot be reused")
+
+	// ErrForbidden is returned when the authenticated user does not have
+	// permission to perform a request.
+	ErrForbidden = errors.New("permission denied by UniFi Controller")
+
+	// ErrSiteNotFound is returned when a request refers to a site which does
+	// not exist, or which is not visible to the authenticated user.
+	ErrSiteNotFound = errors.New("site not found on UniFi Controller")
+
+	// ErrRateLimited is returned when the UniFi Controller, or a UniFi OS
+	// console, rejects a request because too many have been made.
+	ErrRateLimited = errors.New("rate limited by UniFi Controller")
+)
+
+// Error messages reported by the UniFi Controller in the meta field of an
+// unsuccessful response.
+const (
+	msgInvalid       = "api.err.Invalid"
+	msgLoginRequired = "api.err.LoginRequired"
+	msgNoPermission  = "api.err.NoPermission"
+	msgNoSiteContext = "api.err.NoSiteContext"
+)
+
+// statusError converts an unsuccessful HTTP response into one of the error
+// values defined by this package, if possible.  res.Body is read, so
+// statusError must only be called once the response is known to have failed.
+func statusError(res *http.Response, isJSON bool) error {
+	var msg string
+	if isJSON {
+		var v struct {
+			Meta struct {
+				Msg string `json:"msg"`
+			} `json:"meta"`
+		}
+
+		// The message is only used to refine the error, so a body which
+		// cannot be decoded is not itself an error
+		if err := json.NewDecoder(res.Body).Decode(&v); err == nil {
+			msg = v.Meta.Msg
+		}
+	}
+
+	switch msg {
+	case msgLoginRequired:
+		return ErrAuthFailed
+	case msgNoPermission:
+		return ErrForbidden
+	case msgNoSiteContext:
+		return ErrSiteNotFound
+	case msgInvalid:
+		// Classic controllers report bad credentials as invalid input
+		if res.Request != nil && isLoginEndpoint(res.Request.URL.Path) {
+			return ErrAuthFailed
+		}
+	}
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrAuthFailed
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+
+	if msg != "" {
+		return fmt.Errorf("unexpected HTTP status code: %d (%s)", res.StatusCode, msg)
+	}
+
+	return fmt.Errorf("unexpected HTTP status code: %d", res.StatusCode)
+}
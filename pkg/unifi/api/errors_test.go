@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientErrors(t *testing.T) {
+	var tests = []struct {
+		desc   string
+		path   string
+		status int
+		cType  string
+		body   string
+		err    error
+	}{
+		{
+			desc:   "login required",
+			path:   "/api/s/default/stat/sta",
+			status: http.StatusUnauthorized,
+			cType:  jsonContentType,
+			body:   `{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`,
+			err:    ErrAuthFailed,
+		},
+		{
+			desc:   "bad credentials on classic controller",
+			path:   "/api/login",
+			status: http.StatusBadRequest,
+			cType:  jsonContentType,
+			body:   `{"meta":{"rc":"error","msg":"api.err.Invalid"}}`,
+			err:    ErrAuthFailed,
+		},
+		{
+			desc:   "no permission",
+			path:   "/api/s/default/stat/sta",
+			status: http.StatusForbidden,
+			cType:  jsonContentType,
+			body:   `{"meta":{"rc":"error","msg":"api.err.NoPermission"}}`,
+			err:    ErrForbidden,
+		},
+		{
+			desc:   "unknown site",
+			path:   "/api/s/nope/stat/sta",
+			status: http.StatusBadRequest,
+			cType:  jsonContentType,
+			body:   `{"meta":{"rc":"error","msg":"api.err.NoSiteContext"}}`,
+			err:    ErrSiteNotFound,
+		},
+		{
+			desc:   "rate limited without JSON body",
+			path:   "/api/s/default/stat/sta",
+			status: http.StatusTooManyRequests,
+			cType:  "text/html",
+			body:   `<html>Too Many Requests</html>`,
+			err:    ErrRateLimited,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.cType)
+			w.WriteHeader(tt.status)
+			_, _ = w.Write([]byte(tt.body))
+		}))
+
+		c, err := NewClient(srv.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		req, err := c.newRequest(context.Background(), "GET", tt.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+
+		_, err = c.do(req, nil)
+		srv.Close()
+
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
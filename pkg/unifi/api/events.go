@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Events opens a stream of Events for a specified site name, delivered in
+// near-real-time over the UniFi Controller's WebSocket API.
+//
+// The stream remains open until ctx is done, the EventStream is closed, or
+// an error occurs; in all cases, EventStream.C is closed.
+func (c *Client) Events(ctx context.Context, siteName string) (*EventStream, error) {
+	if _, ok := c.client.Transport.(*replayTransport); ok {
+		return nil, errors.New("event streams cannot be replayed from recorded responses")
+	}
+
+	u, err := c.endpointURL(fmt.Sprintf("/wss/s/%s/events", siteName))
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	d := &websocket.Dialer{
+		Jar:              c.client.Jar,
+		HandshakeTimeout: c.client.Timeout,
+	}
+
+	// Reuse the proxy and TLS configuration of the HTTP client, so the
+	// WebSocket is subject to the same certificate verification
+	if t, ok := baseTransport(c.client.Transport); ok {
+		d.Proxy = t.Proxy
+		d.TLSClientConfig = t.TLSClientConfig
+	}
+
+	h := make(http.Header)
+	for k, v := range transportHeaders(c.client.Transport) {
+		h.Set(k, v)
+	}
+	h.Set("User-Agent", c.UserAgent)
+
+	c.mu.Lock()
+	if c.csrfToken != "" {
+		h.Set(csrfTokenHeader, c.csrfToken)
+	}
+	c.mu.Unlock()
+
+	conn, res, err := d.DialContext(ctx, u.String(), h)
+	if err != nil {
+		if res != nil {
+			return nil, fmt.Errorf("failed to open event stream: %v (HTTP status code: %d)", err, res.StatusCode)
+		}
+
+		return nil, fmt.Errorf("failed to open event stream: %v", err)
+	}
+
+	ch := make(chan *Event)
+	s := &EventStream{
+		C: ch,
+
+		conn: conn,
+		done: make(chan struct{}),
+	}
+
+	go s.read(ctx, ch)
+	return s, nil
+}
+
+// An EventStream is a stream of Events from the UniFi Controller.
+type EventStream struct {
+	// C delivers Events as they occur.  C is closed when the stream ends.
+	C <-chan *Event
+
+	conn *websocket.Conn
+
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Close closes the EventStream.
+func (s *EventStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+
+	return err
+}
+
+// Err returns the error which ended the EventStream, if any.  Err should only
+// be called once EventStream.C has been closed.
+func (s *EventStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// read reads messages from the WebSocket and delivers any events they
+// contain until the stream ends.
+func (s *EventStream) read(ctx context.Context, ch chan<- *Event) {
+	defer close(ch)
+	defer s.Close()
+
+	// Unblock ReadMessage when the stream is no longer wanted
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+		}
+		_ = s.Close()
+	}()
+
+	for {
+		_, b, err := s.conn.ReadMessage()
+		if err != nil {
+			s.setErr(ctx, err)
+			return
+		}
+
+		var msg struct {
+			Meta struct {
+				Message string `json:"message"`
+			} `json:"meta"`
+			Data []json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(b, &msg); err != nil {
+			s.setErr(ctx, err)
+			return
+		}
+
+		// Other messages, such as station and device synchronization, are
+		// also sent over the WebSocket, but are not events
+		if msg.Meta.Message != "events" {
+			continue
+		}
+
+		for _, raw := range msg.Data {
+			var e Event
+			if err := json.Unmarshal(raw, &e); err != nil {
+				s.setErr(ctx, err)
+				return
+			}
+
+			select {
+			case ch <- &e:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// setErr stores the error which ended the stream, unless the stream was
+// closed deliberately.
+func (s *EventStream) setErr(ctx context.Context, err error) {
+	select {
+	case <-s.done:
+		err = ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// An Event is an occurrence reported by the UniFi Controller, such as a
+// station roaming between access points or a device losing contact.
+//
+// Fields which do not apply to a given kind of Event are left empty.
+type Event struct {
+	ID        string
+	Key       string
+	Message   string
+	SiteID    string
+	Subsystem string
+	Time      time.Time
+
+	// Devices and stations involved in the Event.  MAC addresses are
+	// lowercase and colon-separated, as formatted by net.HardwareAddr.
+	// APFromMAC and APToMAC are the access points a station roamed between.
+	APMAC      string
+	APName     string
+	APFromMAC  string
+	APToMAC    string
+	SwitchMAC  string
+	GatewayMAC string
+	StationMAC string
+	Hostname   string
+
+	// Wireless details, for wireless events.
+	SSID        string
+	Radio       string
+	RadioFrom   string
+	RadioTo     string
+	Channel     string
+	ChannelFrom string
+	ChannelTo   string
+
+	// Admin and IP address, for administrative events.
+	Admin string
+	IP    string
+
+	// Raw is the raw JSON representation of the Event.
+	Raw json.RawMessage
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of an Event.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var ev event
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return err
+	}
+
+	raw := make(json.RawMessage, len(b))
+	copy(raw, b)
+
+	*e = Event{
+		ID:        ev.ID,
+		Key:       ev.Key,
+		Message:   ev.Msg,
+		SiteID:    ev.SiteID,
+		Subsystem: ev.Subsystem,
+		Time:      time.Unix(0, ev.Time*int64(time.Millisecond)),
+
+		APMAC:      normalizeMAC(ev.AP),
+		APName:     ev.APName,
+		APFromMAC:  normalizeMAC(ev.APFrom),
+		APToMAC:    normalizeMAC(ev.APTo),
+		SwitchMAC:  normalizeMAC(ev.SW),
+		GatewayMAC: normalizeMAC(ev.GW),
+		StationMAC: normalizeMAC(ev.User),
+		Hostname:   ev.Hostname,
+
+		SSID:        ev.SSID,
+		Radio:       ev.Radio,
+		RadioFrom:   ev.RadioFrom,
+		RadioTo:     ev.RadioTo,
+		Channel:     string(ev.Channel),
+		ChannelFrom: string(ev.ChannelFrom),
+		ChannelTo:   string(ev.ChannelTo),
+
+		Admin: ev.Admin,
+		IP:    ev.IP,
+
+		Raw: raw,
+	}
+
+	return nil
+}
+
+// An event is the raw structure of an Event returned from the UniFi
+// Controller API.
+type event struct {
+	ID        string `json:"_id"`
+	Key       string `json:"key"`
+	Msg       string `json:"msg"`
+	SiteID    string `json:"site_id"`
+	Subsystem string `json:"subsystem"`
+	Time      int64  `json:"time"`
+
+	AP       string `json:"ap"`
+	APName   string `json:"ap_name"`
+	APFrom   string `json:"ap_from"`
+	APTo     string `json:"ap_to"`
+	SW       string `json:"sw"`
+	GW       string `json:"gw"`
+	User     string `json:"user"`
+	Hostname string `json:"hostname"`
+
+	SSID        string       `json:"ssid"`
+	Radio       string       `json:"radio"`
+	RadioFrom   string       `json:"radio_from"`
+	RadioTo     string       `json:"radio_to"`
+	Channel     stringNumber `json:"channel"`
+	ChannelFrom stringNumber `json:"channel_from"`
+	ChannelTo   stringNumber `json:"channel_to"`
+
+	Admin string `json:"admin"`
+	IP    string `json:"ip"`
+}
+
+// A stringNumber is a value which the UniFi Controller may report as either
+// a JSON string or number, depending on its version.
+type stringNumber string
+
+// UnmarshalJSON unmarshals a JSON string or number into a stringNumber.
+func (s *stringNumber) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*s = stringNumber(str)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+
+	*s = stringNumber(n.String())
+	return nil
+}
+
+// normalizeMAC returns a MAC address reported by the UniFi Controller in
+// the same format as net.HardwareAddr, which labels every metric, so that
+// addresses from different API fields can be compared.  Values which are not
+// MAC addresses are only lowercased.
+func normalizeMAC(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return strings.ToLower(s)
+	}
+
+	return mac.String()
+}
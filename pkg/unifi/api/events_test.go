@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/wss/s/default/events", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatalf("failed to upgrade connection: %v", err)
+		}
+		defer conn.Close()
+
+		msgs := []string{
+			`{"meta":{"rc":"ok","message":"sta:sync"},"data":[{"mac":"de:ad:be:ef:de:ad"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_WU_Roam","subsystem":"wlan","time":1500000000000,"user":"DE-AD-BE-EF-DE-AD","ap_from":"F0:9F:C2:00:00:01","ap_to":"f0:9f:c2:00:00:02","channel_from":"1","channel_to":36}]}`,
+		}
+		for _, m := range msgs {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+				t.Fatalf("failed to write message: %v", err)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	s, err := c.Events(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	defer s.Close()
+
+	e, ok := <-s.C
+	if !ok {
+		t.Fatalf("event stream closed unexpectedly: %v", s.Err())
+	}
+
+	if want, got := "EVT_WU_Roam", e.Key; want != got {
+		t.Fatalf("unexpected event key:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := "de:ad:be:ef:de:ad", e.StationMAC; want != got {
+		t.Fatalf("unexpected station MAC:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := "f0:9f:c2:00:00:01/f0:9f:c2:00:00:02", e.APFromMAC+"/"+e.APToMAC; want != got {
+		t.Fatalf("unexpected access points:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := "1/36", e.ChannelFrom+"/"+e.ChannelTo; want != got {
+		t.Fatalf("unexpected channels:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := int64(1500000000), e.Time.Unix(); want != got {
+		t.Fatalf("unexpected time:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The server closes the connection after its messages are sent
+	if _, ok := <-s.C; ok {
+		t.Fatal("expected event stream to be closed")
+	}
+	if s.Err() == nil {
+		t.Fatal("expected an error after the server closed the connection")
+	}
+}
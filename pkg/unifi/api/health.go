@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// Health returns the health of each subsystem of a specified site name.
+func (c *Client) Health(ctx context.Context, siteName string) ([]*Health, error) {
+	var v struct {
+		Health []*Health `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"GET",
+		fmt.Sprintf("/api/s/%s/stat/health", siteName),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.Health, err
+}
+
+// Health is the health of one subsystem of a site, such as its WAN or its
+// wireless network.
+type Health struct {
+	Subsystem string // Such as "wan", "lan", "wlan" or "vpn"
+	Status    string // Such as "ok", "warning" or "unknown"
+
+	// WANIP and ISPName are the address and the name of the ISP of the
+	// active WAN of the site's gateway, and are only reported for the
+	// "wan" subsystem.
+	WANIP   net.IP
+	ISPName string
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a Health.
+func (h *Health) UnmarshalJSON(b []byte) error {
+	var hh health
+	if err := json.Unmarshal(b, &hh); err != nil {
+		return err
+	}
+
+	// A missing or unparseable address leaves WANIP nil, rather than
+	// failing the whole site
+	*h = Health{
+		Subsystem: hh.Subsystem,
+		Status:    hh.Status,
+		WANIP:     net.ParseIP(hh.WANIP),
+		ISPName:   hh.ISPName,
+	}
+
+	return nil
+}
+
+// A health is the raw structure of a Health returned from the UniFi
+// Controller API.
+type health struct {
+	Subsystem string `json:"subsystem"`
+	Status    string `json:"status"`
+	WANIP     string `json:"wan_ip"`
+	ISPName   string `json:"isp_name"`
+}
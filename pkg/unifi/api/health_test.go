@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientHealth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/s/default/stat/health" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{"data": [
+	{"subsystem": "wlan", "status": "ok", "num_ap": 2},
+	{"subsystem": "wan", "status": "ok", "wan_ip": "203.0.113.10", "isp_name": "Example ISP", "isp_organization": "Example"},
+	{"subsystem": "vpn", "status": "unknown", "wan_ip": ""}
+]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	health, err := c.Health(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("failed to retrieve health: %v", err)
+	}
+
+	want := []*Health{
+		{Subsystem: "wlan", Status: "ok"},
+		{Subsystem: "wan", Status: "ok", WANIP: net.ParseIP("203.0.113.10"), ISPName: "Example ISP"},
+		{Subsystem: "vpn", Status: "unknown"},
+	}
+	if got := health; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected health:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
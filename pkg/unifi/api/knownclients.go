@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// KnownClients returns every client which the UniFi Controller remembers for a
+// specified site name, whether or not it is connected now.
+func (c *Client) KnownClients(ctx context.Context, siteName string) ([]*KnownClient, error) {
+	var v struct {
+		KnownClients []*KnownClient `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"GET",
+		fmt.Sprintf("/api/s/%s/rest/user", siteName),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.KnownClients, err
+}
+
+// A KnownClient is a client which has connected to a site at some point, as
+// remembered by the UniFi Controller.  Unlike a Station, it carries no
+// statistics about a current connection.
+type KnownClient struct {
+	ID        string
+	Blocked   bool
+	FirstSeen time.Time // Zero if unknown
+	Hostname  string    // Device-provided name
+	IsGuest   bool
+	IsWired   bool
+	LastSeen  time.Time // Zero if unknown
+	MAC       net.HardwareAddr
+	Name      string // Unifi-set name
+	OUI       string
+	SiteID    string
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a KnownClient.
+func (k *KnownClient) UnmarshalJSON(b []byte) error {
+	var kc knownClient
+	if err := json.Unmarshal(b, &kc); err != nil {
+		return err
+	}
+
+	mac, err := net.ParseMAC(kc.MAC)
+	if err != nil {
+		return err
+	}
+
+	// Zero timestamps indicate the client's history predates the controller
+	// recording it
+	unix := func(v int64) time.Time {
+		if v == 0 {
+			return time.Time{}
+		}
+
+		return time.Unix(v, 0)
+	}
+
+	*k = KnownClient{
+		ID:        kc.ID,
+		Blocked:   kc.Blocked,
+		FirstSeen: unix(kc.FirstSeen),
+		Hostname:  kc.Hostname,
+		IsGuest:   kc.IsGuest,
+		IsWired:   kc.IsWired,
+		LastSeen:  unix(kc.LastSeen),
+		MAC:       mac,
+		Name:      kc.Name,
+		OUI:       kc.OUI,
+		SiteID:    kc.SiteID,
+	}
+
+	return nil
+}
+
+// A knownClient is the raw structure of a KnownClient returned from the UniFi
+// Controller API.
+type knownClient struct {
+	ID        string `json:"_id"`
+	Blocked   bool   `json:"blocked"`
+	FirstSeen int64  `json:"first_seen"`
+	Hostname  string `json:"hostname"`
+	IsGuest   bool   `json:"is_guest"`
+	IsWired   bool   `json:"is_wired"`
+	LastSeen  int64  `json:"last_seen"`
+	MAC       string `json:"mac"`
+	Name      string `json:"name"`
+	OUI       string `json:"oui"`
+	SiteID    string `json:"site_id"`
+}
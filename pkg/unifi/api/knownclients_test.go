@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientKnownClients(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/s/default/rest/user", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{"data": [
+	{
+		"_id": "user1",
+		"mac": "de:ad:be:ef:10:01",
+		"hostname": "laptop",
+		"name": "Alice's Laptop",
+		"oui": "Apple",
+		"first_seen": 1500000000,
+		"last_seen": 1500086400,
+		"site_id": "site1"
+	},
+	{
+		"_id": "user2",
+		"mac": "de:ad:be:ef:10:02",
+		"is_wired": true,
+		"blocked": true
+	}
+]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	kcs, err := c.KnownClients(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("failed to retrieve known clients: %v", err)
+	}
+
+	want := []*KnownClient{
+		{
+			ID:        "user1",
+			FirstSeen: time.Unix(1500000000, 0),
+			Hostname:  "laptop",
+			LastSeen:  time.Unix(1500086400, 0),
+			MAC:       net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0x10, 0x01},
+			Name:      "Alice's Laptop",
+			OUI:       "Apple",
+			SiteID:    "site1",
+		},
+		{
+			ID:      "user2",
+			Blocked: true,
+			IsWired: true,
+			MAC:     net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0x10, 0x02},
+		},
+	}
+	if got := kcs; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected known clients:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
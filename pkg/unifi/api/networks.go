@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// defaultVLAN is the VLAN of networks which are not tagged with a VLAN of
+// their own.
+const defaultVLAN = 1
+
+// Networks returns the networks configured for a specified site name.
+func (c *Client) Networks(ctx context.Context, siteName string) ([]*Network, error) {
+	var v struct {
+		Networks []*Network `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"GET",
+		fmt.Sprintf("/api/s/%s/rest/networkconf", siteName),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.Networks, err
+}
+
+// PortProfiles returns the switch port profiles configured for a specified
+// site name.
+func (c *Client) PortProfiles(ctx context.Context, siteName string) ([]*PortProfile, error) {
+	var v struct {
+		PortProfiles []*PortProfile `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"GET",
+		fmt.Sprintf("/api/s/%s/rest/portconf", siteName),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.PortProfiles, err
+}
+
+// A Network is a network configured in the UniFi Controller, such as a LAN
+// or a VLAN.
+type Network struct {
+	ID      string
+	Name    string
+	Purpose string // Such as "corporate", "guest" or "wan"
+
+	// VLAN is the VLAN ID of the network, which is 1 for a network which
+	// is not tagged with a VLAN of its own.
+	VLAN int
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a Network.
+func (n *Network) UnmarshalJSON(b []byte) error {
+	var nw network
+	if err := json.Unmarshal(b, &nw); err != nil {
+		return err
+	}
+
+	vlan := defaultVLAN
+	if nw.VLANEnabled && nw.VLAN != "" {
+		v, err := strconv.Atoi(string(nw.VLAN))
+		if err != nil {
+			return fmt.Errorf("invalid VLAN %q for network %q: %v", nw.VLAN, nw.Name, err)
+		}
+
+		vlan = v
+	}
+
+	*n = Network{
+		ID:      nw.ID,
+		Name:    nw.Name,
+		Purpose: nw.Purpose,
+		VLAN:    vlan,
+	}
+
+	return nil
+}
+
+// A network is the raw structure of a Network returned from the UniFi
+// Controller API.
+type network struct {
+	ID          string       `json:"_id"`
+	Name        string       `json:"name"`
+	Purpose     string       `json:"purpose"`
+	VLAN        stringNumber `json:"vlan"`
+	VLANEnabled bool         `json:"vlan_enabled"`
+}
+
+// A PortProfile is a switch port profile configured in the UniFi Controller,
+// which sets the networks of the ports it is applied to.
+type PortProfile struct {
+	ID   string
+	Name string
+
+	// NativeNetworkID is the ID of the Network of untagged traffic on
+	// ports with the profile, if any.
+	NativeNetworkID string
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a PortProfile.
+func (p *PortProfile) UnmarshalJSON(b []byte) error {
+	var pp portProfile
+	if err := json.Unmarshal(b, &pp); err != nil {
+		return err
+	}
+
+	*p = PortProfile{
+		ID:              pp.ID,
+		Name:            pp.Name,
+		NativeNetworkID: pp.NativeNetworkconfID,
+	}
+
+	return nil
+}
+
+// A portProfile is the raw structure of a PortProfile returned from the UniFi
+// Controller API.
+type portProfile struct {
+	ID                  string `json:"_id"`
+	Name                string `json:"name"`
+	NativeNetworkconfID string `json:"native_networkconf_id"`
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientNetworksAndPortProfiles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonContentType)
+
+		switch r.URL.Path {
+		case "/api/s/default/rest/networkconf":
+			_, _ = w.Write([]byte(`{"data": [
+	{"_id": "net1", "name": "LAN", "purpose": "corporate"},
+	{"_id": "net2", "name": "IoT", "purpose": "corporate", "vlan_enabled": true, "vlan": "20"},
+	{"_id": "net3", "name": "Guests", "purpose": "guest", "vlan_enabled": true, "vlan": 30},
+	{"_id": "net4", "name": "Disabled", "purpose": "corporate", "vlan_enabled": false, "vlan": 40}
+]}`))
+		case "/api/s/default/rest/portconf":
+			_, _ = w.Write([]byte(`{"data": [
+	{"_id": "pc1", "name": "All", "native_networkconf_id": "net1"},
+	{"_id": "pc2", "name": "Cameras", "native_networkconf_id": "net2"}
+]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	networks, err := c.Networks(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("failed to retrieve networks: %v", err)
+	}
+
+	wantNetworks := []*Network{
+		{ID: "net1", Name: "LAN", Purpose: "corporate", VLAN: 1},
+		{ID: "net2", Name: "IoT", Purpose: "corporate", VLAN: 20},
+		{ID: "net3", Name: "Guests", Purpose: "guest", VLAN: 30},
+		{ID: "net4", Name: "Disabled", Purpose: "corporate", VLAN: 1},
+	}
+	if want, got := wantNetworks, networks; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected networks:\n- want: %+v\n-  got: %+v", want, got)
+	}
+
+	profiles, err := c.PortProfiles(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("failed to retrieve port profiles: %v", err)
+	}
+
+	wantProfiles := []*PortProfile{
+		{ID: "pc1", Name: "All", NativeNetworkID: "net1"},
+		{ID: "pc2", Name: "Cameras", NativeNetworkID: "net2"},
+	}
+	if want, got := wantProfiles, profiles; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected port profiles:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
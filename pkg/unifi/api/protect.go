@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unifiOSProtectPrefix is the path prefix below which UniFi OS consoles serve
+// the UniFi Protect API.
+const unifiOSProtectPrefix = "/proxy/protect"
+
+// Protect returns the NVR and cameras managed by UniFi Protect.  UniFi Protect
+// only runs on UniFi OS consoles, and is not tied to any UniFi Network site.
+func (c *Client) Protect(ctx context.Context) (*Protect, error) {
+	req, err := c.newRequest(ctx, "GET", unifiOSProtectPrefix+"/api/bootstrap", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Protect
+	if _, err := c.do(req, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Protect is the state of UniFi Protect on a UniFi OS console.
+type Protect struct {
+	NVR     *NVR
+	Cameras []*Camera
+}
+
+// An NVR is the network video recorder of UniFi Protect, which stores the
+// recordings of its cameras.
+type NVR struct {
+	ID      string
+	Name    string
+	MAC     net.HardwareAddr
+	Version string
+
+	StorageBytes     float64
+	StorageUsedBytes float64
+
+	// Retention is how long recordings are kept, or zero if they are kept
+	// until storage is full.
+	Retention time.Duration
+}
+
+// A Camera is a camera managed by UniFi Protect.
+type Camera struct {
+	ID        string
+	Name      string
+	MAC       net.HardwareAddr
+	Type      string
+	Connected bool
+
+	Recording     bool
+	RecordingMode string
+
+	// RecordingStart and RecordingEnd are the times of the oldest and
+	// newest recordings stored by the NVR, if any.
+	RecordingStart time.Time
+	RecordingEnd   time.Time
+
+	Channels []*CameraChannel
+}
+
+// A CameraChannel is one of the video streams of a Camera, such as its high
+// or low quality stream.
+type CameraChannel struct {
+	Name    string
+	Enabled bool
+
+	// Bitrate is the configured bitrate of the stream, in bits per second.
+	Bitrate float64
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of Protect.
+func (p *Protect) UnmarshalJSON(b []byte) error {
+	var pb protectBootstrap
+	if err := json.Unmarshal(b, &pb); err != nil {
+		return err
+	}
+
+	nvr := &NVR{
+		ID:               pb.NVR.ID,
+		Name:             pb.NVR.Name,
+		MAC:              protectMAC(pb.NVR.MAC),
+		Version:          pb.NVR.Version,
+		StorageBytes:     pb.NVR.StorageInfo.TotalSize,
+		StorageUsedBytes: pb.NVR.StorageInfo.TotalSpaceUsed,
+	}
+
+	// Newer versions of UniFi Protect report storage with the console's
+	// system information instead
+	if nvr.StorageBytes == 0 {
+		nvr.StorageBytes = pb.NVR.SystemInfo.Storage.Size
+		nvr.StorageUsedBytes = pb.NVR.SystemInfo.Storage.Used
+	}
+
+	if ms, err := strconv.ParseFloat(string(pb.NVR.RecordingRetentionDurationMs), 64); err == nil {
+		nvr.Retention = time.Duration(ms) * time.Millisecond
+	}
+
+	cameras := make([]*Camera, 0, len(pb.Cameras))
+	for _, cam := range pb.Cameras {
+		channels := make([]*CameraChannel, 0, len(cam.Channels))
+		for _, ch := range cam.Channels {
+			channels = append(channels, &CameraChannel{
+				Name:    ch.Name,
+				Enabled: ch.Enabled,
+				Bitrate: ch.Bitrate,
+			})
+		}
+
+		c := &Camera{
+			ID:            cam.ID,
+			Name:          cam.Name,
+			MAC:           protectMAC(cam.MAC),
+			Type:          cam.Type,
+			Connected:     cam.IsConnected,
+			Recording:     cam.IsRecording,
+			RecordingMode: cam.RecordingSettings.Mode,
+			Channels:      channels,
+		}
+		if v := cam.Stats.Video; v.RecordingStart > 0 && v.RecordingEnd > 0 {
+			c.RecordingStart = time.Unix(0, v.RecordingStart*int64(time.Millisecond))
+			c.RecordingEnd = time.Unix(0, v.RecordingEnd*int64(time.Millisecond))
+		}
+
+		cameras = append(cameras, c)
+	}
+
+	*p = Protect{
+		NVR:     nvr,
+		Cameras: cameras,
+	}
+
+	return nil
+}
+
+// protectMAC parses a MAC address reported by UniFi Protect, which omits the
+// separators between octets, returning nil if it is not valid.
+func protectMAC(s string) net.HardwareAddr {
+	if len(s) == 12 && !strings.ContainsAny(s, ":-.") {
+		pairs := make([]string, 0, 6)
+		for i := 0; i < len(s); i += 2 {
+			pairs = append(pairs, s[i:i+2])
+		}
+		s = strings.Join(pairs, ":")
+	}
+
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil
+	}
+
+	return mac
+}
+
+// A protectBootstrap is the raw structure of Protect returned from the UniFi
+// Protect API.
+type protectBootstrap struct {
+	NVR struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		MAC         string `json:"mac"`
+		Version     string `json:"version"`
+		StorageInfo struct {
+			TotalSize      float64 `json:"totalSize"`
+			TotalSpaceUsed float64 `json:"totalSpaceUsed"`
+		} `json:"storageInfo"`
+		SystemInfo struct {
+			Storage struct {
+				Size float64 `json:"size"`
+				Used float64 `json:"used"`
+			} `json:"storage"`
+		} `json:"systemInfo"`
+		RecordingRetentionDurationMs stringNumber `json:"recordingRetentionDurationMs"`
+	} `json:"nvr"`
+	Cameras []struct {
+		ID                string `json:"id"`
+		Name              string `json:"name"`
+		MAC               string `json:"mac"`
+		Type              string `json:"type"`
+		IsConnected       bool   `json:"isConnected"`
+		IsRecording       bool   `json:"isRecording"`
+		RecordingSettings struct {
+			Mode string `json:"mode"`
+		} `json:"recordingSettings"`
+		Stats struct {
+			Video struct {
+				RecordingStart int64 `json:"recordingStart"`
+				RecordingEnd   int64 `json:"recordingEnd"`
+			} `json:"video"`
+		} `json:"stats"`
+		Channels []struct {
+			Name    string  `json:"name"`
+			Enabled bool    `json:"enabled"`
+			Bitrate float64 `json:"bitrate"`
+		} `json:"channels"`
+	} `json:"cameras"`
+}
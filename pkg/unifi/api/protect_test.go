@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientProtect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/proxy/protect/api/bootstrap", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{
+	"nvr": {
+		"id": "nvr1",
+		"name": "UNVR",
+		"mac": "F09FC2000001",
+		"version": "2.2.6",
+		"recordingRetentionDurationMs": "2592000000",
+		"systemInfo": {"storage": {"size": 4000000000000, "used": 1000000000000}}
+	},
+	"cameras": [
+		{
+			"id": "cam1",
+			"name": "Front Door",
+			"mac": "F09FC2000002",
+			"type": "UVC G4 Bullet",
+			"isConnected": true,
+			"isRecording": true,
+			"recordingSettings": {"mode": "always"},
+			"stats": {"video": {"recordingStart": 1500000000000, "recordingEnd": 1500086400000}},
+			"channels": [
+				{"name": "High", "enabled": true, "bitrate": 10000000},
+				{"name": "Low", "enabled": false, "bitrate": 300000}
+			]
+		},
+		{
+			"id": "cam2",
+			"name": "Garage",
+			"mac": "F09FC2000003",
+			"type": "UVC G3 Flex",
+			"recordingSettings": {"mode": "never"}
+		}
+	]
+}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	p, err := c.Protect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve UniFi Protect: %v", err)
+	}
+
+	want := &Protect{
+		NVR: &NVR{
+			ID:               "nvr1",
+			Name:             "UNVR",
+			MAC:              net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x01},
+			Version:          "2.2.6",
+			StorageBytes:     4000000000000,
+			StorageUsedBytes: 1000000000000,
+			Retention:        30 * 24 * time.Hour,
+		},
+		Cameras: []*Camera{
+			{
+				ID:             "cam1",
+				Name:           "Front Door",
+				MAC:            net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x02},
+				Type:           "UVC G4 Bullet",
+				Connected:      true,
+				Recording:      true,
+				RecordingMode:  "always",
+				RecordingStart: time.Unix(1500000000, 0),
+				RecordingEnd:   time.Unix(1500086400, 0),
+				Channels: []*CameraChannel{
+					{Name: "High", Enabled: true, Bitrate: 10000000},
+					{Name: "Low", Bitrate: 300000},
+				},
+			},
+			{
+				ID:            "cam2",
+				Name:          "Garage",
+				MAC:           net.HardwareAddr{0xf0, 0x9f, 0xc2, 0x00, 0x00, 0x03},
+				Type:          "UVC G3 Flex",
+				RecordingMode: "never",
+				Channels:      []*CameraChannel{},
+			},
+		},
+	}
+	if got := p; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected UniFi Protect:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
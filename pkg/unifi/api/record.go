@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A recording is a UniFi Controller response stored by a recordTransport.
+//
+// Only the status code, content type and body are stored, so that session
+// cookies and CSRF tokens are never written to disk.
+type recording struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Location    string `json:"location,omitempty"`
+	Body        string `json:"body"`
+}
+
+// A recordTransport is an http.RoundTripper which writes each response to a
+// directory, for later use by a replayTransport.
+type recordTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	b, err := json.MarshalIndent(recording{
+		StatusCode:  res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+		Location:    res.Header.Get("Location"),
+		Body:        string(body),
+	}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to record response: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(t.dir, recordingName(req)), b, 0644); err != nil {
+		return nil, fmt.Errorf("failed to record response: %v", err)
+	}
+
+	return res, nil
+}
+
+// A replayTransport is an http.RoundTripper which serves responses written
+// by a recordTransport, rather than contacting a UniFi Controller.
+type replayTransport struct {
+	dir string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(t.dir, recordingName(req)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL.Path)
+		}
+
+		return nil, err
+	}
+
+	var r recording
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("invalid recorded response for %s %s: %v", req.Method, req.URL.Path, err)
+	}
+
+	h := make(http.Header)
+	h.Set("Content-Type", r.ContentType)
+	if r.Location != "" {
+		h.Set("Location", r.Location)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
+
+// recordingName returns the file name of the recording for req.
+//
+// Request bodies are not part of the name, so that requests which vary only
+// in their parameters, such as the time range of a report, can be replayed;
+// the most recent response for a given method and path is kept.
+func recordingName(req *http.Request) string {
+	path := strings.Trim(req.URL.Path, "/")
+	if path == "" {
+		path = "root"
+	}
+
+	name := req.Method + "_" + strings.Replace(path, "/", "_", -1)
+	if q := req.URL.RawQuery; q != "" {
+		sum := sha256.Sum256([]byte(q))
+		name += "_" + hex.EncodeToString(sum[:4])
+	}
+
+	return name + ".json"
+}
+
+// baseTransport returns the *http.Transport underlying rt, if any.
+func baseTransport(rt http.RoundTripper) (*http.Transport, bool) {
+	switch t := rt.(type) {
+	case *http.Transport:
+		return t, true
+	case *recordTransport:
+		return baseTransport(t.next)
+	case *headerTransport:
+		return baseTransport(t.next)
+	case *resolveTransport:
+		return t.next, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api/apitest"
+)
+
+func TestClientRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unifi-record")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := apitest.NewServer()
+	addr := srv.URL
+
+	session := func(cfg HTTPClientConfig) []*Device {
+		c, err := NewClient(addr, NewHTTPClient(cfg))
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		ctx := context.Background()
+		if err := c.Login(ctx, apitest.Username, apitest.Password); err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+
+		devices, err := c.Devices(ctx, apitest.DefaultSite)
+		if err != nil {
+			t.Fatalf("failed to retrieve devices: %v", err)
+		}
+
+		return devices
+	}
+
+	recorded := session(HTTPClientConfig{RecordDir: dir})
+	srv.Close()
+
+	// Credentials are sent in requests, and must never be recorded
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("failed to list recordings: %v", err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read recording: %v", err)
+		}
+		if strings.Contains(string(b), apitest.Password) {
+			t.Fatalf("recording %q contains password", f)
+		}
+	}
+
+	replayed := session(HTTPClientConfig{ReplayDir: dir})
+
+	if want, got := recorded[0].Name, replayed[0].Name; want != got {
+		t.Fatalf("unexpected replayed device name:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	c, err := NewClient(addr, NewHTTPClient(HTTPClientConfig{ReplayDir: dir}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := c.Stations(context.Background(), apitest.DefaultSite); err == nil {
+		t.Fatal("expected an error for a request which was not recorded")
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A ReportInterval is the granularity of a site report.  The UniFi Controller
+// retains finer grained reports for a shorter period of time.
+type ReportInterval string
+
+// ReportInterval values supported by the UniFi Controller.
+const (
+	Report5Minutes ReportInterval = "5minutes"
+	ReportHourly   ReportInterval = "hourly"
+	ReportDaily    ReportInterval = "daily"
+)
+
+// reportAttributes are the attributes requested for each SiteReport.
+var reportAttributes = []string{
+	"time",
+	"bytes",
+	"wan-rx_bytes",
+	"wan-tx_bytes",
+	"wlan_bytes",
+	"num_sta",
+	"lan-num_sta",
+	"wlan-num_sta",
+}
+
+// SiteReport returns the aggregate SiteReports for a specified site name and
+// interval, for each interval between start and end.  These are the same
+// statistics shown on the UniFi Controller's dashboard.
+func (c *Client) SiteReport(ctx context.Context, siteName string, interval ReportInterval, start time.Time, end time.Time) ([]*SiteReport, error) {
+	var v struct {
+		Reports []*SiteReport `json:"data"`
+	}
+
+	req, err := c.newRequest(
+		ctx,
+		"POST",
+		fmt.Sprintf("/api/s/%s/stat/report/%s.site", siteName, interval),
+		&reportRequest{
+			Attributes: reportAttributes,
+			Start:      unixMilli(start),
+			End:        unixMilli(end),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.do(req, &v)
+	return v.Reports, err
+}
+
+type reportRequest struct {
+	Attributes []string `json:"attrs"`
+	Start      int64    `json:"start"`
+	End        int64    `json:"end"`
+}
+
+// unixMilli returns t as a number of milliseconds since the Unix epoch, as
+// expected by the UniFi Controller.
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// A SiteReport contains aggregate network activity statistics for a site over
+// a single interval.
+type SiteReport struct {
+	Time             time.Time
+	Bytes            float64
+	WANReceiveBytes  float64
+	WANTransmitBytes float64
+	WLANBytes        float64
+	Stations         float64
+	LANStations      float64
+	WLANStations     float64
+}
+
+// UnmarshalJSON unmarshals the raw JSON representation of a SiteReport.
+func (r *SiteReport) UnmarshalJSON(b []byte) error {
+	var sr siteReport
+	if err := json.Unmarshal(b, &sr); err != nil {
+		return err
+	}
+
+	*r = SiteReport{
+		Time:             time.Unix(0, sr.Time*int64(time.Millisecond)),
+		Bytes:            sr.Bytes,
+		WANReceiveBytes:  sr.WANRxBytes,
+		WANTransmitBytes: sr.WANTxBytes,
+		WLANBytes:        sr.WLANBytes,
+		Stations:         sr.NumSta,
+		LANStations:      sr.LANNumSta,
+		WLANStations:     sr.WLANNumSta,
+	}
+
+	return nil
+}
+
+// A siteReport is the raw structure of a SiteReport returned from the UniFi
+// Controller API.
+type siteReport struct {
+	Time       int64   `json:"time"`
+	Bytes      float64 `json:"bytes"`
+	WANRxBytes float64 `json:"wan-rx_bytes"`
+	WANTxBytes float64 `json:"wan-tx_bytes"`
+	WLANBytes  float64 `json:"wlan_bytes"`
+	NumSta     float64 `json:"num_sta"`
+	LANNumSta  float64 `json:"lan-num_sta"`
+	WLANNumSta float64 `json:"wlan-num_sta"`
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientSiteReport(t *testing.T) {
+	start, end := time.Unix(1500000000, 0), time.Unix(1500003600, 0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/s/default/stat/report/hourly.site", r.URL.Path; want != got {
+			t.Fatalf("unexpected request path:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var req reportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if want, got := int64(1500000000000), req.Start; want != got {
+			t.Fatalf("unexpected start:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := int64(1500003600000), req.End; want != got {
+			t.Fatalf("unexpected end:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_, _ = w.Write([]byte(`{"data":[{"o":"site","oid":"abc","time":1500000000000,"bytes":300.5,"wan-rx_bytes":200,"wan-tx_bytes":100,"wlan_bytes":50,"num_sta":3,"lan-num_sta":1,"wlan-num_sta":2}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	reports, err := c.SiteReport(context.Background(), "default", ReportHourly, start, end)
+	if err != nil {
+		t.Fatalf("failed to retrieve site report: %v", err)
+	}
+
+	want := []*SiteReport{{
+		Time:             start,
+		Bytes:            300.5,
+		WANReceiveBytes:  200,
+		WANTransmitBytes: 100,
+		WLANBytes:        50,
+		Stations:         3,
+		LANStations:      1,
+		WLANStations:     2,
+	}}
+	if got := reports; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected site reports:\n- want: %+v\n-  got: %+v", want[0], got[0])
+	}
+}
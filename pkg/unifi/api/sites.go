@@ -1,5 +1,7 @@
 package api
 
+import "context"
+
 // A Site is a physical location with UniFi devices managed by a UniFi
 // Controller.
 type Site struct {
@@ -12,12 +14,13 @@ type Site struct {
 }
 
 // Sites returns all of the Sites managed by a UniFi Controller.
-func (c *Client) Sites() ([]*Site, error) {
+func (c *Client) Sites(ctx context.Context) ([]*Site, error) {
 	var v struct {
 		Sites []*Site `json:"data"`
 	}
 
 	req, err := c.newRequest(
+		ctx,
 		"GET",
 		"/api/self/sites",
 		nil,
@@ -1,22 +1,109 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 )
 
+const (
+	// clientsPageSize is the number of Stations requested at a time by
+	// Clients.
+	clientsPageSize = 500
+)
+
 // Stations returns all of the Stations for a specified site name.
-func (c *Client) Stations(siteName string) ([]*Station, error) {
+//
+// Stations is equivalent to Clients.
+func (c *Client) Stations(ctx context.Context, siteName string) ([]*Station, error) {
+	return c.Clients(ctx, siteName)
+}
+
+// Clients returns all of the clients (Stations) connected to a specified site
+// name.
+//
+// Large sites are retrieved in pages, so that the UniFi Controller does not
+// truncate the response.  Controllers which do not support paging return all
+// of their Stations at once, and duplicate Stations are discarded.
+func (c *Client) Clients(ctx context.Context, siteName string) ([]*Station, error) {
+	raws, err := c.RawClients(ctx, siteName)
+	if err != nil {
+		return nil, err
+	}
+
+	stations := make([]*Station, 0, len(raws))
+	for _, raw := range raws {
+		s := new(Station)
+		if err := json.Unmarshal(raw, s); err != nil {
+			return nil, err
+		}
+
+		stations = append(stations, s)
+	}
+
+	return stations, nil
+}
+
+// RawClients returns the clients connected to a specified site name as they
+// were received from the UniFi Controller, retrieving them in pages as Clients
+// does.  Like Raw, RawClients is intended for debugging.
+func (c *Client) RawClients(ctx context.Context, siteName string) ([]json.RawMessage, error) {
+	var (
+		raws []json.RawMessage
+		seen = make(map[string]struct{})
+	)
+
+	for start := 0; ; start += clientsPageSize {
+		page, err := c.clientsPage(ctx, siteName, start)
+		if err != nil {
+			return nil, err
+		}
+
+		var added int
+		for _, raw := range page {
+			var v struct {
+				MAC string `json:"mac"`
+			}
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, err
+			}
+
+			mac := strings.ToLower(v.MAC)
+			if _, ok := seen[mac]; ok {
+				continue
+			}
+
+			seen[mac] = struct{}{}
+			raws = append(raws, raw)
+			added++
+		}
+
+		// A short page is the last one.  A page larger than requested, or
+		// containing only Stations already seen, means the controller
+		// ignored the paging parameters and returned everything.
+		if len(page) != clientsPageSize || added == 0 {
+			return raws, nil
+		}
+	}
+}
+
+// clientsPage retrieves a single page of Stations, beginning at offset start.
+func (c *Client) clientsPage(ctx context.Context, siteName string, start int) ([]json.RawMessage, error) {
 	var v struct {
-		Stations []*Station `json:"data"`
+		Stations []json.RawMessage `json:"data"`
 	}
 
 	req, err := c.newRequest(
-		"GET",
+		ctx,
+		"POST",
 		fmt.Sprintf("/api/s/%s/stat/sta", siteName),
-		nil,
+		&clientsRequest{
+			Start: start,
+			Limit: clientsPageSize,
+		},
 	)
 	if err != nil {
 		return nil, err
@@ -26,6 +113,11 @@ func (c *Client) Stations(siteName string) ([]*Station, error) {
 	return v.Stations, err
 }
 
+type clientsRequest struct {
+	Start int `json:"_start"`
+	Limit int `json:"_limit"`
+}
+
 // A Station is a client connected to a UniFi access point.
 type Station struct {
 	ID              string
@@ -33,6 +125,7 @@ type Station struct {
 	AssociationTime time.Time
 	Channel         int
 	FirstSeen       time.Time
+	Fingerprint     *StationFingerprint
 	Hostname        string // Device-provided name
 	IdleTime        time.Duration
 	IP              net.IP
@@ -42,13 +135,27 @@ type Station struct {
 	RoamCount       int
 	Name            string // Unifi-set name
 	Noise           int
-	RSSI            int
+	Radio           string // Band of a wireless station, such as ng or na
+	RSSI            int    // Signal strength above the noise floor
+	Satisfaction    int    // Experience score from 0 to 100, or -1 if unknown
+	Signal          int    // Signal strength in dBm
 	SiteID          string
 	Stats           *StationStats
 	Uptime          time.Duration
 	UserID          string
 }
 
+// A StationFingerprint identifies the kind of device a station is, as
+// determined by the UniFi Controller's device fingerprinting.  Each field but
+// OUI is an ID in the controller's fingerprint database, or zero if unknown.
+type StationFingerprint struct {
+	OUI            string // Vendor registered for the MAC address prefix
+	DeviceCategory int
+	DeviceVendor   int
+	DeviceID       int
+	OSName         int
+}
+
 // StationStats contains station network activity statistics.
 type StationStats struct {
 	ReceiveBytes    int64
@@ -77,6 +184,13 @@ func (s *Station) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	// Controllers report -1, or omit the score, for clients which have not
+	// been connected long enough to be scored
+	satisfaction := -1
+	if sta.Satisfaction != nil && *sta.Satisfaction >= 0 {
+		satisfaction = *sta.Satisfaction
+	}
+
 	*s = Station{
 		ID:              sta.ID,
 		APMAC:           apMAC,
@@ -91,8 +205,11 @@ func (s *Station) UnmarshalJSON(b []byte) error {
 		MAC:             mac,
 		Name:            sta.Name,
 		Noise:           sta.Noise,
+		Radio:           sta.Radio,
 		RSSI:            sta.RSSI,
 		RoamCount:       sta.RoamCount,
+		Satisfaction:    satisfaction,
+		Signal:          sta.Signal,
 		SiteID:          sta.SiteID,
 		Stats: &StationStats{
 			ReceiveBytes:    sta.RxBytes,
@@ -103,6 +220,13 @@ func (s *Station) UnmarshalJSON(b []byte) error {
 			TransmitPower:   sta.TxPower,
 			TransmitRate:    sta.TxRate,
 		},
+		Fingerprint: &StationFingerprint{
+			OUI:            sta.Oui,
+			DeviceCategory: sta.DevCat,
+			DeviceVendor:   sta.DevVendor,
+			DeviceID:       sta.DevID,
+			OSName:         sta.OsName,
+		},
 		Uptime: time.Duration(time.Duration(sta.Uptime) * time.Second),
 		UserID: sta.UserID,
 	}
@@ -125,6 +249,9 @@ type station struct {
 	BytesR           int64  `json:"bytes-r"`
 	Ccq              int    `json:"ccq"`
 	Channel          int    `json:"channel"`
+	DevCat           int    `json:"dev_cat"`
+	DevID            int    `json:"dev_id"`
+	DevVendor        int    `json:"dev_vendor"`
 	Essid            string `json:"essid"`
 	FirstSeen        int    `json:"first_seen"`
 	Hostname         string `json:"hostname"`
@@ -136,6 +263,7 @@ type station struct {
 	Mac              string `json:"mac"`
 	Name             string `json:"name"`
 	Noise            int    `json:"noise"`
+	OsName           int    `json:"os_name"`
 	Oui              string `json:"oui"`
 	PowersaveEnabled bool   `json:"powersave_enabled"`
 	QosPolicyApplied bool   `json:"qos_policy_applied"`
@@ -147,6 +275,7 @@ type station struct {
 	RxBytesR         int64  `json:"rx_bytes-r"`
 	RxPackets        int64  `json:"rx_packets"`
 	RxRate           int    `json:"rx_rate"`
+	Satisfaction     *int   `json:"satisfaction"`
 	Signal           int    `json:"signal"`
 	SiteID           string `json:"site_id"`
 	TxBytes          int64  `json:"tx_bytes"`
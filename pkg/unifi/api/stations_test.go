@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientClients(t *testing.T) {
+	var tests = []struct {
+		desc     string
+		total    int
+		paging   bool
+		requests int
+	}{
+		{
+			desc:     "single short page",
+			total:    3,
+			paging:   true,
+			requests: 1,
+		},
+		{
+			desc:     "multiple pages",
+			total:    clientsPageSize*2 + 1,
+			paging:   true,
+			requests: 3,
+		},
+		{
+			desc:     "exact multiple of page size",
+			total:    clientsPageSize,
+			paging:   true,
+			requests: 2,
+		},
+		{
+			desc:     "paging not supported",
+			total:    clientsPageSize + 1,
+			requests: 1,
+		},
+		{
+			desc:     "paging ignored with exactly one page",
+			total:    clientsPageSize,
+			requests: 2,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			var req clientsRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+
+			start, end := req.Start, req.Start+req.Limit
+			if !tt.paging {
+				start, end = 0, tt.total
+			}
+			if end > tt.total {
+				end = tt.total
+			}
+
+			stations := make([]string, 0, end-start)
+			for j := start; j < end; j++ {
+				stations = append(stations, fmt.Sprintf(`{"is_wired":true,"mac":"de:ad:be:ef:%02x:%02x"}`, j>>8, j&0xff))
+			}
+
+			w.Header().Set("Content-Type", jsonContentType)
+			_, _ = w.Write([]byte(`{"data":[` + strings.Join(stations, ",") + `]}`))
+		}))
+
+		c, err := NewClient(srv.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		stations, err := c.Clients(context.Background(), "default")
+		if err != nil {
+			srv.Close()
+			t.Fatalf("failed to retrieve clients: %v", err)
+		}
+
+		if want, got := tt.total, len(stations); want != got {
+			t.Fatalf("unexpected number of clients:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.requests, requests; want != got {
+			t.Fatalf("unexpected number of requests:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Raw clients, as dumped for bug reports, are paged alike
+		raws, err := c.RawClients(context.Background(), "default")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("failed to retrieve raw clients: %v", err)
+		}
+
+		if want, got := tt.total, len(raws); want != got {
+			t.Fatalf("unexpected number of raw clients:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, truncated to six digits
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	var tests = []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] time: %d", i, tt.unix)
+
+		code, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want, got := tt.code, code; want != got {
+			t.Fatalf("unexpected code:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", time.Unix(0, 0)); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
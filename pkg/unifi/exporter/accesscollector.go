@@ -0,0 +1,241 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// An AccessSource retrieves the doors of UniFi Access, and the attempts made
+// to open them.  An *api.AccessClient is an AccessSource.
+type AccessSource interface {
+	Doors(ctx context.Context) ([]*api.Door, error)
+	DoorOpenings(ctx context.Context, since time.Time, until time.Time) ([]*api.DoorOpening, error)
+}
+
+// Verify that the AccessClient implements the AccessSource interface.
+var _ AccessSource = &api.AccessClient{}
+
+// errNoAccess is returned by an AccessCollector which was enabled without an
+// AccessSource.
+var errNoAccess = errors.New("no UniFi Access API is configured")
+
+// An AccessCollector is a Prometheus collector for metrics regarding the doors
+// managed by UniFi Access.
+//
+// UniFi Access only reports attempts to open a door in its log, so an
+// AccessCollector counts the attempts logged since the previous scrape, and
+// must outlive any one scrape.  Like UniFi Protect, UniFi Access is not tied
+// to any UniFi Network site, so its metrics have no site label.
+type AccessCollector struct {
+	DoorOpen          *prometheus.Desc
+	DoorLocked        *prometheus.Desc
+	DoorOpeningsTotal *prometheus.Desc
+
+	c AccessSource
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+
+	mu sync.Mutex
+
+	// since is the time of the oldest log entry not yet counted, and
+	// openings the number counted so far for each door and result.
+	since    time.Time
+	openings map[doorOpening]float64
+}
+
+// A doorOpening identifies the count of attempts to open a door with a result.
+type doorOpening struct {
+	door   string
+	result string
+}
+
+// accessResults maps the results of api.DoorOpenings to the values of the
+// result label.
+var accessResults = map[string]string{
+	api.DoorOpeningGranted: "granted",
+	api.DoorOpeningDenied:  "denied",
+}
+
+// Verify that the AccessCollector implements the collector interface.
+var _ collector = &AccessCollector{}
+
+// NewAccessCollector creates a new AccessCollector which collects metrics
+// from c.  Only attempts to open a door made after the AccessCollector is
+// created are counted.
+func NewAccessCollector(c AccessSource) *AccessCollector {
+	return newAccessCollector(namespace, c)
+}
+
+// newAccessCollector is like NewAccessCollector, but names its metrics within
+// namespace ns.
+func newAccessCollector(ns string, c AccessSource) *AccessCollector {
+	const (
+		subsystem = "access"
+	)
+
+	var (
+		labelsDoor = []string{"id", "name"}
+	)
+
+	return &AccessCollector{
+		DoorOpen: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "door_open"),
+			"Whether the position sensor of a UniFi Access door reports it as open",
+			labelsDoor,
+			nil,
+		),
+
+		DoorLocked: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "door_locked"),
+			"Whether the lock of a UniFi Access door is locked",
+			labelsDoor,
+			nil,
+		),
+
+		DoorOpeningsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "door_openings_total"),
+			"Number of attempts to open a UniFi Access door since the exporter started, by whether access was granted or denied",
+			append(append([]string(nil), labelsDoor...), "result"),
+			nil,
+		),
+
+		c: c,
+
+		since:    time.Now().Truncate(time.Second),
+		openings: make(map[doorOpening]float64),
+	}
+}
+
+// collect begins a metrics collection task for all metrics related to UniFi
+// Access.
+func (c *AccessCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	if c.c == nil {
+		return c.DoorOpen, errNoAccess
+	}
+
+	doors, err := c.c.Doors(ctx)
+	if err != nil {
+		return c.DoorOpen, err
+	}
+
+	if err := c.countOpenings(ctx); err != nil {
+		return c.DoorOpeningsTotal, err
+	}
+
+	c.collectDoors(ch, doors)
+	return nil, nil
+}
+
+// countOpenings counts the attempts to open a door logged since the previous
+// call.  The log is only queried up to the last whole second, so that attempts
+// logged during the current second are counted once, by the next call.
+func (c *AccessCollector) countOpenings(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until := time.Now().Truncate(time.Second).Add(-time.Second)
+	if until.Before(c.since) {
+		return nil
+	}
+
+	openings, err := c.c.DoorOpenings(ctx, c.since, until)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range openings {
+		if result, ok := accessResults[o.Result]; ok && o.DoorID != "" {
+			c.openings[doorOpening{door: o.DoorID, result: result}]++
+		}
+	}
+	c.since = until.Add(time.Second)
+
+	return nil
+}
+
+// collectDoors collects the state of each door, and the number of attempts
+// to open it.
+func (c *AccessCollector) collectDoors(ch chan<- prometheus.Metric, doors []*api.Door) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, d := range doors {
+		labels := []string{
+			d.ID,
+			d.Name,
+		}
+
+		// A door without a hub has no sensor or lock to report
+		if d.Bound {
+			var open, locked float64
+			if d.Open {
+				open = 1
+			}
+			if d.Locked {
+				locked = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.DoorOpen,
+				prometheus.GaugeValue,
+				open,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.DoorLocked,
+				prometheus.GaugeValue,
+				locked,
+				labels...,
+			)
+		}
+
+		for _, result := range accessResults {
+			ch <- prometheus.MustNewConstMetric(
+				c.DoorOpeningsTotal,
+				prometheus.CounterValue,
+				c.openings[doorOpening{door: d.ID, result: result}],
+				append(labels, result)...,
+			)
+		}
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *AccessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.DoorOpen,
+		c.DoorLocked,
+		c.DoorOpeningsTotal,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect is the same as CollectError, but ignores any errors which occur.
+// Collect exists to satisfy the prometheus.Collector interface.
+func (c *AccessCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.CollectError(context.Background(), ch)
+}
+
+// CollectError sends the metric values for each metric pertaining to UniFi
+// Access over to the provided prometheus Metric channel, returning any errors
+// which occur.  Requests to UniFi Access are cancelled when ctx is done.
+// Errors are logged and returned, but are not sent over ch.
+func (c *AccessCollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting UniFi Access metric %v: %v", desc, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// A fakeAccess is an AccessSource which returns fixed doors and attempts to
+// open them.
+type fakeAccess struct {
+	doors    []*api.Door
+	openings []*api.DoorOpening
+}
+
+func (f *fakeAccess) Doors(_ context.Context) ([]*api.Door, error) {
+	return f.doors, nil
+}
+
+func (f *fakeAccess) DoorOpenings(_ context.Context, _ time.Time, _ time.Time) ([]*api.DoorOpening, error) {
+	return f.openings, nil
+}
+
+func TestAccessCollector(t *testing.T) {
+	src := &fakeAccess{
+		doors: []*api.Door{
+			{ID: "door1", Name: "Front Door", Open: true, Bound: true},
+			{ID: "door2", Name: "Back Door", Locked: true},
+		},
+		openings: []*api.DoorOpening{
+			{DoorID: "door1", Result: api.DoorOpeningGranted},
+			{DoorID: "door1", Result: api.DoorOpeningGranted},
+			{DoorID: "door2", Result: api.DoorOpeningDenied},
+			{Result: api.DoorOpeningGranted},
+		},
+	}
+
+	collector := NewAccessCollector(src)
+
+	// Attempts logged before the collector was created are not counted, so
+	// start from an earlier time
+	collector.since = time.Now().Add(-time.Minute)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_access_door_open{id="door1",name="Front Door"} 1`),
+		regexp.MustCompile(`unifi_access_door_locked{id="door1",name="Front Door"} 0`),
+		regexp.MustCompile(`unifi_access_door_openings_total{id="door1",name="Front Door",result="granted"} 2`),
+		regexp.MustCompile(`unifi_access_door_openings_total{id="door1",name="Front Door",result="denied"} 0`),
+		regexp.MustCompile(`unifi_access_door_openings_total{id="door2",name="Back Door",result="denied"} 1`),
+	}
+	nomatch := []*regexp.Regexp{
+		// Doors without a hub report no state
+		regexp.MustCompile(`unifi_access_door_open{id="door2"`),
+		regexp.MustCompile(`unifi_access_door_locked{id="door2"`),
+	}
+
+	out := testCollector(t, collector)
+
+	for i, m := range matches {
+		t.Logf("[%02d] match: %s", i, m.String())
+
+		if !m.Match(out) {
+			fmt.Println(string(out))
+			t.Fatal("output failed to match regex.")
+		}
+	}
+
+	for i, m := range nomatch {
+		t.Logf("[%02d] no match: %s", i, m.String())
+
+		if m.Match(out) {
+			fmt.Println(string(out))
+			t.Fatal("output unexpectedly matched regex.")
+		}
+	}
+
+}
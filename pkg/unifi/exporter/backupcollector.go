@@ -0,0 +1,183 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A BackupSource lists the automatic backups of a UniFi Controller.  An
+// *api.Client is a BackupSource.
+type BackupSource interface {
+	Backups(ctx context.Context, siteName string) ([]*api.Backup, error)
+}
+
+// Verify that the Client implements the BackupSource interface.
+var _ BackupSource = &api.Client{}
+
+// errNoBackups is returned by a BackupCollector whose api.Controller cannot
+// list backups.
+var errNoBackups = errors.New("UniFi controller does not support listing backups")
+
+// errNoBackupSite is returned by a BackupCollector which has no site through
+// which to list backups.
+var errNoBackupSite = errors.New("no site through which to list backups")
+
+// A BackupCollector is a Prometheus collector for metrics regarding the
+// automatic backups of a UniFi Controller.
+//
+// Backups are of the whole UniFi Controller, so its metrics have no site
+// label, and are listed through the first of its sites.
+type BackupCollector struct {
+	Backups             *prometheus.Desc
+	LastBackupTimestamp *prometheus.Desc
+	LastBackupSizeBytes *prometheus.Desc
+	LastBackupInfo      *prometheus.Desc
+
+	c     BackupSource
+	sites []*api.Site
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+}
+
+// Verify that the BackupCollector implements the collector interface.
+var _ collector = &BackupCollector{}
+
+// NewBackupCollector creates a new BackupCollector which collects metrics
+// about the backups of c, listed through the first of sites.
+func NewBackupCollector(c BackupSource, sites []*api.Site) *BackupCollector {
+	return newBackupCollector(namespace, c, sites)
+}
+
+// newBackupCollector is like NewBackupCollector, but names its metrics within
+// namespace ns.
+func newBackupCollector(ns string, c BackupSource, sites []*api.Site) *BackupCollector {
+	const (
+		subsystem = "backup"
+	)
+
+	return &BackupCollector{
+		Backups: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "count"),
+			"Number of automatic backups kept by the UniFi Controller",
+			nil,
+			nil,
+		),
+
+		LastBackupTimestamp: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "last_timestamp_seconds"),
+			"Unix time at which the most recent automatic backup of the UniFi Controller was made",
+			nil,
+			nil,
+		),
+
+		LastBackupSizeBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "last_size_bytes"),
+			"Size of the most recent automatic backup of the UniFi Controller in bytes",
+			nil,
+			nil,
+		),
+
+		LastBackupInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "last_info"),
+			"Information about the most recent automatic backup of the UniFi Controller, with a constant value of 1",
+			[]string{"version"},
+			nil,
+		),
+
+		c:     c,
+		sites: sites,
+	}
+}
+
+// collect begins a metrics collection task for all metrics related to the
+// backups of the UniFi Controller.
+func (c *BackupCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	if c.c == nil {
+		return c.Backups, errNoBackups
+	}
+
+	if len(c.sites) == 0 {
+		return c.Backups, errNoBackupSite
+	}
+
+	backups, err := c.c.Backups(ctx, c.sites[0].Name)
+	if err != nil {
+		return c.Backups, err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.Backups,
+		prometheus.GaugeValue,
+		float64(len(backups)),
+	)
+
+	// The UniFi Controller does not document the order of its backups
+	var last *api.Backup
+	for _, b := range backups {
+		if last == nil || b.Time.After(last.Time) {
+			last = b
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.LastBackupTimestamp,
+		prometheus.GaugeValue,
+		float64(last.Time.Unix()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.LastBackupSizeBytes,
+		prometheus.GaugeValue,
+		last.Size,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.LastBackupInfo,
+		prometheus.GaugeValue,
+		1,
+		last.Version,
+	)
+
+	return nil, nil
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *BackupCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.Backups,
+		c.LastBackupTimestamp,
+		c.LastBackupSizeBytes,
+		c.LastBackupInfo,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect is the same as CollectError, but ignores any errors which occur.
+// Collect exists to satisfy the prometheus.Collector interface.
+func (c *BackupCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.CollectError(context.Background(), ch)
+}
+
+// CollectError sends the metric values for each metric pertaining to the
+// backups of the UniFi Controller over to the provided prometheus Metric
+// channel, returning any errors which occur.  Requests to the UniFi Controller
+// are cancelled when ctx is done.  Errors are logged and returned, but are not
+// sent over ch.
+func (c *BackupCollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting UniFi backup metric %v: %v", desc, err)
+		return err
+	}
+
+	return nil
+}
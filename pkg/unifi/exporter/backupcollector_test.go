@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+func TestBackupCollector(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		input   string
+		matches []*regexp.Regexp
+		nomatch []*regexp.Regexp
+	}{
+		{
+			desc: "latest of several backups",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{"filename": "autobackup_7.4.162_20230801_0000_1690848000000.unf", "size": 2000000, "time": 1690848000000, "version": "7.4.162"},
+		{"filename": "autobackup_7.4.162_20230731_0000_1690761600000.unf", "size": 1000000, "time": 1690761600000, "version": "7.4.162"},
+		{"filename": "autobackup_7.3.83_20230730_0000_1690675200000.unf", "size": 900000, "time": 1690675200000, "version": "7.3.83"}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_backup_count 3`),
+				regexp.MustCompile(`unifi_backup_last_timestamp_seconds 1.690848e\+09`),
+				regexp.MustCompile(`unifi_backup_last_size_bytes 2e\+06`),
+				regexp.MustCompile(`unifi_backup_last_info{version="7.4.162"} 1`),
+			},
+		},
+		{
+			desc:  "no backups",
+			input: `{"data": []}`,
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_backup_count 0`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_backup_last_`),
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		out := testBackupCollector(t, []byte(tt.input))
+
+		for j, m := range tt.matches {
+			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
+
+			if !m.Match(out) {
+				fmt.Println(string(out))
+				t.Fatal("\toutput failed to match regex.")
+			}
+		}
+
+		for j, m := range tt.nomatch {
+			t.Logf("\t[%02d:%02d] no match: %s", i, j, m.String())
+
+			if m.Match(out) {
+				fmt.Println(string(out))
+				t.Fatal("\toutput unexpectedly matched regex.")
+			}
+		}
+	}
+}
+
+func testBackupCollector(t *testing.T, input []byte) []byte {
+	c, done := testUniFiClient(t, input)
+	defer done()
+
+	return testCollector(t, NewBackupCollector(c, []*api.Site{{Name: "default"}}))
+}
@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A deduper forwards metrics to a channel, dropping any metric with the same
+// descriptor and label values as one already forwarded.  Devices or stations
+// which the UniFi Controller reports with identical names and addresses would
+// otherwise produce duplicate samples, which fail the entire scrape.
+type deduper struct {
+	in     chan prometheus.Metric
+	out    chan<- prometheus.Metric
+	logger *log.Logger
+
+	seen    map[string]struct{}
+	dropped map[string]int
+	wg      sync.WaitGroup
+}
+
+// newDeduper creates a deduper which forwards metrics sent on its in channel
+// to out, until close is called.
+func newDeduper(out chan<- prometheus.Metric, logger *log.Logger) *deduper {
+	d := &deduper{
+		in:      make(chan prometheus.Metric),
+		out:     out,
+		logger:  logger,
+		seen:    make(map[string]struct{}),
+		dropped: make(map[string]int),
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return d
+}
+
+// run forwards metrics until the in channel is closed.
+func (d *deduper) run() {
+	defer d.wg.Done()
+
+	var m dto.Metric
+	for metric := range d.in {
+		m.Reset()
+		if err := metric.Write(&m); err != nil {
+			// Leave invalid metrics for the registry to report
+			d.out <- metric
+			continue
+		}
+
+		pairs := make([]string, 0, len(m.Label))
+		for _, lp := range m.Label {
+			pairs = append(pairs, lp.GetName()+"="+lp.GetValue())
+		}
+		key := metric.Desc().String() + "\x00" + strings.Join(pairs, "\x00")
+
+		if _, ok := d.seen[key]; ok {
+			d.dropped[metric.Desc().String()]++
+			continue
+		}
+		d.seen[key] = struct{}{}
+
+		d.out <- metric
+	}
+}
+
+// close waits for all metrics to be forwarded, and logs a warning for each
+// metric of which duplicates were dropped.
+func (d *deduper) close() {
+	close(d.in)
+	d.wg.Wait()
+
+	descs := make([]string, 0, len(d.dropped))
+	for desc := range d.dropped {
+		descs = append(descs, desc)
+	}
+	sort.Strings(descs)
+
+	for _, desc := range descs {
+		logf(d.logger, "[WARN] dropped %d duplicate sample(s) of metric %s", d.dropped[desc], desc)
+	}
+}
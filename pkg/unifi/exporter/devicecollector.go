@@ -1,32 +1,130 @@
 package exporter
 
 import (
+	"context"
 	"log"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// A PortConfigSource retrieves the networks and switch port profiles of a
+// site, which name the native VLAN and port profile of each port.  An
+// *api.Client is a PortConfigSource.
+type PortConfigSource interface {
+	Networks(ctx context.Context, siteName string) ([]*api.Network, error)
+	PortProfiles(ctx context.Context, siteName string) ([]*api.PortProfile, error)
+}
+
+// Verify that the Client implements the PortConfigSource interface.
+var _ PortConfigSource = &api.Client{}
+
+// A WANHealthSource retrieves the health of a site, which names the ISP of
+// the active WAN of its gateway.  An *api.Client is a WANHealthSource.
+type WANHealthSource interface {
+	Health(ctx context.Context, siteName string) ([]*api.Health, error)
+}
+
+// Verify that the Client implements the WANHealthSource interface.
+var _ WANHealthSource = &api.Client{}
+
 // A DeviceCollector is a Prometheus collector for metrics regarding Ubiquiti
 // UniFi devices.
 type DeviceCollector struct {
 	Devices          *prometheus.Desc
 	AdoptedDevices   *prometheus.Desc
 	UnadoptedDevices *prometheus.Desc
+	PendingAdoption  *prometheus.Desc
+	PendingInfo      *prometheus.Desc
+	DevicesByType    *prometheus.Desc
+	DevicesByModel   *prometheus.Desc
+	DevicesByState   *prometheus.Desc
+	DevicesByVersion *prometheus.Desc
+	Upgrading        *prometheus.Desc
+	Upgradable       *prometheus.Desc
 
 	UptimeSecondsTotal *prometheus.Desc
+	UptimeSeconds      *prometheus.Desc
+	BootTimeSeconds    *prometheus.Desc
+	LastSeenSeconds    *prometheus.Desc
+	State              *prometheus.Desc
 
 	ReceivedBytesTotal      *prometheus.Desc
 	TransmittedBytesTotal   *prometheus.Desc
 	ReceivedPacketsTotal    *prometheus.Desc
 	TransmittedPacketsTotal *prometheus.Desc
+	ReceivedDroppedTotal    *prometheus.Desc
 	TransmittedDroppedTotal *prometheus.Desc
+	ReceiveErrorsTotal      *prometheus.Desc
+	TransmitErrorsTotal     *prometheus.Desc
+
+	PortReceivedBytesTotal      *prometheus.Desc
+	PortTransmittedBytesTotal   *prometheus.Desc
+	PortReceivedPacketsTotal    *prometheus.Desc
+	PortTransmittedPacketsTotal *prometheus.Desc
+	PortReceivedDroppedTotal    *prometheus.Desc
+	PortTransmittedDroppedTotal *prometheus.Desc
+	PortReceivedBroadcastTotal  *prometheus.Desc
+	PortReceivedMulticastTotal  *prometheus.Desc
+	PortSTPState                *prometheus.Desc
+	PortInfo                    *prometheus.Desc
+	PortSpeedBitsPerSecond      *prometheus.Desc
+	PortFullDuplex              *prometheus.Desc
+
+	MACTableEntries *prometheus.Desc
+
+	LAGUp          *prometheus.Desc
+	LAGMembers     *prometheus.Desc
+	LAGMembersDown *prometheus.Desc
 
 	Stations *prometheus.Desc
 
-	c     *api.Client
+	UplinkInfo *prometheus.Desc
+
+	WANInfo                     *prometheus.Desc
+	WANIPChangesTotal           *prometheus.Desc
+	WANAvailabilityRatio        *prometheus.Desc
+	WANLatencySeconds           *prometheus.Desc
+	WANPeriodSeconds            *prometheus.Desc
+	WANMonitorAvailabilityRatio *prometheus.Desc
+	WANMonitorLatencySeconds    *prometheus.Desc
+
+	c     api.Controller
 	sites []*api.Site
+
+	// portConfig, if set, resolves the native VLAN and port profile of each
+	// port; otherwise, both are exported as empty labels.
+	portConfig PortConfigSource
+
+	// wanHealth, if set, resolves the ISP of each WAN; otherwise, it is
+	// exported as an empty label.
+	wanHealth WANHealthSource
+
+	// concurrency is the number of sites from which devices are retrieved
+	// at once; zero retrieves them one site at a time.
+	concurrency int
+
+	// siteLabel is the source of the site label, such as SiteLabelName.
+	siteLabel string
+
+	// skipDisconnected excludes devices which are disconnected, or have
+	// missed their heartbeats, from every per-device metric but State.
+	skipDisconnected bool
+
+	// wanIPs counts changes of WAN addresses across scrapes; the Exporter
+	// shares one between the collectors it sets up.
+	wanIPs *wanTracker
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+
+	// now is used to compute the time since a device was last seen;
+	// swappable for tests.
+	now func() time.Time
 }
 
 // Verify that the Exporter implements the collector interface.
@@ -34,120 +132,491 @@ var _ collector = &DeviceCollector{}
 
 // NewDeviceCollector creates a new DeviceCollector which collects metrics for
 // a specified site.
-func NewDeviceCollector(c *api.Client, sites []*api.Site) *DeviceCollector {
+func NewDeviceCollector(c api.Controller, sites []*api.Site) *DeviceCollector {
+	return newDeviceCollector(namespace, c, sites)
+}
+
+// newDeviceCollector is like NewDeviceCollector, but names its metrics within namespace
+// ns.
+func newDeviceCollector(ns string, c api.Controller, sites []*api.Site) *DeviceCollector {
 	const (
 		subsystem = "devices"
 	)
 
 	var (
 		labelsSiteOnly       = []string{"site"}
+		labelsSiteType       = []string{"site", "type"}
+		labelsSiteModel      = []string{"site", "model"}
+		labelsSiteState      = []string{"site", "state"}
+		labelsSiteVersion    = []string{"site", "model", "version"}
+		labelsPendingInfo    = []string{"site", "mac", "model", "type", "ip"}
 		labelsUptime         = []string{"site", "id", "mac", "name"}
+		labelsState          = []string{"site", "id", "mac", "name", "state"}
 		labelsDevice         = []string{"site", "id", "mac", "name", "connection"}
+		labelsDevicePort     = []string{"site", "id", "mac", "name", "port", "interface"}
+		labelsDevicePortSTP  = []string{"site", "id", "mac", "name", "port", "interface", "state"}
+		labelsDevicePortInfo = []string{"site", "id", "mac", "name", "port", "interface", "native_vlan", "port_profile", "poe_mode"}
+		labelsDeviceMACTable = []string{"site", "id", "mac", "name", "vlan"}
+		labelsDeviceLAG      = []string{"site", "id", "mac", "name", "lag"}
 		labelsDeviceStations = []string{"site", "id", "mac", "name", "interface", "radio", "user_type"}
+		labelsUplinkInfo     = []string{"site", "device_mac", "uplink_mac", "uplink_port", "uplink_type"}
+		labelsWAN            = []string{"site", "id", "mac", "name", "wan"}
+		labelsWANInfo        = []string{"site", "id", "mac", "name", "wan", "interface", "ip", "isp"}
+		labelsWANMonitor     = []string{"site", "id", "mac", "name", "wan", "target", "type"}
 	)
 
 	return &DeviceCollector{
 		Devices: prometheus.NewDesc(
 			// Subsystem is used as name so we get "unifi_devices"
-			prometheus.BuildFQName(namespace, "", subsystem),
+			prometheus.BuildFQName(ns, "", subsystem),
 			"Total number of devices",
 			labelsSiteOnly,
 			nil,
 		),
 
 		AdoptedDevices: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "adopted"),
+			prometheus.BuildFQName(ns, subsystem, "adopted"),
 			"Number of devices which are adopted",
 			labelsSiteOnly,
 			nil,
 		),
 
 		UnadoptedDevices: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "unadopted"),
+			prometheus.BuildFQName(ns, subsystem, "unadopted"),
 			"Number of devices which are not adopted",
 			labelsSiteOnly,
 			nil,
 		),
 
+		PendingAdoption: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "pending_adoption"),
+			"Number of devices which the controller has found and which are waiting to be adopted",
+			labelsSiteOnly,
+			nil,
+		),
+
+		PendingInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "pending_adoption_info"),
+			"Information about each device which is waiting to be adopted, with a constant value of 1",
+			labelsPendingInfo,
+			nil,
+		),
+
+		DevicesByType: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "by_type"),
+			"Number of devices of each device type",
+			labelsSiteType,
+			nil,
+		),
+
+		DevicesByModel: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "by_model"),
+			"Number of devices of each device model",
+			labelsSiteModel,
+			nil,
+		),
+
+		DevicesByState: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "by_state"),
+			"Number of devices in each state, such as connected or provisioning",
+			labelsSiteState,
+			nil,
+		),
+
+		DevicesByVersion: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "by_version"),
+			"Number of devices of each device model running each firmware version",
+			labelsSiteVersion,
+			nil,
+		),
+
+		Upgrading: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "upgrading"),
+			"Number of devices which are upgrading their firmware",
+			labelsSiteOnly,
+			nil,
+		),
+
+		Upgradable: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "upgradable"),
+			"Number of devices for which the controller has newer firmware",
+			labelsSiteOnly,
+			nil,
+		),
+
 		UptimeSecondsTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "uptime_seconds_total"),
-			"Device uptime in seconds",
+			prometheus.BuildFQName(ns, subsystem, "uptime_seconds_total"),
+			"Device uptime in seconds; deprecated, as it resets when the device restarts, so use uptime_seconds or boot_time_seconds instead",
+			labelsUptime,
+			nil,
+		),
+
+		UptimeSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "uptime_seconds"),
+			"Number of seconds since the device last started",
+			labelsUptime,
+			nil,
+		),
+
+		BootTimeSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "boot_time_seconds"),
+			"Unix timestamp at which the device last started",
+			labelsUptime,
+			nil,
+		),
+
+		LastSeenSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "last_seen_seconds"),
+			"Number of seconds since the device last checked in with the controller",
 			labelsUptime,
 			nil,
 		),
 
+		State: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "state"),
+			"State of the device as reported by the controller, such as connected or disconnected, with a constant value of 1",
+			labelsState,
+			nil,
+		),
+
 		ReceivedBytesTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "received_bytes_total"),
+			prometheus.BuildFQName(ns, subsystem, "received_bytes_total"),
 			"Number of bytes received by devices",
 			labelsDevice,
 			nil,
 		),
 
 		TransmittedBytesTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "transmitted_bytes_total"),
+			prometheus.BuildFQName(ns, subsystem, "transmitted_bytes_total"),
 			"Number of bytes transmitted by devices",
 			labelsDevice,
 			nil,
 		),
 
 		ReceivedPacketsTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "received_packets_total"),
+			prometheus.BuildFQName(ns, subsystem, "received_packets_total"),
 			"Number of packets received by devices",
 			labelsDevice,
 			nil,
 		),
 
 		TransmittedPacketsTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "transmitted_packets_total"),
+			prometheus.BuildFQName(ns, subsystem, "transmitted_packets_total"),
 			"Number of packets transmitted by devices",
 			labelsDevice,
 			nil,
 		),
 
+		ReceivedDroppedTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "received_packets_dropped_total"),
+			"Number of packets which are dropped on receipt by devices",
+			labelsDevice,
+			nil,
+		),
+
 		TransmittedDroppedTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "transmitted_packets_dropped_total"),
+			prometheus.BuildFQName(ns, subsystem, "transmitted_packets_dropped_total"),
 			"Number of packets which are dropped on transmission by devices",
 			labelsDevice,
 			nil,
 		),
 
+		ReceiveErrorsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "receive_errors_total"),
+			"Number of errors while receiving packets on devices",
+			labelsDevice,
+			nil,
+		),
+
+		TransmitErrorsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "transmit_errors_total"),
+			"Number of errors while transmitting packets on devices",
+			labelsDevice,
+			nil,
+		),
+
+		PortReceivedBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_received_bytes_total"),
+			"Number of bytes received by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortTransmittedBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_transmitted_bytes_total"),
+			"Number of bytes transmitted by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortReceivedPacketsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_received_packets_total"),
+			"Number of packets received by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortTransmittedPacketsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_transmitted_packets_total"),
+			"Number of packets transmitted by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortReceivedDroppedTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_received_packets_dropped_total"),
+			"Number of packets which are dropped on receipt by each wired port of devices, including those dropped by storm control",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortTransmittedDroppedTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_transmitted_packets_dropped_total"),
+			"Number of packets which are dropped on transmission by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortReceivedBroadcastTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_received_broadcast_packets_total"),
+			"Number of broadcast packets received by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortReceivedMulticastTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_received_multicast_packets_total"),
+			"Number of multicast packets received by each wired port of devices",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortSTPState: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_stp_state"),
+			"Spanning tree state of each wired port of devices, such as forwarding or blocking, with a constant value of 1",
+			labelsDevicePortSTP,
+			nil,
+		),
+
+		PortInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_info"),
+			"Configuration of each wired port of devices, with a constant value of 1",
+			labelsDevicePortInfo,
+			nil,
+		),
+
+		PortSpeedBitsPerSecond: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_speed_bits_per_second"),
+			"Negotiated link speed of each wired port of devices which is up",
+			labelsDevicePort,
+			nil,
+		),
+
+		PortFullDuplex: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "port_full_duplex"),
+			"Whether each wired port of devices which is up negotiated full duplex (1) or half duplex (0)",
+			labelsDevicePort,
+			nil,
+		),
+
+		MACTableEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "mac_table_entries"),
+			"Number of addresses in the MAC address table of switches which report one, by VLAN",
+			labelsDeviceMACTable,
+			nil,
+		),
+
+		LAGUp: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "lag_up"),
+			"Whether each link aggregation group of devices has any member port up (1) or none (0)",
+			labelsDeviceLAG,
+			nil,
+		),
+
+		LAGMembers: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "lag_members"),
+			"Number of member ports of each link aggregation group of devices",
+			labelsDeviceLAG,
+			nil,
+		),
+
+		LAGMembersDown: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "lag_members_down"),
+			"Number of member ports of each link aggregation group of devices which are down",
+			labelsDeviceLAG,
+			nil,
+		),
+
 		Stations: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "stations"),
+			prometheus.BuildFQName(ns, subsystem, "stations"),
 			"Total number of stations (clients) connected to devices",
 			labelsDeviceStations,
 			nil,
 		),
 
-		c:     c,
-		sites: sites,
+		UplinkInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "uplink_info"),
+			"Information about the upstream device and port a device is connected to",
+			labelsUplinkInfo,
+			nil,
+		),
+
+		WANInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_info"),
+			"Information about the WAN interfaces of gateways, with a constant value of 1",
+			labelsWANInfo,
+			nil,
+		),
+
+		WANIPChangesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_ip_changes_total"),
+			"Number of times the IP address of each WAN interface of gateways has changed since the exporter started",
+			labelsWAN,
+			nil,
+		),
+
+		WANAvailabilityRatio: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_availability_ratio"),
+			"Availability of each WAN of gateways, as monitored by the controller over its reporting period",
+			labelsWAN,
+			nil,
+		),
+
+		WANLatencySeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_latency_seconds"),
+			"Average latency of each WAN of gateways, as monitored by the controller over its reporting period",
+			labelsWAN,
+			nil,
+		),
+
+		WANPeriodSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_period_seconds"),
+			"Length of the controller's reporting period over which the availability and latency of each WAN of gateways are monitored",
+			labelsWAN,
+			nil,
+		),
+
+		WANMonitorAvailabilityRatio: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_monitor_availability_ratio"),
+			"Availability of each monitoring target over each WAN of gateways",
+			labelsWANMonitor,
+			nil,
+		),
+
+		WANMonitorLatencySeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "wan_monitor_latency_seconds"),
+			"Average latency to each monitoring target over each WAN of gateways",
+			labelsWANMonitor,
+			nil,
+		),
+
+		c:      c,
+		sites:  sites,
+		wanIPs: newWANTracker(),
+		now:    time.Now,
 	}
 }
 
 // collect begins a metrics collection task for all metrics related to UniFi
 // devices.
-func (c *DeviceCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	for _, s := range c.sites {
-		devices, err := c.c.Devices(s.Name)
+func (c *DeviceCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	err := forEachSite(ctx, c.sites, c.concurrency, func(ctx context.Context, s *api.Site) error {
+		devices, err := c.c.Devices(ctx, s.Name)
 		if err != nil {
-			return c.Devices, err
+			return err
 		}
 
+		site := siteLabel(c.siteLabel, s)
+
 		ch <- prometheus.MustNewConstMetric(
 			c.Devices,
 			prometheus.GaugeValue,
 			float64(len(devices)),
-			s.Description,
+			site,
 		)
 
-		c.collectDeviceAdoptions(ch, s.Description, devices)
-		c.collectDeviceUptime(ch, s.Description, devices)
-		c.collectDeviceBytes(ch, s.Description, devices)
-		c.collectDeviceStations(ch, s.Description, devices)
+		c.collectDeviceAdoptions(ch, site, devices)
+		c.collectDevicePendingAdoptions(ch, site, devices)
+		c.collectDeviceCounts(ch, site, devices)
+
+		identified := c.identifiedDevices(site, devices)
+		c.collectDeviceState(ch, site, identified)
+		if c.skipDisconnected {
+			identified = connectedDevices(identified)
+		}
+
+		c.collectDeviceUptime(ch, site, identified)
+		c.collectDeviceLastSeen(ch, site, identified)
+		c.collectDeviceBytes(ch, site, identified)
+		// Ports are still exported if their configuration cannot be
+		// resolved, but without the info metric, whose labels would be
+		// wrong
+		pc := &portConfig{}
+		if c.portConfig != nil {
+			pc, err = newPortConfig(ctx, c.portConfig, s.Name)
+			if err != nil {
+				logf(c.logger, "[WARN] failed to retrieve port configuration for site %q: %v", site, err)
+			}
+		}
+
+		c.collectDevicePorts(ch, site, identified, pc)
+		c.collectDeviceMACTables(ch, site, identified)
+		c.collectDeviceLAGs(ch, site, identified)
+		c.collectDeviceStations(ch, site, identified)
+		c.collectDeviceUplinks(ch, site, identified)
+		// Likewise, WANs are still exported if their ISPs cannot be
+		// resolved, but without the info metric
+		isps := map[string]string{}
+		if c.wanHealth != nil && hasWANs(identified) {
+			isps, err = wanISPs(ctx, c.wanHealth, s.Name)
+			if err != nil {
+				logf(c.logger, "[WARN] failed to retrieve WAN health for site %q: %v", site, err)
+			}
+		}
+
+		c.collectDeviceWANs(ch, site, identified, isps)
+		return nil
+	})
+	if err != nil {
+		return c.Devices, err
 	}
 
 	return nil, nil
 }
 
+// identifiedDevices returns the devices which report a MAC address.  Per-device
+// metrics are labeled by MAC address, so other devices, such as some which are
+// still being adopted, are logged and skipped rather than exported without one.
+func (c *DeviceCollector) identifiedDevices(siteLabel string, devices []*api.Device) []*api.Device {
+	identified := make([]*api.Device, 0, len(devices))
+	for _, d := range devices {
+		if len(d.MAC) == 0 {
+			logf(c.logger, "[WARN] skipping device %q (%s) in site %q with no MAC address", d.Name, d.ID, siteLabel)
+			continue
+		}
+
+		identified = append(identified, d)
+	}
+
+	return identified
+}
+
+// connectedDevices returns the devices which are not disconnected and have not
+// missed their heartbeats, and whose counters are therefore current.
+func connectedDevices(devices []*api.Device) []*api.Device {
+	connected := make([]*api.Device, 0, len(devices))
+	for _, d := range devices {
+		switch d.State {
+		case api.DeviceStateDisconnected, api.DeviceStateHeartbeatMissed:
+			continue
+		}
+
+		connected = append(connected, d)
+	}
+
+	return connected
+}
+
 // collectDeviceAdoptions collects counts for number of adopted and unadopted
 // UniFi devices.
 func (c *DeviceCollector) collectDeviceAdoptions(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
@@ -176,99 +645,601 @@ func (c *DeviceCollector) collectDeviceAdoptions(ch chan<- prometheus.Metric, si
 	)
 }
 
-// collectDeviceUptime collects device uptime for UniFi devices.
-func (c *DeviceCollector) collectDeviceUptime(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+// collectDevicePendingAdoptions collects the number of UniFi devices which are
+// waiting to be adopted, and information about each of them.  Unlike
+// unadopted devices, these exclude devices which are being adopted or whose
+// adoption failed.
+func (c *DeviceCollector) collectDevicePendingAdoptions(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	var pending int
 	for _, d := range devices {
-		labels := []string{
-			siteLabel,
-			d.ID,
-			d.NICs[0].MAC.String(),
-			d.Name,
+		if d.Adopted || d.State != api.DeviceStatePending {
+			continue
+		}
+		pending++
+
+		// Devices without a MAC address cannot be told apart
+		if len(d.MAC) == 0 {
+			continue
+		}
+
+		ip := d.IP
+		if ip == nil {
+			ip = d.InformIP
 		}
 
 		ch <- prometheus.MustNewConstMetric(
-			c.UptimeSecondsTotal,
-			prometheus.CounterValue,
-			float64(d.Uptime/time.Second),
-			labels...,
+			c.PendingInfo,
+			prometheus.GaugeValue,
+			1,
+			siteLabel,
+			d.MAC.String(),
+			d.Model,
+			d.Type,
+			ip.String(),
 		)
 	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.PendingAdoption,
+		prometheus.GaugeValue,
+		float64(pending),
+		siteLabel,
+	)
 }
 
-// collectDeviceBytes collects receive and transmit byte counts for UniFi devices.
-func (c *DeviceCollector) collectDeviceBytes(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+// collectDeviceCounts collects counts for the number of UniFi devices of each
+// device type, model, state and firmware version, and of devices whose
+// firmware is being or can be upgraded.
+func (c *DeviceCollector) collectDeviceCounts(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	types := make(map[string]int)
+	models := make(map[string]int)
+	states := make(map[string]int)
+	versions := make(map[deviceVersion]int)
+	var upgrading, upgradable int
+
 	for _, d := range devices {
-		labels := []string{
-			siteLabel,
-			d.ID,
-			d.NICs[0].MAC.String(),
-			d.Name,
+		types[d.Type]++
+		models[d.Model]++
+		states[d.State.String()]++
+		versions[deviceVersion{model: d.Model, version: d.Version}]++
+
+		if d.State == api.DeviceStateUpgrading {
+			upgrading++
 		}
+		if d.Upgradable {
+			upgradable++
+		}
+	}
 
+	for t, n := range types {
 		ch <- prometheus.MustNewConstMetric(
-			c.ReceivedBytesTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.All.ReceiveBytes),
-			append(labels, "user")...,
+			c.DevicesByType,
+			prometheus.GaugeValue,
+			float64(n),
+			siteLabel,
+			t,
 		)
+	}
+
+	for m, n := range models {
 		ch <- prometheus.MustNewConstMetric(
-			c.TransmittedBytesTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.All.TransmitBytes),
-			append(labels, "user")...,
+			c.DevicesByModel,
+			prometheus.GaugeValue,
+			float64(n),
+			siteLabel,
+			m,
 		)
+	}
+
+	for st, n := range states {
 		ch <- prometheus.MustNewConstMetric(
-			c.ReceivedPacketsTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.All.ReceivePackets),
-			append(labels, "user")...,
+			c.DevicesByState,
+			prometheus.GaugeValue,
+			float64(n),
+			siteLabel,
+			st,
 		)
+	}
+
+	for v, n := range versions {
 		ch <- prometheus.MustNewConstMetric(
-			c.TransmittedPacketsTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.All.TransmitPackets),
-			append(labels, "user")...,
+			c.DevicesByVersion,
+			prometheus.GaugeValue,
+			float64(n),
+			siteLabel,
+			v.model,
+			v.version,
 		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.Upgrading,
+		prometheus.GaugeValue,
+		float64(upgrading),
+		siteLabel,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.Upgradable,
+		prometheus.GaugeValue,
+		float64(upgradable),
+		siteLabel,
+	)
+}
+
+// A deviceVersion is a firmware version of a device model.
+type deviceVersion struct {
+	model   string
+	version string
+}
+
+// collectDeviceUptime collects device uptime and boot time for UniFi devices.
+// Boot time is only collected for devices which report an uptime.
+func (c *DeviceCollector) collectDeviceUptime(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	now := c.now()
+	for _, d := range devices {
+		labels := []string{
+			siteLabel,
+			d.ID,
+			d.MAC.String(),
+			d.Name,
+		}
+
 		ch <- prometheus.MustNewConstMetric(
-			c.TransmittedDroppedTotal,
+			c.UptimeSecondsTotal,
 			prometheus.CounterValue,
-			float64(d.Stats.All.TransmitDropped),
-			append(labels, "user")...,
+			float64(d.Uptime/time.Second),
+			labels...,
 		)
+
 		ch <- prometheus.MustNewConstMetric(
-			c.ReceivedBytesTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.Uplink.ReceiveBytes),
-			append(labels, "uplink")...,
+			c.UptimeSeconds,
+			prometheus.GaugeValue,
+			float64(d.Uptime/time.Second),
+			labels...,
 		)
+
+		if d.Uptime == 0 {
+			continue
+		}
+
+		// Uptime is reported as of the device's last check in, so boot time
+		// is relative to that, rather than to the time of the scrape
+		reported := d.LastSeen
+		if reported.IsZero() {
+			reported = now
+		}
+
 		ch <- prometheus.MustNewConstMetric(
-			c.TransmittedBytesTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.Uplink.TransmitBytes),
-			append(labels, "uplink")...,
+			c.BootTimeSeconds,
+			prometheus.GaugeValue,
+			float64(reported.Add(-d.Uptime).Unix()),
+			labels...,
+		)
+	}
+}
+
+// collectDeviceLastSeen collects the time since UniFi devices last checked in
+// with the controller.  Devices which have never checked in are skipped.
+func (c *DeviceCollector) collectDeviceLastSeen(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	now := c.now()
+	for _, d := range devices {
+		if d.LastSeen.IsZero() {
+			continue
+		}
+
+		labels := []string{
+			siteLabel,
+			d.ID,
+			d.MAC.String(),
+			d.Name,
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.LastSeenSeconds,
+			prometheus.GaugeValue,
+			now.Sub(d.LastSeen).Seconds(),
+			labels...,
 		)
+	}
+}
+
+// collectDeviceState collects the state of UniFi devices.
+func (c *DeviceCollector) collectDeviceState(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	for _, d := range devices {
 		ch <- prometheus.MustNewConstMetric(
-			c.ReceivedPacketsTotal,
-			prometheus.CounterValue,
-			float64(d.Stats.Uplink.ReceivePackets),
-			append(labels, "uplink")...,
+			c.State,
+			prometheus.GaugeValue,
+			1,
+			siteLabel,
+			d.ID,
+			d.MAC.String(),
+			d.Name,
+			d.State.String(),
 		)
+	}
+}
+
+// collectDeviceBytes collects receive and transmit byte, packet, drop and
+// error counts for UniFi devices.
+func (c *DeviceCollector) collectDeviceBytes(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	for _, d := range devices {
+		labels := []string{
+			siteLabel,
+			d.ID,
+			d.MAC.String(),
+			d.Name,
+		}
+
+		// Only access points and gateways report user statistics
+		if all := d.Stats.All; all != nil {
+			c.collectDeviceConnection(ch, labels, "user", map[*prometheus.Desc]float64{
+				c.ReceivedBytesTotal:      all.ReceiveBytes,
+				c.TransmittedBytesTotal:   all.TransmitBytes,
+				c.ReceivedPacketsTotal:    all.ReceivePackets,
+				c.TransmittedPacketsTotal: all.TransmitPackets,
+				c.ReceivedDroppedTotal:    all.ReceiveDropped,
+				c.TransmittedDroppedTotal: all.TransmitDropped,
+				c.ReceiveErrorsTotal:      all.ReceiveErrors,
+				c.TransmitErrorsTotal:     all.TransmitErrors,
+			})
+		}
+
+		uplink := d.Stats.Uplink
+		c.collectDeviceConnection(ch, labels, "uplink", map[*prometheus.Desc]float64{
+			c.ReceivedBytesTotal:      uplink.ReceiveBytes,
+			c.TransmittedBytesTotal:   uplink.TransmitBytes,
+			c.ReceivedPacketsTotal:    uplink.ReceivePackets,
+			c.TransmittedPacketsTotal: uplink.TransmitPackets,
+			c.ReceivedDroppedTotal:    uplink.ReceiveDropped,
+			c.TransmittedDroppedTotal: uplink.TransmitDropped,
+			c.ReceiveErrorsTotal:      uplink.ReceiveErrors,
+			c.TransmitErrorsTotal:     uplink.TransmitErrors,
+		})
+	}
+}
+
+// collectDeviceConnection sends each counter for one connection of a UniFi
+// device, appending the connection to its labels.
+func (c *DeviceCollector) collectDeviceConnection(ch chan<- prometheus.Metric, labels []string, connection string, counters map[*prometheus.Desc]float64) {
+	llabels := make([]string, len(labels), len(labels)+1)
+	copy(llabels, labels)
+	llabels = append(llabels, connection)
+
+	for desc, v := range counters {
 		ch <- prometheus.MustNewConstMetric(
-			c.TransmittedPacketsTotal,
+			desc,
 			prometheus.CounterValue,
-			float64(d.Stats.Uplink.TransmitPackets),
-			append(labels, "uplink")...,
+			v,
+			llabels...,
 		)
 	}
 }
 
+// collectDevicePorts collects receive and transmit counts for each wired port
+// of UniFi devices, so that traffic on multi-port devices, such as switches
+// and gateways, is attributed to the port which carried it.  The
+// configuration of each port is only exported if pc is not nil.
+func (c *DeviceCollector) collectDevicePorts(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device, pc *portConfig) {
+	for _, d := range devices {
+		for _, p := range d.Ports {
+			// Prefer the name shown by the UniFi Controller, such as "Port 1"
+			iface := p.Name
+			if iface == "" {
+				iface = p.InterfaceName
+			}
+
+			labels := []string{
+				siteLabel,
+				d.ID,
+				d.MAC.String(),
+				d.Name,
+				strconv.Itoa(p.Index),
+				iface,
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.PortReceivedBytesTotal,
+				prometheus.CounterValue,
+				p.Stats.ReceiveBytes,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortTransmittedBytesTotal,
+				prometheus.CounterValue,
+				p.Stats.TransmitBytes,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortReceivedPacketsTotal,
+				prometheus.CounterValue,
+				p.Stats.ReceivePackets,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortTransmittedPacketsTotal,
+				prometheus.CounterValue,
+				p.Stats.TransmitPackets,
+				labels...,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				c.PortReceivedDroppedTotal,
+				prometheus.CounterValue,
+				p.Stats.ReceiveDropped,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortTransmittedDroppedTotal,
+				prometheus.CounterValue,
+				p.Stats.TransmitDropped,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortReceivedBroadcastTotal,
+				prometheus.CounterValue,
+				p.ReceiveBroadcast,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortReceivedMulticastTotal,
+				prometheus.CounterValue,
+				p.ReceiveMulticast,
+				labels...,
+			)
+
+			if pc != nil {
+				vlan, profile := pc.resolve(p)
+				ch <- prometheus.MustNewConstMetric(
+					c.PortInfo,
+					prometheus.GaugeValue,
+					1,
+					append(labels, vlan, profile, p.PoEMode)...,
+				)
+			}
+
+			if p.STPState != "" {
+				ch <- prometheus.MustNewConstMetric(
+					c.PortSTPState,
+					prometheus.GaugeValue,
+					1,
+					append(labels, p.STPState)...,
+				)
+			}
+
+			// A port which is down reports no speed and half duplex, which
+			// would look like a badly negotiated link
+			if !p.Up {
+				continue
+			}
+
+			duplex := 0.0
+			if p.FullDuplex {
+				duplex = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.PortSpeedBitsPerSecond,
+				prometheus.GaugeValue,
+				float64(p.Speed)*1e6,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.PortFullDuplex,
+				prometheus.GaugeValue,
+				duplex,
+				labels...,
+			)
+		}
+	}
+}
+
+// A portConfig resolves the IDs of the native network and port profile of
+// ports into the native VLAN and the name of the profile.
+type portConfig struct {
+	vlans    map[string]int
+	profiles map[string]*api.PortProfile
+}
+
+// newPortConfig retrieves the networks and port profiles of a site from src.
+func newPortConfig(ctx context.Context, src PortConfigSource, siteName string) (*portConfig, error) {
+	networks, err := src.Networks(ctx, siteName)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := src.PortProfiles(ctx, siteName)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &portConfig{
+		vlans:    make(map[string]int, len(networks)),
+		profiles: make(map[string]*api.PortProfile, len(profiles)),
+	}
+	for _, n := range networks {
+		pc.vlans[n.ID] = n.VLAN
+	}
+	for _, p := range profiles {
+		pc.profiles[p.ID] = p
+	}
+
+	return pc, nil
+}
+
+// resolve returns the native VLAN and port profile name of a port, either of
+// which is empty if unknown.  A port without a native network of its own has
+// that of its profile.
+func (pc *portConfig) resolve(p *api.Port) (string, string) {
+	var (
+		network = p.NativeNetworkID
+		profile string
+	)
+	if pp, ok := pc.profiles[p.ProfileID]; ok {
+		profile = pp.Name
+		if network == "" {
+			network = pp.NativeNetworkID
+		}
+	}
+
+	var vlan string
+	if v, ok := pc.vlans[network]; ok {
+		vlan = strconv.Itoa(v)
+	}
+
+	return vlan, profile
+}
+
+// collectDeviceMACTables collects the number of addresses learned by UniFi
+// switches on each VLAN, for switches whose ports report a MAC address table.
+func (c *DeviceCollector) collectDeviceMACTables(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	for _, d := range devices {
+		var reported bool
+		entries := make(map[int]int)
+		for _, p := range d.Ports {
+			if p.MACTable == nil {
+				continue
+			}
+
+			reported = true
+			for _, e := range p.MACTable {
+				entries[e.VLAN]++
+			}
+		}
+		if !reported {
+			continue
+		}
+
+		vlans := make([]int, 0, len(entries))
+		for vlan := range entries {
+			vlans = append(vlans, vlan)
+		}
+		sort.Ints(vlans)
+
+		// A switch whose table is empty still reports a total of zero
+		if len(vlans) == 0 {
+			vlans = append(vlans, 0)
+		}
+
+		for _, vlan := range vlans {
+			// Switches which do not report VLANs leave the label empty
+			vlanLabel := ""
+			if vlan != 0 {
+				vlanLabel = strconv.Itoa(vlan)
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.MACTableEntries,
+				prometheus.GaugeValue,
+				float64(entries[vlan]),
+				siteLabel,
+				d.ID,
+				d.MAC.String(),
+				d.Name,
+				vlanLabel,
+			)
+		}
+	}
+}
+
+// collectDeviceLAGs collects the state of each link aggregation group of
+// UniFi devices, identified by the index of the port which leads it.
+func (c *DeviceCollector) collectDeviceLAGs(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	for _, d := range devices {
+		lags := portLAGs(d.Ports)
+
+		leaders := make([]int, 0, len(lags))
+		for idx := range lags {
+			leaders = append(leaders, idx)
+		}
+		sort.Ints(leaders)
+
+		for _, idx := range leaders {
+			members := lags[idx]
+
+			var down int
+			for _, p := range members {
+				if !p.Up {
+					down++
+				}
+			}
+
+			// The aggregate link stays up while any member is up
+			up := 0.0
+			if down < len(members) {
+				up = 1
+			}
+
+			labels := []string{
+				siteLabel,
+				d.ID,
+				d.MAC.String(),
+				d.Name,
+				strconv.Itoa(idx),
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.LAGUp,
+				prometheus.GaugeValue,
+				up,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.LAGMembers,
+				prometheus.GaugeValue,
+				float64(len(members)),
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.LAGMembersDown,
+				prometheus.GaugeValue,
+				float64(down),
+				labels...,
+			)
+		}
+	}
+}
+
+// portLAGs returns the member ports of each link aggregation group among
+// ports, keyed by the index of the port which leads the group.  The leading
+// port is itself a member, and is identified either by its aggregate
+// operating mode or by the other members referring to it.
+func portLAGs(ports []*api.Port) map[int][]*api.Port {
+	byIndex := make(map[int]*api.Port, len(ports))
+	for _, p := range ports {
+		byIndex[p.Index] = p
+	}
+
+	lags := make(map[int][]*api.Port)
+	add := func(leader int, p *api.Port) {
+		if _, ok := lags[leader]; !ok {
+			if lp, ok := byIndex[leader]; ok && lp != p {
+				lags[leader] = []*api.Port{lp}
+			}
+		}
+		lags[leader] = append(lags[leader], p)
+	}
+
+	for _, p := range ports {
+		switch {
+		case p.AggregatedBy != 0 && p.AggregatedBy != p.Index:
+			add(p.AggregatedBy, p)
+		case p.OpMode == "aggregate":
+			if _, ok := lags[p.Index]; !ok {
+				add(p.Index, p)
+			}
+		}
+	}
+
+	return lags
+}
+
 // collectDeviceStations collects station counts for UniFi devices.
 func (c *DeviceCollector) collectDeviceStations(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
 	for _, d := range devices {
 		labels := []string{
 			siteLabel,
 			d.ID,
-			d.NICs[0].MAC.String(),
+			d.MAC.String(),
 			d.Name,
 		}
 
@@ -296,6 +1267,147 @@ func (c *DeviceCollector) collectDeviceStations(ch chan<- prometheus.Metric, sit
 	}
 }
 
+// collectDeviceUplinks collects uplink topology information for UniFi devices.
+// Devices without an upstream UniFi device, such as gateways, are skipped.
+func (c *DeviceCollector) collectDeviceUplinks(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device) {
+	for _, d := range devices {
+		if d.Uplink == nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.UplinkInfo,
+			prometheus.GaugeValue,
+			1,
+			siteLabel,
+			d.MAC.String(),
+			d.Uplink.MAC.String(),
+			strconv.Itoa(d.Uplink.RemotePort),
+			d.Uplink.Type,
+		)
+	}
+}
+
+// collectDeviceWANs collects information about the WAN interfaces of
+// gateways, how often their IP addresses have changed, and how available
+// the UniFi Controller's monitoring found them.  isps maps WAN IP addresses
+// to the names of their ISPs; if nil, WAN information is not collected.
+func (c *DeviceCollector) collectDeviceWANs(ch chan<- prometheus.Metric, siteLabel string, devices []*api.Device, isps map[string]string) {
+	for _, d := range devices {
+		for _, w := range d.WANs {
+			var ip string
+			if w.IP != nil {
+				ip = w.IP.String()
+			}
+
+			labels := []string{
+				siteLabel,
+				d.ID,
+				d.MAC.String(),
+				d.Name,
+				w.Name,
+			}
+
+			if isps != nil {
+				ch <- prometheus.MustNewConstMetric(
+					c.WANInfo,
+					prometheus.GaugeValue,
+					1,
+					append(labels, w.InterfaceName, ip, isps[ip])...,
+				)
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.WANIPChangesTotal,
+				prometheus.CounterValue,
+				c.wanIPs.observe(d.MAC.String()+"/"+w.Name, ip),
+				labels...,
+			)
+		}
+
+		for _, u := range d.WANUptime {
+			labels := []string{
+				siteLabel,
+				d.ID,
+				d.MAC.String(),
+				d.Name,
+				u.WAN,
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.WANAvailabilityRatio,
+				prometheus.GaugeValue,
+				u.Availability/100,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.WANLatencySeconds,
+				prometheus.GaugeValue,
+				u.LatencyAverage.Seconds(),
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.WANPeriodSeconds,
+				prometheus.GaugeValue,
+				u.Period.Seconds(),
+				labels...,
+			)
+
+			for _, m := range u.Monitors {
+				mlabels := append(labels, m.Target, m.Type)
+
+				ch <- prometheus.MustNewConstMetric(
+					c.WANMonitorAvailabilityRatio,
+					prometheus.GaugeValue,
+					m.Availability/100,
+					mlabels...,
+				)
+				ch <- prometheus.MustNewConstMetric(
+					c.WANMonitorLatencySeconds,
+					prometheus.GaugeValue,
+					m.LatencyAverage.Seconds(),
+					mlabels...,
+				)
+			}
+		}
+	}
+}
+
+// A wanTracker counts the changes of the IP addresses of WAN interfaces seen
+// across scrapes.
+type wanTracker struct {
+	mu      sync.Mutex
+	ips     map[string]string
+	changes map[string]float64
+}
+
+// newWANTracker creates an empty wanTracker.
+func newWANTracker() *wanTracker {
+	return &wanTracker{
+		ips:     make(map[string]string),
+		changes: make(map[string]float64),
+	}
+}
+
+// observe records ip as the current address of the WAN identified by key,
+// and returns the number of times its address has changed.  A WAN which has
+// lost its address, such as while reconnecting, keeps its previous address,
+// so that getting the same address back is not counted as a change.
+func (t *wanTracker) observe(key, ip string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ip == "" {
+		return t.changes[key]
+	}
+
+	if prev, ok := t.ips[key]; ok && prev != ip {
+		t.changes[key]++
+	}
+	t.ips[key] = ip
+
+	return t.changes[key]
+}
+
 // Describe sends the descriptors of each metric over to the provided channel.
 // The corresponding metric values are sent separately.
 func (c *DeviceCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -303,16 +1415,60 @@ func (c *DeviceCollector) Describe(ch chan<- *prometheus.Desc) {
 		c.Devices,
 		c.AdoptedDevices,
 		c.UnadoptedDevices,
+		c.PendingAdoption,
+		c.PendingInfo,
+		c.DevicesByType,
+		c.DevicesByModel,
+		c.DevicesByState,
+		c.DevicesByVersion,
+		c.Upgrading,
+		c.Upgradable,
 
 		c.UptimeSecondsTotal,
+		c.UptimeSeconds,
+		c.BootTimeSeconds,
+		c.LastSeenSeconds,
+		c.State,
 
 		c.ReceivedBytesTotal,
 		c.TransmittedBytesTotal,
 		c.ReceivedPacketsTotal,
 		c.TransmittedPacketsTotal,
+		c.ReceivedDroppedTotal,
 		c.TransmittedDroppedTotal,
+		c.ReceiveErrorsTotal,
+		c.TransmitErrorsTotal,
+
+		c.PortReceivedBytesTotal,
+		c.PortTransmittedBytesTotal,
+		c.PortReceivedPacketsTotal,
+		c.PortTransmittedPacketsTotal,
+		c.PortReceivedDroppedTotal,
+		c.PortTransmittedDroppedTotal,
+		c.PortReceivedBroadcastTotal,
+		c.PortReceivedMulticastTotal,
+		c.PortSTPState,
+		c.PortInfo,
+		c.PortSpeedBitsPerSecond,
+		c.PortFullDuplex,
+
+		c.MACTableEntries,
+
+		c.LAGUp,
+		c.LAGMembers,
+		c.LAGMembersDown,
 
 		c.Stations,
+
+		c.UplinkInfo,
+
+		c.WANInfo,
+		c.WANIPChangesTotal,
+		c.WANAvailabilityRatio,
+		c.WANLatencySeconds,
+		c.WANPeriodSeconds,
+		c.WANMonitorAvailabilityRatio,
+		c.WANMonitorLatencySeconds,
 	}
 
 	for _, d := range ds {
@@ -323,18 +1479,50 @@ func (c *DeviceCollector) Describe(ch chan<- *prometheus.Desc) {
 // Collect is the same as CollectError, but ignores any errors which occur.
 // Collect exists to satisfy the prometheus.Collector interface.
 func (c *DeviceCollector) Collect(ch chan<- prometheus.Metric) {
-	_ = c.CollectError(ch)
+	_ = c.CollectError(context.Background(), ch)
 }
 
 // CollectError sends the metric values for each metric pertaining to the global
 // cluster usage over to the provided prometheus Metric channel, returning any
-// errors which occur.
-func (c *DeviceCollector) CollectError(ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
-		ch <- prometheus.NewInvalidMetric(desc, err)
-		log.Printf("[ERROR] failed collecting device metric %v: %v", desc, err)
+// errors which occur.  Requests to the UniFi Controller are cancelled when ctx
+// is done.  Errors are logged and returned, but are not sent over ch.
+func (c *DeviceCollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting device metric %v: %v", desc, err)
 		return err
 	}
 
 	return nil
 }
+
+// hasWANs reports whether any of devices has a WAN interface.
+func hasWANs(devices []*api.Device) bool {
+	for _, d := range devices {
+		if len(d.WANs) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wanISPs retrieves the health of a site from src, and returns the name of
+// the ISP of its active WAN keyed by the WAN's IP address.  The controller
+// does not name the ISPs of other WANs.
+func wanISPs(ctx context.Context, src WANHealthSource, siteName string) (map[string]string, error) {
+	health, err := src.Health(ctx, siteName)
+	if err != nil {
+		return nil, err
+	}
+
+	isps := make(map[string]string)
+	for _, h := range health {
+		if h.Subsystem != "wan" || h.WANIP == nil {
+			continue
+		}
+
+		isps[h.WANIP.String()] = h.ISPName
+	}
+
+	return isps, nil
+}
@@ -1,20 +1,28 @@
 package exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
 )
 
 func TestDeviceCollector(t *testing.T) {
 	var tests = []struct {
-		desc    string
-		input   string
-		sites   []*api.Site
-		matches []*regexp.Regexp
+		desc             string
+		input            string
+		sites            []*api.Site
+		skipDisconnected bool
+		portConfig       PortConfigSource
+		wanHealth        WANHealthSource
+		matches          []*regexp.Regexp
+		nomatch          []*regexp.Regexp
 	}{
 		{
 			desc: "one device, one site",
@@ -26,6 +34,7 @@ func TestDeviceCollector(t *testing.T) {
 			"adopted": true,
 			"inform_ip": "192.168.1.1",
 			"name": "ABC",
+			"model": "U7PG2",
 			"type": "uap",
 			"ethernet_table": [{
 				"mac": "de:ad:be:ef:de:ad"
@@ -60,16 +69,28 @@ func TestDeviceCollector(t *testing.T) {
 					"tx_bytes": 20,
 					"rx_packets": 4,
 					"tx_packets": 1,
-					"tx_dropped": 1
+					"rx_dropped": 2,
+					"tx_dropped": 1,
+					"rx_errors": 3,
+					"tx_errors": 4
 				}
 			},
 			"uplink": {
 				"rx_bytes": 20,
 				"tx_bytes": 10,
 				"rx_packets": 2,
-				"tx_packets": 1
+				"tx_packets": 1,
+				"rx_dropped": 5,
+				"tx_dropped": 6,
+				"rx_errors": 7,
+				"tx_errors": 8,
+				"type": "wire",
+				"uplink_mac": "f0:9f:c2:00:00:01",
+				"uplink_remote_port": 7
 			},
-			"uptime": 10
+			"state": 1,
+			"uptime": 10,
+			"last_seen": 1500000000
 		}
 	]
 }
@@ -78,8 +99,14 @@ func TestDeviceCollector(t *testing.T) {
 				regexp.MustCompile(`unifi_devices{site="Default"} 1`),
 				regexp.MustCompile(`unifi_devices_adopted{site="Default"} 1`),
 				regexp.MustCompile(`unifi_devices_unadopted{site="Default"} 0`),
+				regexp.MustCompile(`unifi_devices_by_type{site="Default",type="uap"} 1`),
+				regexp.MustCompile(`unifi_devices_by_model{model="U7PG2",site="Default"} 1`),
 
+				regexp.MustCompile(`unifi_devices_state{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default",state="connected"} 1`),
 				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_uptime_seconds{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_boot_time_seconds{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1.49999999e\+09`),
+				regexp.MustCompile(`unifi_devices_last_seen_seconds{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 30`),
 
 				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 80`),
 				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 20`),
@@ -91,11 +118,20 @@ func TestDeviceCollector(t *testing.T) {
 				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 10`),
 				regexp.MustCompile(`unifi_devices_received_packets_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 2`),
 				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_received_packets_dropped_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_receive_errors_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 3`),
+				regexp.MustCompile(`unifi_devices_transmit_errors_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 4`),
+				regexp.MustCompile(`unifi_devices_received_packets_dropped_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 5`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 6`),
+				regexp.MustCompile(`unifi_devices_receive_errors_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 7`),
+				regexp.MustCompile(`unifi_devices_transmit_errors_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 8`),
 
 				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default",user_type="private"} 2`),
 				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default",user_type="private"} 4`),
 				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default",user_type="guest"} 1`),
 				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default",user_type="guest"} 2`),
+
+				regexp.MustCompile(`unifi_devices_uplink_info{device_mac="de:ad:be:ef:de:ad",site="Default",uplink_mac="f0:9f:c2:00:00:01",uplink_port="7",uplink_type="wire"} 1`),
 			},
 			sites: []*api.Site{{
 				Name:        "default",
@@ -111,16 +147,19 @@ func TestDeviceCollector(t *testing.T) {
 					"_id": "abc",
 					"adopted": true,
 					"inform_ip": "192.168.1.1",
+					"type": "uap",
 					"name": "ABC",
 					"ethernet_table": [{
 						"mac": "de:ad:be:ef:de:ad"
 					}],
 					"radio_table_stats": [{
+						"radio": "ng",
 						"guest-num_sta": 1,
 						"name": "wifi0",
 						"num_sta": 3,
 						"user-num_sta": 2
 					}, {
+						"radio": "na",
 						"guest-num_sta": 2,
 						"name": "wifi1",
 						"num_sta": 6,
@@ -137,12 +176,14 @@ func TestDeviceCollector(t *testing.T) {
 						}
 					],
 					"stat": {
-						"bytes": 100,
-						"rx_bytes": 80,
-						"tx_bytes": 20,
-						"rx_packets": 4,
-						"tx_packets": 1,
-						"tx_dropped": 1
+						"ap": {
+							"bytes": 100,
+							"rx_bytes": 80,
+							"tx_bytes": 20,
+							"rx_packets": 4,
+							"tx_packets": 1,
+							"tx_dropped": 1
+						}
 					},
 					"uplink": {
 						"rx_bytes": 20,
@@ -156,16 +197,19 @@ func TestDeviceCollector(t *testing.T) {
 					"_id": "def",
 					"adopted": false,
 					"inform_ip": "192.168.1.1",
+					"type": "uap",
 					"name": "DEF",
 					"ethernet_table": [{
 						"mac": "ab:ad:1d:ea:ab:ad"
 					}],
 					"radio_table_stats": [{
+						"radio": "ng",
 						"guest-num_sta": 1,
 						"name": "wifi0",
 						"num_sta": 3,
 						"user-num_sta": 2
 					}, {
+						"radio": "na",
 						"guest-num_sta": 2,
 						"name": "wifi1",
 						"num_sta": 6,
@@ -182,12 +226,14 @@ func TestDeviceCollector(t *testing.T) {
 						}
 					],
 					"stat": {
-						"bytes": 200,
-						"rx_bytes": 10,
-						"tx_bytes": 190,
-						"rx_packets": 1,
-						"tx_packets": 19,
-						"tx_dropped": 1
+						"ap": {
+							"bytes": 200,
+							"rx_bytes": 10,
+							"tx_bytes": 190,
+							"rx_packets": 1,
+							"tx_packets": 19,
+							"tx_dropped": 1
+						}
 					},
 					"uplink": {
 						"rx_bytes": 40,
@@ -204,48 +250,43 @@ func TestDeviceCollector(t *testing.T) {
 				regexp.MustCompile(`unifi_devices{site="Default"} 2`),
 				regexp.MustCompile(`unifi_devices_adopted{site="Default"} 1`),
 				regexp.MustCompile(`unifi_devices_unadopted{site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_by_type{site="Default",type="uap"} 2`),
 
 				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 10`),
 
-				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 80`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 20`),
-
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 4`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
-
-				regexp.MustCompile(`unifi_devices_received_bytes_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 20`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 80`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 20`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 4`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{connection="user",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
 
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 2`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 20`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="uplink",id="abc",mac="de:ad:be:ef:de:ad",name="ABC",site="Default"} 1`),
 
-				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default"} 3`),
-				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default"} 6`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default"} 2`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default"} 4`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default"} 1`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default",user_type="private"} 2`),
+				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default",user_type="private"} 4`),
+				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi0",mac="de:ad:be:ef:de:ad",name="ABC",radio="2.4GHz",site="Default",user_type="guest"} 1`),
+				regexp.MustCompile(`unifi_devices_stations{id="abc",interface="wifi1",mac="de:ad:be:ef:de:ad",name="ABC",radio="5GHz",site="Default",user_type="guest"} 2`),
 
 				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 20`),
 
-				regexp.MustCompile(`unifi_devices_received_bytes_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 10`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 190`),
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 1`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 19`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 1`),
-
-				regexp.MustCompile(`unifi_devices_received_bytes_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 40`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 20`),
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 4`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 2`),
-
-				regexp.MustCompile(`unifi_devices_stations{id="def",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="2.4GHz",site="Default"} 3`),
-				regexp.MustCompile(`unifi_devices_stations{id="def",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="5GHz",site="Default"} 6`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="def",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="2.4GHz",site="Default"} 2`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="def",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="5GHz",site="Default"} 4`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="def",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="2.4GHz",site="Default"} 1`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="def",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="5GHz",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="user",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="user",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 190`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="user",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="user",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 19`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{connection="user",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 1`),
+
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 40`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="uplink",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 20`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="uplink",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 4`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="uplink",id="def",mac="ab:ad:1d:ea:ab:ad",name="DEF",site="Default"} 2`),
+
+				regexp.MustCompile(`unifi_devices_stations{id="def",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="2.4GHz",site="Default",user_type="private"} 2`),
+				regexp.MustCompile(`unifi_devices_stations{id="def",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="5GHz",site="Default",user_type="private"} 4`),
+				regexp.MustCompile(`unifi_devices_stations{id="def",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="2.4GHz",site="Default",user_type="guest"} 1`),
+				regexp.MustCompile(`unifi_devices_stations{id="def",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="DEF",radio="5GHz",site="Default",user_type="guest"} 2`),
 			},
 			sites: []*api.Site{{
 				Name:        "default",
@@ -261,16 +302,19 @@ func TestDeviceCollector(t *testing.T) {
 					"_id": "123",
 					"adopted": true,
 					"inform_ip": "192.168.1.1",
+					"type": "uap",
 					"name": "OneTwoThree",
 					"ethernet_table": [{
 						"mac": "ab:ad:1d:ea:ab:ad"
 					}],
 					"radio_table_stats": [{
+						"radio": "ng",
 						"guest-num_sta": 1,
 						"name": "wifi0",
 						"num_sta": 3,
 						"user-num_sta": 2
 					}, {
+						"radio": "na",
 						"guest-num_sta": 2,
 						"name": "wifi1",
 						"num_sta": 6,
@@ -287,12 +331,14 @@ func TestDeviceCollector(t *testing.T) {
 						}
 					],
 					"stat": {
-						"bytes": 100,
-						"rx_bytes": 80,
-						"tx_bytes": 20,
-						"rx_packets": 4,
-						"tx_packets": 1,
-						"tx_dropped": 1
+						"ap": {
+							"bytes": 100,
+							"rx_bytes": 80,
+							"tx_bytes": 20,
+							"rx_packets": 4,
+							"tx_packets": 1,
+							"tx_dropped": 1
+						}
 					},
 					"uplink": {
 						"rx_bytes": 20,
@@ -312,25 +358,21 @@ func TestDeviceCollector(t *testing.T) {
 
 				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 10`),
 
-				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 80`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 20`),
-
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 4`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 1`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 80`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 20`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 4`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 1`),
 
-				regexp.MustCompile(`unifi_devices_received_bytes_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 20`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 20`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 1`),
 
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 2`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Default"} 1`),
-
-				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Default"} 3`),
-				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Default"} 6`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Default"} 2`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Default"} 4`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Default"} 1`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Default",user_type="private"} 2`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Default",user_type="private"} 4`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Default",user_type="guest"} 1`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Default",user_type="guest"} 2`),
 
 				regexp.MustCompile(`unifi_devices{site="Some Site"} 1`),
 				regexp.MustCompile(`unifi_devices_adopted{site="Some Site"} 1`),
@@ -338,24 +380,21 @@ func TestDeviceCollector(t *testing.T) {
 
 				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 10`),
 
-				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 80`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 20`),
-
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 4`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 1`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 1`),
-
-				regexp.MustCompile(`unifi_devices_received_bytes_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 20`),
-				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 10`),
-				regexp.MustCompile(`unifi_devices_received_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 2`),
-				regexp.MustCompile(`unifi_devices_transmitted_packets_total{id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 1`),
-
-				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Some Site"} 3`),
-				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Some Site"} 6`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Some Site"} 2`),
-				regexp.MustCompile(`unifi_devices_stations_user{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Some Site"} 4`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Some Site"} 1`),
-				regexp.MustCompile(`unifi_devices_stations_guest{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Some Site"} 2`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 80`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 20`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 4`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 1`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_dropped_total{connection="user",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 1`),
+
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 20`),
+				regexp.MustCompile(`unifi_devices_transmitted_bytes_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 10`),
+				regexp.MustCompile(`unifi_devices_received_packets_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 2`),
+				regexp.MustCompile(`unifi_devices_transmitted_packets_total{connection="uplink",id="123",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",site="Some Site"} 1`),
+
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Some Site",user_type="private"} 2`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Some Site",user_type="private"} 4`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi0",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="2.4GHz",site="Some Site",user_type="guest"} 1`),
+				regexp.MustCompile(`unifi_devices_stations{id="123",interface="wifi1",mac="ab:ad:1d:ea:ab:ad",name="OneTwoThree",radio="5GHz",site="Some Site",user_type="guest"} 2`),
 			},
 			sites: []*api.Site{
 				{
@@ -368,12 +407,519 @@ func TestDeviceCollector(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "devices without an ethernet table, with MAC addresses in other formats",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "sw",
+			"adopted": true,
+			"inform_ip": "192.168.1.2",
+			"mac": "F0-9F-C2-00-00-02",
+			"name": "Switch",
+			"type": "usw",
+			"port_table": [
+				{"port_idx": 1, "name": "Port 1", "up": true, "rx_bytes": 300, "tx_bytes": 200, "rx_packets": 3, "tx_packets": 2, "rx_dropped": 7, "tx_dropped": 1, "rx_broadcast": 40, "rx_multicast": 50, "stp_state": "forwarding", "mac_table": [{"mac": "00:00:5e:00:53:01", "vlan": 10}, {"mac": "00:00:5e:00:53:02", "vlan": 10}, {"mac": "00:00:5e:00:53:03", "vlan": 20, "static": true}], "speed": 1000, "full_duplex": true},
+				{"port_idx": 2, "ifname": "eth1", "rx_bytes": 0, "aggregated_by": false},
+				{"port_idx": 3, "name": "Port 3", "up": true, "op_mode": "aggregate", "aggregated_by": false, "mac_table": [{"mac": "00:00:5e:00:53:04", "vlan": 10}]},
+				{"port_idx": 4, "name": "Port 4", "up": false, "op_mode": "aggregate", "aggregated_by": 3, "stp_state": "blocking"},
+				{"port_idx": 5, "name": "Port 5", "up": false, "aggregated_by": 6},
+				{"port_idx": 6, "name": "Port 6", "up": false}
+			],
+			"uplink": {
+				"rx_bytes": 20,
+				"tx_bytes": 10,
+				"uplink_mac": "F0:9F:C2:00:00:01",
+				"uplink_remote_port": 1
+			},
+			"uptime": 10
+		},
+		{
+			"_id": "adopting",
+			"adopted": false,
+			"inform_ip": "192.168.1.3",
+			"type": "uap",
+			"uptime": 5
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices{site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_unadopted{site="Default"} 1`),
+
+				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="sw",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 10`),
+				regexp.MustCompile(`unifi_devices_received_bytes_total{connection="uplink",id="sw",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 20`),
+				regexp.MustCompile(`unifi_devices_port_received_bytes_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 300`),
+				regexp.MustCompile(`unifi_devices_port_transmitted_bytes_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 200`),
+				regexp.MustCompile(`unifi_devices_port_received_packets_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 3`),
+				regexp.MustCompile(`unifi_devices_port_transmitted_packets_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_port_received_bytes_total{id="sw",interface="eth1",mac="f0:9f:c2:00:00:02",name="Switch",port="2",site="Default"} 0`),
+				regexp.MustCompile(`unifi_devices_port_received_packets_dropped_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 7`),
+				regexp.MustCompile(`unifi_devices_port_transmitted_packets_dropped_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_port_received_broadcast_packets_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 40`),
+				regexp.MustCompile(`unifi_devices_port_received_multicast_packets_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 50`),
+				regexp.MustCompile(`unifi_devices_port_stp_state{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default",state="forwarding"} 1`),
+				regexp.MustCompile(`unifi_devices_port_stp_state{id="sw",interface="Port 4",mac="f0:9f:c2:00:00:02",name="Switch",port="4",site="Default",state="blocking"} 1`),
+				regexp.MustCompile(`unifi_devices_port_speed_bits_per_second{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 1e\+09`),
+				regexp.MustCompile(`unifi_devices_port_full_duplex{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_uplink_info{device_mac="f0:9f:c2:00:00:02",site="Default",uplink_mac="f0:9f:c2:00:00:01",uplink_port="1",uplink_type=""} 1`),
+				regexp.MustCompile(`unifi_devices_mac_table_entries{id="sw",mac="f0:9f:c2:00:00:02",name="Switch",site="Default",vlan="10"} 3`),
+				regexp.MustCompile(`unifi_devices_mac_table_entries{id="sw",mac="f0:9f:c2:00:00:02",name="Switch",site="Default",vlan="20"} 1`),
+				regexp.MustCompile(`unifi_devices_lag_up{id="sw",lag="3",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_lag_members{id="sw",lag="3",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_lag_members_down{id="sw",lag="3",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_lag_up{id="sw",lag="6",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 0`),
+				regexp.MustCompile(`unifi_devices_lag_members{id="sw",lag="6",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_lag_members_down{id="sw",lag="6",mac="f0:9f:c2:00:00:02",name="Switch",site="Default"} 2`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_port_speed_bits_per_second{[^}]*port="2"`),
+				regexp.MustCompile(`unifi_devices_port_full_duplex{[^}]*port="2"`),
+				regexp.MustCompile(`unifi_devices_lag_[a-z_]+{[^}]*lag="(1|2|4|5)"`),
+				regexp.MustCompile(`unifi_devices_port_stp_state{[^}]*port="2"`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "port configuration",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "sw",
+			"adopted": true,
+			"inform_ip": "192.168.1.2",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "Switch",
+			"type": "usw",
+			"port_table": [
+				{"port_idx": 1, "name": "Port 1", "up": true, "portconf_id": "pc1", "poe_mode": "auto"},
+				{"port_idx": 2, "name": "Port 2", "up": true, "portconf_id": "pc1", "native_networkconf_id": "net3"},
+				{"port_idx": 3, "name": "Port 3", "up": false, "poe_mode": "off"}
+			]
+		}
+	]
+}
+`),
+			portConfig: &testPortConfigSource{
+				networks: []*api.Network{
+					{ID: "net1", Name: "LAN", VLAN: 1},
+					{ID: "net2", Name: "Cameras", VLAN: 20},
+					{ID: "net3", Name: "Guests", VLAN: 30},
+				},
+				profiles: []*api.PortProfile{
+					{ID: "pc1", Name: "Cameras", NativeNetworkID: "net2"},
+				},
+			},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_port_info{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",native_vlan="20",poe_mode="auto",port="1",port_profile="Cameras",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_port_info{id="sw",interface="Port 2",mac="f0:9f:c2:00:00:02",name="Switch",native_vlan="30",poe_mode="",port="2",port_profile="Cameras",site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_port_info{id="sw",interface="Port 3",mac="f0:9f:c2:00:00:02",name="Switch",native_vlan="",poe_mode="off",port="3",port_profile="",site="Default"} 1`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "port configuration unavailable",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "sw",
+			"adopted": true,
+			"inform_ip": "192.168.1.2",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "Switch",
+			"type": "usw",
+			"port_table": [
+				{"port_idx": 1, "name": "Port 1", "up": true, "rx_bytes": 300, "portconf_id": "pc1"}
+			]
+		}
+	]
+}
+`),
+			portConfig: &testPortConfigSource{err: errors.New("not found")},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_port_received_bytes_total{id="sw",interface="Port 1",mac="f0:9f:c2:00:00:02",name="Switch",port="1",site="Default"} 300`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_port_info`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc:             "disconnected devices skipped",
+			skipDisconnected: true,
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "up",
+			"adopted": true,
+			"inform_ip": "192.168.1.4",
+			"mac": "f0:9f:c2:00:00:01",
+			"name": "Up",
+			"type": "uap",
+			"state": 1,
+			"uptime": 10
+		},
+		{
+			"_id": "down",
+			"adopted": true,
+			"inform_ip": "192.168.1.5",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "Down",
+			"type": "uap",
+			"state": 0,
+			"uptime": 20
+		},
+		{
+			"_id": "missed",
+			"adopted": true,
+			"inform_ip": "192.168.1.6",
+			"mac": "f0:9f:c2:00:00:03",
+			"name": "Missed",
+			"type": "uap",
+			"state": 6,
+			"uptime": 30
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices{site="Default"} 3`),
+				regexp.MustCompile(`unifi_devices_state{id="up",mac="f0:9f:c2:00:00:01",name="Up",site="Default",state="connected"} 1`),
+				regexp.MustCompile(`unifi_devices_state{id="down",mac="f0:9f:c2:00:00:02",name="Down",site="Default",state="disconnected"} 1`),
+				regexp.MustCompile(`unifi_devices_state{id="missed",mac="f0:9f:c2:00:00:03",name="Missed",site="Default",state="heartbeat_missed"} 1`),
+				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="up",mac="f0:9f:c2:00:00:01",name="Up",site="Default"} 10`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="(down|missed)"`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "devices pending adoption",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "adopted",
+			"adopted": true,
+			"inform_ip": "192.168.1.2",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "Adopted",
+			"type": "uap",
+			"state": 1
+		},
+		{
+			"_id": "adopting",
+			"adopted": false,
+			"inform_ip": "192.168.1.3",
+			"mac": "f0:9f:c2:00:00:03",
+			"type": "uap",
+			"state": 7
+		},
+		{
+			"_id": "pending",
+			"adopted": false,
+			"inform_ip": "192.168.1.4",
+			"mac": "f0:9f:c2:00:00:04",
+			"model": "USL8LP",
+			"type": "usw",
+			"state": 2
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_unadopted{site="Default"} 2`),
+				regexp.MustCompile(`unifi_devices_pending_adoption{site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_pending_adoption_info{ip="192.168.1.4",mac="f0:9f:c2:00:00:04",model="USL8LP",site="Default",type="usw"} 1`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_pending_adoption_info{[^}]*mac="f0:9f:c2:00:00:0(2|3)"`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "device firmware versions and upgrades",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "old",
+			"adopted": true,
+			"inform_ip": "192.168.1.4",
+			"mac": "f0:9f:c2:00:00:01",
+			"name": "Old",
+			"type": "uap",
+			"model": "U7PG2",
+			"version": "6.5.28",
+			"upgradable": true,
+			"state": 1
+		},
+		{
+			"_id": "new",
+			"adopted": true,
+			"inform_ip": "192.168.1.5",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "New",
+			"type": "uap",
+			"model": "U7PG2",
+			"version": "6.6.55",
+			"state": 1
+		},
+		{
+			"_id": "upgrading",
+			"adopted": true,
+			"inform_ip": "192.168.1.6",
+			"mac": "f0:9f:c2:00:00:03",
+			"name": "Upgrading",
+			"type": "uap",
+			"model": "U7PG2",
+			"version": "6.5.28",
+			"upgradable": true,
+			"state": 4
+		},
+		{
+			"_id": "unknown",
+			"adopted": true,
+			"inform_ip": "192.168.1.7",
+			"mac": "f0:9f:c2:00:00:04",
+			"name": "Unknown",
+			"type": "uap",
+			"state": 1
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_by_version{model="U7PG2",site="Default",version="6.5.28"} 2`),
+				regexp.MustCompile(`unifi_devices_by_version{model="U7PG2",site="Default",version="6.6.55"} 1`),
+				regexp.MustCompile(`unifi_devices_by_version{model="",site="Default",version=""} 1`),
+				regexp.MustCompile(`unifi_devices_upgrading{site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_upgradable{site="Default"} 2`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "devices by state",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "up",
+			"adopted": true,
+			"inform_ip": "192.168.1.4",
+			"mac": "f0:9f:c2:00:00:01",
+			"name": "Up",
+			"type": "uap",
+			"state": 1
+		},
+		{
+			"_id": "down",
+			"adopted": true,
+			"inform_ip": "192.168.1.5",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "Down",
+			"type": "uap",
+			"state": 0
+		},
+		{
+			"_id": "missed",
+			"adopted": true,
+			"inform_ip": "192.168.1.6",
+			"mac": "f0:9f:c2:00:00:03",
+			"name": "Missed",
+			"type": "uap",
+			"state": 6
+		},
+		{
+			"_id": "provisioning",
+			"adopted": true,
+			"inform_ip": "192.168.1.7",
+			"mac": "f0:9f:c2:00:00:04",
+			"name": "Provisioning",
+			"type": "uap",
+			"state": 5
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_by_state{site="Default",state="connected"} 1`),
+				regexp.MustCompile(`unifi_devices_by_state{site="Default",state="disconnected"} 1`),
+				regexp.MustCompile(`unifi_devices_by_state{site="Default",state="heartbeat_missed"} 1`),
+				regexp.MustCompile(`unifi_devices_by_state{site="Default",state="provisioning"} 1`),
+				regexp.MustCompile(`unifi_devices_state{id="provisioning",mac="f0:9f:c2:00:00:04",name="Provisioning",site="Default",state="provisioning"} 1`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "malformed MAC table entries",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "sw",
+			"adopted": true,
+			"inform_ip": "192.168.1.2",
+			"mac": "f0:9f:c2:00:00:02",
+			"name": "Switch",
+			"type": "usw",
+			"port_table": [
+				{"port_idx": 1, "name": "Port 1", "up": true, "mac_table": [{"mac": "00:00:5e:00:53:01", "vlan": 10}, {"mac": "not a MAC", "vlan": 10}, {"mac": "", "vlan": 20}]}
+			]
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices{site="Default"} 1`),
+				regexp.MustCompile(`unifi_devices_mac_table_entries{id="sw",mac="f0:9f:c2:00:00:02",name="Switch",site="Default",vlan="10"} 1`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_mac_table_entries{.*vlan="20"}`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "gateway WANs",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "gw",
+			"adopted": true,
+			"inform_ip": "192.168.1.1",
+			"mac": "f0:9f:c2:00:00:01",
+			"name": "Gateway",
+			"type": "ugw",
+			"wan1": {"ifname": "eth0", "ip": "203.0.113.10", "up": true},
+			"wan2": {"ifname": "eth2", "ip": "", "up": false},
+			"uptime_stats": {
+				"WAN": {
+					"availability": 99.5,
+					"latency_average": 12,
+					"time_period": 86400,
+					"monitors": [
+						{"availability": 100, "latency_average": 10, "target": "ping.ui.com", "type": "icmp"}
+					]
+				}
+			}
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_wan_info{id="gw",interface="eth0",ip="203.0.113.10",isp="",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 1`),
+				regexp.MustCompile(`unifi_devices_wan_info{id="gw",interface="eth2",ip="",isp="",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan2"} 1`),
+				regexp.MustCompile(`unifi_devices_wan_ip_changes_total{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 0`),
+				regexp.MustCompile(`unifi_devices_wan_availability_ratio{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 0.995`),
+				regexp.MustCompile(`unifi_devices_wan_latency_seconds{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 0.012`),
+				regexp.MustCompile(`unifi_devices_wan_period_seconds{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 86400`),
+				regexp.MustCompile(`unifi_devices_wan_monitor_availability_ratio{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",target="ping.ui.com",type="icmp",wan="wan1"} 1`),
+				regexp.MustCompile(`unifi_devices_wan_monitor_latency_seconds{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",target="ping.ui.com",type="icmp",wan="wan1"} 0.01`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "gateway WAN ISPs",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "gw",
+			"adopted": true,
+			"inform_ip": "192.168.1.1",
+			"mac": "f0:9f:c2:00:00:01",
+			"name": "Gateway",
+			"type": "ugw",
+			"wan1": {"ifname": "eth0", "ip": "203.0.113.10", "up": true},
+			"wan2": {"ifname": "eth2", "ip": "198.51.100.20", "up": true}
+		}
+	]
+}
+`),
+			wanHealth: &testWANHealthSource{
+				health: []*api.Health{
+					{Subsystem: "wlan", Status: "ok"},
+					{Subsystem: "wan", Status: "ok", WANIP: net.ParseIP("203.0.113.10"), ISPName: "Example ISP"},
+				},
+			},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_wan_info{id="gw",interface="eth0",ip="203.0.113.10",isp="Example ISP",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 1`),
+				regexp.MustCompile(`unifi_devices_wan_info{id="gw",interface="eth2",ip="198.51.100.20",isp="",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan2"} 1`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "gateway WAN health unavailable",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "gw",
+			"adopted": true,
+			"inform_ip": "192.168.1.1",
+			"mac": "f0:9f:c2:00:00:01",
+			"name": "Gateway",
+			"type": "ugw",
+			"wan1": {"ifname": "eth0", "ip": "203.0.113.10", "up": true}
+		}
+	]
+}
+`),
+			wanHealth: &testWANHealthSource{err: errors.New("not found")},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_wan_ip_changes_total{id="gw",mac="f0:9f:c2:00:00:01",name="Gateway",site="Default",wan="wan1"} 0`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices_wan_info`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
 	}
 
 	for i, tt := range tests {
 		t.Logf("[%02d] test %q", i, tt.desc)
 
-		out := testDeviceCollector(t, []byte(tt.input), tt.sites)
+		out := testDeviceCollector(t, []byte(tt.input), tt.sites, tt.skipDisconnected, tt.portConfig, tt.wanHealth)
 
 		for j, m := range tt.matches {
 			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
@@ -383,10 +929,49 @@ func TestDeviceCollector(t *testing.T) {
 				t.Fatal("\toutput failed to match regex.")
 			}
 		}
+
+		for j, m := range tt.nomatch {
+			t.Logf("\t[%02d:%02d] no match: %s", i, j, m.String())
+
+			if m.Match(out) {
+				fmt.Println(string(out))
+				t.Fatal("\toutput unexpectedly matched regex.")
+			}
+		}
 	}
 }
 
-func testDeviceCollector(t *testing.T, input []byte, sites []*api.Site) []byte {
+func TestWANTracker(t *testing.T) {
+	tr := newWANTracker()
+
+	tests := []struct {
+		desc string
+		ip   string
+		want float64
+	}{
+		{desc: "first address", ip: "203.0.113.10", want: 0},
+		{desc: "same address", ip: "203.0.113.10", want: 0},
+		{desc: "address lost", ip: "", want: 0},
+		{desc: "same address again", ip: "203.0.113.10", want: 0},
+		{desc: "new address", ip: "203.0.113.20", want: 1},
+		{desc: "address lost then changed", ip: "", want: 1},
+		{desc: "another address", ip: "203.0.113.30", want: 2},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.want, tr.observe("gw/wan1", tt.ip); want != got {
+			t.Fatalf("unexpected number of changes:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	if want, got := 0.0, tr.observe("gw/wan2", "203.0.113.40"); want != got {
+		t.Fatalf("unexpected number of changes for another WAN:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func testDeviceCollector(t *testing.T, input []byte, sites []*api.Site, skipDisconnected bool, portConfig PortConfigSource, wanHealth WANHealthSource) []byte {
 	c, done := testUniFiClient(t, input)
 	defer done()
 
@@ -394,6 +979,39 @@ func testDeviceCollector(t *testing.T, input []byte, sites []*api.Site) []byte {
 		c,
 		sites,
 	)
+	collector.skipDisconnected = skipDisconnected
+	collector.portConfig = portConfig
+	collector.wanHealth = wanHealth
+	collector.now = func() time.Time {
+		return time.Unix(1500000030, 0)
+	}
 
 	return testCollector(t, collector)
 }
+
+// A testPortConfigSource is a PortConfigSource which returns fixed networks
+// and port profiles, or err.
+type testPortConfigSource struct {
+	networks []*api.Network
+	profiles []*api.PortProfile
+	err      error
+}
+
+func (s *testPortConfigSource) Networks(_ context.Context, _ string) ([]*api.Network, error) {
+	return s.networks, s.err
+}
+
+func (s *testPortConfigSource) PortProfiles(_ context.Context, _ string) ([]*api.PortProfile, error) {
+	return s.profiles, s.err
+}
+
+// A testWANHealthSource is a WANHealthSource which returns fixed health, or
+// err.
+type testWANHealthSource struct {
+	health []*api.Health
+	err    error
+}
+
+func (s *testWANHealthSource) Health(_ context.Context, _ string) ([]*api.Health, error) {
+	return s.health, s.err
+}
@@ -0,0 +1,227 @@
+package exporter
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultDPILimit is the default maximum number of stations per site
+	// for which a DPICollector exports metrics.
+	DefaultDPILimit = 100
+)
+
+// A DPICollector is a Prometheus collector for deep packet inspection (DPI)
+// metrics regarding Ubiquiti UniFi stations (clients).
+//
+// Because DPI metrics are exported for every combination of station and
+// application category, a DPICollector only exports metrics for a limited
+// number of the busiest stations in each site.
+type DPICollector struct {
+	ReceivedBytesTotal    *prometheus.Desc
+	TransmittedBytesTotal *prometheus.Desc
+
+	ReceivedPacketsTotal    *prometheus.Desc
+	TransmittedPacketsTotal *prometheus.Desc
+
+	c     api.Controller
+	sites []*api.Site
+	limit int
+
+	// concurrency is the number of sites from which DPI statistics are
+	// retrieved at once; zero retrieves them one site at a time.
+	concurrency int
+
+	// siteLabel is the source of the site label, such as SiteLabelName.
+	siteLabel string
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+
+	// privacy determines how station MAC addresses are exported.
+	privacy privacy
+}
+
+// Verify that the Exporter implements the collector interface.
+var _ collector = &DPICollector{}
+
+// NewDPICollector creates a new DPICollector which collects metrics for
+// a specified site.  At most limit stations per site are exported; if limit
+// is zero or less, DefaultDPILimit is used.
+func NewDPICollector(c api.Controller, sites []*api.Site, limit int) *DPICollector {
+	return newDPICollector(namespace, c, sites, limit)
+}
+
+// newDPICollector is like NewDPICollector, but names its metrics within namespace
+// ns.
+func newDPICollector(ns string, c api.Controller, sites []*api.Site, limit int) *DPICollector {
+	const (
+		subsystem = "stations_dpi"
+	)
+
+	var (
+		labelsDPI = []string{"site", "station_mac", "category"}
+	)
+
+	if limit <= 0 {
+		limit = DefaultDPILimit
+	}
+
+	return &DPICollector{
+		ReceivedBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "received_bytes_total"),
+			"Number of bytes received from stations, by DPI application category",
+			labelsDPI,
+			nil,
+		),
+
+		TransmittedBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "transmitted_bytes_total"),
+			"Number of bytes transmitted to stations, by DPI application category",
+			labelsDPI,
+			nil,
+		),
+
+		ReceivedPacketsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "received_packets_total"),
+			"Number of packets received from stations, by DPI application category",
+			labelsDPI,
+			nil,
+		),
+
+		TransmittedPacketsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "transmitted_packets_total"),
+			"Number of packets transmitted to stations, by DPI application category",
+			labelsDPI,
+			nil,
+		),
+
+		c:     c,
+		sites: sites,
+		limit: limit,
+	}
+}
+
+// collect begins a metrics collection task for all DPI metrics related to
+// UniFi stations.
+func (c *DPICollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	err := forEachSite(ctx, c.sites, c.concurrency, func(ctx context.Context, s *api.Site) error {
+		dpi, err := c.c.StationDPI(ctx, s.Name)
+		if err != nil {
+			return err
+		}
+
+		// Without MAC addresses, stations cannot be told apart
+		if c.privacy.mode == PrivacyDrop {
+			dpi = combineDPI(dpi)
+		}
+
+		if len(dpi) > c.limit {
+			logf(c.logger, "[WARN] site %q has DPI statistics for %d stations, only exporting the busiest %d",
+				s.Description, len(dpi), c.limit)
+
+			sort.SliceStable(dpi, func(i, j int) bool {
+				return dpiTotalBytes(dpi[i]) > dpiTotalBytes(dpi[j])
+			})
+			dpi = dpi[:c.limit]
+		}
+
+		c.collectDPIBytes(ch, siteLabel(c.siteLabel, s), dpi)
+		return nil
+	})
+	if err != nil {
+		return c.ReceivedBytesTotal, err
+	}
+
+	return nil, nil
+}
+
+// dpiTotalBytes returns the total number of bytes received and transmitted
+// by a station across all DPI categories.
+func dpiTotalBytes(s *api.StationDPI) float64 {
+	var total float64
+	for _, cat := range s.Categories {
+		total += cat.ReceiveBytes + cat.TransmitBytes
+	}
+
+	return total
+}
+
+// collectDPIBytes collects receive and transmit byte and packet counts for
+// each DPI category of UniFi stations.
+func (c *DPICollector) collectDPIBytes(ch chan<- prometheus.Metric, siteLabel string, dpi []*api.StationDPI) {
+	for _, s := range dpi {
+		for _, cat := range s.Categories {
+			labels := []string{
+				siteLabel,
+				c.privacy.identifier(s.MAC.String()),
+				cat.Category,
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.ReceivedBytesTotal,
+				prometheus.CounterValue,
+				cat.ReceiveBytes,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.TransmittedBytesTotal,
+				prometheus.CounterValue,
+				cat.TransmitBytes,
+				labels...,
+			)
+
+			ch <- prometheus.MustNewConstMetric(
+				c.ReceivedPacketsTotal,
+				prometheus.CounterValue,
+				cat.ReceivePackets,
+				labels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.TransmittedPacketsTotal,
+				prometheus.CounterValue,
+				cat.TransmitPackets,
+				labels...,
+			)
+		}
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *DPICollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.ReceivedBytesTotal,
+		c.TransmittedBytesTotal,
+
+		c.ReceivedPacketsTotal,
+		c.TransmittedPacketsTotal,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect is the same as CollectError, but ignores any errors which occur.
+// Collect exists to satisfy the prometheus.Collector interface.
+func (c *DPICollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.CollectError(context.Background(), ch)
+}
+
+// CollectError sends the metric values for each metric pertaining to the global
+// cluster usage over to the provided prometheus Metric channel, returning any
+// errors which occur.  Requests to the UniFi Controller are cancelled when ctx
+// is done.  Errors are logged and returned, but are not sent over ch.
+func (c *DPICollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting DPI metric %v: %v", desc, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+func TestDPICollector(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		input   string
+		limit   int
+		privacy privacy
+		sites   []*api.Site
+		matches []*regexp.Regexp
+		nomatch []*regexp.Regexp
+	}{
+		{
+			desc: "one station, one site",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"mac": "de:ad:be:ef:de:ad",
+			"by_cat": [
+				{
+					"cat": 4,
+					"rx_bytes": 100,
+					"rx_packets": 10,
+					"tx_bytes": 2000,
+					"tx_packets": 20
+				},
+				{
+					"cat": 18,
+					"rx_bytes": 1,
+					"rx_packets": 1,
+					"tx_bytes": 2,
+					"tx_packets": 1
+				},
+				{
+					"cat": 20,
+					"rx_bytes": 1,
+					"rx_packets": 1,
+					"tx_bytes": 2,
+					"tx_packets": 1
+				},
+				{
+					"cat": 99,
+					"rx_bytes": 5,
+					"rx_packets": 1,
+					"tx_bytes": 5,
+					"tx_packets": 1
+				}
+			]
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_stations_dpi_received_bytes_total{category="Streaming Media",site="Default",station_mac="de:ad:be:ef:de:ad"} 100`),
+				regexp.MustCompile(`unifi_stations_dpi_transmitted_bytes_total{category="Streaming Media",site="Default",station_mac="de:ad:be:ef:de:ad"} 2000`),
+				regexp.MustCompile(`unifi_stations_dpi_received_packets_total{category="Streaming Media",site="Default",station_mac="de:ad:be:ef:de:ad"} 10`),
+				regexp.MustCompile(`unifi_stations_dpi_transmitted_packets_total{category="Streaming Media",site="Default",station_mac="de:ad:be:ef:de:ad"} 20`),
+
+				regexp.MustCompile(`unifi_stations_dpi_received_bytes_total{category="Network Protocols",site="Default",station_mac="de:ad:be:ef:de:ad"} 2`),
+				regexp.MustCompile(`unifi_stations_dpi_transmitted_bytes_total{category="Network Protocols",site="Default",station_mac="de:ad:be:ef:de:ad"} 4`),
+
+				regexp.MustCompile(`unifi_stations_dpi_received_bytes_total{category="99",site="Default",station_mac="de:ad:be:ef:de:ad"} 5`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc:  "two stations, one site, limit one",
+			limit: 1,
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"mac": "de:ad:be:ef:de:ad",
+			"by_cat": [{
+				"cat": 13,
+				"rx_bytes": 10,
+				"tx_bytes": 20
+			}]
+		},
+		{
+			"mac": "ab:ad:1d:ea:ab:ad",
+			"by_cat": [{
+				"cat": 13,
+				"rx_bytes": 100,
+				"tx_bytes": 200
+			}]
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_stations_dpi_received_bytes_total{category="Web",site="Default",station_mac="ab:ad:1d:ea:ab:ad"} 100`),
+				regexp.MustCompile(`unifi_stations_dpi_transmitted_bytes_total{category="Web",site="Default",station_mac="ab:ad:1d:ea:ab:ad"} 200`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`station_mac="de:ad:be:ef:de:ad"`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc:    "two stations, one site, dropped identifiers",
+			limit:   1,
+			privacy: privacy{mode: PrivacyDrop},
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"mac": "de:ad:be:ef:de:ad",
+			"by_cat": [{
+				"cat": 13,
+				"rx_bytes": 10,
+				"tx_bytes": 20
+			}]
+		},
+		{
+			"mac": "ab:ad:1d:ea:ab:ad",
+			"by_cat": [{
+				"cat": 13,
+				"rx_bytes": 100,
+				"tx_bytes": 200
+			}]
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_stations_dpi_received_bytes_total{category="Web",site="Default",station_mac=""} 110`),
+				regexp.MustCompile(`unifi_stations_dpi_transmitted_bytes_total{category="Web",site="Default",station_mac=""} 220`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`station_mac="[^"]`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		out := testDPICollector(t, []byte(tt.input), tt.sites, tt.limit, tt.privacy)
+
+		for j, m := range tt.matches {
+			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
+
+			if !m.Match(out) {
+				t.Fatal("\toutput failed to match regex")
+			}
+		}
+
+		for j, m := range tt.nomatch {
+			t.Logf("\t[%02d:%02d] no match: %s", i, j, m.String())
+
+			if m.Match(out) {
+				t.Fatal("\toutput unexpectedly matched regex")
+			}
+		}
+	}
+}
+
+func testDPICollector(t *testing.T, input []byte, sites []*api.Site, limit int, p privacy) []byte {
+	c, done := testUniFiClient(t, input)
+	defer done()
+
+	collector := NewDPICollector(
+		c,
+		sites,
+		limit,
+	)
+	collector.privacy = p
+
+	return testCollector(t, collector)
+}
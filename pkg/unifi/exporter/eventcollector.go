@@ -0,0 +1,495 @@
+package exporter
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// An EventSource opens streams of events from a UniFi Controller.  An
+// *api.Client is an EventSource.
+type EventSource interface {
+	Events(ctx context.Context, siteName string) (*api.EventStream, error)
+}
+
+// Verify that the Client implements the EventSource interface.
+var _ EventSource = &api.Client{}
+
+// An EventCollector is a Prometheus collector which counts the events, such as
+// stations roaming or devices losing contact, which a UniFi Controller reports
+// for each site.
+//
+// Unlike other collectors, an EventCollector does not retrieve anything when
+// it is collected: once started, it keeps a stream of events open for each
+// site, and counts each event as it arrives.
+type EventCollector struct {
+	EventsTotal          *prometheus.Desc
+	RadarDetectionsTotal *prometheus.Desc
+	RoamsTotal           *prometheus.Desc
+	RadioRoamsTotal      *prometheus.Desc
+	StationFailuresTotal *prometheus.Desc
+	AdminLoginsTotal     *prometheus.Desc
+	StreamUp             *prometheus.Desc
+
+	// siteLabel is the source of the site label, such as SiteLabelName.
+	siteLabel string
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+
+	// onEvent, if set, is called with each event.
+	onEvent EventFunc
+
+	// retry is how long to wait before opening a stream again after it
+	// ends; swappable for tests.
+	retry time.Duration
+
+	mu     sync.Mutex
+	counts map[eventCount]float64
+	radar  map[radarCount]float64
+	roams  map[roamCount]float64
+	radios map[radioRoamCount]float64
+	fails  map[failureCount]float64
+	logins map[loginCount]float64
+	up     map[string]bool
+	stop   context.CancelFunc
+}
+
+// An eventCount identifies the count of events with a key in a site.
+type eventCount struct {
+	site string
+	key  string
+}
+
+// A roamCount identifies the count of stations roaming to or from an access
+// point in a site.
+type roamCount struct {
+	site      string
+	apMAC     string
+	direction string
+}
+
+// A radioRoamCount identifies the count of stations moving between the radios
+// of an access point in a site.
+type radioRoamCount struct {
+	site      string
+	apMAC     string
+	radioFrom string
+	radioTo   string
+}
+
+// A failureCount identifies the count of failed connections of stations to
+// an access point and SSID in a site, by event key.
+type failureCount struct {
+	site  string
+	apMAC string
+	ssid  string
+	key   string
+}
+
+// A loginCount identifies the count of logins of an admin to a site, by
+// whether they succeeded.  admin is empty for failed logins.
+type loginCount struct {
+	site   string
+	admin  string
+	result string
+}
+
+// Keys of the events which the UniFi Controller reports when a station roams
+// to another access point, and when it moves to another radio of the same
+// access point, such as when band steering moves it to 5 GHz.
+const (
+	eventKeyRoam      = "EVT_WU_Roam"
+	eventKeyRoamRadio = "EVT_WU_RoamRadio"
+)
+
+// A radarCount identifies the count of radar detections by a radio of an
+// access point on a channel in a site.
+type radarCount struct {
+	site    string
+	apMAC   string
+	radio   string
+	channel string
+}
+
+// Verify that the EventCollector implements the collector interface.
+var _ collector = &EventCollector{}
+
+// eventRetryInterval is the default time an EventCollector waits before
+// opening a stream of events again after it ends.
+const eventRetryInterval = 30 * time.Second
+
+// NewEventCollector creates a new EventCollector.  Start must be called to
+// begin counting events.
+func NewEventCollector() *EventCollector {
+	return newEventCollector(namespace)
+}
+
+// newEventCollector is like NewEventCollector, but names its metrics within
+// namespace ns.
+func newEventCollector(ns string) *EventCollector {
+	const (
+		subsystem = "events"
+	)
+
+	return &EventCollector{
+		EventsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "total"),
+			"Number of events reported by the UniFi Controller since the exporter started, by event key",
+			[]string{"site", "key"},
+			nil,
+		),
+
+		RadarDetectionsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "radar_detections_total"),
+			"Number of DFS radar detections reported by the UniFi Controller since the exporter started, by access point, radio and channel",
+			[]string{"site", "ap_mac", "radio", "channel"},
+			nil,
+		),
+
+		RoamsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "roams_total"),
+			"Number of stations which roamed to (in) or from (out) each access point since the exporter started",
+			[]string{"site", "ap_mac", "direction"},
+			nil,
+		),
+
+		RadioRoamsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "radio_roams_total"),
+			"Number of stations which moved between the radios of each access point since the exporter started",
+			[]string{"site", "ap_mac", "radio_from", "radio_to"},
+			nil,
+		),
+
+		StationFailuresTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "station_failures_total"),
+			"Number of failed associations, authentications and address assignments of stations since the exporter started, by access point, SSID and event key",
+			[]string{"site", "ap_mac", "ssid", "key"},
+			nil,
+		),
+
+		AdminLoginsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "admin_logins_total"),
+			"Number of logins of admins to the UniFi Controller since the exporter started, by whether they succeeded and, for successful logins, by admin",
+			[]string{"site", "admin", "result"},
+			nil,
+		),
+
+		StreamUp: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "stream_up"),
+			"Whether the stream of events from the UniFi Controller is open for each site",
+			[]string{"site"},
+			nil,
+		),
+
+		retry:  eventRetryInterval,
+		counts: make(map[eventCount]float64),
+		radar:  make(map[radarCount]float64),
+		roams:  make(map[roamCount]float64),
+		radios: make(map[radioRoamCount]float64),
+		fails:  make(map[failureCount]float64),
+		logins: make(map[loginCount]float64),
+		up:     make(map[string]bool),
+	}
+}
+
+// Start opens a stream of events from src for each of sites, stopping any
+// streams opened by a previous call to Start.  A stream which ends, such as
+// when the UniFi Controller restarts, is opened again until Stop is called.
+//
+// Counts of events are kept across calls to Start, but sites which are no
+// longer streamed are not reported as down.
+func (c *EventCollector) Start(src EventSource, sites []*api.Site) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Streams of a previous call may still be ending, so each call tracks
+	// whether its own streams are up
+	up := make(map[string]bool, len(sites))
+
+	c.mu.Lock()
+	if c.stop != nil {
+		c.stop()
+	}
+	c.stop = cancel
+	c.up = up
+	c.mu.Unlock()
+
+	for _, s := range sites {
+		go c.stream(ctx, src, s, up)
+	}
+}
+
+// Stop closes the streams opened by Start.
+func (c *EventCollector) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stop != nil {
+		c.stop()
+		c.stop = nil
+	}
+	c.up = make(map[string]bool)
+}
+
+// stream counts the events of site until ctx is done, recording whether its
+// stream is open in up.
+func (c *EventCollector) stream(ctx context.Context, src EventSource, site *api.Site, up map[string]bool) {
+	label := siteLabel(c.siteLabel, site)
+
+	setUp := func(ok bool) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		up[label] = ok
+	}
+
+	for {
+		setUp(false)
+
+		s, err := src.Events(ctx, site.Name)
+		if err == nil {
+			setUp(true)
+			for e := range s.C {
+				c.count(label, e)
+				if c.onEvent != nil {
+					c.onEvent(site, e)
+				}
+			}
+			setUp(false)
+
+			err = s.Err()
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logf(c.logger, "[WARN] event stream for site %q ended, opening it again in %s: %v", site.Name, c.retry, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.retry):
+		}
+	}
+}
+
+// count counts an event in site.
+func (c *EventCollector) count(site string, e *api.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[eventCount{site: site, key: e.Key}]++
+
+	switch e.Key {
+	case eventKeyRoam:
+		if e.APFromMAC != "" {
+			c.roams[roamCount{site: site, apMAC: e.APFromMAC, direction: "out"}]++
+		}
+		if e.APToMAC != "" {
+			c.roams[roamCount{site: site, apMAC: e.APToMAC, direction: "in"}]++
+		}
+	case eventKeyRoamRadio:
+		if e.APMAC != "" {
+			c.radios[radioRoamCount{
+				site:      site,
+				apMAC:     e.APMAC,
+				radioFrom: e.RadioFrom,
+				radioTo:   e.RadioTo,
+			}]++
+		}
+	}
+
+	if isStationFailureEvent(e) {
+		c.fails[failureCount{
+			site:  site,
+			apMAC: e.APMAC,
+			ssid:  e.SSID,
+			key:   e.Key,
+		}]++
+	}
+
+	if result, ok := adminLoginResult(e); ok {
+		// A failed login names whatever username was tried, which anyone
+		// who can reach the controller can vary without bound, so only
+		// successful logins are counted by admin
+		admin := e.Admin
+		if result == "failure" {
+			admin = ""
+		}
+
+		c.logins[loginCount{
+			site:   site,
+			admin:  admin,
+			result: result,
+		}]++
+	}
+
+	if isRadarEvent(e) {
+		// An access point which detects radar leaves the channel it was on
+		channel := e.ChannelFrom
+		if channel == "" {
+			channel = e.Channel
+		}
+		radio := e.RadioFrom
+		if radio == "" {
+			radio = e.Radio
+		}
+
+		c.radar[radarCount{
+			site:    site,
+			apMAC:   e.APMAC,
+			radio:   radio,
+			channel: channel,
+		}]++
+	}
+}
+
+// stationFailureKeys are the keys of the events which the UniFi Controller
+// reports when a user (EVT_WU_) or guest (EVT_WG_) station fails to connect
+// to an access point, such as with a wrong pre-shared key, a rejection by a
+// RADIUS server or no reply to DHCP.
+var stationFailureKeys = map[string]bool{
+	"EVT_WU_AssocFailure":      true,
+	"EVT_WU_AuthFailure":       true,
+	"EVT_WU_RadiusAuthFailure": true,
+	"EVT_WU_RadiusRejected":    true,
+	"EVT_WU_DhcpTimeout":       true,
+	"EVT_WG_AssocFailure":      true,
+	"EVT_WG_AuthFailure":       true,
+	"EVT_WG_DhcpTimeout":       true,
+}
+
+// isStationFailureEvent reports whether e is a failure of a station to
+// connect to an access point.
+func isStationFailureEvent(e *api.Event) bool {
+	return e.StationMAC != "" && e.APMAC != "" && stationFailureKeys[e.Key]
+}
+
+// adminLoginResult reports whether e is a login of an admin, and if so,
+// whether it succeeded or failed.  Admin events have keys beginning with
+// EVT_AD_, such as EVT_AD_Login.
+func adminLoginResult(e *api.Event) (string, bool) {
+	key := strings.ToLower(e.Key)
+	if !strings.HasPrefix(key, "evt_ad_") || !strings.Contains(key, "login") {
+		return "", false
+	}
+
+	if strings.Contains(key, "fail") {
+		return "failure", true
+	}
+
+	return "success", true
+}
+
+// isRadarEvent reports whether e is a DFS radar detection by an access point.
+// The key of these events differs between versions of the UniFi Controller,
+// but always mentions radar.
+func isRadarEvent(e *api.Event) bool {
+	return e.APMAC != "" && strings.Contains(strings.ToLower(e.Key), "radar")
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *EventCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.EventsTotal,
+		c.RadarDetectionsTotal,
+		c.RoamsTotal,
+		c.RadioRoamsTotal,
+		c.StationFailuresTotal,
+		c.AdminLoginsTotal,
+		c.StreamUp,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect sends the number of events, roams, station failures, admin logins
+// and radar detections counted so far, and whether each stream of events is
+// open, over to the provided prometheus Metric channel.
+func (c *EventCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, n := range c.counts {
+		ch <- prometheus.MustNewConstMetric(
+			c.EventsTotal,
+			prometheus.CounterValue,
+			n,
+			k.site, k.key,
+		)
+	}
+
+	for k, n := range c.radar {
+		ch <- prometheus.MustNewConstMetric(
+			c.RadarDetectionsTotal,
+			prometheus.CounterValue,
+			n,
+			k.site, k.apMAC, k.radio, k.channel,
+		)
+	}
+
+	for k, n := range c.roams {
+		ch <- prometheus.MustNewConstMetric(
+			c.RoamsTotal,
+			prometheus.CounterValue,
+			n,
+			k.site, k.apMAC, k.direction,
+		)
+	}
+
+	for k, n := range c.radios {
+		ch <- prometheus.MustNewConstMetric(
+			c.RadioRoamsTotal,
+			prometheus.CounterValue,
+			n,
+			k.site, k.apMAC, k.radioFrom, k.radioTo,
+		)
+	}
+
+	for k, n := range c.fails {
+		ch <- prometheus.MustNewConstMetric(
+			c.StationFailuresTotal,
+			prometheus.CounterValue,
+			n,
+			k.site, k.apMAC, k.ssid, k.key,
+		)
+	}
+
+	for k, n := range c.logins {
+		ch <- prometheus.MustNewConstMetric(
+			c.AdminLoginsTotal,
+			prometheus.CounterValue,
+			n,
+			k.site, k.admin, k.result,
+		)
+	}
+
+	for site, ok := range c.up {
+		var v float64
+		if ok {
+			v = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.StreamUp,
+			prometheus.GaugeValue,
+			v,
+			site,
+		)
+	}
+}
+
+// CollectError is the same as Collect.  Events are counted as they arrive, so
+// there is nothing to retrieve which could fail.
+func (c *EventCollector) CollectError(_ context.Context, ch chan<- prometheus.Metric) error {
+	c.Collect(ch)
+	return nil
+}
@@ -0,0 +1,177 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/gorilla/websocket"
+)
+
+func TestEventCollector(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		msgs := []string{
+			`{"meta":{"rc":"ok","message":"sta:sync"},"data":[{"mac":"de:ad:be:ef:de:ad"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_WU_Roam","ap_from":"f0:9f:c2:00:00:01","ap_to":"f0:9f:c2:00:00:02"},{"key":"EVT_WU_Roam","ap_from":"f0:9f:c2:00:00:02","ap_to":"f0:9f:c2:00:00:01"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_WU_AuthFailure","ap":"f0:9f:c2:00:00:01","user":"de:ad:be:ef:de:ad","ssid":"home"},{"key":"EVT_WU_DhcpTimeout","ap":"f0:9f:c2:00:00:01","user":"de:ad:be:ef:de:ad","ssid":"home"},{"key":"EVT_WU_Disconnected","ap":"f0:9f:c2:00:00:01","user":"de:ad:be:ef:de:ad","ssid":"home"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_AD_Login","admin":"alice","ip":"192.0.2.10"},{"key":"EVT_AD_LoginFailed","admin":"alice","ip":"198.51.100.7"},{"key":"EVT_AD_LoginFailed","admin":"alice","ip":"198.51.100.7"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_AD_LoginFailed","admin":"root","ip":"198.51.100.7"},{"key":"EVT_AD_LoginFailed","admin":"administrator","ip":"198.51.100.7"},{"key":"EVT_AD_LoginFailed","admin":"ubnt","ip":"198.51.100.7"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_WU_RoamRadio","ap":"f0:9f:c2:00:00:01","radio_from":"ng","radio_to":"na"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_AP_Lost_Contact"}]}`,
+			`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_AP_RadarDetected","ap":"F0:9F:C2:00:00:01","radio":"na","channel":52},{"key":"EVT_AP_RadarDetected","ap":"f0:9f:c2:00:00:01","radio_from":"na","channel_from":"52","channel_to":"36"}]}`,
+		}
+		for _, m := range msgs {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+				t.Errorf("failed to write message: %v", err)
+				return
+			}
+		}
+
+		// Keep the stream open until the test is done
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	c, err := api.NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create UniFi client: %v", err)
+	}
+
+	collector := NewEventCollector()
+	collector.retry = 10 * time.Millisecond
+	collector.Start(c, []*api.Site{{
+		Name:        "default",
+		Description: "Default",
+	}})
+	defer collector.Stop()
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_events_total{key="EVT_WU_Roam",site="Default"} 2`),
+		regexp.MustCompile(`unifi_events_total{key="EVT_AP_Lost_Contact",site="Default"} 1`),
+		regexp.MustCompile(`unifi_events_total{key="EVT_AP_RadarDetected",site="Default"} 2`),
+		regexp.MustCompile(`unifi_events_radar_detections_total{ap_mac="f0:9f:c2:00:00:01",channel="52",radio="na",site="Default"} 2`),
+		regexp.MustCompile(`unifi_events_roams_total{ap_mac="f0:9f:c2:00:00:01",direction="in",site="Default"} 1`),
+		regexp.MustCompile(`unifi_events_roams_total{ap_mac="f0:9f:c2:00:00:01",direction="out",site="Default"} 1`),
+		regexp.MustCompile(`unifi_events_roams_total{ap_mac="f0:9f:c2:00:00:02",direction="in",site="Default"} 1`),
+		regexp.MustCompile(`unifi_events_radio_roams_total{ap_mac="f0:9f:c2:00:00:01",radio_from="ng",radio_to="na",site="Default"} 1`),
+		regexp.MustCompile(`unifi_events_station_failures_total{ap_mac="f0:9f:c2:00:00:01",key="EVT_WU_AuthFailure",site="Default",ssid="home"} 1`),
+		regexp.MustCompile(`unifi_events_station_failures_total{ap_mac="f0:9f:c2:00:00:01",key="EVT_WU_DhcpTimeout",site="Default",ssid="home"} 1`),
+		regexp.MustCompile(`unifi_events_admin_logins_total{admin="alice",result="success",site="Default"} 1`),
+		regexp.MustCompile(`unifi_events_admin_logins_total{admin="",result="failure",site="Default"} 5`),
+		regexp.MustCompile(`unifi_events_stream_up{site="Default"} 1`),
+	}
+
+	// Events arrive in the background, so wait for them to be counted
+	var out []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out = testCollector(t, collector)
+
+		matched := true
+		for _, m := range matches {
+			matched = matched && m.Match(out)
+		}
+		if matched {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Println(string(out))
+			t.Fatal("output failed to match regexes before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Failed logins are counted in a single series, whatever username was
+	// tried
+	if m := regexp.MustCompile(`unifi_events_admin_logins_total{admin="[^"]+",result="failure"`); m.Match(out) {
+		fmt.Println(string(out))
+		t.Fatal("output unexpectedly matched regex.")
+	}
+}
+
+func TestIsStationFailureEvent(t *testing.T) {
+	var tests = []struct {
+		desc string
+		ev   *api.Event
+		want bool
+	}{
+		{
+			desc: "disconnection",
+			ev:   &api.Event{Key: "EVT_WU_Disconnected", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+		},
+		{
+			desc: "similar key",
+			ev:   &api.Event{Key: "EVT_WU_UpgradeFailure", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+		},
+		{
+			desc: "no station",
+			ev:   &api.Event{Key: "EVT_WU_AuthFailure", APMAC: "f0:9f:c2:00:00:01"},
+		},
+		{
+			desc: "no access point",
+			ev:   &api.Event{Key: "EVT_WU_AuthFailure", StationMAC: "de:ad:be:ef:de:ad"},
+		},
+		{
+			desc: "EVT_WU_AssocFailure",
+			ev:   &api.Event{Key: "EVT_WU_AssocFailure", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WU_AuthFailure",
+			ev:   &api.Event{Key: "EVT_WU_AuthFailure", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WU_RadiusAuthFailure",
+			ev:   &api.Event{Key: "EVT_WU_RadiusAuthFailure", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WU_RadiusRejected",
+			ev:   &api.Event{Key: "EVT_WU_RadiusRejected", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WU_DhcpTimeout",
+			ev:   &api.Event{Key: "EVT_WU_DhcpTimeout", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WG_AssocFailure",
+			ev:   &api.Event{Key: "EVT_WG_AssocFailure", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WG_AuthFailure",
+			ev:   &api.Event{Key: "EVT_WG_AuthFailure", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+		{
+			desc: "EVT_WG_DhcpTimeout",
+			ev:   &api.Event{Key: "EVT_WG_DhcpTimeout", APMAC: "f0:9f:c2:00:00:01", StationMAC: "de:ad:be:ef:de:ad"},
+			want: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.want, isStationFailureEvent(tt.ev); want != got {
+			t.Fatalf("unexpected result:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
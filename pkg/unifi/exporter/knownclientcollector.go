@@ -0,0 +1,278 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A KnownClientSource retrieves the clients which a UniFi Controller remembers
+// for a site.  An *api.Client is a KnownClientSource.
+type KnownClientSource interface {
+	KnownClients(ctx context.Context, siteName string) ([]*api.KnownClient, error)
+}
+
+// Verify that the Client implements the KnownClientSource interface.
+var _ KnownClientSource = &api.Client{}
+
+// errNoKnownClients is returned by a KnownClientCollector whose api.Controller
+// cannot retrieve known clients.
+var errNoKnownClients = errors.New("UniFi controller does not support listing known clients")
+
+// knownClientPeriods are the periods for which the number of known clients
+// seen within each is exported, keyed by the value of the within label.
+var knownClientPeriods = []struct {
+	label string
+	d     time.Duration
+}{
+	{label: "24h", d: 24 * time.Hour},
+	{label: "7d", d: 7 * 24 * time.Hour},
+}
+
+// A KnownClientCollector is a Prometheus collector for metrics regarding every
+// client which a UniFi Controller remembers, including those which are not
+// connected, so that the growth of a site's clients and clients which have not
+// been seen for a long time can be followed.
+type KnownClientCollector struct {
+	KnownClients       *prometheus.Desc
+	KnownClientsSeen   *prometheus.Desc
+	FirstSeenTimestamp *prometheus.Desc
+	LastSeenTimestamp  *prometheus.Desc
+
+	c     KnownClientSource
+	sites []*api.Site
+
+	// concurrency is the number of sites from which known clients are
+	// retrieved at once; zero retrieves them one site at a time.
+	concurrency int
+
+	// siteLabel is the source of the site label, such as SiteLabelName.
+	siteLabel string
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+
+	// privacy determines how client MAC addresses and hostnames are
+	// exported.
+	privacy privacy
+
+	// now returns the current time, against which the periods in which
+	// clients were last seen are measured.
+	now func() time.Time
+}
+
+// Verify that the KnownClientCollector implements the collector interface.
+var _ collector = &KnownClientCollector{}
+
+// NewKnownClientCollector creates a new KnownClientCollector which collects
+// metrics for the specified sites.
+func NewKnownClientCollector(c KnownClientSource, sites []*api.Site) *KnownClientCollector {
+	return newKnownClientCollector(namespace, c, sites)
+}
+
+// newKnownClientCollector is like NewKnownClientCollector, but names its
+// metrics within namespace ns.
+func newKnownClientCollector(ns string, c KnownClientSource, sites []*api.Site) *KnownClientCollector {
+	const (
+		subsystem = "known_clients"
+	)
+
+	var (
+		labelsSite   = []string{"site", "connection"}
+		labelsClient = []string{"site", "id", "station_mac", "hostname", "connection"}
+	)
+
+	return &KnownClientCollector{
+		KnownClients: prometheus.NewDesc(
+			// Subsystem is used as name so we get "unifi_known_clients"
+			prometheus.BuildFQName(ns, "", subsystem),
+			"Total number of clients remembered by the controller, whether or not they are connected",
+			labelsSite,
+			nil,
+		),
+
+		KnownClientsSeen: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "seen"),
+			"Number of known clients last seen within the period given by the within label",
+			append(append([]string(nil), labelsSite...), "within"),
+			nil,
+		),
+
+		FirstSeenTimestamp: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "first_seen_timestamp_seconds"),
+			"UNIX timestamp at which each known client was first seen",
+			labelsClient,
+			nil,
+		),
+
+		LastSeenTimestamp: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "last_seen_timestamp_seconds"),
+			"UNIX timestamp at which each known client was last seen",
+			labelsClient,
+			nil,
+		),
+
+		c:     c,
+		sites: sites,
+		now:   time.Now,
+	}
+}
+
+// collect begins a metrics collection task for all metrics related to known
+// clients.
+func (c *KnownClientCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	if c.c == nil {
+		return c.KnownClients, errNoKnownClients
+	}
+
+	err := forEachSite(ctx, c.sites, c.concurrency, func(ctx context.Context, s *api.Site) error {
+		kcs, err := c.c.KnownClients(ctx, s.Name)
+		if err != nil {
+			return err
+		}
+
+		site := siteLabel(c.siteLabel, s)
+		c.collectCounts(ch, site, kcs)
+		c.collectTimestamps(ch, site, kcs)
+		return nil
+	})
+	if err != nil {
+		return c.KnownClients, err
+	}
+
+	return nil, nil
+}
+
+// knownClientConnection returns whether a known client last connected using a
+// wired or wireless connection.
+func knownClientConnection(kc *api.KnownClient) string {
+	if kc.IsWired {
+		return "wired"
+	}
+
+	return "wireless"
+}
+
+// collectCounts collects the number of known clients of a site, and of those
+// seen within each of knownClientPeriods.
+func (c *KnownClientCollector) collectCounts(ch chan<- prometheus.Metric, siteLabel string, kcs []*api.KnownClient) {
+	now := c.now()
+
+	totals := make(map[string]float64, 2)
+	seen := make(map[string][]float64, 2)
+	for _, conn := range []string{"wired", "wireless"} {
+		seen[conn] = make([]float64, len(knownClientPeriods))
+	}
+
+	for _, kc := range kcs {
+		conn := knownClientConnection(kc)
+		totals[conn]++
+
+		if kc.LastSeen.IsZero() {
+			continue
+		}
+		for i, p := range knownClientPeriods {
+			if now.Sub(kc.LastSeen) <= p.d {
+				seen[conn][i]++
+			}
+		}
+	}
+
+	for _, conn := range []string{"wired", "wireless"} {
+		ch <- prometheus.MustNewConstMetric(
+			c.KnownClients,
+			prometheus.GaugeValue,
+			totals[conn],
+			siteLabel,
+			conn,
+		)
+
+		for i, p := range knownClientPeriods {
+			ch <- prometheus.MustNewConstMetric(
+				c.KnownClientsSeen,
+				prometheus.GaugeValue,
+				seen[conn][i],
+				siteLabel,
+				conn,
+				p.label,
+			)
+		}
+	}
+}
+
+// collectTimestamps collects when each known client of a site was first and
+// last seen.  Timestamps which the controller does not know are not exported.
+func (c *KnownClientCollector) collectTimestamps(ch chan<- prometheus.Metric, siteLabel string, kcs []*api.KnownClient) {
+	for _, kc := range kcs {
+		name := kc.Name
+		if name == "" {
+			name = kc.Hostname
+		}
+		if name == "" {
+			name = kc.MAC.String()
+		}
+
+		labels := []string{
+			siteLabel,
+			kc.ID,
+			c.privacy.identifier(kc.MAC.String()),
+			c.privacy.identifier(name),
+			knownClientConnection(kc),
+		}
+
+		if !kc.FirstSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				c.FirstSeenTimestamp,
+				prometheus.GaugeValue,
+				float64(kc.FirstSeen.Unix()),
+				labels...,
+			)
+		}
+		if !kc.LastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				c.LastSeenTimestamp,
+				prometheus.GaugeValue,
+				float64(kc.LastSeen.Unix()),
+				labels...,
+			)
+		}
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *KnownClientCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.KnownClients,
+		c.KnownClientsSeen,
+		c.FirstSeenTimestamp,
+		c.LastSeenTimestamp,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect is the same as CollectError, but ignores any errors which occur.
+// Collect exists to satisfy the prometheus.Collector interface.
+func (c *KnownClientCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.CollectError(context.Background(), ch)
+}
+
+// CollectError sends the metric values for each metric pertaining to known
+// clients over to the provided prometheus Metric channel, returning any errors
+// which occur.  Requests to the UniFi Controller are cancelled when ctx is
+// done.  Errors are logged and returned, but are not sent over ch.
+func (c *KnownClientCollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting known client metric %v: %v", desc, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+func TestKnownClientCollector(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		input   string
+		privacy privacy
+		matches []*regexp.Regexp
+		nomatch []*regexp.Regexp
+	}{
+		{
+			desc: "three known clients",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "user1",
+			"mac": "de:ad:be:ef:10:01",
+			"hostname": "laptop",
+			"first_seen": 1499000000,
+			"last_seen": 1500000000
+		},
+		{
+			"_id": "user2",
+			"mac": "de:ad:be:ef:10:02",
+			"name": "Printer",
+			"is_wired": true,
+			"first_seen": 1499000000,
+			"last_seen": 1499500000
+		},
+		{
+			"_id": "user3",
+			"mac": "de:ad:be:ef:10:03",
+			"first_seen": 1490000000,
+			"last_seen": 1490000000
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_known_clients{connection="wired",site="Default"} 1`),
+				regexp.MustCompile(`unifi_known_clients{connection="wireless",site="Default"} 2`),
+
+				regexp.MustCompile(`unifi_known_clients_seen{connection="wired",site="Default",within="24h"} 0`),
+				regexp.MustCompile(`unifi_known_clients_seen{connection="wired",site="Default",within="7d"} 1`),
+				regexp.MustCompile(`unifi_known_clients_seen{connection="wireless",site="Default",within="24h"} 1`),
+				regexp.MustCompile(`unifi_known_clients_seen{connection="wireless",site="Default",within="7d"} 1`),
+
+				regexp.MustCompile(`unifi_known_clients_first_seen_timestamp_seconds{connection="wireless",hostname="laptop",id="user1",site="Default",station_mac="de:ad:be:ef:10:01"} 1.499e\+09`),
+				regexp.MustCompile(`unifi_known_clients_last_seen_timestamp_seconds{connection="wired",hostname="Printer",id="user2",site="Default",station_mac="de:ad:be:ef:10:02"} 1.4995e\+09`),
+				regexp.MustCompile(`unifi_known_clients_last_seen_timestamp_seconds{connection="wireless",hostname="de:ad:be:ef:10:03",id="user3",site="Default",station_mac="de:ad:be:ef:10:03"} 1.49e\+09`),
+			},
+		},
+		{
+			desc: "unknown timestamps, dropped identifiers",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "user1",
+			"mac": "de:ad:be:ef:10:01",
+			"hostname": "laptop"
+		}
+	]
+}
+`),
+			privacy: privacy{mode: PrivacyDrop},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_known_clients{connection="wireless",site="Default"} 1`),
+				regexp.MustCompile(`unifi_known_clients_seen{connection="wireless",site="Default",within="7d"} 0`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_known_clients_first_seen_timestamp_seconds{`),
+				regexp.MustCompile(`unifi_known_clients_last_seen_timestamp_seconds{`),
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		out := testKnownClientCollector(t, []byte(tt.input), tt.privacy)
+
+		for j, m := range tt.matches {
+			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
+
+			if !m.Match(out) {
+				t.Fatalf("\toutput failed to match regex:\n%s", out)
+			}
+		}
+
+		for j, m := range tt.nomatch {
+			t.Logf("\t[%02d:%02d] no match: %s", i, j, m.String())
+
+			if m.Match(out) {
+				t.Fatalf("\toutput unexpectedly matched regex:\n%s", out)
+			}
+		}
+	}
+}
+
+func TestKnownClientCollectorUnsupported(t *testing.T) {
+	// An api.Controller which cannot list known clients fails every scrape
+	e, err := NewFromController(&fakeController{}, []*api.Site{{Name: "default"}},
+		DisableCollector(CollectorDevices),
+		DisableCollector(CollectorClients),
+		EnableCollector(CollectorKnownClients),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+	if !regexp.MustCompile(`unifi_up 0`).Match(out) {
+		t.Fatalf("output does not report a failed scrape:\n%s", out)
+	}
+}
+
+func testKnownClientCollector(t *testing.T, input []byte, p privacy) []byte {
+	c, done := testUniFiClient(t, input)
+	defer done()
+
+	collector := NewKnownClientCollector(c, []*api.Site{{
+		Name:        "default",
+		Description: "Default",
+	}})
+	collector.privacy = p
+	collector.now = func() time.Time {
+		return time.Unix(1500010000, 0)
+	}
+
+	return testCollector(t, collector)
+}
@@ -0,0 +1,108 @@
+package exporter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// Modes for exporting the MAC addresses and hostnames of stations, for use
+// with StationPrivacy.
+const (
+	// PrivacyOff exports station MAC addresses and hostnames unchanged.
+	PrivacyOff = "off"
+
+	// PrivacyHash replaces station MAC addresses and hostnames with a keyed
+	// hash of each, so a station can be followed over time without revealing
+	// its identity.
+	PrivacyHash = "hash"
+
+	// PrivacyDrop exports empty station MAC addresses and hostnames, and
+	// combines the DPI metrics of all stations in a site.
+	PrivacyDrop = "drop"
+)
+
+// StationPrivacy sets how the MAC addresses and hostnames of stations are
+// exported, for deployments which must not export client identifiers.  key is
+// the HMAC key used by PrivacyHash, and must be kept secret, because anyone who
+// knows it can confirm whether a hash belongs to a given MAC address.
+func StationPrivacy(mode string, key []byte) Option {
+	return func(e *Exporter) {
+		e.privacy = privacy{
+			mode: mode,
+			key:  key,
+		}
+	}
+}
+
+// privacy applies a StationPrivacy mode to station identifiers.  The zero
+// value exports identifiers unchanged.
+type privacy struct {
+	mode string
+	key  []byte
+}
+
+// validate verifies that the privacy mode is known and has a key, if needed.
+func (p privacy) validate() error {
+	switch p.mode {
+	case "", PrivacyOff, PrivacyDrop:
+		return nil
+	case PrivacyHash:
+		if len(p.key) == 0 {
+			return errors.New("station privacy mode hash requires a key")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown station privacy mode %q", p.mode)
+	}
+}
+
+// identifier returns the value exported for a station identifier, such as a
+// MAC address or hostname.
+func (p privacy) identifier(v string) string {
+	switch p.mode {
+	case PrivacyHash:
+		mac := hmac.New(sha256.New, p.key)
+		_, _ = mac.Write([]byte(v))
+
+		// 64 bits is plenty to distinguish the stations of a site
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	case PrivacyDrop:
+		return ""
+	default:
+		return v
+	}
+}
+
+// combineDPI returns a single StationDPI with no MAC address which combines
+// the statistics of each category across all of dpi.
+func combineDPI(dpi []*api.StationDPI) []*api.StationDPI {
+	byName := make(map[string]*api.DPIStats)
+	var cats []*api.DPIStats
+	for _, s := range dpi {
+		for _, cat := range s.Categories {
+			st, ok := byName[cat.Category]
+			if !ok {
+				st = &api.DPIStats{Category: cat.Category}
+				byName[cat.Category] = st
+				cats = append(cats, st)
+			}
+
+			st.ReceiveBytes += cat.ReceiveBytes
+			st.ReceivePackets += cat.ReceivePackets
+			st.TransmitBytes += cat.TransmitBytes
+			st.TransmitPackets += cat.TransmitPackets
+		}
+	}
+
+	if len(cats) == 0 {
+		return nil
+	}
+
+	return []*api.StationDPI{{Categories: cats}}
+}
@@ -0,0 +1,315 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A ProtectSource retrieves the state of UniFi Protect from a UniFi OS
+// console.  An *api.Client is a ProtectSource.
+type ProtectSource interface {
+	Protect(ctx context.Context) (*api.Protect, error)
+}
+
+// Verify that the Client implements the ProtectSource interface.
+var _ ProtectSource = &api.Client{}
+
+// errNoProtect is returned by a ProtectCollector whose api.Controller cannot
+// retrieve the state of UniFi Protect.
+var errNoProtect = errors.New("UniFi controller does not support UniFi Protect")
+
+// A ProtectCollector is a Prometheus collector for metrics regarding the NVR
+// and cameras of UniFi Protect, on UniFi OS consoles which run it.
+//
+// UniFi Protect is not tied to any UniFi Network site, so its metrics have no
+// site label.
+type ProtectCollector struct {
+	NVRInfo             *prometheus.Desc
+	NVRStorageBytes     *prometheus.Desc
+	NVRStorageUsedBytes *prometheus.Desc
+	NVRRetentionSeconds *prometheus.Desc
+
+	CameraInfo               *prometheus.Desc
+	CameraUp                 *prometheus.Desc
+	CameraRecording          *prometheus.Desc
+	CameraRecordingsSeconds  *prometheus.Desc
+	CameraChannelBitrateBits *prometheus.Desc
+	CameraChannelEnabled     *prometheus.Desc
+
+	c ProtectSource
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+}
+
+// Verify that the ProtectCollector implements the collector interface.
+var _ collector = &ProtectCollector{}
+
+// NewProtectCollector creates a new ProtectCollector which collects metrics
+// from the UniFi Protect of c.
+func NewProtectCollector(c ProtectSource) *ProtectCollector {
+	return newProtectCollector(namespace, c)
+}
+
+// newProtectCollector is like NewProtectCollector, but names its metrics
+// within namespace ns.
+func newProtectCollector(ns string, c ProtectSource) *ProtectCollector {
+	const (
+		subsystem = "protect"
+	)
+
+	var (
+		labelsNVR     = []string{"id", "mac", "name"}
+		labelsCamera  = []string{"id", "mac", "name"}
+		labelsChannel = []string{"id", "mac", "name", "channel"}
+	)
+
+	return &ProtectCollector{
+		NVRInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "nvr_info"),
+			"Information about the UniFi Protect NVR, with a constant value of 1",
+			append(append([]string(nil), labelsNVR...), "version"),
+			nil,
+		),
+
+		NVRStorageBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "nvr_storage_bytes"),
+			"Size of the storage of the UniFi Protect NVR in bytes",
+			labelsNVR,
+			nil,
+		),
+
+		NVRStorageUsedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "nvr_storage_used_bytes"),
+			"Number of bytes of the storage of the UniFi Protect NVR in use",
+			labelsNVR,
+			nil,
+		),
+
+		NVRRetentionSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "nvr_retention_seconds"),
+			"Number of seconds for which the UniFi Protect NVR is configured to keep recordings, if not until its storage is full",
+			labelsNVR,
+			nil,
+		),
+
+		CameraInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "camera_info"),
+			"Information about a UniFi Protect camera, with a constant value of 1",
+			append(append([]string(nil), labelsCamera...), "type", "recording_mode"),
+			nil,
+		),
+
+		CameraUp: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "camera_up"),
+			"Whether a UniFi Protect camera is connected to the NVR",
+			labelsCamera,
+			nil,
+		),
+
+		CameraRecording: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "camera_recording"),
+			"Whether a UniFi Protect camera is recording",
+			labelsCamera,
+			nil,
+		),
+
+		CameraRecordingsSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "camera_recordings_seconds"),
+			"Number of seconds between the oldest and newest recordings of a UniFi Protect camera stored by the NVR",
+			labelsCamera,
+			nil,
+		),
+
+		CameraChannelBitrateBits: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "camera_channel_bitrate_bits_per_second"),
+			"Configured bitrate of a video channel of a UniFi Protect camera in bits per second",
+			labelsChannel,
+			nil,
+		),
+
+		CameraChannelEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "camera_channel_enabled"),
+			"Whether a video channel of a UniFi Protect camera is enabled",
+			labelsChannel,
+			nil,
+		),
+
+		c: c,
+	}
+}
+
+// collect begins a metrics collection task for all metrics related to UniFi
+// Protect.
+func (c *ProtectCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	if c.c == nil {
+		return c.NVRInfo, errNoProtect
+	}
+
+	p, err := c.c.Protect(ctx)
+	if err != nil {
+		return c.NVRInfo, err
+	}
+
+	if p.NVR != nil {
+		c.collectNVR(ch, p.NVR)
+	}
+	c.collectCameras(ch, p.Cameras)
+
+	return nil, nil
+}
+
+// collectNVR collects information about the storage and retention of the
+// UniFi Protect NVR.
+func (c *ProtectCollector) collectNVR(ch chan<- prometheus.Metric, nvr *api.NVR) {
+	labels := []string{
+		nvr.ID,
+		nvr.MAC.String(),
+		nvr.Name,
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.NVRInfo,
+		prometheus.GaugeValue,
+		1,
+		append(labels, nvr.Version)...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.NVRStorageBytes,
+		prometheus.GaugeValue,
+		nvr.StorageBytes,
+		labels...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.NVRStorageUsedBytes,
+		prometheus.GaugeValue,
+		nvr.StorageUsedBytes,
+		labels...,
+	)
+
+	if nvr.Retention > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			c.NVRRetentionSeconds,
+			prometheus.GaugeValue,
+			nvr.Retention.Seconds(),
+			labels...,
+		)
+	}
+}
+
+// collectCameras collects the state, recordings and video channels of each
+// UniFi Protect camera.
+func (c *ProtectCollector) collectCameras(ch chan<- prometheus.Metric, cameras []*api.Camera) {
+	for _, cam := range cameras {
+		labels := []string{
+			cam.ID,
+			cam.MAC.String(),
+			cam.Name,
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.CameraInfo,
+			prometheus.GaugeValue,
+			1,
+			append(labels, cam.Type, cam.RecordingMode)...,
+		)
+
+		var up, recording float64
+		if cam.Connected {
+			up = 1
+		}
+		if cam.Recording {
+			recording = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.CameraUp,
+			prometheus.GaugeValue,
+			up,
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CameraRecording,
+			prometheus.GaugeValue,
+			recording,
+			labels...,
+		)
+
+		if !cam.RecordingStart.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				c.CameraRecordingsSeconds,
+				prometheus.GaugeValue,
+				cam.RecordingEnd.Sub(cam.RecordingStart).Seconds(),
+				labels...,
+			)
+		}
+
+		for _, chn := range cam.Channels {
+			chLabels := append(append([]string(nil), labels...), chn.Name)
+
+			var enabled float64
+			if chn.Enabled {
+				enabled = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.CameraChannelEnabled,
+				prometheus.GaugeValue,
+				enabled,
+				chLabels...,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.CameraChannelBitrateBits,
+				prometheus.GaugeValue,
+				chn.Bitrate,
+				chLabels...,
+			)
+		}
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *ProtectCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		c.NVRInfo,
+		c.NVRStorageBytes,
+		c.NVRStorageUsedBytes,
+		c.NVRRetentionSeconds,
+
+		c.CameraInfo,
+		c.CameraUp,
+		c.CameraRecording,
+		c.CameraRecordingsSeconds,
+		c.CameraChannelBitrateBits,
+		c.CameraChannelEnabled,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Collect is the same as CollectError, but ignores any errors which occur.
+// Collect exists to satisfy the prometheus.Collector interface.
+func (c *ProtectCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.CollectError(context.Background(), ch)
+}
+
+// CollectError sends the metric values for each metric pertaining to UniFi
+// Protect over to the provided prometheus Metric channel, returning any errors
+// which occur.  Requests to the UniFi OS console are cancelled when ctx is
+// done.  Errors are logged and returned, but are not sent over ch.
+func (c *ProtectCollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting UniFi Protect metric %v: %v", desc, err)
+		return err
+	}
+
+	return nil
+}
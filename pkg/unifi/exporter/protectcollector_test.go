@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProtectCollector(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		input   string
+		matches []*regexp.Regexp
+		nomatch []*regexp.Regexp
+	}{
+		{
+			desc: "NVR and cameras",
+			input: strings.TrimSpace(`
+{
+	"nvr": {
+		"id": "nvr1",
+		"name": "UNVR",
+		"mac": "F09FC2000001",
+		"version": "2.2.6",
+		"recordingRetentionDurationMs": 2592000000,
+		"storageInfo": {"totalSize": 4000000000000, "totalSpaceUsed": 1000000000000}
+	},
+	"cameras": [
+		{
+			"id": "cam1",
+			"name": "Front Door",
+			"mac": "F09FC2000002",
+			"type": "UVC G4 Bullet",
+			"isConnected": true,
+			"isRecording": true,
+			"recordingSettings": {"mode": "always"},
+			"stats": {"video": {"recordingStart": 1500000000000, "recordingEnd": 1500086400000}},
+			"channels": [
+				{"name": "High", "enabled": true, "bitrate": 10000000},
+				{"name": "Low", "enabled": false, "bitrate": 300000}
+			]
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_protect_nvr_info{id="nvr1",mac="f0:9f:c2:00:00:01",name="UNVR",version="2.2.6"} 1`),
+				regexp.MustCompile(`unifi_protect_nvr_storage_bytes{id="nvr1",mac="f0:9f:c2:00:00:01",name="UNVR"} 4e\+12`),
+				regexp.MustCompile(`unifi_protect_nvr_storage_used_bytes{id="nvr1",mac="f0:9f:c2:00:00:01",name="UNVR"} 1e\+12`),
+				regexp.MustCompile(`unifi_protect_nvr_retention_seconds{id="nvr1",mac="f0:9f:c2:00:00:01",name="UNVR"} 2.592e\+06`),
+
+				regexp.MustCompile(`unifi_protect_camera_info{id="cam1",mac="f0:9f:c2:00:00:02",name="Front Door",recording_mode="always",type="UVC G4 Bullet"} 1`),
+				regexp.MustCompile(`unifi_protect_camera_up{id="cam1",mac="f0:9f:c2:00:00:02",name="Front Door"} 1`),
+				regexp.MustCompile(`unifi_protect_camera_recording{id="cam1",mac="f0:9f:c2:00:00:02",name="Front Door"} 1`),
+				regexp.MustCompile(`unifi_protect_camera_recordings_seconds{id="cam1",mac="f0:9f:c2:00:00:02",name="Front Door"} 86400`),
+
+				regexp.MustCompile(`unifi_protect_camera_channel_bitrate_bits_per_second{channel="High",id="cam1",mac="f0:9f:c2:00:00:02",name="Front Door"} 1e\+07`),
+				regexp.MustCompile(`unifi_protect_camera_channel_enabled{channel="Low",id="cam1",mac="f0:9f:c2:00:00:02",name="Front Door"} 0`),
+			},
+		},
+		{
+			desc: "retention until full, camera without recordings",
+			input: strings.TrimSpace(`
+{
+	"nvr": {
+		"id": "nvr1",
+		"name": "UNVR",
+		"mac": "F09FC2000001",
+		"recordingRetentionDurationMs": null
+	},
+	"cameras": [
+		{
+			"id": "cam2",
+			"name": "Garage",
+			"mac": "F09FC2000003",
+			"type": "UVC G3 Flex",
+			"recordingSettings": {"mode": "never"}
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_protect_camera_up{id="cam2",mac="f0:9f:c2:00:00:03",name="Garage"} 0`),
+				regexp.MustCompile(`unifi_protect_camera_recording{id="cam2",mac="f0:9f:c2:00:00:03",name="Garage"} 0`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_protect_nvr_retention_seconds{`),
+				regexp.MustCompile(`unifi_protect_camera_recordings_seconds{`),
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		out := testProtectCollector(t, []byte(tt.input))
+
+		for j, m := range tt.matches {
+			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
+
+			if !m.Match(out) {
+				fmt.Println(string(out))
+				t.Fatal("\toutput failed to match regex.")
+			}
+		}
+
+		for j, m := range tt.nomatch {
+			t.Logf("\t[%02d:%02d] no match: %s", i, j, m.String())
+
+			if m.Match(out) {
+				fmt.Println(string(out))
+				t.Fatal("\toutput unexpectedly matched regex.")
+			}
+		}
+	}
+}
+
+func testProtectCollector(t *testing.T, input []byte) []byte {
+	c, done := testUniFiClient(t, input)
+	defer done()
+
+	return testCollector(t, NewProtectCollector(c))
+}
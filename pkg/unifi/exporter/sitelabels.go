@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// siteLabelName is the name of the label which identifies the site of a
+// metric.
+const siteLabelName = "site"
+
+// siteLabels are the labels added to the metrics of each site by the Labels of
+// its SiteConfig, keyed by the value of the site label.
+//
+// Every metric in a family must have the same label names, so each site is
+// given every label name configured for any site, with an empty value if the
+// site has none, which Prometheus treats as if the label were absent.
+type siteLabels struct {
+	bySite map[string][]*dto.LabelPair
+	blank  []*dto.LabelPair
+}
+
+// newSiteLabels creates siteLabels from the labels of each site, keyed by the
+// value of the site label.  It returns nil if no site has any labels.
+func newSiteLabels(labels map[string]map[string]string) *siteLabels {
+	var names []string
+	seen := make(map[string]bool)
+	for _, ls := range labels {
+		for name := range ls {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	pairs := func(ls map[string]string) []*dto.LabelPair {
+		lps := make([]*dto.LabelPair, 0, len(names))
+		for _, name := range names {
+			lps = append(lps, &dto.LabelPair{
+				Name:  proto.String(name),
+				Value: proto.String(ls[name]),
+			})
+		}
+
+		return lps
+	}
+
+	sl := &siteLabels{
+		bySite: make(map[string][]*dto.LabelPair, len(labels)),
+		blank:  pairs(nil),
+	}
+	for site, ls := range labels {
+		sl.bySite[site] = pairs(ls)
+	}
+
+	return sl
+}
+
+// A siteLabeler forwards metrics to a channel, adding siteLabels to each
+// metric which has a site label.  Other metrics, such as unifi_up, are
+// forwarded unchanged.
+type siteLabeler struct {
+	in     chan prometheus.Metric
+	out    chan<- prometheus.Metric
+	labels *siteLabels
+	wg     sync.WaitGroup
+}
+
+// newSiteLabeler creates a siteLabeler which forwards metrics sent on its in
+// channel to out, until close is called.
+func newSiteLabeler(out chan<- prometheus.Metric, labels *siteLabels) *siteLabeler {
+	l := &siteLabeler{
+		in:     make(chan prometheus.Metric),
+		out:    out,
+		labels: labels,
+	}
+
+	l.wg.Add(1)
+	go l.run()
+
+	return l
+}
+
+// run forwards metrics until the in channel is closed.
+func (l *siteLabeler) run() {
+	defer l.wg.Done()
+
+	var m dto.Metric
+	for metric := range l.in {
+		m.Reset()
+		if err := metric.Write(&m); err != nil {
+			// Leave invalid metrics for the registry to report
+			l.out <- metric
+			continue
+		}
+
+		site, ok := "", false
+		for _, lp := range m.Label {
+			if lp.GetName() == siteLabelName {
+				site, ok = lp.GetValue(), true
+				break
+			}
+		}
+		if !ok {
+			l.out <- metric
+			continue
+		}
+
+		lps, ok := l.labels.bySite[site]
+		if !ok {
+			lps = l.labels.blank
+		}
+
+		l.out <- &labeledMetric{Metric: metric, labels: lps}
+	}
+}
+
+// close waits for all metrics to be forwarded.
+func (l *siteLabeler) close() {
+	close(l.in)
+	l.wg.Wait()
+}
+
+// A labeledMetric is a prometheus.Metric with additional labels.  Labels
+// which the metric already has are not replaced.
+type labeledMetric struct {
+	prometheus.Metric
+	labels []*dto.LabelPair
+}
+
+// Write implements prometheus.Metric.
+func (m *labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+
+	have := make(map[string]bool, len(out.Label))
+	for _, lp := range out.Label {
+		have[lp.GetName()] = true
+	}
+	for _, lp := range m.labels {
+		if !have[lp.GetName()] {
+			out.Label = append(out.Label, lp)
+		}
+	}
+	sort.Sort(prometheus.LabelPairSorter(out.Label))
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+// A snapshot is an api.Controller which retrieves each kind of data for each
+// site at most once until it is reset, so that all of the collectors in a
+// scrape share both the requests made to the UniFi Controller and a consistent
+// view of its data.
+//
+// Other methods, such as Sites, are passed through to the underlying
+// api.Controller.
+type snapshot struct {
+	api.Controller
+
+	mu      sync.Mutex
+	results map[snapshotKey]*snapshotResult
+
+	// intervals, if set, are the minimum intervals at which the data of
+	// some sites, by name, is retrieved again.  Their data is kept across
+	// resets until it is older than their interval.
+	intervals map[string]time.Duration
+}
+
+// Verify that the snapshot implements the api.Controller interface.
+var _ api.Controller = &snapshot{}
+
+// A snapshotKey identifies a kind of data retrieved for a site.
+type snapshotKey struct {
+	kind string
+	site string
+}
+
+// A snapshotResult is the outcome of retrieving data, which is available once
+// done is closed.
+type snapshotResult struct {
+	done chan struct{}
+	v    interface{}
+	err  error
+	time time.Time
+}
+
+// newSnapshot creates a snapshot of the data retrieved from c.
+func newSnapshot(c api.Controller) *snapshot {
+	return &snapshot{
+		Controller: c,
+		results:    make(map[snapshotKey]*snapshotResult),
+	}
+}
+
+// reset discards retrieved data, so that it is retrieved again when next
+// requested.  Data which was retrieved successfully for a site with an
+// interval is kept until it is older than the interval.
+func (s *snapshot) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make(map[snapshotKey]*snapshotResult)
+	for key, r := range s.results {
+		interval := s.intervals[key.site]
+		if interval <= 0 {
+			continue
+		}
+
+		select {
+		case <-r.done:
+			if r.err == nil && time.Since(r.time) < interval {
+				results[key] = r
+			}
+		default:
+		}
+	}
+
+	s.results = results
+}
+
+// setIntervals sets the minimum intervals at which the data of each site, by
+// name, is retrieved again.
+func (s *snapshot) setIntervals(intervals map[string]time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.intervals = intervals
+}
+
+// fetch returns the data of the specified kind for a site, invoking fn to
+// retrieve it only if no other caller has done so since the last reset.
+// Callers which arrive while fn is in progress wait for its result.
+func (s *snapshot) fetch(ctx context.Context, kind string, site string, fn func() (interface{}, error)) (interface{}, error) {
+	key := snapshotKey{kind: kind, site: site}
+
+	s.mu.Lock()
+	r, ok := s.results[key]
+	if !ok {
+		r = &snapshotResult{done: make(chan struct{})}
+		s.results[key] = r
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		r.v, r.err = fn()
+		r.time = time.Now()
+		close(r.done)
+		return r.v, r.err
+	}
+
+	select {
+	case <-r.done:
+		return r.v, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Devices implements api.Controller.
+func (s *snapshot) Devices(ctx context.Context, siteName string) ([]*api.Device, error) {
+	v, err := s.fetch(ctx, "devices", siteName, func() (interface{}, error) {
+		devices, err := s.Controller.Devices(ctx, siteName)
+		return devices, err
+	})
+	devices, _ := v.([]*api.Device)
+	return devices, err
+}
+
+// Stations implements api.Controller.
+func (s *snapshot) Stations(ctx context.Context, siteName string) ([]*api.Station, error) {
+	v, err := s.fetch(ctx, "stations", siteName, func() (interface{}, error) {
+		stations, err := s.Controller.Stations(ctx, siteName)
+		return stations, err
+	})
+	stations, _ := v.([]*api.Station)
+	return stations, err
+}
+
+// Clients implements api.Controller.
+func (s *snapshot) Clients(ctx context.Context, siteName string) ([]*api.Station, error) {
+	v, err := s.fetch(ctx, "clients", siteName, func() (interface{}, error) {
+		clients, err := s.Controller.Clients(ctx, siteName)
+		return clients, err
+	})
+	clients, _ := v.([]*api.Station)
+	return clients, err
+}
+
+// StationDPI implements api.Controller.
+func (s *snapshot) StationDPI(ctx context.Context, siteName string) ([]*api.StationDPI, error) {
+	v, err := s.fetch(ctx, "dpi", siteName, func() (interface{}, error) {
+		dpi, err := s.Controller.StationDPI(ctx, siteName)
+		return dpi, err
+	})
+	dpi, _ := v.([]*api.StationDPI)
+	return dpi, err
+}
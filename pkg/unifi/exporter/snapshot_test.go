@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
+)
+
+func Test_snapshotFetchesOnce(t *testing.T) {
+	c := &countingController{}
+	s := newSnapshot(c)
+
+	// Concurrent requests for the same site share a single request, while
+	// other sites are retrieved separately
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for _, site := range []string{"default", "site2"} {
+			wg.Add(1)
+			go func(site string) {
+				defer wg.Done()
+
+				devices, err := s.Devices(context.Background(), site)
+				if err != nil {
+					t.Errorf("failed to retrieve devices: %v", err)
+					return
+				}
+				if want, got := 1, len(devices); want != got {
+					t.Errorf("unexpected number of devices:\n- want: %v\n-  got: %v", want, got)
+				}
+			}(site)
+		}
+	}
+	wg.Wait()
+
+	if want, got := 2, c.calls(); want != got {
+		t.Fatalf("unexpected number of device requests:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	s.reset()
+
+	if _, err := s.Devices(context.Background(), "default"); err != nil {
+		t.Fatalf("failed to retrieve devices: %v", err)
+	}
+
+	if want, got := 3, c.calls(); want != got {
+		t.Fatalf("unexpected number of device requests after reset:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// A countingController is an api.Controller which returns a single device for
+// any site, and counts the number of times devices are retrieved.
+type countingController struct {
+	api.Controller
+
+	mu sync.Mutex
+	n  int
+}
+
+func (c *countingController) Devices(_ context.Context, _ string) ([]*api.Device, error) {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+
+	// Give concurrent callers time to wait on this request
+	time.Sleep(10 * time.Millisecond)
+
+	return []*api.Device{{Name: "Office AP"}}, nil
+}
+
+func (c *countingController) calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.n
+}
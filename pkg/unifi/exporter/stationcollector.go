@@ -1,7 +1,10 @@
 package exporter
 
 import (
+	"context"
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,6 +14,7 @@ import (
 // UniFi stations (clients).
 type StationCollector struct {
 	Stations *prometheus.Desc
+	Info     *prometheus.Desc
 
 	ReceivedBytesTotal    *prometheus.Desc
 	TransmittedBytesTotal *prometheus.Desc
@@ -21,8 +25,29 @@ type StationCollector struct {
 	RSSIDBM  *prometheus.Desc
 	NoiseDBM *prometheus.Desc
 
-	c     *api.Client
+	APClientRSSIDBM        *prometheus.Desc
+	SiteClientSatisfaction *prometheus.Desc
+
+	c     api.Controller
 	sites []*api.Site
+
+	// concurrency is the number of sites from which stations are retrieved
+	// at once; zero retrieves them one site at a time.
+	concurrency int
+
+	// siteLabel is the source of the site label, such as SiteLabelName.
+	siteLabel string
+
+	// logger, if set, is used instead of the log package's standard logger.
+	logger *log.Logger
+
+	// privacy determines how station MAC addresses and hostnames are
+	// exported.
+	privacy privacy
+
+	// nameLabels exports the hostname and alias of each station as separate
+	// labels, rather than a single hostname label.
+	nameLabels bool
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
@@ -30,7 +55,16 @@ var _ collector = &StationCollector{}
 
 // NewStationCollector creates a new StationCollector which collects metrics for
 // a specified site.
-func NewStationCollector(c *api.Client, sites []*api.Site) *StationCollector {
+func NewStationCollector(c api.Controller, sites []*api.Site) *StationCollector {
+	return newStationCollector(namespace, c, sites, false, privacy{})
+}
+
+// newStationCollector is like NewStationCollector, but names its metrics within namespace
+// ns.  If nameLabels is set, stations carry a name label, as described by
+// StationNameLabels.  p determines how station identifiers are exported; with
+// PrivacyDrop, per-station metrics carry no id label, and combine the stations
+// of each AP.
+func newStationCollector(ns string, c api.Controller, sites []*api.Site, nameLabels bool, p privacy) *StationCollector {
 	const (
 		subsystem = "stations"
 	)
@@ -46,72 +80,103 @@ func NewStationCollector(c *api.Client, sites []*api.Site) *StationCollector {
 			"connection",
 		}
 	)
+	if p.mode == PrivacyDrop {
+		labelsStation = append(labelsStation[:1:1], labelsStation[2:]...)
+	}
+	if nameLabels {
+		labelsStation = append(labelsStation, "name")
+	}
 
 	return &StationCollector{
 		Stations: prometheus.NewDesc(
 			// Subsystem is used as name so we get "unifi_stations"
-			prometheus.BuildFQName(namespace, "", subsystem),
+			prometheus.BuildFQName(ns, "", subsystem),
 			"Total number of stations (clients)",
 			labelsSiteOnly,
 			nil,
 		),
 
+		Info: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "info"),
+			"Kind of device of each station, as fingerprinted by the controller, with a constant value of 1.  Apart from oui, the labels are IDs in the controller's fingerprint database, or empty if unknown",
+			[]string{"site", "id", "station_mac", "oui", "dev_cat", "os_name", "fingerprint"},
+			nil,
+		),
+
 		ReceivedBytesTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "received_bytes_total"),
+			prometheus.BuildFQName(ns, subsystem, "received_bytes_total"),
 			"Number of bytes received by the AP for stations (client upload)",
 			labelsStation,
 			nil,
 		),
 
 		TransmittedBytesTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "transmitted_bytes_total"),
+			prometheus.BuildFQName(ns, subsystem, "transmitted_bytes_total"),
 			"Number of bytes transmitted by the AP to stations (client download)",
 			labelsStation,
 			nil,
 		),
 
 		ReceivedPacketsTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "received_packets_total"),
+			prometheus.BuildFQName(ns, subsystem, "received_packets_total"),
 			"Number of packets received by the AP for stations (client upload)",
 			labelsStation,
 			nil,
 		),
 
 		TransmittedPacketsTotal: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "transmitted_packets_total"),
+			prometheus.BuildFQName(ns, subsystem, "transmitted_packets_total"),
 			"Number of packets transmitted by the AP for stations (client download)",
 			labelsStation,
 			nil,
 		),
 
 		RSSIDBM: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "rssi_dbm"),
+			prometheus.BuildFQName(ns, subsystem, "rssi_dbm"),
 			"Current signal strength of stations",
 			labelsStation,
 			nil,
 		),
 
 		NoiseDBM: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, subsystem, "noise_dbm"),
+			prometheus.BuildFQName(ns, subsystem, "noise_dbm"),
 			"Current noise floor of stations",
 			labelsStation,
 			nil,
 		),
 
-		c:     c,
-		sites: sites,
+		APClientRSSIDBM: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "ap", "client_rssi_dbm"),
+			"Distribution of the signal strength of the wireless stations connected to each AP radio",
+			[]string{"site", "ap_mac", "radio"},
+			nil,
+		),
+
+		SiteClientSatisfaction: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "site", "client_satisfaction_score"),
+			"Distribution of the experience scores, from 0 to 100, of the stations in each site which the controller has scored",
+			[]string{"site", "connection"},
+			nil,
+		),
+
+		c:          c,
+		sites:      sites,
+		privacy:    p,
+		nameLabels: nameLabels,
 	}
 }
 
 // collect begins a metrics collection task for all metrics related to UniFi
 // stations.
-func (c *StationCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	for _, s := range c.sites {
-		stations, err := c.c.Stations(s.Name)
+func (c *StationCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	err := forEachSite(ctx, c.sites, c.concurrency, func(ctx context.Context, s *api.Site) error {
+		stations, err := c.c.Stations(ctx, s.Name)
 		if err != nil {
-			return c.Stations, err
+			return err
 		}
 
+		site := siteLabel(c.siteLabel, s)
+
 		var wiredCount, wirelessCount float64
 		for _, station := range stations {
 			if station.IsWired {
@@ -125,19 +190,26 @@ func (c *StationCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Des
 			c.Stations,
 			prometheus.GaugeValue,
 			wiredCount,
-			s.Description,
+			site,
 			"wired",
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.Stations,
 			prometheus.GaugeValue,
 			wirelessCount,
-			s.Description,
+			site,
 			"wireless",
 		)
 
-		c.collectStationBytes(ch, s.Description, stations)
-		c.collectStationSignal(ch, s.Description, stations)
+		c.collectStationInfo(ch, site, stations)
+		c.collectStationBytes(ch, site, stations)
+		c.collectStationSignal(ch, site, stations)
+		c.collectAPClientSignal(ch, site, stations)
+		c.collectSiteClientSatisfaction(ch, site, stations)
+		return nil
+	})
+	if err != nil {
+		return c.Stations, err
 	}
 
 	return nil, nil
@@ -157,6 +229,50 @@ func hostName(s *api.Station) string {
 	return s.MAC.String()
 }
 
+// stationLabels returns the values of the labels of per-station metrics.
+func (c *StationCollector) stationLabels(siteLabel string, s *api.Station) []string {
+	// Without identifiers, the labels of stations are the same but for
+	// their AP and connection
+	if c.privacy.mode == PrivacyDrop {
+		labels := []string{siteLabel, s.APMAC.String(), "", "", connType(s)}
+		if c.nameLabels {
+			labels = append(labels, "")
+		}
+
+		return labels
+	}
+
+	if !c.nameLabels {
+		return []string{
+			siteLabel,
+			s.ID,
+			s.APMAC.String(),
+			c.privacy.identifier(s.MAC.String()),
+			c.privacy.identifier(hostName(s)),
+			connType(s),
+		}
+	}
+
+	// Either name may be empty, so neither is hashed unless it is set
+	identifier := func(v string) string {
+		if v == "" {
+			return ""
+		}
+
+		return c.privacy.identifier(v)
+	}
+
+	return []string{
+		siteLabel,
+		s.ID,
+		s.APMAC.String(),
+		c.privacy.identifier(s.MAC.String()),
+		identifier(s.Hostname),
+		connType(s),
+		identifier(s.Name),
+	}
+}
+
 // connType returns a string indicating if a station is connected using a wired
 // or wireless connection.
 func connType(s *api.Station) string {
@@ -167,60 +283,117 @@ func connType(s *api.Station) string {
 	return "wireless"
 }
 
-// collectStationBytes collects receive and transmit byte counts for UniFi stations.
-func (c *StationCollector) collectStationBytes(ch chan<- prometheus.Metric, siteLabel string, stations []*api.Station) {
+// collectStationInfo collects the device fingerprint of UniFi stations.
+func (c *StationCollector) collectStationInfo(ch chan<- prometheus.Metric, siteLabel string, stations []*api.Station) {
+	// The info metric exists to describe individual stations
+	if c.privacy.mode == PrivacyDrop {
+		return
+	}
+
+	// Zero IDs are unknown, so are exported as empty labels
+	id := func(v int) string {
+		if v == 0 {
+			return ""
+		}
+
+		return strconv.Itoa(v)
+	}
+
 	for _, s := range stations {
-		labels := []string{
+		fp := s.Fingerprint
+		if fp == nil {
+			fp = &api.StationFingerprint{}
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.Info,
+			prometheus.GaugeValue,
+			1,
 			siteLabel,
 			s.ID,
-			s.APMAC.String(),
-			s.MAC.String(),
-			hostName(s),
-			connType(s),
+			c.privacy.identifier(s.MAC.String()),
+			fp.OUI,
+			id(fp.DeviceCategory),
+			id(fp.OSName),
+			id(fp.DeviceID),
+		)
+	}
+}
+
+// collectStationBytes collects receive and transmit byte counts for UniFi stations.
+// Stations whose labels are the same, because their identifiers are dropped,
+// are combined.
+func (c *StationCollector) collectStationBytes(ch chan<- prometheus.Metric, siteLabel string, stations []*api.Station) {
+	type traffic struct {
+		labels []string
+		stats  api.StationStats
+	}
+
+	var (
+		keys  []string
+		byKey = make(map[string]*traffic)
+	)
+	for _, s := range stations {
+		labels := c.stationLabels(siteLabel, s)
+		key := strings.Join(labels, "\x00")
+
+		t, ok := byKey[key]
+		if !ok {
+			t = &traffic{labels: labels}
+			byKey[key] = t
+			keys = append(keys, key)
 		}
 
+		t.stats.ReceiveBytes += s.Stats.ReceiveBytes
+		t.stats.TransmitBytes += s.Stats.TransmitBytes
+		t.stats.ReceivePackets += s.Stats.ReceivePackets
+		t.stats.TransmitPackets += s.Stats.TransmitPackets
+	}
+
+	for _, k := range keys {
+		t := byKey[k]
+
 		ch <- prometheus.MustNewConstMetric(
 			c.ReceivedBytesTotal,
 			prometheus.CounterValue,
-			float64(s.Stats.ReceiveBytes),
-			labels...,
+			float64(t.stats.ReceiveBytes),
+			t.labels...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.TransmittedBytesTotal,
 			prometheus.CounterValue,
-			float64(s.Stats.TransmitBytes),
-			labels...,
+			float64(t.stats.TransmitBytes),
+			t.labels...,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.ReceivedPacketsTotal,
 			prometheus.CounterValue,
-			float64(s.Stats.ReceivePackets),
-			labels...,
+			float64(t.stats.ReceivePackets),
+			t.labels...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.TransmittedPacketsTotal,
 			prometheus.CounterValue,
-			float64(s.Stats.TransmitPackets),
-			labels...,
+			float64(t.stats.TransmitPackets),
+			t.labels...,
 		)
 	}
 }
 
 // collectStationSignal collects wireless signal strength for UniFi stations.
 func (c *StationCollector) collectStationSignal(ch chan<- prometheus.Metric, siteLabel string, stations []*api.Station) {
+	// The signal of combined stations has no meaning, and its distribution
+	// is exported by unifi_ap_client_rssi_dbm instead
+	if c.privacy.mode == PrivacyDrop {
+		return
+	}
+
 	for _, s := range stations {
 		if s.IsWired {
 			continue
 		}
-		labels := []string{
-			siteLabel,
-			s.ID,
-			s.APMAC.String(),
-			s.MAC.String(),
-			hostName(s),
-			connType(s),
-		}
+		labels := c.stationLabels(siteLabel, s)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.RSSIDBM,
@@ -238,11 +411,85 @@ func (c *StationCollector) collectStationSignal(ch chan<- prometheus.Metric, sit
 	}
 }
 
+// apClientRSSIBuckets are the upper bounds, in dBm, of the buckets of
+// unifi_ap_client_rssi_dbm, chosen around the thresholds commonly used to
+// judge wireless signal quality.
+var apClientRSSIBuckets = []float64{-90, -85, -80, -75, -70, -67, -65, -60, -55, -50, -40}
+
+// collectAPClientSignal collects a histogram of the signal strength of the
+// wireless stations connected to each AP radio, which unlike the per-station
+// metrics has a number of series bounded by the number of radios.
+func (c *StationCollector) collectAPClientSignal(ch chan<- prometheus.Metric, siteLabel string, stations []*api.Station) {
+	type radio struct {
+		apMAC string
+		radio string
+	}
+
+	signals := make(map[radio][]int)
+	for _, s := range stations {
+		// Older controllers do not report the signal in dBm
+		if s.IsWired || s.Signal == 0 {
+			continue
+		}
+
+		r := radio{apMAC: s.APMAC.String(), radio: s.Radio}
+		signals[r] = append(signals[r], s.Signal)
+	}
+
+	for r, ss := range signals {
+		ch <- newConstHistogram(c.APClientRSSIDBM, apClientRSSIBuckets, ss, siteLabel, r.apMAC, r.radio)
+	}
+}
+
+// siteClientSatisfactionBuckets are the upper bounds of the buckets of
+// unifi_site_client_satisfaction_score.
+var siteClientSatisfactionBuckets = []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 95}
+
+// collectSiteClientSatisfaction collects a histogram of the experience scores
+// of the wired and wireless stations of a site.
+func (c *StationCollector) collectSiteClientSatisfaction(ch chan<- prometheus.Metric, siteLabel string, stations []*api.Station) {
+	scores := make(map[string][]int, 2)
+	for _, s := range stations {
+		if s.Satisfaction < 0 {
+			continue
+		}
+
+		scores[connType(s)] = append(scores[connType(s)], s.Satisfaction)
+	}
+
+	// Both connections are always exported, so that a site without scored
+	// stations reports none rather than no histogram at all
+	for _, conn := range []string{"wired", "wireless"} {
+		ch <- newConstHistogram(c.SiteClientSatisfaction, siteClientSatisfactionBuckets, scores[conn], siteLabel, conn)
+	}
+}
+
+// newConstHistogram returns a histogram of values, with buckets of the upper
+// bounds in bounds.
+func newConstHistogram(desc *prometheus.Desc, bounds []float64, values []int, labels ...string) prometheus.Metric {
+	var sum float64
+	buckets := make(map[float64]uint64, len(bounds))
+	for _, b := range bounds {
+		buckets[b] = 0
+	}
+	for _, v := range values {
+		sum += float64(v)
+		for _, b := range bounds {
+			if float64(v) <= b {
+				buckets[b]++
+			}
+		}
+	}
+
+	return prometheus.MustNewConstHistogram(desc, uint64(len(values)), sum, buckets, labels...)
+}
+
 // Describe sends the descriptors of each metric over to the provided channel.
 // The corresponding metric values are sent separately.
 func (c *StationCollector) Describe(ch chan<- *prometheus.Desc) {
 	ds := []*prometheus.Desc{
 		c.Stations,
+		c.Info,
 
 		c.ReceivedBytesTotal,
 		c.TransmittedBytesTotal,
@@ -252,6 +499,9 @@ func (c *StationCollector) Describe(ch chan<- *prometheus.Desc) {
 
 		c.RSSIDBM,
 		c.NoiseDBM,
+
+		c.APClientRSSIDBM,
+		c.SiteClientSatisfaction,
 	}
 
 	for _, d := range ds {
@@ -262,16 +512,16 @@ func (c *StationCollector) Describe(ch chan<- *prometheus.Desc) {
 // Collect is the same as Collect, but ignores any errors which occur.
 // Collect exists to satisfy the prometheus.Collector interface.
 func (c *StationCollector) Collect(ch chan<- prometheus.Metric) {
-	_ = c.CollectError(ch)
+	_ = c.CollectError(context.Background(), ch)
 }
 
 // CollectError sends the metric values for each metric pertaining to the global
 // cluster usage over to the provided prometheus Metric channel, returning any
-// errors which occur.
-func (c *StationCollector) CollectError(ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
-		log.Printf("[ERROR] failed collecting station metric %v: %v", desc, err)
-		ch <- prometheus.NewInvalidMetric(desc, err)
+// errors which occur.  Requests to the UniFi Controller are cancelled when ctx
+// is done.  Errors are logged and returned, but are not sent over ch.
+func (c *StationCollector) CollectError(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		logf(c.logger, "[ERROR] failed collecting station metric %v: %v", desc, err)
 		return err
 	}
 
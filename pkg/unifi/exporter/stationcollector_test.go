@@ -10,10 +10,13 @@ import (
 
 func TestStationCollector(t *testing.T) {
 	var tests = []struct {
-		desc    string
-		input   string
-		sites   []*api.Site
-		matches []*regexp.Regexp
+		desc       string
+		input      string
+		sites      []*api.Site
+		privacy    privacy
+		nameLabels bool
+		matches    []*regexp.Regexp
+		nomatch    []*regexp.Regexp
 	}{
 		{
 			desc: "one station, one site",
@@ -25,6 +28,10 @@ func TestStationCollector(t *testing.T) {
 			"ap_mac": "a0:a0:a0:a0:a0:a0",
 			"mac": "de:ad:be:ef:de:ad",
 			"hostname": "foo",
+			"oui": "Apple",
+			"dev_cat": 44,
+			"os_name": 24,
+			"dev_id": 4405,
 			"noise": -110,
 			"rssi": 40,
 			"rx_bytes": 10,
@@ -36,7 +43,9 @@ func TestStationCollector(t *testing.T) {
 }
 `),
 			matches: []*regexp.Regexp{
-				regexp.MustCompile(`unifi_stations{site="Default"} 1`),
+				regexp.MustCompile(`unifi_stations{connection="wireless",site="Default"} 1`),
+
+				regexp.MustCompile(`unifi_stations_info{dev_cat="44",fingerprint="4405",id="abcdef",os_name="24",oui="Apple",site="Default",station_mac="de:ad:be:ef:de:ad"} 1`),
 
 				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 10`),
 				regexp.MustCompile(`unifi_stations_transmitted_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 20`),
@@ -52,6 +61,103 @@ func TestStationCollector(t *testing.T) {
 				Description: "Default",
 			}},
 		},
+		{
+			desc: "signal histogram, one site",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "abcdef",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "de:ad:be:ef:de:ad",
+			"radio": "na",
+			"signal": -52
+		},
+		{
+			"_id": "123456",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:ad",
+			"radio": "na",
+			"signal": -71
+		},
+		{
+			"_id": "654321",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:ae",
+			"radio": "ng",
+			"signal": -80
+		},
+		{
+			"_id": "fedcba",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:af",
+			"radio": "ng"
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_bucket{ap_mac="a0:a0:a0:a0:a0:a0",radio="na",site="Default",le="-75"} 0`),
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_bucket{ap_mac="a0:a0:a0:a0:a0:a0",radio="na",site="Default",le="-70"} 1`),
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_bucket{ap_mac="a0:a0:a0:a0:a0:a0",radio="na",site="Default",le="-50"} 2`),
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_bucket{ap_mac="a0:a0:a0:a0:a0:a0",radio="na",site="Default",le="\+Inf"} 2`),
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_sum{ap_mac="a0:a0:a0:a0:a0:a0",radio="na",site="Default"} -123`),
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_count{ap_mac="a0:a0:a0:a0:a0:a0",radio="na",site="Default"} 2`),
+
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_bucket{ap_mac="a0:a0:a0:a0:a0:a0",radio="ng",site="Default",le="-80"} 1`),
+				regexp.MustCompile(`unifi_ap_client_rssi_dbm_count{ap_mac="a0:a0:a0:a0:a0:a0",radio="ng",site="Default"} 1`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "satisfaction histogram, one site",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "abcdef",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "de:ad:be:ef:de:ad",
+			"satisfaction": 98
+		},
+		{
+			"_id": "123456",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:ad",
+			"satisfaction": 75
+		},
+		{
+			"_id": "654321",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:ae",
+			"satisfaction": -1
+		},
+		{
+			"_id": "fedcba",
+			"is_wired": true,
+			"mac": "ab:ad:1d:ea:ab:af"
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_bucket{connection="wireless",site="Default",le="70"} 0`),
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_bucket{connection="wireless",site="Default",le="80"} 1`),
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_bucket{connection="wireless",site="Default",le="95"} 1`),
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_bucket{connection="wireless",site="Default",le="\+Inf"} 2`),
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_sum{connection="wireless",site="Default"} 173`),
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_count{connection="wireless",site="Default"} 2`),
+
+				regexp.MustCompile(`unifi_site_client_satisfaction_score_count{connection="wired",site="Default"} 0`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
 		{
 			desc: "one wired station, one site",
 			input: strings.TrimSpace(`
@@ -71,7 +177,7 @@ func TestStationCollector(t *testing.T) {
 }
 `),
 			matches: []*regexp.Regexp{
-				regexp.MustCompile(`unifi_stations{site="Default"} 1`),
+				regexp.MustCompile(`unifi_stations{connection="wired",site="Default"} 1`),
 
 				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="",connection="wired",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 10`),
 				regexp.MustCompile(`unifi_stations_transmitted_bytes_total{ap_mac="",connection="wired",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 20`),
@@ -117,7 +223,7 @@ func TestStationCollector(t *testing.T) {
 }
 `),
 			matches: []*regexp.Regexp{
-				regexp.MustCompile(`unifi_stations{site="Default"} 2`),
+				regexp.MustCompile(`unifi_stations{connection="wireless",site="Default"} 2`),
 
 				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 10`),
 				regexp.MustCompile(`unifi_stations_transmitted_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 20`),
@@ -163,7 +269,7 @@ func TestStationCollector(t *testing.T) {
 }
 `),
 			matches: []*regexp.Regexp{
-				regexp.MustCompile(`unifi_stations{site="Default"} 1`),
+				regexp.MustCompile(`unifi_stations{connection="wireless",site="Default"} 1`),
 
 				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 10`),
 				regexp.MustCompile(`unifi_stations_transmitted_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 20`),
@@ -174,7 +280,7 @@ func TestStationCollector(t *testing.T) {
 				regexp.MustCompile(`unifi_stations_noise_dbm{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} -110`),
 				regexp.MustCompile(`unifi_stations_rssi_dbm{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Default",station_mac="de:ad:be:ef:de:ad"} 40`),
 
-				regexp.MustCompile(`unifi_stations{site="Some Site"} 1`),
+				regexp.MustCompile(`unifi_stations{connection="wireless",site="Some Site"} 1`),
 
 				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Some Site",station_mac="de:ad:be:ef:de:ad"} 10`),
 				regexp.MustCompile(`unifi_stations_transmitted_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",site="Some Site",station_mac="de:ad:be:ef:de:ad"} 20`),
@@ -196,30 +302,135 @@ func TestStationCollector(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "one station, one site, hashed identifiers",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "abcdef",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "de:ad:be:ef:de:ad",
+			"hostname": "foo",
+			"rx_bytes": 10
+		}
+	]
+}
+`),
+			privacy: privacy{mode: PrivacyHash, key: []byte("secret")},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="773ba44693c7553d",id="abcdef",site="Default",station_mac="26fa612704f408f5"} 10`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc: "two stations, one site, dropped identifiers",
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "abcdef",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "de:ad:be:ef:de:ad",
+			"hostname": "foo",
+			"rx_bytes": 10,
+			"rssi": 40
+		},
+		{
+			"_id": "123456",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:ad",
+			"hostname": "bar",
+			"rx_bytes": 20,
+			"rssi": 30
+		}
+	]
+}
+`),
+			privacy: privacy{mode: PrivacyDrop},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="",site="Default",station_mac=""} 30`),
+			},
+			nomatch: []*regexp.Regexp{
+				regexp.MustCompile(`id="`),
+				regexp.MustCompile(`unifi_stations_rssi_dbm{`),
+				regexp.MustCompile(`unifi_stations_info{`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
+		{
+			desc:       "two stations, one site, name labels",
+			nameLabels: true,
+			input: strings.TrimSpace(`
+{
+	"data": [
+		{
+			"_id": "abcdef",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "de:ad:be:ef:de:ad",
+			"hostname": "foo",
+			"name": "Living Room TV",
+			"rx_bytes": 10
+		},
+		{
+			"_id": "123456",
+			"ap_mac": "a0:a0:a0:a0:a0:a0",
+			"mac": "ab:ad:1d:ea:ab:ad",
+			"rx_bytes": 20
+		}
+	]
+}
+`),
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="foo",id="abcdef",name="Living Room TV",site="Default",station_mac="de:ad:be:ef:de:ad"} 10`),
+				regexp.MustCompile(`unifi_stations_received_bytes_total{ap_mac="a0:a0:a0:a0:a0:a0",connection="wireless",hostname="",id="123456",name="",site="Default",station_mac="ab:ad:1d:ea:ab:ad"} 20`),
+			},
+			sites: []*api.Site{{
+				Name:        "default",
+				Description: "Default",
+			}},
+		},
 	}
 
 	for i, tt := range tests {
 		t.Logf("[%02d] test %q", i, tt.desc)
 
-		out := testStationCollector(t, []byte(tt.input), tt.sites)
+		out := testStationCollector(t, []byte(tt.input), tt.sites, tt.privacy, tt.nameLabels)
 
 		for j, m := range tt.matches {
 			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
 
 			if !m.Match(out) {
-				t.Fatal("\toutput failed to match regex")
+				t.Fatalf("\toutput failed to match regex:\n%s", out)
+			}
+		}
+
+		for j, m := range tt.nomatch {
+			t.Logf("\t[%02d:%02d] no match: %s", i, j, m.String())
+
+			if m.Match(out) {
+				t.Fatalf("\toutput unexpectedly matched regex:\n%s", out)
 			}
 		}
 	}
 }
 
-func testStationCollector(t *testing.T, input []byte, sites []*api.Site) []byte {
+func testStationCollector(t *testing.T, input []byte, sites []*api.Site, p privacy, nameLabels bool) []byte {
 	c, done := testUniFiClient(t, input)
 	defer done()
 
-	collector := NewStationCollector(
+	collector := newStationCollector(
+		namespace,
 		c,
 		sites,
+		nameLabels,
+		p,
 	)
 
 	return testCollector(t, collector)
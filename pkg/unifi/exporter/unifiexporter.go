@@ -1,15 +1,20 @@
 package exporter
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
-	// namespace is the top-level namespace for this UniFi exporter.
+	// namespace is the default top-level namespace for this UniFi exporter.
 	namespace = "unifi"
 )
 
@@ -21,9 +26,86 @@ const (
 // register with Prometheus.
 type Exporter struct {
 	mu         sync.Mutex
-	collectors []collector
+	collectors []namedCollector
 	sites      []*api.Site
 	clientFn   ClientFunc
+
+	// snapshot is shared by all collectors, and reset after each scrape.
+	snapshot *snapshot
+
+	// authMu protects authenticated, which may be checked while a scrape
+	// holds mu.
+	authMu        sync.Mutex
+	authenticated bool
+
+	enabled           map[string]bool
+	dpiLimit          int
+	siteConcurrency   int
+	siteLabel         string
+	enableSiteInfo    bool
+	skipDisconnected  bool
+	privacy           privacy
+	stationNameLabels bool
+	namespace         string
+	logger            *log.Logger
+
+	// siteRefresh, if set, is the interval at which the list of sites is
+	// retrieved again, and passed through selectSites, during a scrape.
+	siteRefresh    time.Duration
+	selectSites    SiteFunc
+	sitesRefreshed time.Time
+
+	// allSites, if set, are the sites through which the collectors of the
+	// whole UniFi Controller retrieve its data in place of sites, and are
+	// selected again by selectAllSites when the list of sites is refreshed.
+	allSites       []*api.Site
+	selectAllSites SiteFunc
+
+	// siteConfig, if set, overrides the configuration of individual sites,
+	// and siteLabels are the labels it adds to the metrics of the current
+	// sites.
+	siteConfig SiteConfigFunc
+	siteLabels *siteLabels
+
+	// staleMaxAge, if set, is how long the last good metrics of a collector
+	// are served in place of those of a failed scrape.
+	staleMaxAge time.Duration
+	lastGood    map[string]lastGood
+
+	// lastSuccess is the time of each collector's last successful scrape.
+	lastSuccess map[string]time.Time
+
+	// wanIPs counts changes of gateways' WAN addresses, and outlives the
+	// collectors set up by initCollectors.
+	wanIPs *wanTracker
+
+	// events counts events from the UniFi Controller, if the events
+	// collector is enabled.  Unlike other collectors, it outlives the
+	// sessions and sites with which it is started.
+	events  *EventCollector
+	onEvent []EventFunc
+
+	// access counts attempts to open UniFi Access doors, if the access
+	// collector is enabled, and likewise outlives initCollectors.
+	access       *AccessCollector
+	accessSource AccessSource
+
+	// siteInfo carries the name, description and ID of each site.
+	siteInfo *prometheus.Desc
+
+	// Metrics about the Exporter's own scrapes of the UniFi Controller.
+	up              *prometheus.Desc
+	scrapeDuration  *prometheus.Desc
+	scrapeErrors    *prometheus.CounterVec
+	siteScrapeError *prometheus.Desc
+	lastSuccessTime *prometheus.Desc
+	dataAge         *prometheus.Desc
+}
+
+// lastGood is the output of a collector's last successful scrape.
+type lastGood struct {
+	metrics []prometheus.Metric
+	time    time.Time
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
@@ -31,31 +113,452 @@ var _ prometheus.Collector = &Exporter{}
 
 // collector is essentially a modified prometheus.Collector which can return
 // errors used to reconfigure the application.
+//
+// A collector does not send invalid metrics when it fails, so that one failing
+// collector does not fail an entire scrape.  Instead, the Exporter reports
+// failures with its unifi_up and unifi_collector_scrape_errors_total
+// metrics, or only the latter for auxiliaryCollectors.  A collector which fails for only some sites returns siteErrors,
+// which the Exporter reports with its unifi_site_scrape_error metric.
 type collector interface {
 	prometheus.Collector
-	CollectError(chan<- prometheus.Metric) error
+	CollectError(context.Context, chan<- prometheus.Metric) error
+}
+
+// A namedCollector is a collector and the name by which it is enabled, which
+// labels the metrics about its scrapes, and the sites for which it reports
+// whether its scrape failed, which are none for auxiliaryCollectors.
+type namedCollector struct {
+	name  string
+	sites []*api.Site
+	collector
 }
 
 // A ClientFunc is a function which can return an authenticated UniFi client.
 // A ClientFunc is invoked by an Exporter whenever authentication against a UniFi
 // controller fails, such as when a user's privileges are revoked or the
-// authenticated session times out.
-type ClientFunc func() (*api.Client, error)
+// authenticated session times out and cannot be re-established: that is, when
+// a collector fails with api.ErrAuthFailed or api.ErrTokenExpired.  The
+// previous api.Controller is logged out once a new one is returned.
+//
+// The returned api.Controller is usually an *api.Client, but may be any
+// implementation, such as one instrumented by the caller.
+type ClientFunc func(ctx context.Context) (api.Controller, error)
+
+// Names of the collectors which make up an Exporter, for use with
+// EnableCollector and DisableCollector.
+const (
+	CollectorDevices = "devices"
+	CollectorClients = "clients"
+	CollectorDPI     = "dpi"
+	CollectorEvents  = "events"
+	CollectorProtect = "protect"
+	CollectorAccess  = "access"
+
+	CollectorKnownClients = "knownclients"
+	CollectorBackups      = "backups"
+)
+
+// defaultCollectors reports whether each collector is enabled by default.
+var defaultCollectors = map[string]bool{
+	CollectorDevices: true,
+	CollectorClients: true,
+	CollectorDPI:     false,
+	CollectorEvents:  false,
+	CollectorProtect: false,
+	CollectorAccess:  false,
+
+	CollectorKnownClients: false,
+	CollectorBackups:      false,
+}
+
+// auxiliaryCollectors are the collectors of data beyond that of the UniFi
+// Controller's sites, which may be unavailable while its sites are not, such
+// as UniFi Protect on a classic controller, backups listed without admin
+// rights, or UniFi Access, which has its own API and token.  Their failures
+// are only counted by unifi_collector_scrape_errors_total, and neither fail
+// the scrape nor cause the Exporter to authenticate again.
+var auxiliaryCollectors = map[string]bool{
+	CollectorProtect: true,
+	CollectorAccess:  true,
+	CollectorBackups: true,
+}
+
+// An Option configures optional behavior of an Exporter.
+type Option func(e *Exporter)
+
+// EnableDPI enables collection of per-station deep packet inspection (DPI)
+// metrics.  DPI metrics are exported for at most limit stations per site,
+// to bound the number of time series produced.
+func EnableDPI(limit int) Option {
+	return func(e *Exporter) {
+		e.enabled[CollectorDPI] = true
+		e.dpiLimit = limit
+	}
+}
+
+// DefaultSiteConcurrency is the default maximum number of sites from which
+// each collector retrieves data at once.
+const DefaultSiteConcurrency = 4
+
+// SiteConcurrency sets the maximum number of sites from which each collector
+// retrieves data at once.  Because collectors also run in parallel, an
+// *api.Client should be configured with SetMaxConcurrentRequests to bound the
+// total number of requests made to the UniFi Controller.
+func SiteConcurrency(n int) Option {
+	return func(e *Exporter) {
+		e.siteConcurrency = n
+	}
+}
+
+// Sources of the site label, for use with SiteLabel.
+const (
+	SiteLabelDescription = "description"
+	SiteLabelName        = "name"
+	SiteLabelID          = "id"
+)
+
+// SiteLabel selects the field of each site used as the value of the site
+// label: its description, as shown in the UniFi Controller's web interface
+// (the default), its internal name, such as "default", or its ID.  Unlike
+// descriptions, names and IDs do not change when a site is renamed.
+func SiteLabel(source string) Option {
+	return func(e *Exporter) {
+		e.siteLabel = source
+	}
+}
+
+// EnableSiteInfo enables the unifi_site_info metric, which maps the site label
+// of each site to its name, description and ID.
+func EnableSiteInfo() Option {
+	return func(e *Exporter) {
+		e.enableSiteInfo = true
+	}
+}
+
+// SkipDisconnectedDevices excludes devices which the UniFi Controller reports
+// as disconnected, or as having missed their heartbeats, from every device
+// metric but unifi_devices_state, so that the last counters reported by a
+// decommissioned device are not exported indefinitely.
+func SkipDisconnectedDevices() Option {
+	return func(e *Exporter) {
+		e.skipDisconnected = true
+	}
+}
+
+// ServeStale serves the metrics of each collector's last successful scrape,
+// for up to maxAge, in place of those of a scrape which failed, such as while
+// the UniFi Controller restarts.  unifi_up still reports the failure, and
+// unifi_scrape_data_age_seconds reports how old the metrics served for each
+// collector are.
+func ServeStale(maxAge time.Duration) Option {
+	return func(e *Exporter) {
+		e.staleMaxAge = maxAge
+	}
+}
+
+// StationNameLabels exports the hostname reported by each station and the
+// alias given to it in the UniFi Controller as separate hostname and name
+// labels.  By default, the hostname label alone carries the alias, or the
+// hostname if the station has no alias.  Either label changes whenever a
+// station is renamed, starting a new time series.
+func StationNameLabels() Option {
+	return func(e *Exporter) {
+		e.stationNameLabels = true
+	}
+}
+
+// EnableAccess enables collection of metrics regarding the doors of UniFi
+// Access, which are retrieved from src, such as an *api.AccessClient, rather
+// than from the UniFi Controller.
+func EnableAccess(src AccessSource) Option {
+	return func(e *Exporter) {
+		e.enabled[CollectorAccess] = true
+		e.accessSource = src
+	}
+}
+
+// An EventFunc is called with each event streamed from the UniFi Controller,
+// and the site in which it occurred.
+type EventFunc func(site *api.Site, ev *api.Event)
+
+// OnEvent enables the events collector, and calls fn with each event it
+// receives.  fn is called by the goroutine which streams the site's events,
+// so it should return quickly, such as by queueing any slow work.  OnEvent
+// may be given more than once, and each fn is called in turn.
+func OnEvent(fn EventFunc) Option {
+	return func(e *Exporter) {
+		e.enabled[CollectorEvents] = true
+		e.onEvent = append(e.onEvent, fn)
+	}
+}
+
+// A SiteFunc selects the sites from which metrics are collected, from all of
+// the sites managed by a UniFi Controller.
+type SiteFunc func(sites []*api.Site) ([]*api.Site, error)
+
+// RefreshSites retrieves the list of sites from the UniFi Controller again, at
+// most once per interval, so that sites added to or removed from the UniFi
+// Controller after the Exporter is created are collected or dropped.  fn
+// selects the sites to collect; if fn is nil, every site is collected.  If
+// the list of sites cannot be retrieved or fn returns an error, the previous
+// sites continue to be collected.
+func RefreshSites(interval time.Duration, fn SiteFunc) Option {
+	return func(e *Exporter) {
+		e.siteRefresh = interval
+		e.selectSites = fn
+	}
+}
+
+// AllSites sets every selected site of the UniFi Controller, for an Exporter
+// which collects the metrics of only some of them, such as one of several
+// Exporters which split the sites between them.  Events are streamed from,
+// and backups listed through, sites rather than the sites whose other metrics
+// are collected.  With RefreshSites, fn selects them again from every site of
+// the UniFi Controller; if fn is nil, sites are kept.
+func AllSites(sites []*api.Site, fn SiteFunc) Option {
+	return func(e *Exporter) {
+		e.allSites = sites
+		e.selectAllSites = fn
+	}
+}
+
+// A SiteConfig overrides the configuration of an Exporter for a single site.
+type SiteConfig struct {
+	// Collectors enables or disables the devices, clients and DPI
+	// collectors for the site, by name, in place of the Exporter's
+	// configuration.  Other collectors cannot be configured per site, and
+	// are ignored.
+	Collectors map[string]bool
+
+	// Labels are added to every metric of the site which has a site label.
+	// Labels which a metric already has are not replaced.
+	Labels map[string]string
+
+	// Interval, if set, is the minimum interval at which the site's data is
+	// retrieved from the UniFi Controller.  Scrapes in between export the
+	// data retrieved last, so that a site which changes slowly can be
+	// collected less often than others.
+	Interval time.Duration
+}
+
+// siteCollectors are the collectors which can be configured per site.
+var siteCollectors = map[string]bool{
+	CollectorDevices: true,
+	CollectorClients: true,
+	CollectorDPI:     true,
+}
+
+// A SiteConfigFunc returns the SiteConfig of a site, or nil if the site uses
+// the configuration of the Exporter.
+type SiteConfigFunc func(s *api.Site) *SiteConfig
+
+// SiteConfigs overrides the configuration of individual sites with the
+// SiteConfig returned by fn for each site, whenever the sites are set up,
+// including after RefreshSites finds new sites.
+func SiteConfigs(fn SiteConfigFunc) Option {
+	return func(e *Exporter) {
+		e.siteConfig = fn
+	}
+}
+
+// Namespace sets the prefix of every metric name, in place of "unifi", such as
+// for embedding an Exporter in a service which exports metrics of its own.
+func Namespace(ns string) Option {
+	return func(e *Exporter) {
+		e.namespace = ns
+	}
+}
+
+// Logger sets the logger to which the Exporter and its collectors report
+// errors and changes in state, in place of the log package's standard logger.
+func Logger(l *log.Logger) Option {
+	return func(e *Exporter) {
+		e.logger = l
+	}
+}
+
+// logf logs a message to l, or to the log package's standard logger if l is
+// nil.
+func logf(l *log.Logger, format string, v ...interface{}) {
+	if l == nil {
+		log.Printf(format, v...)
+		return
+	}
+
+	l.Printf(format, v...)
+}
+
+// siteLabel returns the value of the site label for s, using the named source
+// field.  The description is used if source is empty.
+func siteLabel(source string, s *api.Site) string {
+	switch source {
+	case SiteLabelName:
+		return s.Name
+	case SiteLabelID:
+		return s.ID
+	default:
+		return s.Description
+	}
+}
+
+// EnableCollector enables the named collector, such as CollectorDPI.
+func EnableCollector(name string) Option {
+	return func(e *Exporter) {
+		e.enabled[name] = true
+	}
+}
+
+// DisableCollector disables the named collector, such as CollectorDevices.
+func DisableCollector(name string) Option {
+	return func(e *Exporter) {
+		e.enabled[name] = false
+	}
+}
 
 // New creates a new Exporter which collects metrics from one or mote sites.
-func New(sites []*api.Site, fn ClientFunc) (*Exporter, error) {
+func New(sites []*api.Site, fn ClientFunc, options ...Option) (*Exporter, error) {
 	e := &Exporter{
-		clientFn: fn,
-		sites:    sites,
+		clientFn:        fn,
+		sites:           sites,
+		enabled:         make(map[string]bool, len(defaultCollectors)),
+		siteConcurrency: DefaultSiteConcurrency,
+		siteLabel:       SiteLabelDescription,
+		sitesRefreshed:  time.Now(),
+		namespace:       namespace,
+		lastGood:        make(map[string]lastGood),
+		lastSuccess:     make(map[string]time.Time),
+		wanIPs:          newWANTracker(),
 	}
 
-	if err := e.initClient(); err != nil {
+	for name, enabled := range defaultCollectors {
+		e.enabled[name] = enabled
+	}
+
+	for _, o := range options {
+		o(e)
+	}
+
+	if e.enabled[CollectorEvents] {
+		e.events = newEventCollector(e.namespace)
+		e.events.logger = e.logger
+		e.events.siteLabel = e.siteLabel
+		if fns := e.onEvent; len(fns) > 0 {
+			e.events.onEvent = func(site *api.Site, ev *api.Event) {
+				for _, fn := range fns {
+					fn(site, ev)
+				}
+			}
+		}
+	}
+
+	if e.enabled[CollectorAccess] {
+		e.access = newAccessCollector(e.namespace, e.accessSource)
+		e.access.logger = e.logger
+	}
+
+	// Metric names depend on the Namespace option
+	e.siteInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "site", "info"),
+		"Information about a site, with a constant value of 1",
+		[]string{"site", "name", "description", "id"},
+		nil,
+	)
+
+	e.up = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "", "up"),
+		"Whether the last scrape of the UniFi Controller was successful",
+		nil,
+		nil,
+	)
+
+	e.scrapeDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "scrape", "duration_seconds"),
+		"Duration of the last scrape of the UniFi Controller, by collector",
+		[]string{"collector"},
+		nil,
+	)
+
+	e.scrapeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: e.namespace,
+			Subsystem: "collector",
+			Name:      "scrape_errors_total",
+			Help:      "Number of failed scrapes of the UniFi Controller, by collector",
+		},
+		[]string{"collector"},
+	)
+
+	e.siteScrapeError = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "site", "scrape_error"),
+		"Whether the last scrape of a site failed, by collector",
+		[]string{"collector", "site"},
+		nil,
+	)
+
+	e.lastSuccessTime = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "collector", "last_success_timestamp_seconds"),
+		"Unix time of the last successful scrape of the UniFi Controller, by collector, or 0 if it has never succeeded",
+		[]string{"collector"},
+		nil,
+	)
+
+	e.dataAge = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "scrape", "data_age_seconds"),
+		"Number of seconds since the metrics served for a collector were scraped from the UniFi Controller, which is non-zero while stale metrics are served",
+		[]string{"collector"},
+		nil,
+	)
+
+	switch e.siteLabel {
+	case SiteLabelDescription, SiteLabelName, SiteLabelID:
+	default:
+		return nil, fmt.Errorf("unknown site label source %q", e.siteLabel)
+	}
+
+	if err := e.privacy.validate(); err != nil {
+		return nil, err
+	}
+
+	for name, enabled := range e.enabled {
+		if _, ok := defaultCollectors[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+
+		// Export a zero error count for each collector from the start
+		if enabled {
+			e.scrapeErrors.WithLabelValues(name)
+		}
+	}
+
+	if err := e.initClient(context.Background()); err != nil {
 		return nil, err
 	}
 
 	return e, nil
 }
 
+// NewFromController creates a new Exporter which collects metrics from one or
+// more sites using c, which must already be authenticated.  It is intended for
+// registering an Exporter with an existing service's registry:
+//
+//	e, err := exporter.NewFromController(client, sites,
+//		exporter.Namespace("myservice_unifi"),
+//		exporter.DisableCollector(exporter.CollectorClients),
+//	)
+//	if err != nil {
+//		// handle error
+//	}
+//	prometheus.MustRegister(e)
+//
+// Unlike an Exporter created by New, c is never replaced, so it must
+// authenticate again itself, as an *api.Client does, if its session expires.
+// Close logs c out.
+func NewFromController(c api.Controller, sites []*api.Site, options ...Option) (*Exporter, error) {
+	return New(sites, func(_ context.Context) (api.Controller, error) {
+		return c, nil
+	}, options...)
+}
+
 // Describe sends all the descriptors of the collectors included to
 // the provided channel.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
@@ -65,42 +568,653 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, cc := range e.collectors {
 		cc.Describe(ch)
 	}
+
+	if e.enableSiteInfo {
+		ch <- e.siteInfo
+	}
+
+	ch <- e.up
+	ch <- e.scrapeDuration
+	ch <- e.siteScrapeError
+	ch <- e.lastSuccessTime
+	e.scrapeErrors.Describe(ch)
+
+	if e.staleMaxAge > 0 {
+		ch <- e.dataAge
+	}
 }
 
 // Collect sends the collected metrics from each of the collectors to
 // prometheus. Collect could be called several times concurrently
-// and thus its run is protected by a single mutex.
+// and thus its run is protected by a single mutex.  Within a single run,
+// the collectors run in parallel.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is like Collect, but cancels any requests to the UniFi
+// Controller when ctx is done.
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	for _, cc := range e.collectors {
-		if err := cc.CollectError(ch); err == nil {
+	// Every metric passes through the deduper, which is closed only after
+	// the deferred metrics below are sent
+	d := newDeduper(ch, e.logger)
+	defer d.close()
+	ch = d.in
+
+	// Data retrieved during this scrape must not be reused by the next,
+	// except that of sites with a SiteConfig interval
+	defer func() {
+		e.snapshot.reset()
+	}()
+
+	e.refreshSites(ctx)
+
+	// Site labels are added before metrics are deduplicated, for the sites
+	// as refreshed
+	if e.siteLabels != nil {
+		l := newSiteLabeler(ch, e.siteLabels)
+		defer l.close()
+		ch = l.in
+	}
+
+	if e.enableSiteInfo {
+		for _, s := range e.sites {
+			ch <- prometheus.MustNewConstMetric(
+				e.siteInfo,
+				prometheus.GaugeValue,
+				1,
+				siteLabel(e.siteLabel, s), s.Name, s.Description, s.ID,
+			)
+		}
+	}
+
+	up, reauth := 1.0, false
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up)
+		e.scrapeErrors.Collect(ch)
+	}()
+
+	errs := make([]error, len(e.collectors))
+	results := make([][]prometheus.Metric, len(e.collectors))
+
+	var wg sync.WaitGroup
+	wg.Add(len(e.collectors))
+	for i, cc := range e.collectors {
+		go func(i int, cc namedCollector) {
+			defer wg.Done()
+
+			// With ServeStale, whether a collector's metrics are sent
+			// depends on whether it fails, so hold them until it is done
+			cch := ch
+			if e.staleMaxAge > 0 {
+				buf := make(chan prometheus.Metric)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for m := range buf {
+						results[i] = append(results[i], m)
+					}
+				}()
+				defer func() {
+					close(buf)
+					<-done
+				}()
+				cch = buf
+			}
+
+			start := time.Now()
+			errs[i] = cc.CollectError(ctx, cch)
+
+			ch <- prometheus.MustNewConstMetric(
+				e.scrapeDuration,
+				prometheus.GaugeValue,
+				time.Since(start).Seconds(),
+				cc.name,
+			)
+		}(i, cc)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		name := e.collectors[i].name
+		failed := e.collectSiteErrors(ch, name, e.collectors[i].sites, err)
+		if e.staleMaxAge > 0 {
+			e.collectStale(ch, name, results[i], failed)
+		}
+		if !failed {
+			e.lastSuccess[name] = time.Now()
+		}
+
+		var last float64
+		if t, ok := e.lastSuccess[name]; ok {
+			last = float64(t.UnixNano()) / 1e9
+		}
+		ch <- prometheus.MustNewConstMetric(e.lastSuccessTime, prometheus.GaugeValue, last, name)
+
+		if !failed {
 			continue
 		}
 
-		if err := e.initClient(); err != nil {
-			log.Printf("[ERROR] could not initialize UniFi client: %v", err)
-			return
+		e.scrapeErrors.WithLabelValues(name).Inc()
+		if auxiliaryCollectors[name] {
+			continue
+		}
+
+		up = 0
+		reauth = reauth || isAuthError(errs[i])
+	}
+
+	// A client re-authenticates by itself when its session expires, so a new
+	// one is only needed once it can no longer do so
+	if !reauth {
+		return
+	}
+
+	// The scrape was abandoned, so there is no point in authenticating again
+	if ctx.Err() != nil {
+		logf(e.logger, "[ERROR] scrape cancelled: %v", ctx.Err())
+		return
+	}
+
+	if err := e.initClient(ctx); err != nil {
+		logf(e.logger, "[ERROR] could not initialize UniFi client: %v", err)
+	}
+}
+
+// isAuthError reports whether err, or the error of any site in err, is one
+// with which an api.Controller reports that it could not authenticate again
+// by itself, such as when its credentials are rejected.
+func isAuthError(err error) bool {
+	if errs, ok := err.(siteErrors); ok {
+		for _, err := range errs {
+			if isAuthError(err) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return err == api.ErrAuthFailed || err == api.ErrTokenExpired
+}
+
+// collectSiteErrors sends whether the named collector failed for each site,
+// given the error it returned, and reports whether it failed for every site.
+// Only a collector which failed for every site fails the scrape, because a
+// single site may be unavailable, such as when the user cannot access it.
+func (e *Exporter) collectSiteErrors(ch chan<- prometheus.Metric, name string, sites []*api.Site, err error) bool {
+	errs, partial := err.(siteErrors)
+	for _, s := range sites {
+		var failed float64
+		if _, ok := errs[s]; ok || (err != nil && !partial) {
+			failed = 1
 		}
+
+		ch <- prometheus.MustNewConstMetric(
+			e.siteScrapeError,
+			prometheus.GaugeValue,
+			failed,
+			name, siteLabel(e.siteLabel, s),
+		)
 	}
+
+	return err != nil && (!partial || len(errs) >= len(sites))
+}
+
+// collectStale sends the metrics collected by the named collector, or, if it
+// failed and its last good metrics are no older than the ServeStale maximum
+// age, those instead, along with the age of the metrics sent.
+func (e *Exporter) collectStale(ch chan<- prometheus.Metric, name string, metrics []prometheus.Metric, failed bool) {
+	now := time.Now()
+
+	lg, ok := e.lastGood[name]
+	switch {
+	case !failed:
+		lg = lastGood{metrics: metrics, time: now}
+		e.lastGood[name] = lg
+	case ok && now.Sub(lg.time) <= e.staleMaxAge:
+		logf(e.logger, "[WARN] serving metrics of collector %q from %s ago", name, now.Sub(lg.time))
+	default:
+		// Nothing recent enough to serve, so send whatever was collected
+		lg = lastGood{metrics: metrics, time: now}
+	}
+
+	for _, m := range lg.metrics {
+		ch <- m
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		e.dataAge,
+		prometheus.GaugeValue,
+		now.Sub(lg.time).Seconds(),
+		name,
+	)
+}
+
+// Authenticated reports whether the Exporter's most recent attempt to
+// authenticate against the UniFi Controller succeeded, and thus whether it has
+// a session with which to collect metrics.
+func (e *Exporter) Authenticated() bool {
+	e.authMu.Lock()
+	defer e.authMu.Unlock()
+
+	return e.authenticated
+}
+
+// SiteDevices are the devices of a single site.
+type SiteDevices struct {
+	Site    *api.Site
+	Devices []*api.Device
+}
+
+// Devices retrieves the devices of each site exported by e from the UniFi
+// Controller, such as for other scrapers to discover them as targets.  It
+// always makes fresh requests, rather than sharing those of a scrape.
+//
+// If the devices of some sites could not be retrieved, the devices of the
+// others are returned along with an error.
+func (e *Exporter) Devices(ctx context.Context) ([]SiteDevices, error) {
+	e.mu.Lock()
+	sites, c := e.sites, e.snapshot.Controller
+	e.mu.Unlock()
+
+	var mu sync.Mutex
+	bySite := make(map[*api.Site][]*api.Device, len(sites))
+	err := forEachSite(ctx, sites, e.siteConcurrency, func(ctx context.Context, s *api.Site) error {
+		ds, err := c.Devices(ctx, s.Name)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		bySite[s] = ds
+		return nil
+	})
+
+	// Sites are returned in the order in which they are exported
+	sds := make([]SiteDevices, 0, len(bySite))
+	for _, s := range sites {
+		if ds, ok := bySite[s]; ok {
+			sds = append(sds, SiteDevices{Site: s, Devices: ds})
+		}
+	}
+
+	return sds, err
+}
+
+// Close ends the Exporter's session with the UniFi Controller, if its
+// api.Controller supports logging out, as an *api.Client does.  Close waits
+// for any scrape in progress to complete.  The Exporter must not be used after
+// Close is called.
+func (e *Exporter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.events != nil {
+		e.events.Stop()
+	}
+
+	if e.snapshot == nil {
+		return nil
+	}
+
+	e.setAuthenticated(false)
+
+	return logout(ctx, e.snapshot.Controller)
+}
+
+// logout ends the session of c with the UniFi Controller, if c supports
+// logging out, as an *api.Client does.
+func logout(ctx context.Context, c api.Controller) error {
+	l, ok := c.(interface {
+		Logout(ctx context.Context) error
+	})
+	if !ok {
+		return nil
+	}
+
+	return l.Logout(ctx)
+}
+
+// setAuthenticated records the outcome of an attempt to authenticate.
+func (e *Exporter) setAuthenticated(ok bool) {
+	e.authMu.Lock()
+	defer e.authMu.Unlock()
+
+	e.authenticated = ok
+}
+
+// WithContext returns a prometheus.Collector which collects metrics from e,
+// cancelling any requests to the UniFi Controller when ctx is done.  It is
+// intended to be registered with a short-lived registry for a single scrape.
+func (e *Exporter) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{
+		e:   e,
+		ctx: ctx,
+	}
+}
+
+// A contextCollector is a prometheus.Collector which binds an Exporter to a
+// context.Context.
+type contextCollector struct {
+	e   *Exporter
+	ctx context.Context
+}
+
+// Describe implements prometheus.Collector.
+func (c *contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.e.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.e.CollectContext(c.ctx, ch)
 }
 
 // initClient sets up collectors for the Exporter, authenticating against
-// the UniFi controller with a fresh session before doing so.
+// the UniFi controller with a fresh session before doing so.  The session of
+// the api.Controller it replaces, if any, is ended.
 //
 // initClient must be called with e's mutex locked.
-func (e *Exporter) initClient() error {
-	c, err := e.clientFn()
+func (e *Exporter) initClient(ctx context.Context) error {
+	client, err := e.clientFn(ctx)
+	e.setAuthenticated(err == nil)
 	if err != nil {
 		return err
 	}
 
-	e.collectors = []collector{
-		NewDeviceCollector(c, e.sites),
-		NewStationCollector(c, e.sites),
+	// The previous session is most likely gone already, but if not, it
+	// would linger on the UniFi Controller until it expired
+	if e.snapshot != nil && e.snapshot.Controller != client {
+		if err := logout(ctx, e.snapshot.Controller); err != nil {
+			logf(e.logger, "[WARN] failed to log out of previous UniFi controller session: %v", err)
+		}
+	}
+
+	// Collectors which need the same data from the UniFi Controller share
+	// a single request for it
+	e.snapshot = newSnapshot(client)
+	e.initCollectors()
+
+	logf(e.logger, "[INFO] successfully authenticated to UniFi controller")
+	return nil
+}
+
+// initCollectors sets up the enabled collectors for the Exporter's current
+// sites and snapshot.
+//
+// initCollectors must be called with e's mutex locked.
+func (e *Exporter) initCollectors() {
+	c := e.snapshot
+	e.initSiteConfigs()
+
+	e.collectors = nil
+	if sites, ok := e.collectorSites(CollectorDevices); ok {
+		dc := newDeviceCollector(e.namespace, c, sites)
+		dc.logger = e.logger
+		dc.concurrency = e.siteConcurrency
+		dc.siteLabel = e.siteLabel
+		dc.skipDisconnected = e.skipDisconnected
+		dc.wanIPs = e.wanIPs
+		// Port configuration and WAN health are only resolved by an
+		// api.Controller which supports them
+		dc.portConfig, _ = c.Controller.(PortConfigSource)
+		dc.wanHealth, _ = c.Controller.(WANHealthSource)
+		e.collectors = append(e.collectors, namedCollector{CollectorDevices, sites, dc})
+	}
+	if sites, ok := e.collectorSites(CollectorClients); ok {
+		sc := newStationCollector(e.namespace, c, sites, e.stationNameLabels, e.privacy)
+		sc.logger = e.logger
+		sc.concurrency = e.siteConcurrency
+		sc.siteLabel = e.siteLabel
+		e.collectors = append(e.collectors, namedCollector{CollectorClients, sites, sc})
+	}
+	if sites, ok := e.collectorSites(CollectorDPI); ok {
+		dpic := newDPICollector(e.namespace, c, sites, e.dpiLimit)
+		dpic.logger = e.logger
+		dpic.concurrency = e.siteConcurrency
+		dpic.siteLabel = e.siteLabel
+		dpic.privacy = e.privacy
+		e.collectors = append(e.collectors, namedCollector{CollectorDPI, sites, dpic})
+	}
+	if e.enabled[CollectorProtect] {
+		// UniFi Protect is retrieved with the same session as other
+		// requests, but only from an api.Controller which supports it, such
+		// as an *api.Client; otherwise the collector fails each scrape
+		src, _ := c.Controller.(ProtectSource)
+		pc := newProtectCollector(e.namespace, src)
+		pc.logger = e.logger
+		e.collectors = append(e.collectors, namedCollector{CollectorProtect, nil, pc})
+	}
+	if e.enabled[CollectorKnownClients] {
+		// Known clients are likewise only retrieved from an
+		// api.Controller which supports it
+		src, _ := c.Controller.(KnownClientSource)
+		kc := newKnownClientCollector(e.namespace, src, e.sites)
+		kc.logger = e.logger
+		kc.concurrency = e.siteConcurrency
+		kc.siteLabel = e.siteLabel
+		kc.privacy = e.privacy
+		e.collectors = append(e.collectors, namedCollector{CollectorKnownClients, e.sites, kc})
+	}
+	if e.enabled[CollectorBackups] {
+		// Backups are likewise only listed by an api.Controller which
+		// supports it
+		src, _ := c.Controller.(BackupSource)
+		bc := newBackupCollector(e.namespace, src, e.controllerSites())
+		bc.logger = e.logger
+		e.collectors = append(e.collectors, namedCollector{CollectorBackups, nil, bc})
+	}
+	if e.access != nil {
+		e.collectors = append(e.collectors, namedCollector{CollectorAccess, nil, e.access})
+	}
+	if e.events != nil {
+		sites := e.controllerSites()
+
+		// Events are streamed with the same session as other requests, but
+		// only an api.Controller which can stream them, such as an
+		// *api.Client, has any to count
+		if src, ok := c.Controller.(EventSource); ok {
+			e.events.Start(src, sites)
+		} else {
+			logf(e.logger, "[WARN] UniFi controller does not support event streams, so no events are counted")
+		}
+		e.collectors = append(e.collectors, namedCollector{CollectorEvents, sites, e.events})
+	}
+
+	// A collector may be enabled only by the SiteConfig of a site, so its
+	// zero error count is only exported now
+	for _, cc := range e.collectors {
+		e.scrapeErrors.WithLabelValues(cc.name)
+	}
+}
+
+// initSiteConfigs sets up the labels and intervals of the SiteConfig of each
+// of the Exporter's current sites.
+//
+// initSiteConfigs must be called with e's mutex locked.
+func (e *Exporter) initSiteConfigs() {
+	e.siteLabels = nil
+	if e.siteConfig == nil {
+		return
+	}
+
+	labels := make(map[string]map[string]string)
+	intervals := make(map[string]time.Duration)
+	for _, s := range e.sites {
+		sc := e.siteConfig(s)
+		if sc == nil {
+			continue
+		}
+
+		if len(sc.Labels) > 0 {
+			labels[siteLabel(e.siteLabel, s)] = sc.Labels
+		}
+		if sc.Interval > 0 {
+			intervals[s.Name] = sc.Interval
+		}
+	}
+
+	e.siteLabels = newSiteLabels(labels)
+	e.snapshot.setIntervals(intervals)
+}
+
+// collectorSites returns the sites for which the named collector is enabled,
+// by the Exporter's configuration or the SiteConfig of each site, and whether
+// the collector is enabled at all.
+func (e *Exporter) collectorSites(name string) ([]*api.Site, bool) {
+	if e.siteConfig == nil || !siteCollectors[name] {
+		return e.sites, e.enabled[name]
+	}
+
+	var sites []*api.Site
+	for _, s := range e.sites {
+		enabled := e.enabled[name]
+		if sc := e.siteConfig(s); sc != nil {
+			if v, ok := sc.Collectors[name]; ok {
+				enabled = v
+			}
+		}
+
+		if enabled {
+			sites = append(sites, s)
+		}
+	}
+
+	return sites, e.enabled[name] || len(sites) > 0
+}
+
+// refreshSites retrieves the list of sites again if the RefreshSites interval
+// has elapsed, and sets up the collectors again if the selected sites have
+// changed.
+//
+// refreshSites must be called with e's mutex locked.
+func (e *Exporter) refreshSites(ctx context.Context) {
+	if e.siteRefresh <= 0 || time.Since(e.sitesRefreshed) < e.siteRefresh {
+		return
+	}
+
+	all, err := e.snapshot.Sites(ctx)
+	sites, allSites := all, e.allSites
+	if err == nil && e.selectSites != nil {
+		sites, err = e.selectSites(all)
+	}
+	if err == nil && e.selectAllSites != nil {
+		allSites, err = e.selectAllSites(all)
+	}
+	if err != nil {
+		logf(e.logger, "[WARN] failed to refresh list of sites, keeping previous sites: %v", err)
+		return
+	}
+
+	e.sitesRefreshed = time.Now()
+	if sameSites(e.sites, sites) && sameSites(e.allSites, allSites) {
+		return
+	}
+
+	logf(e.logger, "[INFO] list of sites changed from %d to %d site(s)", len(e.sites), len(sites))
+	e.sites, e.allSites = sites, allSites
+	e.initCollectors()
+}
+
+// controllerSites returns the sites through which the collectors of the whole
+// UniFi Controller retrieve its data: the AllSites, if set, or else the sites
+// whose metrics are collected.
+func (e *Exporter) controllerSites() []*api.Site {
+	if e.allSites != nil {
+		return e.allSites
+	}
+
+	return e.sites
+}
+
+// sameSites reports whether a and b contain the same sites, in the same order.
+func sameSites(a, b []*api.Site) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Name != b[i].Name || a[i].Description != b[i].Description {
+			return false
+		}
+	}
+
+	return true
+}
+
+// siteErrors are the errors returned for individual sites by the function
+// passed to forEachSite.  Metrics were still collected for every other site.
+type siteErrors map[*api.Site]error
+
+// Error implements error.
+func (e siteErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for s, err := range e {
+		msgs = append(msgs, fmt.Sprintf("site %q: %v", s.Name, err))
+	}
+	sort.Strings(msgs)
+
+	return strings.Join(msgs, "; ")
+}
+
+// forEachSite invokes fn for each of sites, with at most n invocations in
+// progress at once.  If n is zero or less, sites are visited one at a time.
+//
+// A site for which fn returns an error does not stop the others from being
+// visited; the errors are returned together as siteErrors.  If ctx is done
+// before every site has been visited, the remaining sites are skipped and
+// ctx.Err() is returned instead.
+func forEachSite(ctx context.Context, sites []*api.Site, n int, fn func(ctx context.Context, s *api.Site) error) error {
+	if n <= 0 {
+		n = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		skipped bool
+
+		mu   sync.Mutex
+		errs = make(siteErrors)
+	)
+
+	sem := make(chan struct{}, n)
+	for _, s := range sites {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			skipped = true
+			break
+		}
+
+		wg.Add(1)
+		go func(s *api.Site) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := fn(ctx, s); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs[s] = err
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if skipped {
+		return ctx.Err()
+	}
+	if len(errs) > 0 {
+		return errs
 	}
 
-	log.Println("[INFO] successfully authenticated to UniFi controller")
 	return nil
 }
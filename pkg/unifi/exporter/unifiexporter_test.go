@@ -1,13 +1,25 @@
 package exporter
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bah2830/unifi_exporter/pkg/unifi/api"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func testUniFiClient(t *testing.T, input []byte) (*api.Client, func()) {
@@ -25,12 +37,15 @@ func testUniFiClient(t *testing.T, input []byte) (*api.Client, func()) {
 }
 
 func testCollector(t *testing.T, collector prometheus.Collector) []byte {
-	if err := prometheus.Register(collector); err != nil {
+	// Each collector is registered with its own registry, because the
+	// default registry rejects a metric whose labels differ from those it was
+	// first registered with, even after it is unregistered
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(collector); err != nil {
 		t.Fatalf("failed to register Prometheus collector: %v", err)
 	}
-	defer prometheus.Unregister(collector)
 
-	promServer := httptest.NewServer(prometheus.Handler())
+	promServer := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 	defer promServer.Close()
 
 	resp, err := http.Get(promServer.URL)
@@ -46,3 +61,793 @@ func testCollector(t *testing.T, collector prometheus.Collector) []byte {
 
 	return buf
 }
+
+func TestExporterReinitializesClientOnAuthError(t *testing.T) {
+	var cs []*fakeController
+	fn := func(_ context.Context) (api.Controller, error) {
+		c := &fakeController{
+			failDevices: len(cs) == 0,
+			devicesErr:  api.ErrAuthFailed,
+		}
+		cs = append(cs, c)
+		return c, nil
+	}
+
+	e, err := New([]*api.Site{{Name: "default", Description: "Default"}}, fn)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+
+	if want, got := 2, len(cs); want != got {
+		t.Fatalf("unexpected number of ClientFunc calls:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The session which could no longer authenticate is ended, and the new
+	// one is kept
+	if want, got := []bool{true, false}, []bool{cs[0].loggedOut, cs[1].loggedOut}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected logouts:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_up 0`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="devices"} 1`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="clients"} 0`),
+		regexp.MustCompile(`unifi_scrape_duration_seconds{collector="clients"} \d`),
+		regexp.MustCompile(`unifi_site_scrape_error{collector="devices",site="Default"} 1`),
+		regexp.MustCompile(`unifi_collector_last_success_timestamp_seconds{collector="devices"} 0\n`),
+		regexp.MustCompile(`unifi_collector_last_success_timestamp_seconds{collector="clients"} 1\.\d+e\+09`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatal("\toutput failed to match regex")
+		}
+	}
+}
+
+func TestExporterKeepsClientOnError(t *testing.T) {
+	var calls int
+	fn := func(_ context.Context) (api.Controller, error) {
+		calls++
+		return &fakeController{failDevices: true}, nil
+	}
+
+	e, err := New([]*api.Site{{Name: "default", Description: "Default"}}, fn,
+		Logger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	// A failure which is not one of authentication, such as the UniFi
+	// Controller being unreachable, fails the scrape, but a new session
+	// would fare no better
+	for i := 0; i < 2; i++ {
+		out := testCollector(t, e)
+
+		if !regexp.MustCompile(`unifi_up 0`).Match(out) {
+			t.Fatalf("output failed to match regex:\n%s", out)
+		}
+	}
+
+	if want, got := 1, calls; want != got {
+		t.Fatalf("unexpected number of ClientFunc calls:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestExporterAuxiliaryCollectorFailure(t *testing.T) {
+	var calls int
+	fn := func(_ context.Context) (api.Controller, error) {
+		calls++
+		return &fakeController{}, nil
+	}
+
+	// A fakeController supports neither UniFi Protect nor backups, and no
+	// UniFi Access source is given, so each of them fails every scrape
+	e, err := New([]*api.Site{{Name: "default", Description: "Default"}}, fn,
+		EnableCollector(CollectorProtect),
+		EnableCollector(CollectorBackups),
+		EnableAccess(nil),
+		Logger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+
+	if want, got := 1, calls; want != got {
+		t.Fatalf("unexpected number of ClientFunc calls:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_up 1`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="protect"} 1`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="backups"} 1`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="access"} 1`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatalf("\toutput failed to match regex:\n%s", out)
+		}
+	}
+
+	if regexp.MustCompile(`unifi_site_scrape_error{collector="(protect|backups|access)"`).Match(out) {
+		t.Fatal("output contains site scrape errors for a collector of no site")
+	}
+}
+
+// A fakeController is an api.Controller which returns no data other than its
+// sites and devices, and optionally fails to retrieve devices for every site,
+// with devicesErr if set, or for the site named failSite.
+type fakeController struct {
+	api.Controller
+	sites       []*api.Site
+	devices     []*api.Device
+	failDevices bool
+	devicesErr  error
+	failSite    string
+	loggedOut   bool
+}
+
+func (c *fakeController) Sites(_ context.Context) ([]*api.Site, error) {
+	return c.sites, nil
+}
+
+func (c *fakeController) Devices(_ context.Context, site string) ([]*api.Device, error) {
+	if c.failDevices && c.devicesErr != nil {
+		return nil, c.devicesErr
+	}
+	if c.failDevices || site == c.failSite {
+		return nil, errors.New("failed to retrieve devices")
+	}
+
+	return c.devices, nil
+}
+
+func (c *fakeController) Stations(_ context.Context, _ string) ([]*api.Station, error) {
+	return nil, nil
+}
+
+func (c *fakeController) Logout(_ context.Context) error {
+	c.loggedOut = true
+	return nil
+}
+
+func TestExporterSiteFailure(t *testing.T) {
+	var calls int
+	fn := func(_ context.Context) (api.Controller, error) {
+		calls++
+		return &fakeController{failSite: "lab"}, nil
+	}
+
+	sites := []*api.Site{
+		{Name: "default", Description: "Default"},
+		{Name: "lab", Description: "Lab"},
+	}
+	e, err := New(sites, fn)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+
+	// A single failing site must not fail the scrape, nor cause the Exporter
+	// to authenticate again
+	if want, got := 1, calls; want != got {
+		t.Fatalf("unexpected number of ClientFunc calls:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_up 1`),
+		regexp.MustCompile(`unifi_devices{site="Default"} 0`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="devices"} 0`),
+		regexp.MustCompile(`unifi_site_scrape_error{collector="devices",site="Default"} 0`),
+		regexp.MustCompile(`unifi_site_scrape_error{collector="devices",site="Lab"} 1`),
+		regexp.MustCompile(`unifi_site_scrape_error{collector="clients",site="Lab"} 0`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatalf("\toutput failed to match regex:\n%s", out)
+		}
+	}
+
+	if regexp.MustCompile(`unifi_devices{site="Lab"}`).Match(out) {
+		t.Fatal("output contains metrics for a site which failed")
+	}
+}
+
+func TestExporterDevices(t *testing.T) {
+	devices := []*api.Device{{Name: "ap", IP: net.IPv4(192, 168, 1, 2)}}
+	c := &fakeController{devices: devices, failSite: "lab"}
+
+	sites := []*api.Site{
+		{Name: "default", Description: "Default"},
+		{Name: "lab", Description: "Lab"},
+	}
+	e, err := NewFromController(c, sites)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	// The devices of the failing site are omitted, but those of the others
+	// are still returned
+	sds, err := e.Devices(context.Background())
+	if err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	want := []SiteDevices{{Site: sites[0], Devices: devices}}
+	if got := sds; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected devices:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestExporterServeStale(t *testing.T) {
+	mac, _ := net.ParseMAC("de:ad:be:ef:de:ad")
+	c := &fakeController{devices: []*api.Device{{
+		ID:     "abc",
+		MAC:    mac,
+		Stats:  &api.DeviceStats{Uplink: &api.WiredStats{}},
+		Uptime: 10 * time.Second,
+	}}}
+	fn := func(_ context.Context) (api.Controller, error) {
+		return c, nil
+	}
+
+	e, err := New([]*api.Site{{Name: "default", Description: "Default"}}, fn,
+		ServeStale(time.Hour),
+		Logger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	_ = testCollector(t, e)
+
+	// The UniFi Controller becomes unreachable after the first scrape
+	c.failDevices = true
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_up 0`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="devices"} 1`),
+		regexp.MustCompile(`unifi_site_scrape_error{collector="devices",site="Default"} 1`),
+		regexp.MustCompile(`unifi_devices{site="Default"} 1`),
+		regexp.MustCompile(`unifi_devices_uptime_seconds{id="abc",mac="de:ad:be:ef:de:ad",name="",site="Default"} 10`),
+		regexp.MustCompile(`unifi_scrape_data_age_seconds{collector="devices"} (0\.|[1-9])`),
+		regexp.MustCompile(`unifi_scrape_data_age_seconds{collector="clients"} 0\n`),
+		regexp.MustCompile(`unifi_collector_last_success_timestamp_seconds{collector="devices"} 1\.\d+e\+09`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatalf("\toutput failed to match regex:\n%s", out)
+		}
+	}
+}
+
+func TestExporterDuplicateMetrics(t *testing.T) {
+	mac, _ := net.ParseMAC("de:ad:be:ef:de:ad")
+	device := func() *api.Device {
+		return &api.Device{
+			ID:     "abc",
+			MAC:    mac,
+			Stats:  &api.DeviceStats{Uplink: &api.WiredStats{}},
+			Uptime: 10 * time.Second,
+		}
+	}
+
+	// The UniFi Controller reports the same device twice
+	c := &fakeController{devices: []*api.Device{device(), device()}}
+
+	var buf bytes.Buffer
+	e, err := NewFromController(c, []*api.Site{{Name: "default", Description: "Default"}},
+		Logger(log.New(&buf, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_up 1`),
+		regexp.MustCompile(`unifi_devices{site="Default"} 2`),
+		regexp.MustCompile(`unifi_devices_uptime_seconds_total{id="abc",mac="de:ad:be:ef:de:ad",name="",site="Default"} 10`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatalf("\toutput failed to match regex:\n%s", out)
+		}
+	}
+
+	if want, got := `dropped 1 duplicate sample(s) of metric Desc{fqName: "unifi_devices_uptime_seconds_total"`, buf.String(); !strings.Contains(got, want) {
+		t.Fatalf("unexpected log output:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestExporterRefreshSites(t *testing.T) {
+	c := &fakeController{
+		sites: []*api.Site{
+			{Name: "default", Description: "Default"},
+			{Name: "lab", Description: "Lab"},
+			{Name: "office", Description: "Office"},
+		},
+	}
+	fn := func(_ context.Context) (api.Controller, error) {
+		return c, nil
+	}
+
+	// Every site but the lab is selected on refresh
+	selectSites := func(sites []*api.Site) ([]*api.Site, error) {
+		var pick []*api.Site
+		for _, s := range sites {
+			if s.Name != "lab" {
+				pick = append(pick, s)
+			}
+		}
+
+		return pick, nil
+	}
+
+	e, err := New(c.sites[:1], fn, RefreshSites(time.Nanosecond, selectSites))
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_devices{site="Default"} 0`),
+		regexp.MustCompile(`unifi_devices{site="Office"} 0`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatal("\toutput failed to match regex")
+		}
+	}
+
+	if regexp.MustCompile(`site="Lab"`).Match(out) {
+		t.Fatal("output contains metrics for a site which is not selected")
+	}
+}
+
+func TestExporterAllSites(t *testing.T) {
+	c := &allSitesController{
+		fakeController: fakeController{
+			sites: []*api.Site{
+				{Name: "default", Description: "Default"},
+				{Name: "lab", Description: "Lab"},
+			},
+		},
+		streamed: make(map[string]bool),
+	}
+	fn := func(_ context.Context) (api.Controller, error) {
+		return c, nil
+	}
+
+	// Devices are collected from the lab site, but events are streamed
+	// from both, and backups listed through the first
+	e, err := New(c.sites[1:], fn,
+		OnEvent(func(*api.Site, *api.Event) {}),
+		EnableCollector(CollectorBackups),
+		AllSites(c.sites, nil),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+	defer e.Close(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.hasStreamed("default", "lab") {
+		if time.Now().After(deadline) {
+			t.Fatal("events of every site were not streamed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out := testCollector(t, e)
+	if regexp.MustCompile(`unifi_devices{site="Default"}`).Match(out) {
+		t.Fatal("output contains devices of a site which is not collected")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if want, got := "default", c.backupSite; want != got {
+		t.Fatalf("unexpected site through which backups were listed:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// An allSitesController is a fakeController which records the sites whose
+// events are streamed, and through which backups are listed, and fails each
+// stream.
+type allSitesController struct {
+	fakeController
+
+	mu         sync.Mutex
+	streamed   map[string]bool
+	backupSite string
+}
+
+func (c *allSitesController) Events(_ context.Context, site string) (*api.EventStream, error) {
+	c.mu.Lock()
+	c.streamed[site] = true
+	c.mu.Unlock()
+
+	return nil, errors.New("no events")
+}
+
+func (c *allSitesController) Backups(_ context.Context, site string) ([]*api.Backup, error) {
+	c.mu.Lock()
+	c.backupSite = site
+	c.mu.Unlock()
+
+	return nil, nil
+}
+
+func (c *allSitesController) hasStreamed(sites ...string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range sites {
+		if !c.streamed[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A siteCountingController is a fakeController which counts the requests for
+// the devices of each site.
+type siteCountingController struct {
+	fakeController
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *siteCountingController) Devices(ctx context.Context, site string) ([]*api.Device, error) {
+	c.mu.Lock()
+	c.calls[site]++
+	c.mu.Unlock()
+
+	return c.fakeController.Devices(ctx, site)
+}
+
+func TestExporterSiteConfigs(t *testing.T) {
+	c := &siteCountingController{calls: make(map[string]int)}
+	fn := func(_ context.Context) (api.Controller, error) {
+		return c, nil
+	}
+
+	sites := []*api.Site{
+		{Name: "default", Description: "Default"},
+		{Name: "hq", Description: "HQ"},
+		{Name: "lab", Description: "Lab"},
+	}
+	configs := map[string]*SiteConfig{
+		"hq": {
+			Collectors: map[string]bool{CollectorClients: true},
+			Labels:     map[string]string{"tier": "hq"},
+			Interval:   time.Hour,
+		},
+		"lab": {
+			Collectors: map[string]bool{CollectorDevices: false},
+		},
+	}
+
+	e, err := New(sites, fn,
+		DisableCollector(CollectorClients),
+		SiteConfigs(func(s *api.Site) *SiteConfig {
+			return configs[s.Name]
+		}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	// The data of the HQ site is retrieved again only after its interval
+	var out []byte
+	for i := 0; i < 2; i++ {
+		out = testCollector(t, e)
+	}
+
+	if want, got := (map[string]int{"default": 2, "hq": 1}), c.calls; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected requests for devices:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_devices{site="Default",tier=""} 0`),
+		regexp.MustCompile(`unifi_devices{site="HQ",tier="hq"} 0`),
+		regexp.MustCompile(`unifi_site_scrape_error{collector="clients",site="HQ",tier="hq"} 0`),
+		regexp.MustCompile(`unifi_collector_scrape_errors_total{collector="clients"} 0`),
+		regexp.MustCompile(`unifi_up 1`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatal("\toutput failed to match regex")
+		}
+	}
+
+	nonMatches := []*regexp.Regexp{
+		regexp.MustCompile(`unifi_devices{site="Lab"`),
+		regexp.MustCompile(`{collector="clients",site="Default"`),
+		regexp.MustCompile(`{collector="devices",site="Lab"`),
+	}
+	for j, m := range nonMatches {
+		t.Logf("\t[%02d:%02d] no match: %s", 0, j, m.String())
+
+		if m.Match(out) {
+			t.Fatal("\toutput unexpectedly matched regex")
+		}
+	}
+}
+
+func TestNewFromController(t *testing.T) {
+	var buf bytes.Buffer
+	e, err := NewFromController(
+		&fakeController{failDevices: true},
+		[]*api.Site{{Name: "default", Description: "Default"}},
+		Namespace("foo"),
+		Logger(log.New(&buf, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	out := testCollector(t, e)
+
+	matches := []*regexp.Regexp{
+		regexp.MustCompile(`foo_up 0`),
+		regexp.MustCompile(`foo_scrape_duration_seconds{collector="clients"} \d`),
+		regexp.MustCompile(`foo_collector_scrape_errors_total{collector="devices"} 1`),
+	}
+	for j, m := range matches {
+		t.Logf("\t[%02d:%02d] match: %s", 0, j, m.String())
+
+		if !m.Match(out) {
+			t.Fatal("\toutput failed to match regex")
+		}
+	}
+
+	if regexp.MustCompile(`unifi_`).Match(out) {
+		t.Fatal("output contains metrics in the default namespace")
+	}
+
+	if want, got := "failed to retrieve devices", buf.String(); !strings.Contains(got, want) {
+		t.Fatalf("unexpected log output:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestExporterSiteLabel(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		options []Option
+		matches []*regexp.Regexp
+		ok      bool
+	}{
+		{
+			desc: "description",
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices{site="Default"} 0`),
+			},
+			ok: true,
+		},
+		{
+			desc:    "name",
+			options: []Option{SiteLabel(SiteLabelName)},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices{site="default"} 0`),
+			},
+			ok: true,
+		},
+		{
+			desc:    "ID with site info",
+			options: []Option{SiteLabel(SiteLabelID), EnableSiteInfo()},
+			matches: []*regexp.Regexp{
+				regexp.MustCompile(`unifi_devices{site="abc123"} 0`),
+				regexp.MustCompile(`unifi_site_info{description="Default",id="abc123",name="default",site="abc123"} 1`),
+			},
+			ok: true,
+		},
+		{
+			desc:    "unknown source",
+			options: []Option{SiteLabel("foo")},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		fn := func(_ context.Context) (api.Controller, error) {
+			return &fakeController{}, nil
+		}
+
+		sites := []*api.Site{{ID: "abc123", Name: "default", Description: "Default"}}
+
+		e, err := New(sites, fn, tt.options...)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected success:\n- want: %v\n-  got: %v (%v)", want, got, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		out := testCollector(t, e)
+
+		for j, m := range tt.matches {
+			t.Logf("\t[%02d:%02d] match: %s", i, j, m.String())
+
+			if !m.Match(out) {
+				t.Fatal("\toutput failed to match regex")
+			}
+		}
+	}
+}
+
+func TestExporterCollectors(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		options []Option
+		n       int
+		ok      bool
+	}{
+		{
+			desc: "defaults",
+			n:    2,
+			ok:   true,
+		},
+		{
+			desc:    "devices disabled",
+			options: []Option{DisableCollector(CollectorDevices)},
+			n:       1,
+			ok:      true,
+		},
+		{
+			desc:    "DPI enabled",
+			options: []Option{EnableCollector(CollectorDPI)},
+			n:       3,
+			ok:      true,
+		},
+		{
+			desc:    "unknown collector",
+			options: []Option{EnableCollector("foo")},
+		},
+		{
+			desc:    "hashed station identifiers",
+			options: []Option{StationPrivacy(PrivacyHash, []byte("secret"))},
+			n:       2,
+			ok:      true,
+		},
+		{
+			desc:    "hashed station identifiers without key",
+			options: []Option{StationPrivacy(PrivacyHash, nil)},
+		},
+		{
+			desc:    "unknown privacy mode",
+			options: []Option{StationPrivacy("foo", nil)},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		fn := func(_ context.Context) (api.Controller, error) {
+			return &fakeController{}, nil
+		}
+
+		e, err := New(nil, fn, tt.options...)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected success:\n- want: %v\n-  got: %v (%v)", want, got, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.n, len(e.collectors); want != got {
+			t.Fatalf("unexpected number of collectors:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func Test_forEachSite(t *testing.T) {
+	const n = 2
+
+	sites := make([]*api.Site, 3*n)
+	for i := range sites {
+		sites[i] = &api.Site{Name: fmt.Sprintf("site%d", i)}
+	}
+
+	// A failing site does not stop the others from being visited
+	var tests = []struct {
+		desc    string
+		fail    string
+		visited int
+		ok      bool
+	}{
+		{
+			desc:    "OK",
+			visited: len(sites),
+			ok:      true,
+		},
+		{
+			desc:    "first site fails",
+			fail:    "site0",
+			visited: len(sites),
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var (
+			mu                      sync.Mutex
+			visited, inFlight, peak int
+		)
+
+		err := forEachSite(context.Background(), sites, n, func(ctx context.Context, s *api.Site) error {
+			mu.Lock()
+			visited++
+			inFlight++
+			if inFlight > peak {
+				peak = inFlight
+			}
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+
+			if s.Name == tt.fail {
+				return errors.New("failed to retrieve site")
+			}
+
+			// Hold each slot, so that the peak number of sites in
+			// progress reaches the limit
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected success:\n- want: %v\n-  got: %v (%v)", want, got, err)
+		}
+		if max, got := n, peak; got > max {
+			t.Fatalf("too many sites in progress:\n- max: %v\n- got: %v", max, got)
+		}
+		if !tt.ok {
+			errs, ok := err.(siteErrors)
+			if !ok {
+				t.Fatalf("unexpected error type: %T", err)
+			}
+			if want, got := 1, len(errs); want != got {
+				t.Fatalf("unexpected number of failed sites:\n- want: %v\n-  got: %v", want, got)
+			}
+		}
+
+		if want, got := tt.visited, visited; want != got {
+			t.Fatalf("unexpected number of sites visited:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := n, peak; want != got {
+			t.Fatalf("unexpected peak number of sites in progress:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}